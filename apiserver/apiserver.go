@@ -3,6 +3,8 @@ package main
 import "github.com/nitrous-io/rise-server/apiserver/server"
 
 func main() {
+	server.StartMetricsServer()
+
 	r := server.New()
 	r.Run(":3000")
 }
@@ -15,6 +15,10 @@ var (
 	GitHubAPIHost  = os.Getenv("GITHUB_API_HOST")
 	GitHubAPIToken = os.Getenv("GITHUB_API_TOKEN")
 	WebhookHost    = os.Getenv("WEBHOOK_HOST")
+
+	// MetricsAddr is the internal address that metrics are served on, e.g.
+	// ":9100". If empty, metrics are not served.
+	MetricsAddr = os.Getenv("METRICS_ADDR")
 )
 
 func init() {
@@ -0,0 +1,158 @@
+// Package buildenvvars manages the environment variables that the assets of a
+// project are optimized with. Unlike JS environment variables, they are
+// secret: they are encrypted at rest, never deployed, and their values are
+// never returned.
+package buildenvvars
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+)
+
+// MaskedValue is returned in place of the value of every variable.
+const MaskedValue = "********"
+
+// Index lists the build-time environment variables of the project, with
+// their values masked.
+func Index(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	vars, err := proj.BuildEnvVars(common.AesKey)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"build_env_vars": masked(vars),
+	})
+}
+
+// Add sets the build-time environment variables in the JSON object in the
+// request body, which take effect from the next build.
+func Add(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	var newVars map[string]string
+	if err := c.Bind(&newVars); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":             "invalid_request",
+			"error_description": "request body is in invalid format",
+		})
+		return
+	}
+
+	if len(newVars) == 0 {
+		c.JSON(422, gin.H{
+			"error":             "invalid_params",
+			"error_description": "request body is empty",
+		})
+		return
+	}
+
+	errs := map[string]string{}
+	for name, value := range newVars {
+		if msg := project.ValidateBuildEnvVar(name); msg != "" {
+			errs[name] = msg
+		} else if msg := project.ValidateBuildEnvVarValue(value); msg != "" {
+			errs[name] = msg
+		}
+	}
+	if len(errs) > 0 {
+		c.JSON(422, gin.H{
+			"error":  "invalid_params",
+			"errors": errs,
+		})
+		return
+	}
+
+	vars, err := proj.BuildEnvVars(common.AesKey)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	for name, value := range newVars {
+		vars[name] = value
+	}
+
+	if err := save(proj, vars); err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"build_env_vars": masked(vars),
+	})
+}
+
+// Delete removes the build-time environment variables named by the "keys"
+// form values.
+func Delete(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	if err := c.Request.ParseForm(); err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	keys := c.Request.PostForm["keys"]
+	if len(keys) == 0 {
+		c.JSON(422, gin.H{
+			"error":             "invalid_params",
+			"error_description": "request body is empty",
+		})
+		return
+	}
+
+	vars, err := proj.BuildEnvVars(common.AesKey)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	var n int
+	for _, key := range keys {
+		if _, ok := vars[key]; ok {
+			delete(vars, key)
+			n++
+		}
+	}
+
+	if n > 0 {
+		if err := save(proj, vars); err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"build_env_vars": masked(vars),
+	})
+}
+
+func save(proj *project.Project, vars map[string]string) error {
+	if err := proj.SetBuildEnvVars(vars, common.AesKey); err != nil {
+		return err
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		return err
+	}
+
+	return db.Model(project.Project{}).Where("id = ?", proj.ID).Update("encrypted_build_env_vars", proj.EncryptedBuildEnvVars).Error
+}
+
+func masked(vars map[string]string) map[string]string {
+	m := make(map[string]string, len(vars))
+	for name := range vars {
+		m[name] = MaskedValue
+	}
+	return m
+}
@@ -0,0 +1,343 @@
+package buildenvvars_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/apiserver/server"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+	"github.com/nitrous-io/rise-server/testhelper/sharedexamples"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "buildenvvars")
+}
+
+var _ = Describe("BuildEnvVars", func() {
+	var (
+		db *gorm.DB
+
+		s   *httptest.Server
+		res *http.Response
+		err error
+
+		u *user.User
+		t *oauthtoken.OauthToken
+
+		headers    http.Header
+		proj       *project.Project
+		origAesKey string
+	)
+
+	BeforeEach(func() {
+		origAesKey = common.AesKey
+		common.AesKey = "something-something-something-32"
+
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+
+		testhelper.TruncateTables(db.DB())
+		u, _, t = factories.AuthTrio(db)
+
+		proj = &project.Project{
+			Name:   "foo-bar-express",
+			UserID: u.ID,
+		}
+		Expect(proj.SetBuildEnvVars(map[string]string{
+			"API_TOKEN": "s3cr3t",
+			"API_HOST":  "api.example.com",
+		}, common.AesKey)).To(Succeed())
+		Expect(db.Create(proj).Error).To(BeNil())
+
+		headers = http.Header{
+			"Authorization": {"Bearer " + t.Token},
+		}
+	})
+
+	AfterEach(func() {
+		common.AesKey = origAesKey
+
+		if res != nil {
+			res.Body.Close()
+		}
+		s.Close()
+	})
+
+	buildEnvVars := func() map[string]string {
+		Expect(db.First(proj, proj.ID).Error).To(BeNil())
+		vars, err := proj.BuildEnvVars(common.AesKey)
+		Expect(err).To(BeNil())
+		return vars
+	}
+
+	Describe("GET /projects/:project_name/buildenvvars", func() {
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("GET", s.URL+"/projects/foo-bar-express/buildenvvars", nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		It("returns the variables with their values masked", func() {
+			doRequest()
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(b.String()).To(MatchJSON(`{
+				"build_env_vars": {
+					"API_TOKEN": "********",
+					"API_HOST": "********"
+				}
+			}`))
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
+
+	Describe("PUT /projects/:project_name/buildenvvars/add", func() {
+		var params map[string]string
+
+		BeforeEach(func() {
+			params = map[string]string{
+				"API_TOKEN":  "n3w-s3cr3t",
+				"ANALYTICS":  "UA-1234",
+				"EMPTY_FLAG": "",
+			}
+		})
+
+		doRequestWith := func(b []byte) {
+			s = httptest.NewServer(server.New())
+
+			req, err := http.NewRequest("PUT", s.URL+"/projects/foo-bar-express/buildenvvars/add", bytes.NewBuffer(b))
+			Expect(err).To(BeNil())
+			req.Header.Add("Content-Type", "application/json")
+
+			for k, v := range headers {
+				for _, h := range v {
+					req.Header.Add(k, h)
+				}
+			}
+
+			res, err = http.DefaultClient.Do(req)
+			Expect(err).To(BeNil())
+		}
+
+		doRequest := func() {
+			b, err := json.Marshal(params)
+			Expect(err).To(BeNil())
+
+			doRequestWith(b)
+		}
+
+		It("sets the variables and returns them with their values masked", func() {
+			doRequest()
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(b.String()).To(MatchJSON(`{
+				"build_env_vars": {
+					"API_TOKEN": "********",
+					"API_HOST": "********",
+					"ANALYTICS": "********",
+					"EMPTY_FLAG": "********"
+				}
+			}`))
+
+			Expect(buildEnvVars()).To(Equal(map[string]string{
+				"API_TOKEN":  "n3w-s3cr3t",
+				"API_HOST":   "api.example.com",
+				"ANALYTICS":  "UA-1234",
+				"EMPTY_FLAG": "",
+			}))
+			Expect(proj.EncryptedBuildEnvVars).NotTo(ContainSubstring("n3w-s3cr3t"))
+		})
+
+		DescribeTable("errors",
+			func(setup func(), expectedCode int, expectedBody string) {
+				setup()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(expectedCode))
+				Expect(b.String()).To(MatchJSON(expectedBody))
+
+				Expect(buildEnvVars()).To(Equal(map[string]string{
+					"API_TOKEN": "s3cr3t",
+					"API_HOST":  "api.example.com",
+				}))
+			},
+			Entry("when request body is invalid json", func() {
+				doRequestWith([]byte(`{hello`))
+			}, http.StatusBadRequest, `{
+				"error": "invalid_request",
+				"error_description": "request body is in invalid format"
+			}`),
+			Entry("when request body is empty", func() {
+				doRequestWith([]byte(`{}`))
+			}, 422, `{
+				"error": "invalid_params",
+				"error_description": "request body is empty"
+			}`),
+			Entry("when a name is invalid", func() {
+				doRequestWith([]byte(`{"API-TOKEN": "s3cr3t", "DOMAIN_NAMES_WITH_PROTOCOL": "http://example.com"}`))
+			}, 422, `{
+				"error": "invalid_params",
+				"errors": {
+					"API-TOKEN": "is not a valid environment variable name",
+					"DOMAIN_NAMES_WITH_PROTOCOL": "is reserved"
+				}
+			}`),
+			Entry("when a name is reserved for Docker or the dynamic linker", func() {
+				doRequestWith([]byte(`{"DOCKER_HOST": "tcp://evil.example.com:2375", "LD_PRELOAD": "/tmp/evil.so", "PATH": "/tmp", "HOME": "/tmp"}`))
+			}, 422, `{
+				"error": "invalid_params",
+				"errors": {
+					"DOCKER_HOST": "is reserved",
+					"LD_PRELOAD": "is reserved",
+					"PATH": "is reserved",
+					"HOME": "is reserved"
+				}
+			}`),
+			Entry("when a value spans lines", func() {
+				doRequestWith([]byte(`{"API_TOKEN": "s3cr3t\nPATH=/tmp"}`))
+			}, 422, `{
+				"error": "invalid_params",
+				"errors": {
+					"API_TOKEN": "must not contain line breaks"
+				}
+			}`),
+		)
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItLocksProject(func() (*gorm.DB, *project.Project) {
+			return db, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
+
+	Describe("PUT /projects/:project_name/buildenvvars/delete", func() {
+		var params url.Values
+
+		BeforeEach(func() {
+			params = url.Values{
+				"keys": {"API_TOKEN", "NOT_SET"},
+			}
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("PUT", s.URL+"/projects/foo-bar-express/buildenvvars/delete", params, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		It("removes the variables and returns the rest with their values masked", func() {
+			doRequest()
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(b.String()).To(MatchJSON(`{
+				"build_env_vars": {
+					"API_HOST": "********"
+				}
+			}`))
+
+			Expect(buildEnvVars()).To(Equal(map[string]string{
+				"API_HOST": "api.example.com",
+			}))
+		})
+
+		Context("when the request has no keys", func() {
+			BeforeEach(func() {
+				params = url.Values{}
+			})
+
+			It("returns 422", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_params",
+					"error_description": "request body is empty"
+				}`))
+			})
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItLocksProject(func() (*gorm.DB, *project.Project) {
+			return db, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
+})
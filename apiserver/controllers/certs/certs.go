@@ -194,7 +194,7 @@ func Create(c *gin.Context) {
 	ct.Issuer = &info.Issuer
 	ct.Subject = &info.Subject
 
-	if err := uploadCert(domainName, certBytes, pKeyBytes); err != nil {
+	if err := uploadCert(domainName, certBytes, pKeyBytes, controllers.RequestID(c)); err != nil {
 		controllers.InternalServerError(c, err)
 		return
 	}
@@ -447,7 +447,7 @@ func LetsEncrypt(c *gin.Context) {
 		Type:  "RSA PRIVATE KEY",
 		Bytes: x509.MarshalPKCS1PrivateKey(certKey),
 	})
-	if err := uploadCert(dom.Name, bundledPEM, certKeyPEM); err != nil {
+	if err := uploadCert(dom.Name, bundledPEM, certKeyPEM, controllers.RequestID(c)); err != nil {
 		controllers.InternalServerError(c, err)
 		return
 	}
@@ -494,7 +494,7 @@ func LetsEncrypt(c *gin.Context) {
 	})
 }
 
-func uploadCert(domainName string, cert, key []byte) error {
+func uploadCert(domainName string, cert, key []byte, requestID string) error {
 	certPath := fmt.Sprintf("certs/%s/ssl.crt", domainName)
 	encryptedCert, err := aesencrypter.Encrypt(cert, []byte(common.AesKey))
 	if err != nil {
@@ -517,7 +517,8 @@ func uploadCert(domainName string, cert, key []byte) error {
 
 	// Invalidate cert cache
 	m, err := pubsub.NewMessageWithJSON(exchanges.Edges, exchanges.RouteV1Invalidation, &messages.V1InvalidationMessageData{
-		Domains: []string{domainName},
+		Domains:   []string{domainName},
+		RequestID: requestID,
 	})
 	if err != nil {
 		return err
@@ -593,7 +594,8 @@ func Destroy(c *gin.Context) {
 	}
 
 	m, err := pubsub.NewMessageWithJSON(exchanges.Edges, exchanges.RouteV1Invalidation, &messages.V1InvalidationMessageData{
-		Domains: []string{domainName},
+		Domains:   []string{domainName},
+		RequestID: controllers.RequestID(c),
 	})
 
 	if err != nil {
@@ -344,7 +344,7 @@ A6ao9QSL1ryillYV9Y4001C3jApzmMtBWoMp3NPzwU8nacAOzClJYUcSLkbAIEWV
 
 			d := testhelper.ConsumeQueue(mq, invalidationQueueName)
 			Expect(d).NotTo(BeNil())
-			Expect(d.Body).To(MatchJSON(`{"domains": ["www.foo-bar-express.com"]}`))
+			Expect(d.Body).To(MatchJSON(`{"domains": ["www.foo-bar-express.com"], "request_id": "` + res.Header.Get("X-Request-Id") + `"}`))
 		})
 
 		It("tracks an 'Uploaded SSL Certificate' event", func() {
@@ -477,7 +477,7 @@ A6ao9QSL1ryillYV9Y4001C3jApzmMtBWoMp3NPzwU8nacAOzClJYUcSLkbAIEWV
 
 				d := testhelper.ConsumeQueue(mq, invalidationQueueName)
 				Expect(d).NotTo(BeNil())
-				Expect(d.Body).To(MatchJSON(`{"domains": ["www.foo-bar-express.com"]}`))
+				Expect(d.Body).To(MatchJSON(`{"domains": ["www.foo-bar-express.com"], "request_id": "` + res.Header.Get("X-Request-Id") + `"}`))
 			})
 
 			It("tracks an 'Uploaded SSL Certificate' event", func() {
@@ -977,7 +977,7 @@ A6ao9QSL1ryillYV9Y4001C3jApzmMtBWoMp3NPzwU8nacAOzClJYUcSLkbAIEWV
 
 			d := testhelper.ConsumeQueue(mq, invalidationQueueName)
 			Expect(d).NotTo(BeNil())
-			Expect(d.Body).To(MatchJSON(`{"domains": ["www.foo-bar-express.com"]}`))
+			Expect(d.Body).To(MatchJSON(`{"domains": ["www.foo-bar-express.com"], "request_id": "` + res.Header.Get("X-Request-Id") + `"}`))
 		})
 
 		It("saves Let's Encrypt's HTTP challenge details", func() {
@@ -1408,7 +1408,7 @@ A6ao9QSL1ryillYV9Y4001C3jApzmMtBWoMp3NPzwU8nacAOzClJYUcSLkbAIEWV
 
 			d := testhelper.ConsumeQueue(mq, qName)
 			Expect(d).NotTo(BeNil())
-			Expect(d.Body).To(MatchJSON(`{ "domains": ["www.foo-bar-express.com"] }`))
+			Expect(d.Body).To(MatchJSON(`{ "domains": ["www.foo-bar-express.com"], "request_id": "` + res.Header.Get("X-Request-Id") + `" }`))
 		})
 
 		It("tracks a 'Deleted SSL Certificate' event", func() {
@@ -6,7 +6,9 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/nitrous-io/rise-server/apiserver/models/deploytoken"
 	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
+	"github.com/nitrous-io/rise-server/apiserver/models/organization"
 	"github.com/nitrous-io/rise-server/apiserver/models/project"
 	"github.com/nitrous-io/rise-server/apiserver/models/user"
 
@@ -16,9 +18,14 @@ import (
 )
 
 const (
-	CurrentTokenKey   = "current_token"
-	CurrentUserKey    = "current_user"
-	CurrentProjectKey = "current_project"
+	CurrentTokenKey        = "current_token"
+	CurrentDeployTokenKey  = "current_deploy_token"
+	AllowDeployTokenKey    = "allow_deploy_token"
+	CurrentUserKey         = "current_user"
+	CurrentProjectKey      = "current_project"
+	CurrentProjectRoleKey  = "current_project_role"
+	CurrentOrganizationKey = "current_organization"
+	RequestIDKey           = "request_id"
 )
 
 func CurrentToken(c *gin.Context) *oauthtoken.OauthToken {
@@ -34,6 +41,21 @@ func CurrentToken(c *gin.Context) *oauthtoken.OauthToken {
 	return t
 }
 
+// CurrentDeployToken returns the deploy token the request was authenticated
+// with, or nil if it was authenticated with an OAuth token.
+func CurrentDeployToken(c *gin.Context) *deploytoken.DeployToken {
+	ti, exists := c.Get(CurrentDeployTokenKey)
+	if ti == nil || !exists {
+		return nil
+	}
+
+	t, ok := ti.(*deploytoken.DeployToken)
+	if !ok {
+		return nil
+	}
+	return t
+}
+
 func CurrentUser(c *gin.Context) *user.User {
 	ui, exists := c.Get(CurrentUserKey)
 	if ui == nil || !exists {
@@ -60,6 +82,35 @@ func CurrentProject(c *gin.Context) *project.Project {
 	return p
 }
 
+// CurrentProjectRole returns the collaborator role that the current user has
+// in the current project. Owners of the project have the admin role.
+func CurrentProjectRole(c *gin.Context) string {
+	r, _ := c.Get(CurrentProjectRoleKey)
+	s, _ := r.(string)
+	return s
+}
+
+func CurrentOrganization(c *gin.Context) *organization.Organization {
+	oi, exists := c.Get(CurrentOrganizationKey)
+	if oi == nil || !exists {
+		return nil
+	}
+
+	o, ok := oi.(*organization.Organization)
+	if !ok {
+		return nil
+	}
+	return o
+}
+
+// RequestID returns the ID assigned to the request by the RequestID
+// middleware, or an empty string if there is none.
+func RequestID(c *gin.Context) string {
+	id, _ := c.Get(RequestIDKey)
+	s, _ := id.(string)
+	return s
+}
+
 func InternalServerError(c *gin.Context, err error, msg ...string) {
 	var (
 		errMsg  = "internal server error"
@@ -82,6 +133,10 @@ func InternalServerError(c *gin.Context, err error, msg ...string) {
 		"ip":  c.ClientIP(),
 	}
 
+	if reqID := RequestID(c); reqID != "" {
+		fields["request_id"] = reqID
+	}
+
 	j := gin.H{
 		"error": "internal_server_error",
 	}
@@ -308,11 +308,13 @@ func Create(c *gin.Context) {
 			DeploymentID:  depl.ID,
 			UseRawBundle:  true,
 			ArchiveFormat: archiveFormat,
+			RequestID:     controllers.RequestID(c),
 		})
 	} else {
 		j, err = job.NewWithJSON(queues.Build, &messages.BuildJobData{
 			DeploymentID:  depl.ID,
 			ArchiveFormat: archiveFormat,
+			RequestID:     controllers.RequestID(c),
 		})
 	}
 
@@ -344,6 +346,7 @@ func Create(c *gin.Context) {
 				"deploymentId":      depl.ID,
 				"deploymentPrefix":  depl.Prefix,
 				"deploymentVersion": depl.Version,
+				"requestId":         controllers.RequestID(c),
 			}
 			context = map[string]interface{}{
 				"ip":         common.GetIP(c.Request),
@@ -363,6 +366,8 @@ func Create(c *gin.Context) {
 
 // Show displays information of a single deployment.
 func Show(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
 	deploymentID, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{
@@ -380,7 +385,7 @@ func Show(c *gin.Context) {
 
 	depl := &deployment.Deployment{}
 
-	if err := db.First(depl, deploymentID).Error; err != nil {
+	if err := db.Where("project_id = ?", proj.ID).First(depl, deploymentID).Error; err != nil {
 		if err == gorm.RecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{
 				"error":             "not_found",
@@ -392,11 +397,85 @@ func Show(c *gin.Context) {
 		return
 	}
 
+	deplJSON := depl.AsJSON()
+	deplJSON.Diagnostics, err = depl.BuildDiagnostics()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"deployment": depl.AsJSON(),
+		"deployment": deplJSON,
 	})
 }
 
+// Report displays the build report of a deployment, along with how its sizes
+// have changed since the previous deployment that was built.
+func Report(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	deploymentID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "deployment could not be found",
+		})
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	depl := &deployment.Deployment{}
+	if err := db.Where("project_id = ?", proj.ID).First(depl, deploymentID).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":             "not_found",
+				"error_description": "deployment could not be found",
+			})
+			return
+		}
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	report, err := depl.Report()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+	if report == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "deployment does not have a build report",
+		})
+		return
+	}
+
+	j := gin.H{
+		"report": report,
+	}
+
+	prevDepl, err := depl.PreviousBuiltDeployment(db)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+	if prevDepl != nil {
+		prevReport, err := prevDepl.Report()
+		if err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
+		j["previous"] = report.CompareWith(prevDepl, prevReport)
+	}
+
+	c.JSON(http.StatusOK, j)
+}
+
 // Download allows users to download an (unoptimized) tarball of the files of a
 // deployment.
 func Download(c *gin.Context) {
@@ -552,6 +631,7 @@ func Rollback(c *gin.Context) {
 	j, err := job.NewWithJSON(queues.Deploy, &messages.DeployJobData{
 		DeploymentID:      depl.ID,
 		SkipWebrootUpload: true,
+		RequestID:         controllers.RequestID(c),
 	})
 
 	if err != nil {
@@ -578,6 +658,7 @@ func Rollback(c *gin.Context) {
 				"projectName":     proj.Name,
 				"deployedVersion": currentDepl.Version,
 				"targetVersion":   depl.Version,
+				"requestId":       controllers.RequestID(c),
 			}
 			context = map[string]interface{}{
 				"ip":         common.GetIP(c.Request),
@@ -595,7 +676,8 @@ func Rollback(c *gin.Context) {
 	})
 }
 
-// Index lists all deployments of a project.
+// Index lists all deployments of a project that have not been deleted by its
+// retention policy.
 func Index(c *gin.Context) {
 	proj := controllers.CurrentProject(c)
 
@@ -605,7 +687,7 @@ func Index(c *gin.Context) {
 		return
 	}
 
-	depls, err := deployment.CompletedDeployments(db, proj.ID, proj.MaxDeploysKept)
+	depls, err := deployment.CompletedDeployments(db, proj.ID, 0)
 	if err != nil {
 		controllers.InternalServerError(c, err)
 		return
@@ -622,3 +704,146 @@ func Index(c *gin.Context) {
 		"deployments": deplsToJSON,
 	})
 }
+
+// Pin labels a deployment of the current project so that it is never deleted
+// by the retention policy of the project.
+func Pin(c *gin.Context) {
+	u := controllers.CurrentUser(c)
+	proj := controllers.CurrentProject(c)
+
+	deploymentID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "deployment could not be found",
+		})
+		return
+	}
+
+	label := strings.TrimSpace(c.PostForm("label"))
+	if label == "" || len(label) > 255 {
+		errMsg := "is required"
+		if label != "" {
+			errMsg = "is too long (max. 255 characters)"
+		}
+
+		c.JSON(422, gin.H{
+			"error": "invalid_params",
+			"errors": map[string]interface{}{
+				"label": errMsg,
+			},
+		})
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	depl := &deployment.Deployment{}
+	if err := db.Where("project_id = ?", proj.ID).First(depl, deploymentID).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":             "not_found",
+				"error_description": "deployment could not be found",
+			})
+			return
+		}
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if err := depl.Pin(db, label); err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	{
+		var (
+			event = "Pinned Deployment"
+			props = map[string]interface{}{
+				"projectName":       proj.Name,
+				"deploymentId":      depl.ID,
+				"deploymentVersion": depl.Version,
+				"label":             label,
+			}
+			context = map[string]interface{}{
+				"ip":         common.GetIP(c.Request),
+				"user_agent": c.Request.UserAgent(),
+			}
+		)
+		if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
+			log.Errorf("failed to track %q event for user ID %d, err: %v",
+				event, u.ID, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"deployment": depl.AsJSON(),
+	})
+}
+
+// Unpin removes the label of a deployment of the current project, so that it
+// can be deleted by the retention policy of the project again.
+func Unpin(c *gin.Context) {
+	u := controllers.CurrentUser(c)
+	proj := controllers.CurrentProject(c)
+
+	deploymentID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "deployment could not be found",
+		})
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	depl := &deployment.Deployment{}
+	if err := db.Where("project_id = ?", proj.ID).First(depl, deploymentID).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":             "not_found",
+				"error_description": "deployment could not be found",
+			})
+			return
+		}
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if err := depl.Unpin(db); err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	{
+		var (
+			event = "Unpinned Deployment"
+			props = map[string]interface{}{
+				"projectName":       proj.Name,
+				"deploymentId":      depl.ID,
+				"deploymentVersion": depl.Version,
+			}
+			context = map[string]interface{}{
+				"ip":         common.GetIP(c.Request),
+				"user_agent": c.Request.UserAgent(),
+			}
+		)
+		if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
+			log.Errorf("failed to track %q event for user ID %d, err: %v",
+				event, u.ID, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"deployment": depl.AsJSON(),
+	})
+}
@@ -18,9 +18,11 @@ import (
 	"github.com/jinzhu/gorm"
 	"github.com/nitrous-io/rise-server/apiserver/common"
 	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/collab"
 	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
 	"github.com/nitrous-io/rise-server/apiserver/models/domain"
 	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
+	"github.com/nitrous-io/rise-server/apiserver/models/organization"
 	"github.com/nitrous-io/rise-server/apiserver/models/project"
 	"github.com/nitrous-io/rise-server/apiserver/models/rawbundle"
 	"github.com/nitrous-io/rise-server/apiserver/models/template"
@@ -36,6 +38,7 @@ import (
 	"github.com/nitrous-io/rise-server/testhelper/fake"
 	"github.com/nitrous-io/rise-server/testhelper/sharedexamples"
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 	"github.com/streadway/amqp"
 )
@@ -203,6 +206,18 @@ var _ = Describe("Deployments", func() {
 			Expect(db.Last(depl).Error).To(Equal(gorm.RecordNotFound))
 		})
 
+		sharedexamples.ItRequiresProjectRole(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, collab.RoleDeployer, func() *http.Response {
+			doRequest()
+			return res
+		}, func() {
+			// should not deploy anything if user is not allowed to deploy
+			Expect(fakeS3.UploadCalls.Count()).To(Equal(0))
+			depl := &deployment.Deployment{}
+			Expect(db.Last(depl).Error).To(Equal(gorm.RecordNotFound))
+		})
+
 		sharedexamples.ItLocksProject(func() (*gorm.DB, *project.Project) {
 			return db, proj
 		}, func() *http.Response {
@@ -215,6 +230,108 @@ var _ = Describe("Deployments", func() {
 			Expect(db.Last(depl).Error).To(Equal(gorm.RecordNotFound))
 		})
 
+		Context("when the request is authenticated with a deploy token", func() {
+			It("creates a deployment as the user who created the deploy token", func() {
+				u2 := factories.User(db)
+				Expect(proj.AddCollaboratorWithRole(db, u2, collab.RoleDeployer)).To(Succeed())
+				headers = http.Header{
+					"Authorization": {"Bearer " + factories.DeployToken(db, proj, u2).Token},
+				}
+
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+
+				depl := &deployment.Deployment{}
+				Expect(db.Last(depl).Error).To(BeNil())
+				Expect(depl.ProjectID).To(Equal(proj.ID))
+				Expect(depl.UserID).To(Equal(u2.ID))
+			})
+
+			It("returns 404 not found if the deploy token belongs to another project", func() {
+				headers = http.Header{
+					"Authorization": {"Bearer " + factories.DeployToken(db, factories.Project(db, u), u).Token},
+				}
+
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+
+				depl := &deployment.Deployment{}
+				Expect(db.Last(depl).Error).To(Equal(gorm.RecordNotFound))
+			})
+
+			DescribeTable("when the user who created the deploy token can no longer deploy to the project",
+				func(setUp func()) {
+					setUp()
+
+					doRequest()
+
+					Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+
+					depl := &deployment.Deployment{}
+					Expect(db.Last(depl).Error).To(Equal(gorm.RecordNotFound))
+				},
+				Entry("when they have been removed as a collaborator", func() {
+					u2 := factories.User(db)
+					Expect(proj.AddCollaboratorWithRole(db, u2, collab.RoleDeployer)).To(Succeed())
+					headers = http.Header{
+						"Authorization": {"Bearer " + factories.DeployToken(db, proj, u2).Token},
+					}
+
+					Expect(proj.RemoveCollaborator(db, u2)).To(Succeed())
+				}),
+				Entry("when they have been made a viewer", func() {
+					u2 := factories.User(db)
+					Expect(proj.AddCollaboratorWithRole(db, u2, collab.RoleDeployer)).To(Succeed())
+					headers = http.Header{
+						"Authorization": {"Bearer " + factories.DeployToken(db, proj, u2).Token},
+					}
+
+					Expect(proj.SetCollaboratorRole(db, u2, collab.RoleViewer)).To(Succeed())
+				}),
+				Entry("when they have been removed from the organization that owns the project", func() {
+					org := factories.Organization(db, u)
+					Expect(db.Model(proj).Update("organization_id", org.ID).Error).To(BeNil())
+
+					u2 := factories.User(db)
+					Expect(org.AddMember(db, u2, organization.RoleMember)).To(Succeed())
+					headers = http.Header{
+						"Authorization": {"Bearer " + factories.DeployToken(db, proj, u2).Token},
+					}
+
+					Expect(org.RemoveMember(db, u2)).To(Succeed())
+				}),
+				Entry("when the project has been transferred to another user", func() {
+					headers = http.Header{
+						"Authorization": {"Bearer " + factories.DeployToken(db, proj, u).Token},
+					}
+
+					Expect(proj.TransferTo(db, factories.User(db), false)).To(Succeed())
+				}),
+			)
+
+			Context("when the project has been transferred, keeping the previous owner as a collaborator", func() {
+				BeforeEach(func() {
+					headers = http.Header{
+						"Authorization": {"Bearer " + factories.DeployToken(db, proj, u).Token},
+					}
+
+					Expect(proj.TransferTo(db, factories.User(db), true)).To(Succeed())
+				})
+
+				It("creates a deployment as the user who created the deploy token", func() {
+					doRequest()
+
+					Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+
+					depl := &deployment.Deployment{}
+					Expect(db.Last(depl).Error).To(BeNil())
+					Expect(depl.UserID).To(Equal(u.ID))
+				})
+			})
+		})
+
 		Context("when the project belongs to current user", func() {
 			Context("when the request does not contain payload part", func() {
 				It("returns 422 with invalid_params", func() {
@@ -398,9 +515,10 @@ var _ = Describe("Deployments", func() {
 					Expect(d.Body).To(MatchJSON(fmt.Sprintf(`
 						{
 							"deployment_id": %d,
-							"archive_format": "tar.gz"
+							"archive_format": "tar.gz",
+							"request_id": %q
 						}
-					`, depl.ID)))
+					`, depl.ID, res.Header.Get("X-Request-Id"))))
 				})
 
 				It("tracks an 'Initiated Project Deployment' event", func() {
@@ -474,9 +592,10 @@ var _ = Describe("Deployments", func() {
 					Expect(d.Body).To(MatchJSON(fmt.Sprintf(`
 						{
 							"deployment_id": %d,
-							"archive_format": "tar.gz"
+							"archive_format": "tar.gz",
+							"request_id": %q
 						}
-					`, depl.ID)))
+					`, depl.ID, res.Header.Get("X-Request-Id"))))
 				})
 
 				Context("when skip_build is true", func() {
@@ -498,9 +617,10 @@ var _ = Describe("Deployments", func() {
 								"skip_webroot_upload": false,
 								"skip_invalidation": false,
 								"use_raw_bundle": true,
-								"archive_format": "tar.gz"
+								"archive_format": "tar.gz",
+								"request_id": %q
 							}
-						`, depl.ID)))
+						`, depl.ID, res.Header.Get("X-Request-Id"))))
 					})
 
 					It("update deployment to be `pending_deploy`", func() {
@@ -585,9 +705,10 @@ var _ = Describe("Deployments", func() {
 						Expect(m.Body).To(MatchJSON(fmt.Sprintf(`
 							{
 								"deployment_id": %d,
-								"archive_format": "tar.gz"
+								"archive_format": "tar.gz",
+								"request_id": %q
 							}
-						`, depl.ID)))
+						`, depl.ID, res.Header.Get("X-Request-Id"))))
 					})
 
 					Context("when the raw bundle is not associated with the project", func() {
@@ -772,9 +893,10 @@ var _ = Describe("Deployments", func() {
 						Expect(m.Body).To(MatchJSON(fmt.Sprintf(`
 							{
 								"deployment_id": %d,
-								"archive_format": "zip"
+								"archive_format": "zip",
+								"request_id": %q
 							}
-						`, depl.ID)))
+						`, depl.ID, res.Header.Get("X-Request-Id"))))
 					})
 				})
 
@@ -929,6 +1051,51 @@ var _ = Describe("Deployments", func() {
 				Expect(err).To(BeNil())
 				Expect(b.String()).To(MatchJSON(expectedJSON))
 			})
+
+			Context("when the deployment has build diagnostics", func() {
+				BeforeEach(func() {
+					Expect(depl.SaveDiagnostics(db, []*deployment.Diagnostic{
+						{Severity: deployment.SeverityError, Stage: "js", File: "js/app.js", Line: 11, Message: "unclosed ("},
+						{Severity: deployment.SeverityWarning, Stage: "links", File: "index.html", Line: 2, Message: "broken link to /docs/"},
+					})).To(Succeed())
+				})
+
+				It("returns them", func() {
+					doRequest()
+					b := &bytes.Buffer{}
+					_, err = b.ReadFrom(res.Body)
+
+					Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+					var d deployment.Deployment
+					Expect(db.First(&d, depl.ID).Error).To(BeNil())
+					Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+						"deployment": {
+							"id": %d,
+							"state": "pending_deploy",
+							"deployed_at": %q,
+							"version": %d,
+							"error_message": "index.js:Missing Parent",
+							"diagnostics": [
+								{
+									"severity": "error",
+									"stage": "js",
+									"file": "js/app.js",
+									"line": 11,
+									"message": "unclosed ("
+								},
+								{
+									"severity": "warning",
+									"stage": "links",
+									"file": "index.html",
+									"line": 2,
+									"message": "broken link to /docs/"
+								}
+							]
+						}
+					}`, d.ID, d.DeployedAt.Format(time.RFC3339Nano), d.Version)))
+				})
+			})
 		})
 
 		Context("the deployment does not exist", func() {
@@ -970,6 +1137,179 @@ var _ = Describe("Deployments", func() {
 		})
 	})
 
+	Describe("GET /projects/:project_name/deployments/:id/report", func() {
+		var (
+			err error
+
+			u *user.User
+			t *oauthtoken.OauthToken
+
+			headers http.Header
+			proj    *project.Project
+			depl    *deployment.Deployment
+		)
+
+		BeforeEach(func() {
+			u, _, t = factories.AuthTrio(db)
+
+			proj = &project.Project{
+				Name:   "foo-bar-express",
+				UserID: u.ID,
+			}
+			Expect(db.Create(proj).Error).To(BeNil())
+
+			headers = http.Header{
+				"Authorization": {"Bearer " + t.Token},
+			}
+
+			depl = factories.Deployment(db, proj, u, deployment.StateDeployed)
+			Expect(depl.SaveBuildReport(db, &deployment.BuildReport{
+				TotalSize:    1100,
+				OriginalSize: 1500,
+				SizesByType:  map[string]int64{"html": 300, "js": 800},
+				LargestFiles: []*deployment.FileSize{
+					{Path: "js/app.js", Size: 800},
+					{Path: "index.html", Size: 300},
+				},
+				HeaviestPages: []*deployment.FileSize{
+					{Path: "index.html", Size: 1100},
+				},
+				OverBudget: []*deployment.BudgetViolation{
+					{Budget: deployment.BudgetJSSize, Limit: 500, Size: 800},
+				},
+			})).To(Succeed())
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			url := fmt.Sprintf("%s/projects/foo-bar-express/deployments/%d/report", s.URL, depl.ID)
+			res, err = testhelper.MakeRequest("GET", url, nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		expectedReportJSON := `{
+			"total_size": 1100,
+			"original_size": 1500,
+			"sizes_by_type": {"html": 300, "js": 800},
+			"largest_files": [
+				{"path": "js/app.js", "size": 800},
+				{"path": "index.html", "size": 300}
+			],
+			"heaviest_pages": [
+				{"path": "index.html", "size": 1100}
+			],
+			"over_budget": [
+				{"budget": "max_js_size", "limit": 500, "size": 800}
+			],
+			"optimized": null
+		}`
+
+		It("returns the build report", func() {
+			doRequest()
+
+			b := &bytes.Buffer{}
+			_, err = b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(b.String()).To(MatchJSON(`{"report": ` + expectedReportJSON + `}`))
+		})
+
+		Context("when an earlier deployment has a build report", func() {
+			var prevDepl *deployment.Deployment
+
+			BeforeEach(func() {
+				// Make the deployment with the report the later one.
+				report, err := depl.Report()
+				Expect(err).To(BeNil())
+				prevDepl = depl
+				depl = factories.Deployment(db, proj, u, deployment.StateDeployed)
+				Expect(depl.SaveBuildReport(db, report)).To(Succeed())
+
+				Expect(prevDepl.SaveBuildReport(db, &deployment.BuildReport{
+					TotalSize:   1000,
+					SizesByType: map[string]int64{"html": 400, "js": 500, "css": 100},
+				})).To(Succeed())
+			})
+
+			It("compares the sizes with those of the earlier deployment", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"report": %s,
+					"previous": {
+						"deployment_id": %d,
+						"version": %d,
+						"total_size": 1000,
+						"total_size_change": 100,
+						"sizes_by_type_change": {"html": -100, "js": 300, "css": -100}
+					}
+				}`, expectedReportJSON, prevDepl.ID, prevDepl.Version)))
+			})
+		})
+
+		Context("when the deployment does not have a build report", func() {
+			BeforeEach(func() {
+				depl = factories.Deployment(db, proj, u, deployment.StateDeployed)
+			})
+
+			It("returns 404 not found", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "not_found",
+					"error_description": "deployment does not have a build report"
+				}`))
+			})
+		})
+
+		Context("when the deployment belongs to another project", func() {
+			BeforeEach(func() {
+				otherProj := factories.Project(db, u)
+				depl = factories.Deployment(db, otherProj, u, deployment.StateDeployed)
+				Expect(depl.SaveBuildReport(db, &deployment.BuildReport{TotalSize: 100})).To(Succeed())
+			})
+
+			It("returns 404 not found", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "not_found",
+					"error_description": "deployment could not be found"
+				}`))
+			})
+		})
+	})
+
 	Describe("GET /projects/:project_name/deployments/:id/download", func() {
 		var (
 			err error
@@ -1301,9 +1641,10 @@ var _ = Describe("Deployments", func() {
 						"deployment_id": %d,
 						"skip_webroot_upload": true,
 						"skip_invalidation": false,
-						"use_raw_bundle": false
+						"use_raw_bundle": false,
+						"request_id": %q
 					}
-				`, depl1.ID)))
+				`, depl1.ID, res.Header.Get("X-Request-Id"))))
 			})
 
 			It("marks the deployment as 'pending_rollback'", func() {
@@ -1392,9 +1733,10 @@ var _ = Describe("Deployments", func() {
 						"deployment_id": %d,
 						"skip_webroot_upload": true,
 						"skip_invalidation": false,
-						"use_raw_bundle": false
+						"use_raw_bundle": false,
+						"request_id": %q
 					}
-				`, depl4.ID)))
+				`, depl4.ID, res.Header.Get("X-Request-Id"))))
 			})
 
 			It("marks the deployment as 'pending_rollback'", func() {
@@ -1696,13 +2038,12 @@ var _ = Describe("Deployments", func() {
 			)))
 		})
 
-		Context("when project has a limit on max deployments kept", func() {
+		Context("when the retention policy of the project has deleted deployments", func() {
 			BeforeEach(func() {
-				proj.MaxDeploysKept = 1
-				Expect(db.Save(proj).Error).To(BeNil())
+				Expect(deployment.DeleteExpired(db, proj.ID, 1, 0)).To(Succeed())
 			})
 
-			It("returns only those deployments", func() {
+			It("returns only the deployments that are kept", func() {
 				doRequest()
 
 				b := &bytes.Buffer{}
@@ -1726,5 +2067,269 @@ var _ = Describe("Deployments", func() {
 				)))
 			})
 		})
+
+		Context("when a deployment is pinned", func() {
+			BeforeEach(func() {
+				Expect(depl4.Pin(db, "launch")).To(Succeed())
+				Expect(deployment.DeleteExpired(db, proj.ID, 1, 0)).To(Succeed())
+			})
+
+			It("returns it with its label, even if the retention policy does not keep it", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				depl2 = reloadDeployment(depl2)
+				depl4 = reloadDeployment(depl4)
+
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"deployments": [
+						{
+							"id": %d,
+							"state": "%s",
+							"active": true,
+							"deployed_at": %s,
+							"version": %d
+						},
+						{
+							"id": %d,
+							"state": "%s",
+							"deployed_at": %s,
+							"version": %d,
+							"pinned_label": "launch"
+						}
+					]
+				}`, depl2.ID, depl2.State, formattedTimeForJSON(depl2.DeployedAt), depl2.Version,
+					depl4.ID, depl4.State, formattedTimeForJSON(depl4.DeployedAt), depl4.Version,
+				)))
+			})
+		})
+	})
+
+	Describe("PUT /projects/:project_name/deployments/:id/pin", func() {
+		var (
+			err error
+
+			u *user.User
+			t *oauthtoken.OauthToken
+
+			headers http.Header
+			params  url.Values
+			proj    *project.Project
+			depl    *deployment.Deployment
+		)
+
+		BeforeEach(func() {
+			u, _, t = factories.AuthTrio(db)
+
+			proj = &project.Project{
+				Name:   "foo-bar-express",
+				UserID: u.ID,
+			}
+			Expect(db.Create(proj).Error).To(BeNil())
+
+			headers = http.Header{
+				"Authorization": {"Bearer " + t.Token},
+			}
+			params = url.Values{
+				"label": {"v1 launch"},
+			}
+
+			depl = factories.Deployment(db, proj, u, deployment.StateDeployed)
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			url := fmt.Sprintf("%s/projects/foo-bar-express/deployments/%d/pin", s.URL, depl.ID)
+			res, err = testhelper.MakeRequest("PUT", url, params, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProjectRole(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, collab.RoleDeployer, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		It("returns 200 OK with the pinned deployment", func() {
+			doRequest()
+
+			b := &bytes.Buffer{}
+			_, err = b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+			var j map[string]map[string]interface{}
+			Expect(json.Unmarshal(b.Bytes(), &j)).To(Succeed())
+			Expect(j["deployment"]["id"]).To(BeEquivalentTo(depl.ID))
+			Expect(j["deployment"]["pinned_label"]).To(Equal("v1 launch"))
+		})
+
+		It("pins the deployment", func() {
+			doRequest()
+
+			Expect(db.First(depl, depl.ID).Error).To(BeNil())
+			Expect(depl.PinnedLabel).NotTo(BeNil())
+			Expect(*depl.PinnedLabel).To(Equal("v1 launch"))
+		})
+
+		It("tracks a 'Pinned Deployment' event", func() {
+			doRequest()
+
+			trackCall := fakeTracker.TrackCalls.NthCall(1)
+			Expect(trackCall).NotTo(BeNil())
+			Expect(trackCall.Arguments[0]).To(Equal(fmt.Sprintf("%d", u.ID)))
+			Expect(trackCall.Arguments[1]).To(Equal("Pinned Deployment"))
+
+			props, ok := trackCall.Arguments[3].(map[string]interface{})
+			Expect(ok).To(BeTrue())
+			Expect(props["projectName"]).To(Equal(proj.Name))
+			Expect(props["label"]).To(Equal("v1 launch"))
+		})
+
+		Context("when the label is missing", func() {
+			BeforeEach(func() {
+				params.Del("label")
+			})
+
+			It("returns 422 unprocessable entity", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_params",
+					"errors": {
+						"label": "is required"
+					}
+				}`))
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.PinnedLabel).To(BeNil())
+			})
+		})
+
+		Context("when the deployment belongs to another project", func() {
+			BeforeEach(func() {
+				depl = factories.Deployment(db, factories.Project(db, u), u, deployment.StateDeployed)
+			})
+
+			It("returns 404 not found", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "not_found",
+					"error_description": "deployment could not be found"
+				}`))
+			})
+		})
+	})
+
+	Describe("DELETE /projects/:project_name/deployments/:id/pin", func() {
+		var (
+			err error
+
+			u *user.User
+			t *oauthtoken.OauthToken
+
+			headers http.Header
+			proj    *project.Project
+			depl    *deployment.Deployment
+		)
+
+		BeforeEach(func() {
+			u, _, t = factories.AuthTrio(db)
+
+			proj = &project.Project{
+				Name:   "foo-bar-express",
+				UserID: u.ID,
+			}
+			Expect(db.Create(proj).Error).To(BeNil())
+
+			headers = http.Header{
+				"Authorization": {"Bearer " + t.Token},
+			}
+
+			depl = factories.Deployment(db, proj, u, deployment.StateDeployed)
+			Expect(depl.Pin(db, "v1 launch")).To(Succeed())
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			url := fmt.Sprintf("%s/projects/foo-bar-express/deployments/%d/pin", s.URL, depl.ID)
+			res, err = testhelper.MakeRequest("DELETE", url, nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProjectRole(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, collab.RoleDeployer, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		It("returns 200 OK and unpins the deployment", func() {
+			doRequest()
+
+			b := &bytes.Buffer{}
+			_, err = b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+			var j map[string]map[string]interface{}
+			Expect(json.Unmarshal(b.Bytes(), &j)).To(Succeed())
+			Expect(j["deployment"]).NotTo(HaveKey("pinned_label"))
+
+			Expect(db.First(depl, depl.ID).Error).To(BeNil())
+			Expect(depl.PinnedLabel).To(BeNil())
+		})
+
+		It("tracks an 'Unpinned Deployment' event", func() {
+			doRequest()
+
+			trackCall := fakeTracker.TrackCalls.NthCall(1)
+			Expect(trackCall).NotTo(BeNil())
+			Expect(trackCall.Arguments[1]).To(Equal("Unpinned Deployment"))
+		})
+
+		Context("when the deployment does not exist", func() {
+			BeforeEach(func() {
+				Expect(db.Delete(depl).Error).To(BeNil())
+			})
+
+			It("returns 404 not found", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+			})
+		})
 	})
 })
@@ -0,0 +1,152 @@
+package deploytokens
+
+import (
+	"net/http"
+	"strconv"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/deploytoken"
+)
+
+// Index lists the deploy tokens of the current project, without the tokens
+// themselves.
+func Index(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	tokens, err := deploytoken.TokensByProjectID(db, proj.ID)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"deploy_tokens": tokens,
+	})
+}
+
+// Create creates a deploy token for the current project. This is the only
+// time the token is shown.
+func Create(c *gin.Context) {
+	u := controllers.CurrentUser(c)
+	proj := controllers.CurrentProject(c)
+
+	t := &deploytoken.DeployToken{
+		ProjectID:   proj.ID,
+		CreatedByID: u.ID,
+		Name:        c.PostForm("name"),
+	}
+
+	if errs := t.Validate(); errs != nil {
+		c.JSON(422, gin.H{
+			"error":  "invalid_params",
+			"errors": errs,
+		})
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if err := db.Create(t).Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	{
+		var (
+			event = "Created Deploy Token"
+			props = map[string]interface{}{
+				"projectName":     proj.Name,
+				"deployTokenName": t.Name,
+			}
+			context = map[string]interface{}{
+				"ip":         common.GetIP(c.Request),
+				"user_agent": c.Request.UserAgent(),
+			}
+		)
+		if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
+			log.Errorf("failed to track %q event for user ID %d, err: %v",
+				event, u.ID, err)
+		}
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"deploy_token": gin.H{
+			"id":           t.ID,
+			"name":         t.Name,
+			"token":        t.Token,
+			"created_by":   u.Email,
+			"last_used_at": t.LastUsedAt,
+			"created_at":   t.CreatedAt,
+		},
+	})
+}
+
+// Destroy revokes a deploy token of the current project.
+func Destroy(c *gin.Context) {
+	u := controllers.CurrentUser(c)
+	proj := controllers.CurrentProject(c)
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	var t *deploytoken.DeployToken
+	if id, err := strconv.ParseUint(c.Param("id"), 10, 64); err == nil {
+		t, err = deploytoken.FindByID(db, proj.ID, uint(id))
+		if err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
+	}
+
+	if t == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "deploy token could not be found",
+		})
+		return
+	}
+
+	if err := db.Delete(t).Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	{
+		var (
+			event = "Revoked Deploy Token"
+			props = map[string]interface{}{
+				"projectName":     proj.Name,
+				"deployTokenName": t.Name,
+			}
+			context = map[string]interface{}{
+				"ip":         common.GetIP(c.Request),
+				"user_agent": c.Request.UserAgent(),
+			}
+		)
+		if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
+			log.Errorf("failed to track %q event for user ID %d, err: %v",
+				event, u.ID, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"revoked": true,
+	})
+}
@@ -0,0 +1,384 @@
+package deploytokens_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/collab"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/deploytoken"
+	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/apiserver/server"
+	"github.com/nitrous-io/rise-server/pkg/tracker"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+	"github.com/nitrous-io/rise-server/testhelper/fake"
+	"github.com/nitrous-io/rise-server/testhelper/sharedexamples"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "deploytokens")
+}
+
+var _ = Describe("DeployTokens", func() {
+	var (
+		db      *gorm.DB
+		s       *httptest.Server
+		res     *http.Response
+		headers http.Header
+		err     error
+
+		fakeTracker *fake.Tracker
+		origTracker tracker.Trackable
+
+		u    *user.User
+		t    *oauthtoken.OauthToken
+		proj *project.Project
+	)
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+		testhelper.TruncateTables(db.DB())
+
+		origTracker = common.Tracker
+		fakeTracker = &fake.Tracker{}
+		common.Tracker = fakeTracker
+
+		u, _, t = factories.AuthTrio(db)
+
+		headers = http.Header{
+			"Authorization": {"Bearer " + t.Token},
+		}
+
+		proj = factories.Project(db, u, "foo-bar-express")
+	})
+
+	AfterEach(func() {
+		if res != nil {
+			res.Body.Close()
+		}
+		s.Close()
+
+		common.Tracker = origTracker
+	})
+
+	Describe("GET /projects/:project_name/deploy_tokens", func() {
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("GET", s.URL+"/projects/foo-bar-express/deploy_tokens", nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		It("returns 200 OK with the deploy tokens of the project without the tokens", func() {
+			dt := factories.DeployToken(db, proj, u)
+			factories.DeployToken(db, factories.Project(db, u), u) // another project
+
+			doRequest()
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+			var j struct {
+				DeployTokens []map[string]interface{} `json:"deploy_tokens"`
+			}
+			Expect(json.NewDecoder(res.Body).Decode(&j)).To(Succeed())
+
+			Expect(j.DeployTokens).To(HaveLen(1))
+			Expect(j.DeployTokens[0]["id"]).To(BeEquivalentTo(dt.ID))
+			Expect(j.DeployTokens[0]["name"]).To(Equal(dt.Name))
+			Expect(j.DeployTokens[0]["created_by"]).To(Equal(u.Email))
+			Expect(j.DeployTokens[0]["last_used_at"]).To(BeNil())
+			Expect(j.DeployTokens[0]).To(HaveKey("created_at"))
+			Expect(j.DeployTokens[0]).NotTo(HaveKey("token"))
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProjectRole(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, collab.RoleDeployer, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
+
+	Describe("POST /projects/:project_name/deploy_tokens", func() {
+		var params url.Values
+
+		BeforeEach(func() {
+			params = url.Values{
+				"name": {"CircleCI"},
+			}
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("POST", s.URL+"/projects/foo-bar-express/deploy_tokens", params, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		It("returns 201 Created with the token", func() {
+			doRequest()
+
+			Expect(res.StatusCode).To(Equal(http.StatusCreated))
+
+			var j struct {
+				DeployToken map[string]interface{} `json:"deploy_token"`
+			}
+			Expect(json.NewDecoder(res.Body).Decode(&j)).To(Succeed())
+
+			tokens := []*deploytoken.DeployToken{}
+			Expect(db.Where("project_id = ?", proj.ID).Find(&tokens).Error).To(BeNil())
+			Expect(tokens).To(HaveLen(1))
+			Expect(tokens[0].Name).To(Equal("CircleCI"))
+			Expect(tokens[0].CreatedByID).To(Equal(u.ID))
+
+			Expect(j.DeployToken["id"]).To(BeEquivalentTo(tokens[0].ID))
+			Expect(j.DeployToken["name"]).To(Equal("CircleCI"))
+			Expect(j.DeployToken["token"]).To(Equal(tokens[0].Token))
+			Expect(j.DeployToken["created_by"]).To(Equal(u.Email))
+		})
+
+		It("tracks a 'Created Deploy Token' event", func() {
+			doRequest()
+
+			trackCall := fakeTracker.TrackCalls.NthCall(1)
+			Expect(trackCall).NotTo(BeNil())
+			Expect(trackCall.Arguments[0]).To(Equal(fmt.Sprintf("%d", u.ID)))
+			Expect(trackCall.Arguments[1]).To(Equal("Created Deploy Token"))
+
+			props, ok := trackCall.Arguments[3].(map[string]interface{})
+			Expect(ok).To(BeTrue())
+			Expect(props["projectName"]).To(Equal("foo-bar-express"))
+			Expect(props["deployTokenName"]).To(Equal("CircleCI"))
+		})
+
+		Context("when the name is missing", func() {
+			BeforeEach(func() {
+				params = url.Values{}
+			})
+
+			It("returns 422 and does not create a deploy token", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_params",
+					"errors": {
+						"name": "is required"
+					}
+				}`))
+
+				var count int
+				Expect(db.Model(deploytoken.DeployToken{}).Count(&count).Error).To(BeNil())
+				Expect(count).To(Equal(0))
+			})
+		})
+
+		Context("when the request is authenticated with a deploy token", func() {
+			BeforeEach(func() {
+				headers = http.Header{
+					"Authorization": {"Bearer " + factories.DeployToken(db, proj, u).Token},
+				}
+			})
+
+			It("returns 401 Unauthorized", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusUnauthorized))
+			})
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProjectRole(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, collab.RoleDeployer, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
+
+	Describe("DELETE /projects/:project_name/deploy_tokens/:id", func() {
+		var dt *deploytoken.DeployToken
+
+		BeforeEach(func() {
+			dt = factories.DeployToken(db, proj, u)
+		})
+
+		doRequest := func(id string) {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("DELETE", s.URL+"/projects/foo-bar-express/deploy_tokens/"+id, nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		It("returns 200 OK and revokes the deploy token", func() {
+			doRequest(fmt.Sprintf("%d", dt.ID))
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(b.String()).To(MatchJSON(`{
+				"revoked": true
+			}`))
+
+			found, err := deploytoken.FindByToken(db, dt.Token)
+			Expect(err).To(BeNil())
+			Expect(found).To(BeNil())
+
+			trackCall := fakeTracker.TrackCalls.NthCall(1)
+			Expect(trackCall).NotTo(BeNil())
+			Expect(trackCall.Arguments[1]).To(Equal("Revoked Deploy Token"))
+		})
+
+		It("returns 404 Not Found if the deploy token belongs to another project", func() {
+			other := factories.DeployToken(db, factories.Project(db, u), u)
+
+			doRequest(fmt.Sprintf("%d", other.ID))
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+			Expect(b.String()).To(MatchJSON(`{
+				"error": "not_found",
+				"error_description": "deploy token could not be found"
+			}`))
+
+			found, err := deploytoken.FindByToken(db, other.Token)
+			Expect(err).To(BeNil())
+			Expect(found).NotTo(BeNil())
+		})
+
+		It("returns 404 Not Found if the id is not a number", func() {
+			doRequest("foo")
+
+			Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest(fmt.Sprintf("%d", dt.ID))
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, func() *http.Response {
+			doRequest(fmt.Sprintf("%d", dt.ID))
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProjectRole(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, collab.RoleDeployer, func() *http.Response {
+			doRequest(fmt.Sprintf("%d", dt.ID))
+			return res
+		}, nil)
+	})
+
+	Describe("authenticating with a deploy token", func() {
+		var dt *deploytoken.DeployToken
+
+		BeforeEach(func() {
+			dt = factories.DeployToken(db, proj, u)
+			headers = http.Header{
+				"Authorization": {"Bearer " + dt.Token},
+			}
+		})
+
+		doRequest := func(method, path string) {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest(method, s.URL+path, nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		It("reads the status of a deployment of the project and records the use", func() {
+			depl := factories.Deployment(db, proj, u, deployment.StateDeployed)
+
+			doRequest("GET", fmt.Sprintf("/projects/foo-bar-express/deployments/%d", depl.ID))
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+			Expect(db.First(dt, dt.ID).Error).To(BeNil())
+			Expect(dt.LastUsedAt).NotTo(BeNil())
+		})
+
+		It("does not read the deployments of another project", func() {
+			other := factories.Project(db, u)
+			depl := factories.Deployment(db, other, u, deployment.StateDeployed)
+
+			doRequest("GET", fmt.Sprintf("/projects/%s/deployments/%d", other.Name, depl.ID))
+
+			Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+		})
+
+		It("does not authenticate any other request", func() {
+			s = httptest.NewServer(server.New())
+			for _, path := range []string{"/projects/foo-bar-express", "/projects/foo-bar-express/deployments", "/projects"} {
+				res, err = testhelper.MakeRequest("GET", s.URL+path, nil, headers, nil)
+				Expect(err).To(BeNil())
+				Expect(res.StatusCode).To(Equal(http.StatusUnauthorized))
+				res.Body.Close()
+			}
+			res = nil
+
+			Expect(db.First(dt, dt.ID).Error).To(BeNil())
+			Expect(dt.LastUsedAt).To(BeNil())
+		})
+
+		It("returns 401 Unauthorized if the deploy token has been revoked", func() {
+			Expect(db.Delete(dt).Error).To(BeNil())
+
+			doRequest("GET", "/projects/foo-bar-express/deployments/1")
+
+			Expect(res.StatusCode).To(Equal(http.StatusUnauthorized))
+		})
+	})
+})
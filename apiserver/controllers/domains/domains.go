@@ -159,6 +159,24 @@ func Create(c *gin.Context) {
 		return
 	}
 
+	// The domains of a deleted project are released when it is purged, so
+	// that it can be restored with them.
+	held, err := project.IsDomainOfDeletedProject(db, dom.Name)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if held {
+		c.JSON(422, gin.H{
+			"error": "invalid_params",
+			"errors": map[string]interface{}{
+				"name": "is taken",
+			},
+		})
+		return
+	}
+
 	if err := db.Create(dom).Error; err != nil {
 		if e, ok := err.(*pq.Error); ok && e.Code.Name() == "unique_violation" {
 			c.JSON(422, gin.H{
@@ -179,6 +197,7 @@ func Create(c *gin.Context) {
 			DeploymentID:      *proj.ActiveDeploymentID,
 			SkipWebrootUpload: true,
 			SkipInvalidation:  true, // invalidation is not necessary because we are adding a new domain
+			RequestID:         controllers.RequestID(c),
 		})
 		if err != nil {
 			controllers.InternalServerError(c, err)
@@ -271,7 +290,8 @@ func Destroy(c *gin.Context) {
 	}
 
 	m, err := pubsub.NewMessageWithJSON(exchanges.Edges, exchanges.RouteV1Invalidation, &messages.V1InvalidationMessageData{
-		Domains: []string{domainName},
+		Domains:   []string{domainName},
+		RequestID: controllers.RequestID(c),
 	})
 
 	if err != nil {
@@ -14,6 +14,7 @@ import (
 	"github.com/nitrous-io/rise-server/apiserver/dbconn"
 	"github.com/nitrous-io/rise-server/apiserver/models/acmecert"
 	"github.com/nitrous-io/rise-server/apiserver/models/cert"
+	"github.com/nitrous-io/rise-server/apiserver/models/collab"
 	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
 	"github.com/nitrous-io/rise-server/apiserver/models/domain"
 	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
@@ -260,6 +261,30 @@ var _ = Describe("Domains", func() {
 				})
 			})
 
+			Context("when the domain name belongs to a deleted project that can still be restored", func() {
+				BeforeEach(func() {
+					deletedProj := factories.Project(db, u)
+					factories.Domain(db, deletedProj, "www.foo-bar-express.com")
+					Expect(deletedProj.Destroy(db)).To(BeNil())
+
+					doRequest()
+				})
+
+				It("returns 422 unprocessable entity", func() {
+					b := &bytes.Buffer{}
+					_, err := b.ReadFrom(res.Body)
+					Expect(err).To(BeNil())
+
+					Expect(res.StatusCode).To(Equal(422))
+					Expect(b.String()).To(MatchJSON(`{
+						"error": "invalid_params",
+						"errors": {
+							"name": "is taken"
+						}
+					}`))
+				})
+			})
+
 			Context("when the project has reached max number of domains allowed", func() {
 				var origMaxDomains int
 
@@ -389,8 +414,9 @@ var _ = Describe("Domains", func() {
 							"deployment_id": %d,
 							"skip_webroot_upload": true,
 							"skip_invalidation": true,
-							"use_raw_bundle": false
-						}`, *proj.ActiveDeploymentID)))
+							"use_raw_bundle": false,
+							"request_id": %q
+						}`, *proj.ActiveDeploymentID, res.Header.Get("X-Request-Id"))))
 					})
 				})
 
@@ -457,6 +483,13 @@ var _ = Describe("Domains", func() {
 			return res
 		}, nil)
 
+		sharedexamples.ItRequiresProjectRole(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, collab.RoleAdmin, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
 		sharedexamples.ItLocksProject(func() (*gorm.DB, *project.Project) {
 			return db, proj
 		}, func() *http.Response {
@@ -545,8 +578,9 @@ var _ = Describe("Domains", func() {
 				m := testhelper.ConsumeQueue(mq, qName)
 				Expect(m).NotTo(BeNil())
 				Expect(m.Body).To(MatchJSON(fmt.Sprintf(`{
-					"domains": ["%s"]
-				}`, domainName)))
+					"domains": ["%s"],
+					"request_id": "%s"
+				}`, domainName, res.Header.Get("X-Request-Id"))))
 			})
 
 			It("tracks a 'Deleted Custom Domain' event", func() {
@@ -632,8 +666,9 @@ var _ = Describe("Domains", func() {
 					d := testhelper.ConsumeQueue(mq, qName)
 					Expect(d).NotTo(BeNil())
 					Expect(d.Body).To(MatchJSON(fmt.Sprintf(`{
-						"domains": ["%s"]
-					}`, domainName)))
+						"domains": ["%s"],
+						"request_id": "%s"
+					}`, domainName, res.Header.Get("X-Request-Id"))))
 				})
 
 				Context("when cert is a Let's Encrypt ACME cert", func() {
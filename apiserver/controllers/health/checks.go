@@ -0,0 +1,70 @@
+package health
+
+import (
+	"errors"
+	"os"
+
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/pkg/esconn"
+	"github.com/nitrous-io/rise-server/pkg/mqconn"
+	"github.com/nitrous-io/rise-server/shared/s3client"
+)
+
+// storageCheckKey is the key looked up to check that storage is reachable. It
+// does not need to exist.
+const storageCheckKey = "health-check"
+
+// Database checks that a query can be run against PostgreSQL.
+func Database() error {
+	db, err := dbconn.DB()
+	if err != nil {
+		return err
+	}
+
+	var one int
+	return db.DB().QueryRow("SELECT 1").Scan(&one)
+}
+
+// MQ checks that RabbitMQ is connected by opening a channel.
+func MQ() error {
+	mq, err := mqconn.MQ()
+	if err != nil {
+		return err
+	}
+
+	ch, err := mq.Channel()
+	if err != nil {
+		return err
+	}
+	return ch.Close()
+}
+
+// Storage checks that the bucket used for deployments can be reached.
+func Storage() error {
+	_, err := s3client.Exists(storageCheckKey)
+	return err
+}
+
+// Elasticsearch checks that the Elasticsearch cluster used for stats is
+// reachable and not in the red state.
+func Elasticsearch() error {
+	client, err := esconn.ES()
+	if err != nil {
+		return err
+	}
+
+	res, err := client.ClusterHealth().Do()
+	if err != nil {
+		return err
+	}
+	if res.Status == "red" {
+		return errors.New("cluster status is red")
+	}
+	return nil
+}
+
+// StatsEnabled reports whether Elasticsearch is configured, i.e. whether stats
+// are served and the Elasticsearch check applies.
+func StatsEnabled() bool {
+	return os.Getenv("ELASTICSEARCH_URL") != ""
+}
@@ -0,0 +1,32 @@
+package health
+
+import (
+	"github.com/gin-gonic/gin"
+	hc "github.com/nitrous-io/rise-server/pkg/health"
+)
+
+// Checker checks the services the API server depends on. Elasticsearch is only
+// checked if stats are enabled.
+var Checker = newChecker()
+
+func newChecker() *hc.Checker {
+	c := hc.NewChecker()
+	c.Add("database", Database)
+	c.Add("mq", MQ)
+	c.Add("storage", Storage)
+	if StatsEnabled() {
+		c.Add("elasticsearch", Elasticsearch)
+	}
+	return c
+}
+
+// Live responds with 200 OK as long as the server is able to serve requests.
+func Live(c *gin.Context) {
+	hc.LiveHandler().ServeHTTP(c.Writer, c.Request)
+}
+
+// Ready responds with the result of checking each dependency, and 503 Service
+// Unavailable if any of them failed.
+func Ready(c *gin.Context) {
+	Checker.ReadyHandler().ServeHTTP(c.Writer, c.Request)
+}
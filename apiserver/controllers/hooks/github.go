@@ -155,7 +155,8 @@ func GitHubPush(c *gin.Context) {
 	}
 
 	jb, err := job.NewWithJSON(queues.Push, &messages.PushJobData{
-		PushID: pu.ID,
+		PushID:    pu.ID,
+		RequestID: controllers.RequestID(c),
 	})
 	if err != nil {
 		unexpectedErr(err)
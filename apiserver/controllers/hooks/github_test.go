@@ -156,8 +156,9 @@ var _ = Describe("GitHub", func() {
 			d := testhelper.ConsumeQueue(mq, queues.Push)
 			Expect(d).NotTo(BeNil())
 			Expect(d.Body).To(MatchJSON(fmt.Sprintf(`{
-				"push_id": %d
-			}`, push.ID)))
+				"push_id": %d,
+				"request_id": %q
+			}`, push.ID, res.Header.Get("X-Request-Id"))))
 		})
 
 		Context("when request body is empty (i.e. GitHub sends a request with empty body)", func() {
@@ -3,6 +3,7 @@ package jsenvvars
 import (
 	"encoding/json"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jinzhu/gorm"
@@ -78,7 +79,7 @@ func Add(c *gin.Context) {
 		return
 	}
 
-	newDepl, err := deployWithJsEnvVars(db, u, proj, &depl, &currentJsEnvVars)
+	newDepl, err := deployWithJsEnvVars(db, u, proj, &depl, &currentJsEnvVars, controllers.RequestID(c))
 	if err != nil {
 		controllers.InternalServerError(c, err)
 		return
@@ -148,7 +149,7 @@ func Delete(c *gin.Context) {
 		return
 	}
 
-	newDepl, err := deployWithJsEnvVars(db, u, proj, &depl, &currentJsEnvVars)
+	newDepl, err := deployWithJsEnvVars(db, u, proj, &depl, &currentJsEnvVars, controllers.RequestID(c))
 	if err != nil {
 		controllers.InternalServerError(c, err)
 		return
@@ -194,7 +195,200 @@ func Index(c *gin.Context) {
 	return
 }
 
-func deployWithJsEnvVars(db *gorm.DB, u *user.User, proj *project.Project, currentDepl *deployment.Deployment, jsEnvVars *map[string]string) (*deployment.Deployment, error) {
+// Replace replaces all of the JS environment variables of the project with
+// those in the JSON object in the request body, which may be empty, in a
+// single deployment.
+func Replace(c *gin.Context) {
+	u := controllers.CurrentUser(c)
+	proj := controllers.CurrentProject(c)
+
+	var newJsEnvVars map[string]string
+	if err := c.Bind(&newJsEnvVars); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":             "invalid_request",
+			"error_description": "request body is in invalid format",
+		})
+		return
+	}
+	if newJsEnvVars == nil {
+		newJsEnvVars = map[string]string{}
+	}
+
+	if proj.ActiveDeploymentID == nil {
+		c.JSON(http.StatusPreconditionFailed, gin.H{
+			"error":             "precondition_failed",
+			"error_description": "current active deployment could not be found",
+		})
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	var depl deployment.Deployment
+	if err := db.First(&depl, *proj.ActiveDeploymentID).Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	currentJsEnvVars, err := depl.JsEnvVarsMap()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if len(deployment.DiffJsEnvVars(currentJsEnvVars, newJsEnvVars)) == 0 {
+		c.JSON(http.StatusAccepted, gin.H{
+			"deployment": depl.AsJSON(),
+		})
+		return
+	}
+
+	newDepl, err := deployWithJsEnvVars(db, u, proj, &depl, &newJsEnvVars, controllers.RequestID(c))
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"deployment": newDepl.AsJSON(),
+	})
+}
+
+// History lists the changes that were made to the JS environment variables of
+// the project, latest first, with who made them and the versions of the
+// deployments they were made in.
+func History(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	history, err := deployment.JsEnvVarsHistory(db, proj.ID)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	userIDs := []uint{}
+	for _, v := range history {
+		userIDs = append(userIDs, v.UserID)
+	}
+
+	var users []*user.User
+	if len(userIDs) > 0 {
+		if err := db.Unscoped().Where("id IN (?)", userIDs).Find(&users).Error; err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
+	}
+
+	emails := map[uint]string{}
+	for _, u := range users {
+		emails[u.ID] = u.Email
+	}
+	for _, v := range history {
+		v.ChangedBy = emails[v.UserID]
+	}
+
+	if history == nil {
+		history = []*deployment.JsEnvVarsVersion{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"history": history,
+	})
+}
+
+// Rollback restores the JS environment variables of the deployment with the
+// given version, in a new deployment of the files of the active deployment.
+func Rollback(c *gin.Context) {
+	u := controllers.CurrentUser(c)
+	proj := controllers.CurrentProject(c)
+
+	version, err := strconv.ParseInt(c.PostForm("version"), 10, 64)
+	if err != nil {
+		msg := "is not a number"
+		if c.PostForm("version") == "" {
+			msg = "is required"
+		}
+		c.JSON(422, gin.H{
+			"error":  "invalid_params",
+			"errors": map[string]string{"version": msg},
+		})
+		return
+	}
+
+	if proj.ActiveDeploymentID == nil {
+		c.JSON(http.StatusPreconditionFailed, gin.H{
+			"error":             "precondition_failed",
+			"error_description": "current active deployment could not be found",
+		})
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	var depl deployment.Deployment
+	if err := db.First(&depl, *proj.ActiveDeploymentID).Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	var oldDepl deployment.Deployment
+	if err := db.Unscoped().Where("project_id = ? AND version = ?", proj.ID, version).First(&oldDepl).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			c.JSON(422, gin.H{
+				"error":             "invalid_request",
+				"error_description": "deployment with a given version could not be found",
+			})
+			return
+		}
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	currentJsEnvVars, err := depl.JsEnvVarsMap()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	oldJsEnvVars, err := oldDepl.JsEnvVarsMap()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if len(deployment.DiffJsEnvVars(currentJsEnvVars, oldJsEnvVars)) == 0 {
+		c.JSON(http.StatusAccepted, gin.H{
+			"deployment": depl.AsJSON(),
+		})
+		return
+	}
+
+	newDepl, err := deployWithJsEnvVars(db, u, proj, &depl, &oldJsEnvVars, controllers.RequestID(c))
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"deployment": newDepl.AsJSON(),
+	})
+}
+
+func deployWithJsEnvVars(db *gorm.DB, u *user.User, proj *project.Project, currentDepl *deployment.Deployment, jsEnvVars *map[string]string, requestID string) (*deployment.Deployment, error) {
 	updatedJSON, err := json.Marshal(&jsEnvVars)
 	if err != nil {
 		return nil, err
@@ -217,7 +411,10 @@ func deployWithJsEnvVars(db *gorm.DB, u *user.User, proj *project.Project, curre
 		return nil, err
 	}
 
-	j, err := job.NewWithJSON(queues.Build, &messages.BuildJobData{DeploymentID: newDepl.ID})
+	j, err := job.NewWithJSON(queues.Build, &messages.BuildJobData{
+		DeploymentID: newDepl.ID,
+		RequestID:    requestID,
+	})
 	if err != nil {
 		return nil, err
 	}
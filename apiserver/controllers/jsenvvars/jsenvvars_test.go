@@ -188,9 +188,10 @@ var _ = Describe("JSEnvVars", func() {
 				Expect(d).NotTo(BeNil())
 				Expect(d.Body).To(MatchJSON(fmt.Sprintf(`
 					{
-						"deployment_id": %d
+						"deployment_id": %d,
+						"request_id": %q
 					}
-				`, newDepl.ID)))
+				`, newDepl.ID, res.Header.Get("X-Request-Id"))))
 			})
 
 			It("marks the deployment as 'pending_build'", func() {
@@ -379,9 +380,10 @@ var _ = Describe("JSEnvVars", func() {
 				Expect(d).NotTo(BeNil())
 				Expect(d.Body).To(MatchJSON(fmt.Sprintf(`
 					{
-						"deployment_id": %d
+						"deployment_id": %d,
+						"request_id": %q
 					}
-				`, newDepl.ID)))
+				`, newDepl.ID, res.Header.Get("X-Request-Id"))))
 			})
 
 			It("marks the deployment as 'pending_build'", func() {
@@ -553,4 +555,557 @@ var _ = Describe("JSEnvVars", func() {
 			return res
 		}, nil)
 	})
+
+	Describe("PUT /projects/:project_name/jsenvvars", func() {
+		var (
+			fakeS3 *fake.S3
+			origS3 filetransfer.FileTransfer
+
+			params map[string]string
+			depl   *deployment.Deployment
+		)
+
+		BeforeEach(func() {
+			origS3 = s3client.S3
+			fakeS3 = &fake.S3{}
+			s3client.S3 = fakeS3
+
+			params = map[string]string{
+				"foo":    "BAR",
+				"grault": "garply",
+			}
+
+			rawBundle := factories.RawBundle(db, proj)
+
+			now := time.Now()
+			depl = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				State:       deployment.StateDeployed,
+				DeployedAt:  &now,
+				RawBundleID: &rawBundle.ID,
+				JsEnvVars:   []byte(`{"foo":"bar","baz":"qux"}`),
+			})
+			db.Model(proj).UpdateColumn("active_deployment_id", depl.ID)
+		})
+
+		AfterEach(func() {
+			s3client.S3 = origS3
+		})
+
+		doRequestWith := func(b []byte) {
+			s = httptest.NewServer(server.New())
+
+			req, err := http.NewRequest("PUT", s.URL+"/projects/foo-bar-express/jsenvvars", bytes.NewBuffer(b))
+			Expect(err).To(BeNil())
+			req.Header.Add("Content-Type", "application/json")
+
+			for k, v := range headers {
+				for _, h := range v {
+					req.Header.Add(k, h)
+				}
+			}
+
+			res, err = http.DefaultClient.Do(req)
+			Expect(err).To(BeNil())
+		}
+
+		doRequest := func() {
+			b, err := json.Marshal(params)
+			Expect(err).To(BeNil())
+
+			doRequestWith(b)
+		}
+
+		assertNoDeployment := func() {
+			Expect(testhelper.ConsumeQueue(mq, queues.Build)).To(BeNil())
+			var count int
+			Expect(db.Model(deployment.Deployment{}).Where("id <> ?", depl.ID).Count(&count).Error).To(BeNil())
+			Expect(count).To(Equal(0))
+		}
+
+		Context("when active_deployment_id exists", func() {
+			var newDepl *deployment.Deployment
+
+			BeforeEach(func() {
+				doRequest()
+
+				newDepl = &deployment.Deployment{}
+				db.Last(newDepl)
+			})
+
+			It("return 202 with accepted", func() {
+				Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				j := map[string]interface{}{
+					"deployment": map[string]interface{}{
+						"id":      newDepl.ID,
+						"state":   deployment.StatePendingBuild,
+						"version": newDepl.Version,
+					},
+				}
+
+				expectedJSON, err := json.Marshal(j)
+				Expect(err).To(BeNil())
+				Expect(b.String()).To(MatchJSON(expectedJSON))
+			})
+
+			It("replaces all of the variables in a new deployment", func() {
+				Expect(newDepl.ID).NotTo(Equal(depl.ID))
+				Expect(newDepl.JsEnvVars).To(MatchJSON(`{"foo": "BAR", "grault": "garply"}`))
+				Expect(newDepl.RawBundleID).To(Equal(depl.RawBundleID))
+				Expect(newDepl.State).To(Equal(deployment.StatePendingBuild))
+			})
+
+			It("enqueues a build job", func() {
+				d := testhelper.ConsumeQueue(mq, queues.Build)
+				Expect(d).NotTo(BeNil())
+				Expect(d.Body).To(MatchJSON(fmt.Sprintf(`
+					{
+						"deployment_id": %d,
+						"request_id": %q
+					}
+				`, newDepl.ID, res.Header.Get("X-Request-Id"))))
+			})
+		})
+
+		Context("when the request body is an empty object", func() {
+			BeforeEach(func() {
+				doRequestWith([]byte(`{}`))
+			})
+
+			It("removes all of the variables", func() {
+				Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+
+				newDepl := &deployment.Deployment{}
+				Expect(db.Last(newDepl).Error).To(BeNil())
+				Expect(newDepl.ID).NotTo(Equal(depl.ID))
+				Expect(newDepl.JsEnvVars).To(MatchJSON(`{}`))
+			})
+		})
+
+		Context("when there is no changes", func() {
+			BeforeEach(func() {
+				params = map[string]string{
+					"baz": "qux",
+					"foo": "bar",
+				}
+				doRequest()
+			})
+
+			It("return 202 with accepted", func() {
+				Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				j := map[string]interface{}{
+					"deployment": map[string]interface{}{
+						"id":          depl.ID,
+						"state":       depl.State,
+						"version":     depl.Version,
+						"deployed_at": depl.DeployedAt,
+					},
+				}
+
+				expectedJSON, err := json.Marshal(j)
+				Expect(err).To(BeNil())
+				Expect(b.String()).To(MatchJSON(expectedJSON))
+
+				assertNoDeployment()
+			})
+		})
+
+		DescribeTable("errors",
+			func(setup func(), expectedCode int, expectedBody string) {
+				setup()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(expectedCode))
+				Expect(b.String()).To(MatchJSON(expectedBody))
+
+				assertNoDeployment()
+			},
+			Entry("when there is no active deployment", func() {
+				db.Model(proj).UpdateColumn("active_deployment_id", nil)
+				doRequest()
+			}, http.StatusPreconditionFailed, `{
+				"error":             "precondition_failed",
+				"error_description": "current active deployment could not be found"
+			}`),
+			Entry("when request body is invalid json", func() {
+				doRequestWith([]byte(`{hello`))
+			}, http.StatusBadRequest, `{
+				"error": "invalid_request",
+				"error_description": "request body is in invalid format"
+			}`),
+		)
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, func() {
+			assertNoDeployment()
+		})
+
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, func() {
+			assertNoDeployment()
+		})
+
+		sharedexamples.ItLocksProject(func() (*gorm.DB, *project.Project) {
+			return db, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, func() {
+			assertNoDeployment()
+		})
+	})
+
+	Describe("GET /projects/:project_name/jsenvvars/history", func() {
+		var (
+			u2     *user.User
+			d1, d2 *deployment.Deployment
+		)
+
+		BeforeEach(func() {
+			u2 = factories.User(db)
+
+			d1 = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				State:     deployment.StateDeployed,
+				JsEnvVars: []byte(`{"foo":"bar"}`),
+			})
+			d2 = factories.DeploymentWithAttrs(db, proj, u2, deployment.Deployment{
+				State:     deployment.StateDeployed,
+				JsEnvVars: []byte(`{"baz":"qux"}`),
+			})
+			db.Model(proj).UpdateColumn("active_deployment_id", d2.ID)
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("GET", s.URL+"/projects/foo-bar-express/jsenvvars/history", nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		It("returns the changes, latest first", func() {
+			doRequest()
+			Expect(db.First(d1, d1.ID).Error).To(BeNil())
+			Expect(db.First(d2, d2.ID).Error).To(BeNil())
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+				"history": [
+					{
+						"deployment_id": %d,
+						"version": %d,
+						"changed_by": %q,
+						"changed_at": %s,
+						"changes": [
+							{"key": "baz", "old_value": null, "new_value": "qux"},
+							{"key": "foo", "old_value": "bar", "new_value": null}
+						]
+					},
+					{
+						"deployment_id": %d,
+						"version": %d,
+						"changed_by": %q,
+						"changed_at": %s,
+						"changes": [
+							{"key": "foo", "old_value": null, "new_value": "bar"}
+						]
+					}
+				]
+			}`, d2.ID, d2.Version, u2.Email, jsonTime(*d2.DeployedAt),
+				d1.ID, d1.Version, u.Email, jsonTime(*d1.DeployedAt))))
+		})
+
+		Context("when the project has never been deployed", func() {
+			BeforeEach(func() {
+				Expect(db.Unscoped().Where("project_id = ?", proj.ID).Delete(deployment.Deployment{}).Error).To(BeNil())
+			})
+
+			It("returns an empty history", func() {
+				doRequest()
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(b.String()).To(MatchJSON(`{"history": []}`))
+			})
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
+
+	Describe("POST /projects/:project_name/jsenvvars/rollback", func() {
+		var (
+			fakeS3 *fake.S3
+			origS3 filetransfer.FileTransfer
+
+			params url.Values
+			d1, d3 *deployment.Deployment
+		)
+
+		BeforeEach(func() {
+			origS3 = s3client.S3
+			fakeS3 = &fake.S3{}
+			s3client.S3 = fakeS3
+
+			rawBundle1 := factories.RawBundle(db, proj)
+			rawBundle2 := factories.RawBundle(db, proj)
+
+			d1 = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				State:       deployment.StateDeployed,
+				RawBundleID: &rawBundle1.ID,
+				JsEnvVars:   []byte(`{"foo":"bar"}`),
+			})
+			factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				State:       deployment.StateDeployed,
+				RawBundleID: &rawBundle2.ID,
+				JsEnvVars:   []byte(`{"foo":"bar"}`),
+			})
+			d3 = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				State:       deployment.StateDeployed,
+				RawBundleID: &rawBundle2.ID,
+				JsEnvVars:   []byte(`{"foo":"baz","qux":"quux"}`),
+			})
+			db.Model(proj).UpdateColumn("active_deployment_id", d3.ID)
+
+			params = url.Values{
+				"version": {fmt.Sprintf("%d", d1.Version)},
+			}
+		})
+
+		AfterEach(func() {
+			s3client.S3 = origS3
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("POST", s.URL+"/projects/foo-bar-express/jsenvvars/rollback", params, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		assertNoDeployment := func() {
+			Expect(testhelper.ConsumeQueue(mq, queues.Build)).To(BeNil())
+			var count int
+			Expect(db.Model(deployment.Deployment{}).Where("project_id = ?", proj.ID).Count(&count).Error).To(BeNil())
+			Expect(count).To(Equal(3))
+		}
+
+		Context("when the deployment exists", func() {
+			var newDepl *deployment.Deployment
+
+			BeforeEach(func() {
+				doRequest()
+
+				newDepl = &deployment.Deployment{}
+				db.Last(newDepl)
+			})
+
+			It("return 202 with accepted", func() {
+				Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				j := map[string]interface{}{
+					"deployment": map[string]interface{}{
+						"id":      newDepl.ID,
+						"state":   deployment.StatePendingBuild,
+						"version": newDepl.Version,
+					},
+				}
+
+				expectedJSON, err := json.Marshal(j)
+				Expect(err).To(BeNil())
+				Expect(b.String()).To(MatchJSON(expectedJSON))
+			})
+
+			It("restores the variables of the deployment with the files of the active deployment", func() {
+				Expect(newDepl.ID).NotTo(Equal(d3.ID))
+				Expect(newDepl.JsEnvVars).To(MatchJSON(`{"foo": "bar"}`))
+				Expect(newDepl.RawBundleID).To(Equal(d3.RawBundleID))
+				Expect(newDepl.RawBundleID).NotTo(Equal(d1.RawBundleID))
+			})
+
+			It("enqueues a build job", func() {
+				d := testhelper.ConsumeQueue(mq, queues.Build)
+				Expect(d).NotTo(BeNil())
+				Expect(d.Body).To(MatchJSON(fmt.Sprintf(`
+					{
+						"deployment_id": %d,
+						"request_id": %q
+					}
+				`, newDepl.ID, res.Header.Get("X-Request-Id"))))
+			})
+		})
+
+		Context("when the deployment has been deleted", func() {
+			BeforeEach(func() {
+				Expect(db.Delete(d1).Error).To(BeNil())
+				doRequest()
+			})
+
+			It("still restores its variables", func() {
+				Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+
+				newDepl := &deployment.Deployment{}
+				Expect(db.Last(newDepl).Error).To(BeNil())
+				Expect(newDepl.ID).NotTo(Equal(d3.ID))
+				Expect(newDepl.JsEnvVars).To(MatchJSON(`{"foo": "bar"}`))
+			})
+		})
+
+		Context("when the variables are the same as the active deployment's", func() {
+			BeforeEach(func() {
+				params.Set("version", fmt.Sprintf("%d", d3.Version))
+				doRequest()
+			})
+
+			It("return 202 with accepted", func() {
+				Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(db.First(d3, d3.ID).Error).To(BeNil())
+				j := map[string]interface{}{
+					"deployment": map[string]interface{}{
+						"id":          d3.ID,
+						"state":       d3.State,
+						"version":     d3.Version,
+						"deployed_at": d3.DeployedAt,
+					},
+				}
+
+				expectedJSON, err := json.Marshal(j)
+				Expect(err).To(BeNil())
+				Expect(b.String()).To(MatchJSON(expectedJSON))
+
+				assertNoDeployment()
+			})
+		})
+
+		DescribeTable("errors",
+			func(setup func(), expectedCode int, expectedBody string) {
+				setup()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(expectedCode))
+				Expect(b.String()).To(MatchJSON(expectedBody))
+
+				assertNoDeployment()
+			},
+			Entry("when version is missing", func() {
+				params.Del("version")
+				doRequest()
+			}, 422, `{
+				"error": "invalid_params",
+				"errors": {
+					"version": "is required"
+				}
+			}`),
+			Entry("when version is not a number", func() {
+				params.Set("version", "v1")
+				doRequest()
+			}, 422, `{
+				"error": "invalid_params",
+				"errors": {
+					"version": "is not a number"
+				}
+			}`),
+			Entry("when there is no active deployment", func() {
+				db.Model(proj).UpdateColumn("active_deployment_id", nil)
+				doRequest()
+			}, http.StatusPreconditionFailed, `{
+				"error":             "precondition_failed",
+				"error_description": "current active deployment could not be found"
+			}`),
+			Entry("when the version does not exist", func() {
+				params.Set("version", "100")
+				doRequest()
+			}, 422, `{
+				"error": "invalid_request",
+				"error_description": "deployment with a given version could not be found"
+			}`),
+			Entry("when the version belongs to another project", func() {
+				factories.DeploymentWithAttrs(db, nil, nil, deployment.Deployment{
+					State:   deployment.StateDeployed,
+					Version: 100,
+				})
+				params.Set("version", "100")
+				doRequest()
+			}, 422, `{
+				"error": "invalid_request",
+				"error_description": "deployment with a given version could not be found"
+			}`),
+		)
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItLocksProject(func() (*gorm.DB, *project.Project) {
+			return db, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
 })
+
+// jsonTime returns t as it is marshaled to JSON.
+func jsonTime(t time.Time) string {
+	b, err := json.Marshal(t)
+	Expect(err).To(BeNil())
+	return string(b)
+}
@@ -0,0 +1,441 @@
+package organizations
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/organization"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+)
+
+// Create creates an organization, with the current user as its owner.
+func Create(c *gin.Context) {
+	u := controllers.CurrentUser(c)
+
+	org := &organization.Organization{
+		Name: strings.ToLower(c.PostForm("name")),
+	}
+
+	if errs := org.Validate(); errs != nil {
+		c.JSON(422, gin.H{
+			"error":  "invalid_params",
+			"errors": errs,
+		})
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	tx := db.Begin()
+	if err := tx.Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+	defer tx.Rollback()
+
+	if err := tx.Create(org).Error; err != nil {
+		if e, ok := err.(*pq.Error); ok && e.Code.Name() == "unique_violation" {
+			c.JSON(422, gin.H{
+				"error": "invalid_params",
+				"errors": map[string]interface{}{
+					"name": "is taken",
+				},
+			})
+			return
+		}
+
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if err := org.AddMember(tx, u, organization.RoleOwner); err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	// Re-fetch from db to get correct timestamps.
+	if err := db.First(org, org.ID).Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	track(c, u, "Created Organization", map[string]interface{}{
+		"organizationName": org.Name,
+	})
+
+	c.JSON(http.StatusCreated, gin.H{
+		"organization": org.AsJSON(),
+	})
+}
+
+// Index lists the organizations that the current user is a member of, and the
+// invitations to organizations they have not accepted yet.
+func Index(c *gin.Context) {
+	u := controllers.CurrentUser(c)
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	orgs, err := organization.OrganizationsByUserID(db, u.ID)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	orgsAsJSON := []interface{}{}
+	for _, org := range orgs {
+		orgsAsJSON = append(orgsAsJSON, org.AsJSON())
+	}
+
+	invs, err := organization.InvitationsByUserID(db, u.ID)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"organizations": orgsAsJSON,
+		"invitations":   invs,
+	})
+}
+
+// Show returns the organization with its members.
+func Show(c *gin.Context) {
+	org := controllers.CurrentOrganization(c)
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	members, err := org.Members(db)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"organization": org.AsJSON(),
+		"members":      members,
+	})
+}
+
+// RemoveMember removes a member from the organization. Owners can remove
+// anyone, and members can remove themselves.
+func RemoveMember(c *gin.Context) {
+	currUser := controllers.CurrentUser(c)
+	org := controllers.CurrentOrganization(c)
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	u, err := user.FindByEmail(db, c.Param("email"))
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if u == nil || u.ID != currUser.ID {
+		m, err := organization.Membership(db, org.ID, currUser.ID)
+		if err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
+		if m == nil || m.Role != organization.RoleOwner {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":             "forbidden",
+				"error_description": "only owners can remove other members",
+			})
+			return
+		}
+	}
+
+	if u == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "member could not be found",
+		})
+		return
+	}
+
+	if err := org.RemoveMember(db, u); err != nil {
+		switch err {
+		case organization.ErrNotMember:
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":             "not_found",
+				"error_description": "member could not be found",
+			})
+		case organization.ErrLastOwner:
+			c.JSON(422, gin.H{
+				"error":             "invalid_request",
+				"error_description": "the last owner of an organization cannot be removed",
+			})
+		default:
+			controllers.InternalServerError(c, err)
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"removed": true,
+	})
+}
+
+// Invite invites the user with the given email to become a member of the
+// organization, and emails them about it.
+func Invite(c *gin.Context) {
+	currUser := controllers.CurrentUser(c)
+	org := controllers.CurrentOrganization(c)
+
+	role := c.PostForm("role")
+	if role == "" {
+		role = organization.RoleMember
+	}
+	if !organization.IsValidRole(role) {
+		c.JSON(422, gin.H{
+			"error": "invalid_params",
+			"errors": map[string]string{
+				"role": "is invalid",
+			},
+		})
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	u, err := user.FindByEmail(db, c.PostForm("email"))
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+	if u == nil || u.ConfirmedAt == nil {
+		c.JSON(422, gin.H{
+			"error":             "invalid_params",
+			"error_description": "email is not found",
+		})
+		return
+	}
+
+	tx := db.Begin()
+	if err := tx.Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := org.Invite(tx, u, currUser, role); err != nil {
+		switch err {
+		case organization.ErrMemberAlreadyExists:
+			c.JSON(http.StatusConflict, gin.H{
+				"error":             "already_exists",
+				"error_description": "user is already a member",
+			})
+		case organization.ErrInvitationAlreadyExists:
+			c.JSON(http.StatusConflict, gin.H{
+				"error":             "already_exists",
+				"error_description": "user has already been invited",
+			})
+		default:
+			controllers.InternalServerError(c, err)
+		}
+		return
+	}
+
+	if err := sendInvitationEmail(org, currUser, u); err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	track(c, currUser, "Invited Organization Member", map[string]interface{}{
+		"organizationName": org.Name,
+		"memberEmail":      u.Email,
+	})
+
+	c.JSON(http.StatusCreated, gin.H{
+		"invited": true,
+	})
+}
+
+// RevokeInvitation deletes the invitation of the user with the given email.
+func RevokeInvitation(c *gin.Context) {
+	org := controllers.CurrentOrganization(c)
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	notFound := func() {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "invitation could not be found",
+		})
+	}
+
+	u, err := user.FindByEmail(db, c.Param("email"))
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+	if u == nil {
+		notFound()
+		return
+	}
+
+	inv, err := organization.FindInvitation(db, org.ID, u.ID)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+	if inv == nil {
+		notFound()
+		return
+	}
+
+	if err := db.Delete(inv).Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"revoked": true,
+	})
+}
+
+// AcceptInvitation makes the current user a member of the organization that
+// they have been invited to.
+func AcceptInvitation(c *gin.Context) {
+	u := controllers.CurrentUser(c)
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	notFound := func() {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "invitation could not be found",
+		})
+	}
+
+	org, err := organization.FindByName(db, c.Param("org_name"))
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+	if org == nil {
+		notFound()
+		return
+	}
+
+	inv, err := organization.FindInvitation(db, org.ID, u.ID)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+	if inv == nil {
+		notFound()
+		return
+	}
+
+	tx := db.Begin()
+	if err := tx.Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+	defer tx.Rollback()
+
+	if err := inv.Accept(tx); err != nil {
+		if err == organization.ErrMemberAlreadyExists {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":             "already_exists",
+				"error_description": "user is already a member",
+			})
+			return
+		}
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	track(c, u, "Joined Organization", map[string]interface{}{
+		"organizationName": org.Name,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"organization": org.AsJSON(),
+	})
+}
+
+func track(c *gin.Context, u *user.User, event string, props map[string]interface{}) {
+	context := map[string]interface{}{
+		"ip":         common.GetIP(c.Request),
+		"user_agent": c.Request.UserAgent(),
+	}
+	if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
+		log.Errorf("failed to track %q event for user ID %d, err: %v",
+			event, u.ID, err)
+	}
+}
+
+func sendInvitationEmail(org *organization.Organization, from, to *user.User) error {
+	subject := from.Email + " has invited you to join " + org.Name + " on PubStorm"
+
+	txt := from.Email + " has invited you to join the PubStorm organization \"" + org.Name + "\", which will give you access to all of its projects.\n\n" +
+		"To accept the invitation, please log in with the PubStorm CLI or API and join the organization.\n\n" +
+		"Thanks,\n" +
+		"PubStorm"
+
+	html := "<p>" + from.Email + " has invited you to join the PubStorm organization \"" + org.Name + "\", which will give you access to all of its projects.</p>" +
+		"<p>To accept the invitation, please log in with the PubStorm CLI or API and join the organization.</p>" +
+		"<p>Thanks,<br />" +
+		"PubStorm</p>"
+
+	return common.SendMail(
+		[]string{to.Email}, // tos
+		nil,                // ccs
+		nil,                // bccs
+		subject,            // subject
+		txt,                // text body
+		html,               // html body
+	)
+}
@@ -0,0 +1,613 @@
+package organizations_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
+	"github.com/nitrous-io/rise-server/apiserver/models/organization"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/apiserver/server"
+	"github.com/nitrous-io/rise-server/pkg/mailer"
+	"github.com/nitrous-io/rise-server/pkg/tracker"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+	"github.com/nitrous-io/rise-server/testhelper/fake"
+	"github.com/nitrous-io/rise-server/testhelper/sharedexamples"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "organizations")
+}
+
+var _ = Describe("Organizations", func() {
+	var (
+		db *gorm.DB
+
+		s   *httptest.Server
+		res *http.Response
+		err error
+
+		u *user.User
+		t *oauthtoken.OauthToken
+
+		headers http.Header
+
+		fakeMailer *fake.Mailer
+		origMailer mailer.Mailer
+
+		fakeTracker *fake.Tracker
+		origTracker tracker.Trackable
+	)
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+
+		testhelper.TruncateTables(db.DB())
+		u, _, t = factories.AuthTrio(db)
+
+		headers = http.Header{
+			"Authorization": {"Bearer " + t.Token},
+		}
+
+		origMailer = common.Mailer
+		fakeMailer = &fake.Mailer{}
+		common.Mailer = fakeMailer
+
+		origTracker = common.Tracker
+		fakeTracker = &fake.Tracker{}
+		common.Tracker = fakeTracker
+	})
+
+	AfterEach(func() {
+		common.Mailer = origMailer
+		common.Tracker = origTracker
+
+		if res != nil {
+			res.Body.Close()
+		}
+		s.Close()
+	})
+
+	decodeBody := func(v interface{}) {
+		Expect(json.NewDecoder(res.Body).Decode(v)).To(Succeed())
+	}
+
+	Describe("POST /organizations", func() {
+		var params url.Values
+
+		BeforeEach(func() {
+			params = url.Values{
+				"name": {"Acme-Inc"},
+			}
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("POST", s.URL+"/organizations", params, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		It("returns 201 created and makes the current user its owner", func() {
+			doRequest()
+
+			Expect(res.StatusCode).To(Equal(http.StatusCreated))
+
+			org, err := organization.FindByName(db, "acme-inc")
+			Expect(err).To(BeNil())
+			Expect(org).NotTo(BeNil())
+
+			var j map[string]map[string]interface{}
+			decodeBody(&j)
+			Expect(j["organization"]["name"]).To(Equal("acme-inc"))
+
+			m, err := organization.Membership(db, org.ID, u.ID)
+			Expect(err).To(BeNil())
+			Expect(m.Role).To(Equal(organization.RoleOwner))
+		})
+
+		It("tracks a 'Created Organization' event", func() {
+			doRequest()
+
+			trackCall := fakeTracker.TrackCalls.NthCall(1)
+			Expect(trackCall).NotTo(BeNil())
+			Expect(trackCall.Arguments[0]).To(Equal(fmt.Sprintf("%d", u.ID)))
+			Expect(trackCall.Arguments[1]).To(Equal("Created Organization"))
+
+			props, ok := trackCall.Arguments[3].(map[string]interface{})
+			Expect(ok).To(BeTrue())
+			Expect(props["organizationName"]).To(Equal("acme-inc"))
+		})
+
+		Context("when the name is invalid", func() {
+			BeforeEach(func() {
+				params.Set("name", "a")
+			})
+
+			It("returns 422 unprocessable entity", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(422))
+
+				var j map[string]interface{}
+				decodeBody(&j)
+				Expect(j["error"]).To(Equal("invalid_params"))
+				Expect(j["errors"]).To(Equal(map[string]interface{}{
+					"name": "is too short (min. 3 characters)",
+				}))
+			})
+		})
+
+		Context("when the name is taken", func() {
+			BeforeEach(func() {
+				Expect(db.Create(&organization.Organization{Name: "acme-inc"}).Error).To(BeNil())
+			})
+
+			It("returns 422 unprocessable entity", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(422))
+
+				var j map[string]interface{}
+				decodeBody(&j)
+				Expect(j["errors"]).To(Equal(map[string]interface{}{
+					"name": "is taken",
+				}))
+			})
+		})
+	})
+
+	Describe("GET /organizations", func() {
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("GET", s.URL+"/organizations", nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		It("returns the organizations of the user and pending invitations", func() {
+			org1 := factories.Organization(db, u)
+			u2 := factories.User(db)
+			org2 := factories.Organization(db, u2)
+			Expect(org2.AddMember(db, u, organization.RoleMember)).To(Succeed())
+			org3 := factories.Organization(db, u2)
+			_, err := org3.Invite(db, u, u2, organization.RoleOwner)
+			Expect(err).To(BeNil())
+			factories.Organization(db, u2) // not a member
+
+			doRequest()
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+			var j struct {
+				Organizations []map[string]interface{} `json:"organizations"`
+				Invitations   []map[string]interface{} `json:"invitations"`
+			}
+			decodeBody(&j)
+
+			Expect(j.Organizations).To(HaveLen(2))
+			Expect(j.Organizations[0]["name"]).To(Equal(org1.Name))
+			Expect(j.Organizations[0]["role"]).To(Equal(organization.RoleOwner))
+			Expect(j.Organizations[1]["name"]).To(Equal(org2.Name))
+			Expect(j.Organizations[1]["role"]).To(Equal(organization.RoleMember))
+
+			Expect(j.Invitations).To(HaveLen(1))
+			Expect(j.Invitations[0]["organization"]).To(Equal(org3.Name))
+			Expect(j.Invitations[0]["invited_by"]).To(Equal(u2.Email))
+			Expect(j.Invitations[0]["role"]).To(Equal(organization.RoleOwner))
+		})
+	})
+
+	Describe("GET /organizations/:org_name", func() {
+		var org *organization.Organization
+
+		BeforeEach(func() {
+			org = factories.Organization(db, nil)
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("GET", s.URL+"/organizations/"+org.Name, nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		It("returns 404 not found if the user is not a member", func() {
+			doRequest()
+
+			Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+
+			var j map[string]interface{}
+			decodeBody(&j)
+			Expect(j).To(Equal(map[string]interface{}{
+				"error":             "not_found",
+				"error_description": "organization could not be found",
+			}))
+		})
+
+		It("returns the organization with its members", func() {
+			Expect(org.AddMember(db, u, organization.RoleMember)).To(Succeed())
+
+			doRequest()
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+			var j struct {
+				Organization map[string]interface{}   `json:"organization"`
+				Members      []map[string]interface{} `json:"members"`
+			}
+			decodeBody(&j)
+
+			Expect(j.Organization["name"]).To(Equal(org.Name))
+			Expect(j.Members).To(HaveLen(2))
+			Expect(j.Members).To(ContainElement(map[string]interface{}{
+				"email": u.Email,
+				"role":  organization.RoleMember,
+			}))
+		})
+	})
+
+	Describe("DELETE /organizations/:org_name/members/:email", func() {
+		var (
+			org   *organization.Organization
+			owner *user.User
+			u2    *user.User
+			email string
+		)
+
+		BeforeEach(func() {
+			owner = factories.User(db)
+			org = factories.Organization(db, owner)
+			u2 = factories.User(db)
+			Expect(org.AddMember(db, u2, organization.RoleMember)).To(Succeed())
+			email = u2.Email
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("DELETE", s.URL+"/organizations/"+org.Name+"/members/"+email, nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		Context("when the current user is an owner", func() {
+			BeforeEach(func() {
+				Expect(org.AddMember(db, u, organization.RoleOwner)).To(Succeed())
+			})
+
+			It("removes the member", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				var j map[string]interface{}
+				decodeBody(&j)
+				Expect(j).To(Equal(map[string]interface{}{"removed": true}))
+
+				m, err := organization.Membership(db, org.ID, u2.ID)
+				Expect(err).To(BeNil())
+				Expect(m).To(BeNil())
+			})
+
+			It("returns 404 if the user is not a member", func() {
+				email = factories.User(db).Email
+
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+
+				var j map[string]interface{}
+				decodeBody(&j)
+				Expect(j["error_description"]).To(Equal("member could not be found"))
+			})
+
+			It("does not remove the last owner", func() {
+				Expect(org.RemoveMember(db, owner)).To(Succeed())
+				email = u.Email
+
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(422))
+
+				var j map[string]interface{}
+				decodeBody(&j)
+				Expect(j).To(Equal(map[string]interface{}{
+					"error":             "invalid_request",
+					"error_description": "the last owner of an organization cannot be removed",
+				}))
+			})
+		})
+
+		Context("when the current user is a member", func() {
+			BeforeEach(func() {
+				Expect(org.AddMember(db, u, organization.RoleMember)).To(Succeed())
+			})
+
+			It("returns 403 forbidden when removing another member", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusForbidden))
+
+				var j map[string]interface{}
+				decodeBody(&j)
+				Expect(j).To(Equal(map[string]interface{}{
+					"error":             "forbidden",
+					"error_description": "only owners can remove other members",
+				}))
+
+				m, err := organization.Membership(db, org.ID, u2.ID)
+				Expect(err).To(BeNil())
+				Expect(m).NotTo(BeNil())
+			})
+
+			It("allows the user to leave the organization", func() {
+				email = u.Email
+
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				m, err := organization.Membership(db, org.ID, u.ID)
+				Expect(err).To(BeNil())
+				Expect(m).To(BeNil())
+			})
+		})
+	})
+
+	Describe("POST /organizations/:org_name/invitations", func() {
+		var (
+			org    *organization.Organization
+			u2     *user.User
+			params url.Values
+		)
+
+		BeforeEach(func() {
+			org = factories.Organization(db, u)
+			u2 = factories.User(db)
+			params = url.Values{
+				"email": {u2.Email},
+			}
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("POST", s.URL+"/organizations/"+org.Name+"/invitations", params, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		It("invites the user as a member and emails them", func() {
+			doRequest()
+
+			Expect(res.StatusCode).To(Equal(http.StatusCreated))
+
+			var j map[string]interface{}
+			decodeBody(&j)
+			Expect(j).To(Equal(map[string]interface{}{"invited": true}))
+
+			inv, err := organization.FindInvitation(db, org.ID, u2.ID)
+			Expect(err).To(BeNil())
+			Expect(inv).NotTo(BeNil())
+			Expect(inv.Role).To(Equal(organization.RoleMember))
+			Expect(inv.InvitedByID).To(Equal(u.ID))
+
+			Expect(fakeMailer.SendMailCalled).To(BeTrue())
+			Expect(fakeMailer.Tos).To(Equal([]string{u2.Email}))
+			Expect(fakeMailer.Subject).To(ContainSubstring(org.Name))
+		})
+
+		It("tracks an 'Invited Organization Member' event", func() {
+			doRequest()
+
+			trackCall := fakeTracker.TrackCalls.NthCall(1)
+			Expect(trackCall).NotTo(BeNil())
+			Expect(trackCall.Arguments[1]).To(Equal("Invited Organization Member"))
+		})
+
+		It("invites the user with the given role", func() {
+			params.Set("role", organization.RoleOwner)
+
+			doRequest()
+
+			Expect(res.StatusCode).To(Equal(http.StatusCreated))
+
+			inv, err := organization.FindInvitation(db, org.ID, u2.ID)
+			Expect(err).To(BeNil())
+			Expect(inv.Role).To(Equal(organization.RoleOwner))
+		})
+
+		It("returns 422 if the role is invalid", func() {
+			params.Set("role", "admin")
+
+			doRequest()
+
+			Expect(res.StatusCode).To(Equal(422))
+
+			var j map[string]interface{}
+			decodeBody(&j)
+			Expect(j["errors"]).To(Equal(map[string]interface{}{
+				"role": "is invalid",
+			}))
+		})
+
+		It("returns 422 if the user does not exist", func() {
+			params.Set("email", "nobody@example.com")
+
+			doRequest()
+
+			Expect(res.StatusCode).To(Equal(422))
+
+			var j map[string]interface{}
+			decodeBody(&j)
+			Expect(j["error_description"]).To(Equal("email is not found"))
+		})
+
+		It("returns 409 if the user is already a member", func() {
+			Expect(org.AddMember(db, u2, organization.RoleMember)).To(Succeed())
+
+			doRequest()
+
+			Expect(res.StatusCode).To(Equal(http.StatusConflict))
+
+			var j map[string]interface{}
+			decodeBody(&j)
+			Expect(j["error_description"]).To(Equal("user is already a member"))
+		})
+
+		It("returns 409 if the user has already been invited", func() {
+			_, err := org.Invite(db, u2, u, organization.RoleMember)
+			Expect(err).To(BeNil())
+
+			doRequest()
+
+			Expect(res.StatusCode).To(Equal(http.StatusConflict))
+
+			var j map[string]interface{}
+			decodeBody(&j)
+			Expect(j["error_description"]).To(Equal("user has already been invited"))
+		})
+
+		It("returns 404 if the current user is not an owner", func() {
+			org = factories.Organization(db, nil)
+			Expect(org.AddMember(db, u, organization.RoleMember)).To(Succeed())
+
+			doRequest()
+
+			Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+
+			inv, err := organization.FindInvitation(db, org.ID, u2.ID)
+			Expect(err).To(BeNil())
+			Expect(inv).To(BeNil())
+		})
+	})
+
+	Describe("DELETE /organizations/:org_name/invitations/:email", func() {
+		var (
+			org *organization.Organization
+			u2  *user.User
+		)
+
+		BeforeEach(func() {
+			org = factories.Organization(db, u)
+			u2 = factories.User(db)
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("DELETE", s.URL+"/organizations/"+org.Name+"/invitations/"+u2.Email, nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		It("revokes the invitation", func() {
+			_, err := org.Invite(db, u2, u, organization.RoleMember)
+			Expect(err).To(BeNil())
+
+			doRequest()
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+			var j map[string]interface{}
+			decodeBody(&j)
+			Expect(j).To(Equal(map[string]interface{}{"revoked": true}))
+
+			inv, err := organization.FindInvitation(db, org.ID, u2.ID)
+			Expect(err).To(BeNil())
+			Expect(inv).To(BeNil())
+		})
+
+		It("returns 404 if there is no invitation", func() {
+			doRequest()
+
+			Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+
+			var j map[string]interface{}
+			decodeBody(&j)
+			Expect(j["error_description"]).To(Equal("invitation could not be found"))
+		})
+	})
+
+	Describe("POST /organizations/:org_name/invitation/accept", func() {
+		var org *organization.Organization
+
+		BeforeEach(func() {
+			org = factories.Organization(db, nil)
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("POST", s.URL+"/organizations/"+org.Name+"/invitation/accept", nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		It("makes the user a member", func() {
+			_, err := org.Invite(db, u, factories.User(db), organization.RoleOwner)
+			Expect(err).To(BeNil())
+
+			doRequest()
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+			var j map[string]map[string]interface{}
+			decodeBody(&j)
+			Expect(j["organization"]["name"]).To(Equal(org.Name))
+
+			m, err := organization.Membership(db, org.ID, u.ID)
+			Expect(err).To(BeNil())
+			Expect(m.Role).To(Equal(organization.RoleOwner))
+
+			trackCall := fakeTracker.TrackCalls.NthCall(1)
+			Expect(trackCall).NotTo(BeNil())
+			Expect(trackCall.Arguments[1]).To(Equal("Joined Organization"))
+		})
+
+		It("returns 404 if the user has not been invited", func() {
+			doRequest()
+
+			Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+
+			var j map[string]interface{}
+			decodeBody(&j)
+			Expect(j["error_description"]).To(Equal("invitation could not be found"))
+
+			m, err := organization.Membership(db, org.ID, u.ID)
+			Expect(err).To(BeNil())
+			Expect(m).To(BeNil())
+		})
+	})
+})
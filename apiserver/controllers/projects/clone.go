@@ -0,0 +1,202 @@
+package projects
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/organization"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/rawbundle"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/pkg/job"
+	"github.com/nitrous-io/rise-server/shared/messages"
+	"github.com/nitrous-io/rise-server/shared/queues"
+	"github.com/nitrous-io/rise-server/shared/s3client"
+)
+
+// cloneSource is the deployment that a new project is cloned from.
+type cloneSource struct {
+	proj *project.Project
+	depl *deployment.Deployment
+	bun  *rawbundle.RawBundle
+}
+
+// findCloneSource returns the deployment with the ID fromDeployment of the
+// project named fromProject, or the active deployment of the project if
+// fromDeployment is empty. The user must be the owner or a collaborator of the
+// project. If the deployment cannot be cloned from, it returns a map of param
+// names to error messages.
+func findCloneSource(db *gorm.DB, u *user.User, fromProject, fromDeployment string) (*cloneSource, map[string]interface{}, error) {
+	if fromProject == "" {
+		return nil, map[string]interface{}{"from_project": "is required"}, nil
+	}
+
+	src, err := project.FindByName(db, strings.ToLower(fromProject))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	canAccess := false
+	if src != nil {
+		canAccess, err = canAccessProject(db, src, u)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if !canAccess {
+		return nil, map[string]interface{}{"from_project": "could not be found"}, nil
+	}
+
+	var deplID int64
+	if fromDeployment == "" {
+		if src.ActiveDeploymentID == nil {
+			return nil, map[string]interface{}{"from_project": "has no active deployment"}, nil
+		}
+		deplID = int64(*src.ActiveDeploymentID)
+	} else {
+		deplID, err = strconv.ParseInt(fromDeployment, 10, 64)
+		if err != nil {
+			return nil, map[string]interface{}{"from_deployment": "could not be found"}, nil
+		}
+	}
+
+	depl := &deployment.Deployment{}
+	if err := db.Where("project_id = ?", src.ID).First(depl, deplID).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return nil, map[string]interface{}{"from_deployment": "could not be found"}, nil
+		}
+		return nil, nil, err
+	}
+
+	// Only the raw bundle is copied, so deployments that were created before
+	// raw bundles were kept cannot be cloned from.
+	if depl.RawBundleID == nil {
+		return nil, map[string]interface{}{"from_deployment": "cannot be cloned"}, nil
+	}
+
+	bun := &rawbundle.RawBundle{}
+	if err := db.First(bun, *depl.RawBundleID).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return nil, map[string]interface{}{"from_deployment": "cannot be cloned"}, nil
+		}
+		return nil, nil, err
+	}
+
+	return &cloneSource{proj: src, depl: depl, bun: bun}, nil, nil
+}
+
+// canAccessProject returns whether the user is the owner or a collaborator of
+// the project, or a member of the organization that owns it.
+func canAccessProject(db *gorm.DB, proj *project.Project, u *user.User) (bool, error) {
+	if proj.OrganizationID == nil && proj.UserID == u.ID {
+		return true, nil
+	}
+
+	role, err := proj.CollaboratorRole(db, u)
+	if err != nil {
+		return false, err
+	}
+	if role != "" {
+		return true, nil
+	}
+
+	if proj.OrganizationID != nil {
+		m, err := organization.Membership(db, *proj.OrganizationID, u.ID)
+		if err != nil {
+			return false, err
+		}
+		return m != nil, nil
+	}
+
+	return false, nil
+}
+
+// cloneDeployment creates the first deployment of proj from a copy of the raw
+// bundle of the source deployment, with the JS environment variables of the
+// source deployment if copyJsEnvVars is true. It returns the format of the
+// archive of the raw bundle.
+func cloneDeployment(db *gorm.DB, u *user.User, proj *project.Project, src *cloneSource, copyJsEnvVars bool) (*deployment.Deployment, string, error) {
+	ver, err := proj.NextVersion(db)
+	if err != nil {
+		return nil, "", err
+	}
+
+	depl := &deployment.Deployment{
+		ProjectID: proj.ID,
+		UserID:    u.ID,
+		Version:   ver,
+	}
+	if copyJsEnvVars {
+		depl.JsEnvVars = src.depl.JsEnvVars
+	}
+
+	if err := db.Create(depl).Error; err != nil {
+		return nil, "", err
+	}
+
+	archiveFormat := "tar.gz"
+	if strings.HasSuffix(src.bun.UploadedPath, ".zip") {
+		archiveFormat = "zip"
+	}
+
+	bundlePath := "deployments/" + depl.PrefixID() + "/raw-bundle." + archiveFormat
+	if err := s3client.Copy(src.bun.UploadedPath, bundlePath); err != nil {
+		return nil, "", err
+	}
+
+	bun := &rawbundle.RawBundle{
+		ProjectID:    proj.ID,
+		Checksum:     src.bun.Checksum,
+		UploadedPath: bundlePath,
+	}
+	if err := db.Create(bun).Error; err != nil {
+		return nil, "", err
+	}
+
+	depl.RawBundleID = &bun.ID
+	if err := depl.UpdateState(db, deployment.StateUploaded); err != nil {
+		return nil, "", err
+	}
+
+	return depl, archiveFormat, nil
+}
+
+// enqueueClonedDeployment enqueues a job to build or deploy the deployment
+// that a project was cloned with, depending on whether the project skips
+// builds.
+func enqueueClonedDeployment(db *gorm.DB, proj *project.Project, depl *deployment.Deployment, archiveFormat, requestID string) error {
+	var (
+		j   *job.Job
+		err error
+	)
+	if proj.SkipBuild {
+		j, err = job.NewWithJSON(queues.Deploy, &messages.DeployJobData{
+			DeploymentID:  depl.ID,
+			UseRawBundle:  true,
+			ArchiveFormat: archiveFormat,
+			RequestID:     requestID,
+		})
+	} else {
+		j, err = job.NewWithJSON(queues.Build, &messages.BuildJobData{
+			DeploymentID:  depl.ID,
+			ArchiveFormat: archiveFormat,
+			RequestID:     requestID,
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := j.Enqueue(); err != nil {
+		return err
+	}
+
+	newState := deployment.StatePendingBuild
+	if proj.SkipBuild {
+		newState = deployment.StatePendingDeploy
+	}
+
+	return depl.UpdateState(db, newState)
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/gin-gonic/gin"
@@ -26,9 +27,10 @@ func ListCollaborators(c *gin.Context) {
 
 	collaborators := []struct {
 		Email string `json:"email"`
+		Role  string `json:"role"`
 	}{}
 
-	if err := db.Model(collab.Collab{}).Select("users.email").Joins("JOIN projects ON projects.id = collabs.project_id JOIN users ON users.id = collabs.user_id").Where("collabs.project_id = ?", proj.ID).Order("users.email ASC").Scan(&collaborators).Error; err != nil {
+	if err := db.Model(collab.Collab{}).Select("users.email, collabs.role").Joins("JOIN projects ON projects.id = collabs.project_id JOIN users ON users.id = collabs.user_id").Where("collabs.project_id = ?", proj.ID).Order("users.email ASC").Scan(&collaborators).Error; err != nil {
 		fmt.Println(err)
 		controllers.InternalServerError(c, err)
 		return
@@ -42,27 +44,38 @@ func ListCollaborators(c *gin.Context) {
 func AddCollaborator(c *gin.Context) {
 	proj := controllers.CurrentProject(c)
 
+	role := c.PostForm("role")
+	if role == "" {
+		role = collab.RoleAdmin
+	}
+	if !collab.IsValidRole(role) {
+		c.JSON(422, gin.H{
+			"error": "invalid_params",
+			"errors": map[string]string{
+				"role": "is invalid",
+			},
+		})
+		return
+	}
+
 	db, err := dbconn.DB()
 	if err != nil {
 		controllers.InternalServerError(c, err)
 		return
 	}
 
-	u, err := user.FindByEmail(db, c.PostForm("email"))
+	email := strings.ToLower(c.PostForm("email"))
+	u, err := user.FindByEmail(db, email)
 	if err != nil {
 		controllers.InternalServerError(c, err)
 		return
 	}
 	if u == nil {
-		// TODO Support adding collaborator who does not already have an account.
-		c.JSON(422, gin.H{
-			"error":             "invalid_params",
-			"error_description": "email is not found",
-		})
+		inviteCollaborator(c, db, proj, email, role)
 		return
 	}
 
-	if err := proj.AddCollaborator(db, u); err != nil {
+	if err := proj.AddCollaboratorWithRole(db, u, role); err != nil {
 		switch err {
 		case project.ErrCollaboratorIsOwner:
 			c.JSON(422, gin.H{
@@ -88,6 +101,7 @@ func AddCollaborator(c *gin.Context) {
 			props = map[string]interface{}{
 				"projectName": proj.Name,
 				"collabEmail": u.Email,
+				"role":        role,
 			}
 			context = map[string]interface{}{
 				"ip":         common.GetIP(c.Request),
@@ -160,3 +174,79 @@ func RemoveCollaborator(c *gin.Context) {
 		"removed": true,
 	})
 }
+
+// UpdateCollaborator changes the role of a collaborator of the project.
+func UpdateCollaborator(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	role := c.PostForm("role")
+	if !collab.IsValidRole(role) {
+		c.JSON(422, gin.H{
+			"error": "invalid_params",
+			"errors": map[string]string{
+				"role": "is invalid",
+			},
+		})
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	notFound := func() {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "collaborator could not be found",
+		})
+	}
+
+	u, err := user.FindByEmail(db, c.Param("email"))
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+	if u == nil {
+		notFound()
+		return
+	}
+
+	if err := proj.SetCollaboratorRole(db, u, role); err != nil {
+		if err == project.ErrNotCollaborator {
+			notFound()
+			return
+		}
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	{
+		currUser := controllers.CurrentUser(c)
+
+		var (
+			event = "Changed Collaborator Role"
+			props = map[string]interface{}{
+				"projectName": proj.Name,
+				"collabEmail": u.Email,
+				"role":        role,
+			}
+			context = map[string]interface{}{
+				"ip":         common.GetIP(c.Request),
+				"user_agent": c.Request.UserAgent(),
+			}
+		)
+		if err := common.Track(strconv.Itoa(int(currUser.ID)), event, "", props, context); err != nil {
+			log.Errorf("failed to track %q event for user ID %d, err: %v",
+				event, currUser.ID, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"collaborator": gin.H{
+			"email": u.Email,
+			"role":  role,
+		},
+	})
+}
@@ -11,10 +11,12 @@ import (
 	"github.com/nitrous-io/rise-server/apiserver/common"
 	"github.com/nitrous-io/rise-server/apiserver/dbconn"
 	"github.com/nitrous-io/rise-server/apiserver/models/collab"
+	"github.com/nitrous-io/rise-server/apiserver/models/invitation"
 	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
 	"github.com/nitrous-io/rise-server/apiserver/models/project"
 	"github.com/nitrous-io/rise-server/apiserver/models/user"
 	"github.com/nitrous-io/rise-server/apiserver/server"
+	"github.com/nitrous-io/rise-server/pkg/mailer"
 	"github.com/nitrous-io/rise-server/pkg/tracker"
 	"github.com/nitrous-io/rise-server/testhelper"
 	"github.com/nitrous-io/rise-server/testhelper/factories"
@@ -89,7 +91,8 @@ var _ = Describe("Project collaborators", func() {
 				u2 = factories.User(db)
 				u3 = factories.User(db)
 				factories.Collab(db, proj, u2)
-				factories.Collab(db, proj, u3)
+				c3 := factories.Collab(db, proj, u3)
+				Expect(db.Model(c3).Update("role", collab.RoleViewer).Error).To(BeNil())
 				factories.Collab(db, nil, nil) // another project
 			})
 
@@ -104,10 +107,12 @@ var _ = Describe("Project collaborators", func() {
 				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
 					"collaborators": [
 						{
-							"email": "%s"
+							"email": "%s",
+							"role": "admin"
 						},
 						{
-							"email": "%s"
+							"email": "%s",
+							"role": "viewer"
 						}
 					]
 				}`, u2.Email, u3.Email)))
@@ -151,19 +156,114 @@ var _ = Describe("Project collaborators", func() {
 			Expect(err).To(BeNil())
 		}
 
-		Context("when using an email that does not exist", func() {
-			It("returns 422 unprocessable entity", func() {
-				doRequest(url.Values{"email": {"fakestevejobs@apple.com"}})
+		Context("when using an email that does not belong to a user", func() {
+			var (
+				fakeMailer *fake.Mailer
+				origMailer mailer.Mailer
+			)
+
+			BeforeEach(func() {
+				origMailer = common.Mailer
+				fakeMailer = &fake.Mailer{}
+				common.Mailer = fakeMailer
+			})
+
+			AfterEach(func() {
+				common.Mailer = origMailer
+			})
+
+			It("returns 201 Created and invites the email to collaborate", func() {
+				doRequest(url.Values{"email": {"FakeSteveJobs@apple.com"}, "role": {collab.RoleDeployer}})
 
 				b := &bytes.Buffer{}
 				_, err := b.ReadFrom(res.Body)
 				Expect(err).To(BeNil())
 
-				Expect(res.StatusCode).To(Equal(422))
+				Expect(res.StatusCode).To(Equal(http.StatusCreated))
 				Expect(b.String()).To(MatchJSON(`{
-					"error": "invalid_params",
-					"error_description": "email is not found"
+					"invited": true
 				}`))
+
+				inv, err := invitation.FindByEmail(db, proj.ID, "fakestevejobs@apple.com")
+				Expect(err).To(BeNil())
+				Expect(inv).NotTo(BeNil())
+				Expect(inv.InvitedByID).To(Equal(u.ID))
+				Expect(inv.Role).To(Equal(collab.RoleDeployer))
+				Expect(inv.Token).NotTo(BeEmpty())
+
+				var count int
+				Expect(db.Model(collab.Collab{}).Where("project_id = ?", proj.ID).Count(&count).Error).To(BeNil())
+				Expect(count).To(Equal(0))
+			})
+
+			It("emails the invitation", func() {
+				doRequest(url.Values{"email": {"fakestevejobs@apple.com"}})
+
+				inv, err := invitation.FindByEmail(db, proj.ID, "fakestevejobs@apple.com")
+				Expect(err).To(BeNil())
+
+				Expect(fakeMailer.SendMailCalled).To(BeTrue())
+				Expect(fakeMailer.From).To(Equal(common.MailerEmail))
+				Expect(fakeMailer.Tos).To(Equal([]string{"fakestevejobs@apple.com"}))
+				Expect(fakeMailer.Subject).To(ContainSubstring("panda-express"))
+				Expect(fakeMailer.Body).To(ContainSubstring(inv.Token))
+				Expect(fakeMailer.HTML).To(ContainSubstring(inv.Token))
+			})
+
+			It("tracks an 'Invited Collaborator' event", func() {
+				doRequest(url.Values{"email": {"fakestevejobs@apple.com"}})
+
+				trackCall := fakeTracker.TrackCalls.NthCall(1)
+				Expect(trackCall).NotTo(BeNil())
+				Expect(trackCall.Arguments[0]).To(Equal(fmt.Sprintf("%d", u.ID)))
+				Expect(trackCall.Arguments[1]).To(Equal("Invited Collaborator"))
+
+				props, ok := trackCall.Arguments[3].(map[string]interface{})
+				Expect(ok).To(BeTrue())
+				Expect(props["projectName"]).To(Equal("panda-express"))
+				Expect(props["collabEmail"]).To(Equal("fakestevejobs@apple.com"))
+			})
+
+			Context("when the email has already been invited", func() {
+				BeforeEach(func() {
+					inv, err := invitation.New(proj.ID, u.ID, "fakestevejobs@apple.com", collab.RoleAdmin, common.AesKey)
+					Expect(err).To(BeNil())
+					Expect(invitation.Create(db, inv)).To(Succeed())
+				})
+
+				It("returns 409 conflict", func() {
+					doRequest(url.Values{"email": {"fakestevejobs@apple.com"}})
+
+					b := &bytes.Buffer{}
+					_, err := b.ReadFrom(res.Body)
+					Expect(err).To(BeNil())
+
+					Expect(res.StatusCode).To(Equal(http.StatusConflict))
+					Expect(b.String()).To(MatchJSON(`{
+						"error": "already_exists",
+						"error_description": "email has already been invited"
+					}`))
+					Expect(fakeMailer.SendMailCalled).To(BeFalse())
+				})
+			})
+
+			Context("when the email is invalid", func() {
+				It("returns 422 unprocessable entity", func() {
+					doRequest(url.Values{"email": {"fakestevejobs"}})
+
+					b := &bytes.Buffer{}
+					_, err := b.ReadFrom(res.Body)
+					Expect(err).To(BeNil())
+
+					Expect(res.StatusCode).To(Equal(422))
+					Expect(b.String()).To(MatchJSON(`{
+						"error": "invalid_params",
+						"errors": {
+							"email": "is invalid"
+						}
+					}`))
+					Expect(fakeMailer.SendMailCalled).To(BeFalse())
+				})
 			})
 		})
 
@@ -231,6 +331,37 @@ var _ = Describe("Project collaborators", func() {
 				Expect(len(cols)).To(Equal(1))
 				Expect(cols[0].UserID).To(Equal(anotherU.ID))
 				Expect(cols[0].ProjectID).To(Equal(proj.ID))
+				Expect(cols[0].Role).To(Equal(collab.RoleAdmin))
+			})
+
+			It("adds the user with the given role", func() {
+				doRequest(url.Values{"email": {anotherU.Email}, "role": {collab.RoleDeployer}})
+
+				Expect(res.StatusCode).To(Equal(http.StatusCreated))
+
+				var c collab.Collab
+				Expect(db.Where("project_id = ? AND user_id = ?", proj.ID, anotherU.ID).First(&c).Error).To(BeNil())
+				Expect(c.Role).To(Equal(collab.RoleDeployer))
+			})
+
+			It("returns 422 if the role is invalid", func() {
+				doRequest(url.Values{"email": {anotherU.Email}, "role": {"owner"}})
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_params",
+					"errors": {
+						"role": "is invalid"
+					}
+				}`))
+
+				var count int
+				Expect(db.Model(collab.Collab{}).Where("project_id = ?", proj.ID).Count(&count).Error).To(BeNil())
+				Expect(count).To(Equal(0))
 			})
 
 			It("tracks an 'Added Collaborator' event", func() {
@@ -386,4 +517,105 @@ var _ = Describe("Project collaborators", func() {
 			return res
 		}, nil)
 	})
+
+	Describe("PUT /projects/collaborators/:email", func() {
+		var (
+			u2     *user.User
+			params url.Values
+		)
+
+		BeforeEach(func() {
+			u2 = factories.User(db)
+			factories.Collab(db, proj, u2)
+			params = url.Values{"role": {collab.RoleViewer}}
+		})
+
+		doRequest := func(email string) {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("PUT",
+				fmt.Sprintf("%s/projects/panda-express/collaborators/%s", s.URL, email),
+				params, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		It("returns 200 OK and changes the role of the collaborator", func() {
+			doRequest(u2.Email)
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+				"collaborator": {
+					"email": "%s",
+					"role": "viewer"
+				}
+			}`, u2.Email)))
+
+			role, err := proj.CollaboratorRole(db, u2)
+			Expect(err).To(BeNil())
+			Expect(role).To(Equal(collab.RoleViewer))
+		})
+
+		It("tracks a 'Changed Collaborator Role' event", func() {
+			doRequest(u2.Email)
+
+			trackCall := fakeTracker.TrackCalls.NthCall(1)
+			Expect(trackCall).NotTo(BeNil())
+			Expect(trackCall.Arguments[0]).To(Equal(fmt.Sprintf("%d", u.ID)))
+			Expect(trackCall.Arguments[1]).To(Equal("Changed Collaborator Role"))
+
+			props, ok := trackCall.Arguments[3].(map[string]interface{})
+			Expect(ok).To(BeTrue())
+			Expect(props["collabEmail"]).To(Equal(u2.Email))
+			Expect(props["role"]).To(Equal(collab.RoleViewer))
+		})
+
+		Context("when the role is invalid", func() {
+			BeforeEach(func() {
+				params.Set("role", "superuser")
+			})
+
+			It("returns 422 unprocessable entity", func() {
+				doRequest(u2.Email)
+
+				Expect(res.StatusCode).To(Equal(422))
+
+				role, err := proj.CollaboratorRole(db, u2)
+				Expect(err).To(BeNil())
+				Expect(role).To(Equal(collab.RoleAdmin))
+			})
+		})
+
+		Context("when the user is not a collaborator", func() {
+			It("returns 404 not found", func() {
+				doRequest(factories.User(db).Email)
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "not_found",
+					"error_description": "collaborator could not be found"
+				}`))
+			})
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest(u2.Email)
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProject(func() (*gorm.DB, *project.Project) {
+			return db, proj
+		}, func() *http.Response {
+			doRequest(u2.Email)
+			return res
+		}, nil)
+	})
 })
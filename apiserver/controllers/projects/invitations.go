@@ -0,0 +1,174 @@
+package projects
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/invitation"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+)
+
+// inviteCollaborator invites an email that does not belong to a user yet to
+// collaborate on the project, and emails the invitation to it.
+func inviteCollaborator(c *gin.Context, db *gorm.DB, proj *project.Project, email, role string) {
+	currUser := controllers.CurrentUser(c)
+
+	if !user.IsValidEmail(email) {
+		c.JSON(422, gin.H{
+			"error": "invalid_params",
+			"errors": map[string]string{
+				"email": "is invalid",
+			},
+		})
+		return
+	}
+
+	inv, err := invitation.New(proj.ID, currUser.ID, email, role, common.AesKey)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	tx := db.Begin()
+	if err := tx.Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+	defer tx.Rollback()
+
+	if err := invitation.Create(tx, inv); err != nil {
+		if err == invitation.ErrInvitationAlreadyExists {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":             "already_exists",
+				"error_description": "email has already been invited",
+			})
+			return
+		}
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if err := sendInvitationEmail(inv, proj, currUser); err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	{
+		var (
+			event = "Invited Collaborator"
+			props = map[string]interface{}{
+				"projectName": proj.Name,
+				"collabEmail": email,
+				"role":        role,
+			}
+			context = map[string]interface{}{
+				"ip":         common.GetIP(c.Request),
+				"user_agent": c.Request.UserAgent(),
+			}
+		)
+		if err := common.Track(strconv.Itoa(int(currUser.ID)), event, "", props, context); err != nil {
+			log.Errorf("failed to track %q event for user ID %d, err: %v",
+				event, currUser.ID, err)
+		}
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"invited": true,
+	})
+}
+
+// ListInvitations lists the invitations to collaborate on the project that
+// have not been accepted yet.
+func ListInvitations(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	invs, err := invitation.InvitationsByProjectID(db, proj.ID)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"invitations": invs,
+	})
+}
+
+// RevokeInvitation deletes the invitation of the given email to collaborate on
+// the project.
+func RevokeInvitation(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	inv, err := invitation.FindByEmail(db, proj.ID, strings.ToLower(c.Param("email")))
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+	if inv == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "invitation could not be found",
+		})
+		return
+	}
+
+	if err := db.Delete(inv).Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"revoked": true,
+	})
+}
+
+func sendInvitationEmail(inv *invitation.Invitation, proj *project.Project, from *user.User) error {
+	subject := from.Email + " has invited you to collaborate on " + proj.Name + " on PubStorm"
+
+	txt := from.Email + " has invited you to collaborate on the PubStorm project \"" + proj.Name + "\".\n\n" +
+		"To accept the invitation, sign up for PubStorm with this email address. Once you confirm your account, you will be added to the project automatically.\n\n" +
+		"If you sign up with a different email address, please use the following code when confirming your account:\n\n" +
+		inv.Token + "\n\n" +
+		"Thanks,\n" +
+		"PubStorm"
+
+	html := "<p>" + from.Email + " has invited you to collaborate on the PubStorm project \"" + proj.Name + "\".</p>" +
+		"<p>To accept the invitation, sign up for PubStorm with this email address. Once you confirm your account, you will be added to the project automatically.</p>" +
+		"<p>If you sign up with a different email address, please use the following code when confirming your account:</p>" +
+		"<p><strong>" + inv.Token + "</strong></p>" +
+		"<p>Thanks,<br />" +
+		"PubStorm</p>"
+
+	return common.SendMail(
+		[]string{inv.Email}, // tos
+		nil,                 // ccs
+		nil,                 // bccs
+		subject,             // subject
+		txt,                 // text body
+		html,                // html body
+	)
+}
@@ -0,0 +1,179 @@
+package projects_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/collab"
+	"github.com/nitrous-io/rise-server/apiserver/models/invitation"
+	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/apiserver/server"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+	"github.com/nitrous-io/rise-server/testhelper/sharedexamples"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Project invitations", func() {
+	var (
+		db      *gorm.DB
+		s       *httptest.Server
+		res     *http.Response
+		headers http.Header
+		err     error
+
+		u    *user.User
+		t    *oauthtoken.OauthToken
+		proj *project.Project
+	)
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+		testhelper.TruncateTables(db.DB())
+
+		u, _, t = factories.AuthTrio(db)
+
+		headers = http.Header{
+			"Authorization": {"Bearer " + t.Token},
+		}
+
+		proj = &project.Project{
+			Name:   "panda-express",
+			UserID: u.ID,
+		}
+		Expect(db.Create(proj).Error).To(BeNil())
+	})
+
+	AfterEach(func() {
+		if res != nil {
+			res.Body.Close()
+		}
+		s.Close()
+	})
+
+	invite := func(p *project.Project, email, role string) *invitation.Invitation {
+		inv, err := invitation.New(p.ID, u.ID, email, role, common.AesKey)
+		Expect(err).To(BeNil())
+		Expect(invitation.Create(db, inv)).To(Succeed())
+		return inv
+	}
+
+	Describe("GET /projects/:project_name/invitations", func() {
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("GET", s.URL+"/projects/panda-express/invitations", nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		It("returns 200 OK with the project's invitations", func() {
+			invite(proj, "zebra@example.com", collab.RoleViewer)
+			invite(proj, "aardvark@example.com", collab.RoleAdmin)
+			invite(factories.Project(db, u), "giraffe@example.com", collab.RoleAdmin) // another project
+
+			doRequest()
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+			var j struct {
+				Invitations []map[string]interface{} `json:"invitations"`
+			}
+			Expect(json.NewDecoder(res.Body).Decode(&j)).To(Succeed())
+
+			Expect(j.Invitations).To(HaveLen(2))
+			Expect(j.Invitations[0]["email"]).To(Equal("aardvark@example.com"))
+			Expect(j.Invitations[0]["role"]).To(Equal(collab.RoleAdmin))
+			Expect(j.Invitations[0]["invited_by"]).To(Equal(u.Email))
+			Expect(j.Invitations[0]).To(HaveKey("created_at"))
+			Expect(j.Invitations[0]).NotTo(HaveKey("token"))
+			Expect(j.Invitations[1]["email"]).To(Equal("zebra@example.com"))
+			Expect(j.Invitations[1]["role"]).To(Equal(collab.RoleViewer))
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
+
+	Describe("DELETE /projects/:project_name/invitations/:email", func() {
+		doRequest := func(email string) {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("DELETE",
+				fmt.Sprintf("%s/projects/panda-express/invitations/%s", s.URL, email),
+				nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		It("returns 200 OK and revokes the invitation", func() {
+			invite(proj, "aardvark@example.com", collab.RoleAdmin)
+			invite(proj, "zebra@example.com", collab.RoleAdmin)
+
+			doRequest("aardvark@example.com")
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(b.String()).To(MatchJSON(`{
+				"revoked": true
+			}`))
+
+			inv, err := invitation.FindByEmail(db, proj.ID, "aardvark@example.com")
+			Expect(err).To(BeNil())
+			Expect(inv).To(BeNil())
+
+			inv, err = invitation.FindByEmail(db, proj.ID, "zebra@example.com")
+			Expect(err).To(BeNil())
+			Expect(inv).NotTo(BeNil())
+		})
+
+		It("returns 404 Not Found if there is no invitation", func() {
+			doRequest("aardvark@example.com")
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+			Expect(b.String()).To(MatchJSON(`{
+				"error": "not_found",
+				"error_description": "invitation could not be found"
+			}`))
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest("aardvark@example.com")
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProject(func() (*gorm.DB, *project.Project) {
+			return db, proj
+		}, func() *http.Response {
+			doRequest("aardvark@example.com")
+			return res
+		}, nil)
+	})
+})
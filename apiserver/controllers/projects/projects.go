@@ -12,8 +12,11 @@ import (
 	"github.com/nitrous-io/rise-server/apiserver/controllers"
 	"github.com/nitrous-io/rise-server/apiserver/dbconn"
 	"github.com/nitrous-io/rise-server/apiserver/models/blacklistedname"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/organization"
 	"github.com/nitrous-io/rise-server/apiserver/models/project"
-	"github.com/nitrous-io/rise-server/apiserver/models/rawbundle"
+	"github.com/nitrous-io/rise-server/apiserver/models/projectrename"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
 	"github.com/nitrous-io/rise-server/pkg/job"
 	"github.com/nitrous-io/rise-server/pkg/pubsub"
 	"github.com/nitrous-io/rise-server/shared"
@@ -77,7 +80,50 @@ func Create(c *gin.Context) {
 		return
 	}
 
-	canCreate, err := project.CanAddProject(db, u)
+	if reserved, err := isNameReserved(db, proj.Name, 0); err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	} else if reserved {
+		c.JSON(422, gin.H{
+			"error": "invalid_params",
+			"errors": map[string]interface{}{
+				"name": "is taken",
+			},
+		})
+		return
+	}
+
+	var canCreate bool
+	if orgName := c.PostForm("organization"); orgName != "" {
+		org, err := organization.FindByName(db, orgName)
+		if err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
+
+		var m *organization.Member
+		if org != nil {
+			m, err = organization.Membership(db, org.ID, u.ID)
+			if err != nil {
+				controllers.InternalServerError(c, err)
+				return
+			}
+		}
+		if m == nil {
+			c.JSON(422, gin.H{
+				"error": "invalid_params",
+				"errors": map[string]interface{}{
+					"organization": "could not be found",
+				},
+			})
+			return
+		}
+
+		proj.OrganizationID = &org.ID
+		canCreate, err = project.CanAddOrganizationProject(db, org.ID)
+	} else {
+		canCreate, err = project.CanAddProject(db, u)
+	}
 	if err != nil {
 		controllers.InternalServerError(c, err)
 		return
@@ -91,7 +137,34 @@ func Create(c *gin.Context) {
 		return
 	}
 
-	if err := db.Create(proj).Error; err != nil {
+	// A project can be cloned from a deployment of another project that the
+	// user has access to, instead of starting out empty.
+	var src *cloneSource
+	if c.PostForm("from_project") != "" || c.PostForm("from_deployment") != "" {
+		var errs map[string]interface{}
+		src, errs, err = findCloneSource(db, u, c.PostForm("from_project"), c.PostForm("from_deployment"))
+		if err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
+
+		if errs != nil {
+			c.JSON(422, gin.H{
+				"error":  "invalid_params",
+				"errors": errs,
+			})
+			return
+		}
+	}
+
+	tx := db.Begin()
+	if err := tx.Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+	defer tx.Rollback()
+
+	if err := tx.Create(proj).Error; err != nil {
 		if e, ok := err.(*pq.Error); ok && e.Code.Name() == "unique_violation" {
 			c.JSON(422, gin.H{
 				"error": "invalid_params",
@@ -106,6 +179,41 @@ func Create(c *gin.Context) {
 		return
 	}
 
+	var (
+		depl          *deployment.Deployment
+		archiveFormat string
+	)
+	if src != nil {
+		// Settings are copied after the project is created, since settings
+		// that are false would otherwise be replaced by their defaults.
+		if copySettings, _ := strconv.ParseBool(c.PostForm("copy_settings")); copySettings {
+			proj.CopySettingsFrom(src.proj)
+			if err := tx.Save(proj).Error; err != nil {
+				controllers.InternalServerError(c, err)
+				return
+			}
+		}
+
+		copyJsEnvVars, _ := strconv.ParseBool(c.PostForm("copy_js_env_vars"))
+		depl, archiveFormat, err = cloneDeployment(tx, u, proj, src, copyJsEnvVars)
+		if err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if depl != nil {
+		if err := enqueueClonedDeployment(db, proj, depl, archiveFormat, controllers.RequestID(c)); err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
+	}
+
 	// Re-fetch from db to get correct timestamps.
 	if err := db.First(proj, proj.ID).Error; err != nil {
 		controllers.InternalServerError(c, err)
@@ -121,12 +229,24 @@ func Create(c *gin.Context) {
 				"user_agent": c.Request.UserAgent(),
 			}
 		)
+		if src != nil {
+			props["clonedFromProjectName"] = src.proj.Name
+			props["clonedFromDeploymentId"] = src.depl.ID
+		}
 		if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
 			log.Errorf("failed to track %q event for user ID %d, err: %v",
 				event, u.ID, err)
 		}
 	}
 
+	if depl != nil {
+		c.JSON(http.StatusCreated, gin.H{
+			"project":    proj.AsJSON(),
+			"deployment": depl.AsJSON(),
+		})
+		return
+	}
+
 	c.JSON(http.StatusCreated, gin.H{
 		"project": proj.AsJSON(),
 	})
@@ -171,9 +291,21 @@ func Index(c *gin.Context) {
 		sharedProjectsAsJson = append(sharedProjectsAsJson, proj.AsJSON())
 	}
 
+	orgProjects, err := project.OrganizationProjectsByUserID(db, u.ID)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	orgProjectsAsJson := []interface{}{}
+	for _, proj := range orgProjects {
+		orgProjectsAsJson = append(orgProjectsAsJson, proj.AsJSON())
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"projects":        projectsAsJson,
-		"shared_projects": sharedProjectsAsJson,
+		"projects":              projectsAsJson,
+		"shared_projects":       sharedProjectsAsJson,
+		"organization_projects": orgProjectsAsJson,
 	})
 }
 
@@ -184,6 +316,141 @@ func Update(c *gin.Context) {
 	updatedProj := *proj
 	projChanged := false
 
+	// Validate the name and build settings before anything else, since
+	// changing the other settings enqueues jobs.
+	if c.PostForm("name") != "" {
+		updatedProj.Name = strings.ToLower(c.PostForm("name"))
+	}
+
+	if c.PostForm("optimizer") != "" {
+		updatedProj.Optimizer = c.PostForm("optimizer")
+	}
+
+	if c.PostForm("build_error_policy") != "" {
+		updatedProj.BuildErrorPolicy = c.PostForm("build_error_policy")
+	}
+
+	if c.PostForm("image_quality") != "" {
+		imageQuality, err := strconv.Atoi(c.PostForm("image_quality"))
+		if err != nil {
+			c.JSON(422, gin.H{
+				"error": "invalid_params",
+				"errors": map[string]interface{}{
+					"image_quality": "is not a number",
+				},
+			})
+			return
+		}
+		updatedProj.ImageQuality = imageQuality
+	}
+
+	for param, budget := range map[string]*int64{
+		"max_total_size":  &updatedProj.MaxTotalSize,
+		"max_js_size":     &updatedProj.MaxJSSize,
+		"max_css_size":    &updatedProj.MaxCSSSize,
+		"max_page_weight": &updatedProj.MaxPageWeight,
+	} {
+		if c.PostForm(param) == "" {
+			continue
+		}
+
+		size, err := strconv.ParseInt(c.PostForm(param), 10, 64)
+		if err != nil {
+			c.JSON(422, gin.H{
+				"error": "invalid_params",
+				"errors": map[string]interface{}{
+					param: "is not a number",
+				},
+			})
+			return
+		}
+		*budget = size
+	}
+
+	for param, policy := range map[string]*uint{
+		"max_deploys_kept":      &updatedProj.MaxDeploysKept,
+		"deploy_retention_days": &updatedProj.DeployRetentionDays,
+	} {
+		if c.PostForm(param) == "" {
+			continue
+		}
+
+		n, err := strconv.Atoi(c.PostForm(param))
+		if err != nil {
+			c.JSON(422, gin.H{
+				"error": "invalid_params",
+				"errors": map[string]interface{}{
+					param: "is not a number",
+				},
+			})
+			return
+		}
+		if n < 0 {
+			c.JSON(422, gin.H{
+				"error": "invalid_params",
+				"errors": map[string]interface{}{
+					param: "must not be negative",
+				},
+			})
+			return
+		}
+		*policy = uint(n)
+	}
+
+	if proj.MaxDeploysKept != updatedProj.MaxDeploysKept ||
+		proj.DeployRetentionDays != updatedProj.DeployRetentionDays {
+		projChanged = true
+	}
+
+	if proj.Name != updatedProj.Name ||
+		proj.Optimizer != updatedProj.Optimizer ||
+		proj.ImageQuality != updatedProj.ImageQuality ||
+		proj.BuildErrorPolicy != updatedProj.BuildErrorPolicy ||
+		proj.MaxTotalSize != updatedProj.MaxTotalSize ||
+		proj.MaxJSSize != updatedProj.MaxJSSize ||
+		proj.MaxCSSSize != updatedProj.MaxCSSSize ||
+		proj.MaxPageWeight != updatedProj.MaxPageWeight {
+		if errs := updatedProj.Validate(); errs != nil {
+			c.JSON(422, gin.H{
+				"error":  "invalid_params",
+				"errors": errs,
+			})
+			return
+		}
+		projChanged = true
+	}
+
+	renamed := proj.Name != updatedProj.Name
+	if renamed {
+		db, err := dbconn.DB()
+		if err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
+
+		blacklisted, err := blacklistedname.IsBlacklisted(db, updatedProj.Name)
+		if err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
+
+		reserved, err := isNameReserved(db, updatedProj.Name, proj.ID)
+		if err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
+
+		if blacklisted || reserved {
+			c.JSON(422, gin.H{
+				"error": "invalid_params",
+				"errors": map[string]interface{}{
+					"name": "is taken",
+				},
+			})
+			return
+		}
+	}
+
 	if c.PostForm("default_domain_enabled") != "" {
 		defaultDomainEnabled, _ := strconv.ParseBool(c.PostForm("default_domain_enabled"))
 		updatedProj.DefaultDomainEnabled = defaultDomainEnabled
@@ -200,6 +467,7 @@ func Update(c *gin.Context) {
 						DeploymentID:      *proj.ActiveDeploymentID,
 						SkipWebrootUpload: true,
 						SkipInvalidation:  true,
+						RequestID:         controllers.RequestID(c),
 					})
 					if err != nil {
 						controllers.InternalServerError(c, err)
@@ -212,15 +480,37 @@ func Update(c *gin.Context) {
 					}
 				} else {
 					// If default domain was just disabled, we need to remove it so that it no longer works.
-					defaultDomain := proj.Name + "." + shared.DefaultDomain
+					// Former default domains that redirect to it go with it.
+					defaultDomains := []string{proj.Name + "." + shared.DefaultDomain}
+
+					db, err := dbconn.DB()
+					if err != nil {
+						controllers.InternalServerError(c, err)
+						return
+					}
+
+					renames, err := projectrename.ActiveByProjectID(db, proj.ID)
+					if err != nil {
+						controllers.InternalServerError(c, err)
+						return
+					}
+					for _, r := range renames {
+						defaultDomains = append(defaultDomains, r.DefaultDomainName())
+					}
 
-					if err := s3client.Delete("/domains/" + defaultDomain + "/meta.json"); err != nil {
+					var filesToDelete []string
+					for _, defaultDomain := range defaultDomains {
+						filesToDelete = append(filesToDelete, "/domains/"+defaultDomain+"/meta.json")
+					}
+
+					if err := s3client.Delete(filesToDelete...); err != nil {
 						controllers.InternalServerError(c, err)
 						return
 					}
 
 					m, err := pubsub.NewMessageWithJSON(exchanges.Edges, exchanges.RouteV1Invalidation, &messages.V1InvalidationMessageData{
-						Domains: []string{defaultDomain},
+						Domains:   defaultDomains,
+						RequestID: controllers.RequestID(c),
 					})
 					if err != nil {
 						controllers.InternalServerError(c, err)
@@ -251,6 +541,7 @@ func Update(c *gin.Context) {
 					DeploymentID:      *proj.ActiveDeploymentID,
 					SkipWebrootUpload: true,
 					SkipInvalidation:  false,
+					RequestID:         controllers.RequestID(c),
 				})
 				if err != nil {
 					controllers.InternalServerError(c, err)
@@ -273,6 +564,30 @@ func Update(c *gin.Context) {
 		}
 	}
 
+	if c.PostForm("fingerprint_assets") != "" {
+		fingerprintAssets, _ := strconv.ParseBool(c.PostForm("fingerprint_assets"))
+		updatedProj.FingerprintAssets = fingerprintAssets
+		if proj.FingerprintAssets != updatedProj.FingerprintAssets {
+			projChanged = true
+		}
+	}
+
+	if c.PostForm("fail_on_broken_links") != "" {
+		failOnBrokenLinks, _ := strconv.ParseBool(c.PostForm("fail_on_broken_links"))
+		updatedProj.FailOnBrokenLinks = failOnBrokenLinks
+		if proj.FailOnBrokenLinks != updatedProj.FailOnBrokenLinks {
+			projChanged = true
+		}
+	}
+
+	if c.PostForm("fail_over_budget") != "" {
+		failOverBudget, _ := strconv.ParseBool(c.PostForm("fail_over_budget"))
+		updatedProj.FailOverBudget = failOverBudget
+		if proj.FailOverBudget != updatedProj.FailOverBudget {
+			projChanged = true
+		}
+	}
+
 	if projChanged {
 		db, err := dbconn.DB()
 		if err != nil {
@@ -280,14 +595,68 @@ func Update(c *gin.Context) {
 			return
 		}
 
-		if err := db.Save(&updatedProj).Error; err != nil {
+		tx := db.Begin()
+		if err := tx.Error; err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
+		defer tx.Rollback()
+
+		if err := tx.Save(&updatedProj).Error; err != nil {
+			if e, ok := err.(*pq.Error); ok && e.Code.Name() == "unique_violation" {
+				c.JSON(422, gin.H{
+					"error": "invalid_params",
+					"errors": map[string]interface{}{
+						"name": "is taken",
+					},
+				})
+				return
+			}
+
+			controllers.InternalServerError(c, err)
+			return
+		}
+
+		if renamed {
+			if _, err := projectrename.Create(tx, proj.ID, proj.Name, updatedProj.Name); err != nil {
+				controllers.InternalServerError(c, err)
+				return
+			}
+		}
+
+		if err := tx.Commit().Error; err != nil {
 			controllers.InternalServerError(c, err)
 			return
 		}
 
+		if renamed {
+			if err := redirectFormerDefaultDomains(&updatedProj, controllers.RequestID(c)); err != nil {
+				controllers.InternalServerError(c, err)
+				return
+			}
+		}
+
 		{
 			u := controllers.CurrentUser(c)
 
+			if renamed {
+				var (
+					event = "Renamed Project"
+					props = map[string]interface{}{
+						"projectName":    updatedProj.Name,
+						"oldProjectName": proj.Name,
+					}
+					context = map[string]interface{}{
+						"ip":         common.GetIP(c.Request),
+						"user_agent": c.Request.UserAgent(),
+					}
+				)
+				if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
+					log.Errorf("failed to track %q event for user ID %d, err: %v",
+						event, u.ID, err)
+				}
+			}
+
 			if proj.DefaultDomainEnabled != updatedProj.DefaultDomainEnabled {
 				var (
 					event   = "Disabled Default Domain"
@@ -353,24 +722,24 @@ func Destroy(c *gin.Context) {
 		return
 	}
 
-	var rawBundles []*rawbundle.RawBundle
-	if err := db.Where("project_id = ?", proj.ID).Find(&rawBundles).Error; err != nil {
+	renames, err := projectrename.ActiveByProjectID(db, proj.ID)
+	if err != nil {
 		controllers.InternalServerError(c, err)
 		return
 	}
 
-	// Delete ssl certs from S3
+	// Stop serving the domains. Certs, raw bundles and deployments are kept in
+	// S3 so that the project can be restored, until it is purged.
 	var filesToDelete []string
 	for _, domainName := range domainNames {
 		filesToDelete = append(filesToDelete, "domains/"+domainName+"/meta.json")
-		if domainName != proj.DefaultDomainName() {
-			filesToDelete = append(filesToDelete, "certs/"+domainName+"/ssl.crt")
-			filesToDelete = append(filesToDelete, "certs/"+domainName+"/ssl.key")
-		}
 	}
 
-	for _, rawBundle := range rawBundles {
-		filesToDelete = append(filesToDelete, rawBundle.UploadedPath)
+	// Former default domains redirect to the project until their grace
+	// period ends, so they go away with the project.
+	for _, r := range renames {
+		domainNames = append(domainNames, r.DefaultDomainName())
+		filesToDelete = append(filesToDelete, "domains/"+r.DefaultDomainName()+"/meta.json")
 	}
 
 	if err := s3client.Delete(filesToDelete...); err != nil {
@@ -379,7 +748,8 @@ func Destroy(c *gin.Context) {
 	}
 
 	m, err := pubsub.NewMessageWithJSON(exchanges.Edges, exchanges.RouteV1Invalidation, &messages.V1InvalidationMessageData{
-		Domains: domainNames,
+		Domains:   domainNames,
+		RequestID: controllers.RequestID(c),
 	})
 	if err != nil {
 		controllers.InternalServerError(c, err)
@@ -423,6 +793,107 @@ func Destroy(c *gin.Context) {
 	})
 }
 
+// Restore restores the current project, which was deleted within
+// project.RestoreWindow, and serves its active deployment again.
+func Restore(c *gin.Context) {
+	u := controllers.CurrentUser(c)
+	proj := controllers.CurrentProject(c)
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	var canRestore bool
+	if proj.OrganizationID != nil {
+		canRestore, err = project.CanAddOrganizationProject(db, *proj.OrganizationID)
+	} else {
+		var owner user.User
+		if err := db.First(&owner, proj.UserID).Error; err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
+		canRestore, err = project.CanAddProject(db, &owner)
+	}
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if !canRestore {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":             "invalid_request",
+			"error_description": "maximum number of projects reached",
+		})
+		return
+	}
+
+	tx := db.Begin()
+	if err := tx.Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+	defer tx.Rollback()
+
+	if err := proj.Restore(tx); err != nil {
+		if err == project.ErrNameTaken || err == project.ErrDomainTaken {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":             "already_exists",
+				"error_description": err.Error(),
+			})
+			return
+		}
+
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if proj.ActiveDeploymentID != nil {
+		// Upload meta.json for the domains of the project again, and invalidate
+		// the pages that the edges cached while it was deleted.
+		j, err := job.NewWithJSON(queues.Deploy, &messages.DeployJobData{
+			DeploymentID:      *proj.ActiveDeploymentID,
+			SkipWebrootUpload: true,
+			SkipInvalidation:  false,
+			RequestID:         controllers.RequestID(c),
+		})
+		if err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
+
+		if err := j.Enqueue(); err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
+	}
+
+	{
+		var (
+			event   = "Restored Project"
+			props   = map[string]interface{}{"projectName": proj.Name}
+			context = map[string]interface{}{
+				"ip":         common.GetIP(c.Request),
+				"user_agent": c.Request.UserAgent(),
+			}
+		)
+		if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
+			log.Errorf("failed to track %q event for user ID %d, err: %v",
+				event, u.ID, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"project": proj.AsJSON(),
+	})
+}
+
 func CreateAuth(c *gin.Context) {
 	proj := controllers.CurrentProject(c)
 
@@ -445,7 +916,7 @@ func CreateAuth(c *gin.Context) {
 	}
 
 	if proj.ActiveDeploymentID != nil {
-		if err := publishInvalidationJob(proj); err != nil {
+		if err := publishInvalidationJob(proj, controllers.RequestID(c)); err != nil {
 			controllers.InternalServerError(c, err)
 			return
 		}
@@ -470,7 +941,7 @@ func CreateAuth(c *gin.Context) {
 func DeleteAuth(c *gin.Context) {
 	proj := controllers.CurrentProject(c)
 	if proj.ActiveDeploymentID != nil {
-		if err := publishInvalidationJob(proj); err != nil {
+		if err := publishInvalidationJob(proj, controllers.RequestID(c)); err != nil {
 			controllers.InternalServerError(c, err)
 			return
 		}
@@ -494,11 +965,12 @@ func DeleteAuth(c *gin.Context) {
 	})
 }
 
-func publishInvalidationJob(proj *project.Project) error {
+func publishInvalidationJob(proj *project.Project, requestID string) error {
 	j, err := job.NewWithJSON(queues.Deploy, &messages.DeployJobData{
 		DeploymentID:      *proj.ActiveDeploymentID,
 		SkipWebrootUpload: true,
 		SkipInvalidation:  false,
+		RequestID:         requestID,
 	})
 
 	if err != nil {
@@ -4,10 +4,13 @@ import (
 	"bytes"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strconv"
 	"testing"
 	"time"
 
@@ -18,7 +21,9 @@ import (
 	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
 	"github.com/nitrous-io/rise-server/apiserver/models/domain"
 	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
+	"github.com/nitrous-io/rise-server/apiserver/models/organization"
 	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/projectrename"
 	"github.com/nitrous-io/rise-server/apiserver/models/rawbundle"
 	"github.com/nitrous-io/rise-server/apiserver/models/user"
 	"github.com/nitrous-io/rise-server/apiserver/server"
@@ -213,6 +218,52 @@ var _ = Describe("Projects", func() {
 			})
 		})
 
+		Context("when the project name is a former name of another project", func() {
+			BeforeEach(func() {
+				_, err := projectrename.Create(db, factories.Project(db, u).ID, "foo-bar-express", "foo-bar-renamed")
+				Expect(err).To(BeNil())
+
+				doRequest()
+			})
+
+			It("returns 422 unprocessable entity", func() {
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_params",
+					"errors": {
+						"name": "is taken"
+					}
+				}`))
+			})
+		})
+
+		Context("when the project name is the name of a deleted project that has not been purged", func() {
+			BeforeEach(func() {
+				deletedProj := factories.Project(db, u, "foo-bar-express")
+				Expect(deletedProj.Destroy(db)).To(BeNil())
+
+				doRequest()
+			})
+
+			It("returns 422 unprocessable entity", func() {
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_params",
+					"errors": {
+						"name": "is taken"
+					}
+				}`))
+			})
+		})
+
 		Context("when the project name contains uppercase characters", func() {
 			BeforeEach(func() {
 				params.Set("name", "Foo-Bar-Express")
@@ -238,6 +289,18 @@ var _ = Describe("Projects", func() {
 						"default_domain_enabled": true,
 						"force_https": false,
 						"skip_build": false,
+						"optimizer": "docker",
+						"image_quality": 0,
+						"fingerprint_assets": false,
+						"fail_on_broken_links": false,
+						"build_error_policy": "raw_bundle",
+						"max_total_size": 0,
+						"max_js_size": 0,
+						"max_css_size": 0,
+						"max_page_weight": 0,
+						"fail_over_budget": false,
+						"max_deploys_kept": 0,
+						"deploy_retention_days": 0,
 						"created_at": %s
 					}
 				}`, createdAtJSON)))
@@ -273,6 +336,84 @@ var _ = Describe("Projects", func() {
 			})
 		})
 
+		Context("when an organization is given", func() {
+			var org *organization.Organization
+
+			BeforeEach(func() {
+				org = factories.Organization(db, nil)
+				params.Set("organization", org.Name)
+			})
+
+			Context("when the user is a member of the organization", func() {
+				BeforeEach(func() {
+					Expect(org.AddMember(db, u, organization.RoleMember)).To(Succeed())
+				})
+
+				It("creates a project that belongs to the organization", func() {
+					doRequest()
+
+					Expect(res.StatusCode).To(Equal(http.StatusCreated))
+
+					proj := &project.Project{}
+					Expect(db.Last(proj).Error).To(BeNil())
+					Expect(proj.Name).To(Equal("foo-bar-express"))
+					Expect(proj.UserID).To(Equal(u.ID))
+					Expect(proj.OrganizationID).NotTo(BeNil())
+					Expect(*proj.OrganizationID).To(Equal(org.ID))
+				})
+
+				Context("when the organization has the max number of projects", func() {
+					var origMaxProjectPerOrganization int
+
+					BeforeEach(func() {
+						factories.OrganizationProject(db, org, u)
+						origMaxProjectPerOrganization = project.MaxProjectPerOrganization
+						project.MaxProjectPerOrganization = 1
+					})
+
+					AfterEach(func() {
+						project.MaxProjectPerOrganization = origMaxProjectPerOrganization
+					})
+
+					It("returns 403 invalid request", func() {
+						doRequest()
+
+						b := &bytes.Buffer{}
+						_, err := b.ReadFrom(res.Body)
+						Expect(err).To(BeNil())
+
+						Expect(res.StatusCode).To(Equal(http.StatusForbidden))
+						Expect(b.String()).To(MatchJSON(`{
+							"error": "invalid_request",
+							"error_description": "maximum number of projects reached"
+						}`))
+					})
+				})
+			})
+
+			Context("when the user is not a member of the organization", func() {
+				It("returns 422 unprocessable entity", func() {
+					doRequest()
+
+					b := &bytes.Buffer{}
+					_, err := b.ReadFrom(res.Body)
+					Expect(err).To(BeNil())
+
+					Expect(res.StatusCode).To(Equal(422))
+					Expect(b.String()).To(MatchJSON(`{
+						"error": "invalid_params",
+						"errors": {
+							"organization": "could not be found"
+						}
+					}`))
+
+					var count int
+					Expect(db.Model(project.Project{}).Where("name = ?", "foo-bar-express").Count(&count).Error).To(BeNil())
+					Expect(count).To(Equal(0))
+				})
+			})
+		})
+
 		Context("when a valid project name is given", func() {
 			var proj *project.Project
 
@@ -302,6 +443,18 @@ var _ = Describe("Projects", func() {
 						"default_domain_enabled": true,
 						"force_https": false,
 						"skip_build": false,
+						"optimizer": "docker",
+						"image_quality": 0,
+						"fingerprint_assets": false,
+						"fail_on_broken_links": false,
+						"build_error_policy": "raw_bundle",
+						"max_total_size": 0,
+						"max_js_size": 0,
+						"max_css_size": 0,
+						"max_page_weight": 0,
+						"fail_over_budget": false,
+						"max_deploys_kept": 0,
+						"deploy_retention_days": 0,
 						"created_at": %s
 					}
 				}`, createdAtJSON)))
@@ -333,6 +486,320 @@ var _ = Describe("Projects", func() {
 			})
 		})
 
+		Context("when cloning from a deployment of another project", func() {
+			var (
+				fakeS3 *fake.S3
+				origS3 filetransfer.FileTransfer
+				mq     *amqp.Connection
+
+				srcProj *project.Project
+				srcBun  *rawbundle.RawBundle
+				srcDepl *deployment.Deployment
+			)
+
+			BeforeEach(func() {
+				origS3 = s3client.S3
+				fakeS3 = &fake.S3{}
+				s3client.S3 = fakeS3
+
+				mq, err = mqconn.MQ()
+				Expect(err).To(BeNil())
+
+				testhelper.DeleteQueue(mq, queues.All...)
+
+				srcProj = factories.Project(db, u, "source-project")
+				srcProj.ForceHTTPS = true
+				srcProj.SkipBuild = true
+				Expect(db.Save(srcProj).Error).To(BeNil())
+
+				srcBun = factories.RawBundle(db, srcProj)
+				srcDepl = factories.DeploymentWithAttrs(db, srcProj, u, deployment.Deployment{
+					State:       deployment.StateDeployed,
+					RawBundleID: &srcBun.ID,
+					JsEnvVars:   []byte(`{"API_URL":"https://api.example.com"}`),
+				})
+				Expect(db.Model(srcProj).UpdateColumn("active_deployment_id", srcDepl.ID).Error).To(BeNil())
+
+				params.Set("from_project", srcProj.Name)
+			})
+
+			AfterEach(func() {
+				s3client.S3 = origS3
+			})
+
+			clonedProjectAndDeployment := func() (*project.Project, *deployment.Deployment) {
+				proj, err := project.FindByName(db, "foo-bar-express")
+				Expect(err).To(BeNil())
+				Expect(proj).NotTo(BeNil())
+
+				depl := &deployment.Deployment{}
+				Expect(db.Where("project_id = ?", proj.ID).First(depl).Error).To(BeNil())
+
+				return proj, depl
+			}
+
+			It("returns 201 created with the project and its first deployment", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusCreated))
+
+				_, depl := clonedProjectAndDeployment()
+
+				var j map[string]map[string]interface{}
+				Expect(json.Unmarshal(b.Bytes(), &j)).To(Succeed())
+				Expect(j["project"]["name"]).To(Equal("foo-bar-express"))
+				Expect(j["deployment"]["id"]).To(BeEquivalentTo(depl.ID))
+				Expect(j["deployment"]["state"]).To(Equal(deployment.StatePendingBuild))
+			})
+
+			It("copies the raw bundle of the active deployment of the source project in S3", func() {
+				doRequest()
+
+				proj, depl := clonedProjectAndDeployment()
+
+				Expect(fakeS3.CopyCalls.Count()).To(Equal(1))
+				copyCall := fakeS3.CopyCalls.NthCall(1)
+				Expect(copyCall).NotTo(BeNil())
+				Expect(copyCall.Arguments[0]).To(Equal(s3client.BucketRegion))
+				Expect(copyCall.Arguments[1]).To(Equal(s3client.BucketName))
+				Expect(copyCall.Arguments[2]).To(Equal(srcBun.UploadedPath))
+				Expect(copyCall.Arguments[3]).To(Equal("deployments/" + depl.PrefixID() + "/raw-bundle.tar.gz"))
+
+				Expect(depl.RawBundleID).NotTo(BeNil())
+				bun := &rawbundle.RawBundle{}
+				Expect(db.First(bun, *depl.RawBundleID).Error).To(BeNil())
+				Expect(bun.ProjectID).To(Equal(proj.ID))
+				Expect(bun.Checksum).To(Equal(srcBun.Checksum))
+				Expect(bun.UploadedPath).To(Equal("deployments/" + depl.PrefixID() + "/raw-bundle.tar.gz"))
+			})
+
+			It("enqueues a build job for the deployment", func() {
+				doRequest()
+
+				_, depl := clonedProjectAndDeployment()
+
+				d := testhelper.ConsumeQueue(mq, queues.Build)
+				Expect(d).NotTo(BeNil())
+				Expect(d.Body).To(MatchJSON(fmt.Sprintf(`{
+					"deployment_id": %d,
+					"archive_format": "tar.gz",
+					"request_id": %q
+				}`, depl.ID, res.Header.Get("X-Request-Id"))))
+			})
+
+			It("does not copy the JS env vars or settings of the source", func() {
+				doRequest()
+
+				proj, depl := clonedProjectAndDeployment()
+
+				Expect(depl.JsEnvVars).To(MatchJSON(`{}`))
+				Expect(proj.ForceHTTPS).To(BeFalse())
+				Expect(proj.SkipBuild).To(BeFalse())
+			})
+
+			It("tracks a 'Created Project' event with the source", func() {
+				doRequest()
+
+				trackCall := fakeTracker.TrackCalls.NthCall(1)
+				Expect(trackCall).NotTo(BeNil())
+				Expect(trackCall.Arguments[1]).To(Equal("Created Project"))
+
+				props, ok := trackCall.Arguments[3].(map[string]interface{})
+				Expect(ok).To(BeTrue())
+				Expect(props["projectName"]).To(Equal("foo-bar-express"))
+				Expect(props["clonedFromProjectName"]).To(Equal(srcProj.Name))
+				Expect(props["clonedFromDeploymentId"]).To(Equal(srcDepl.ID))
+			})
+
+			Context("when copy_js_env_vars is true", func() {
+				BeforeEach(func() {
+					params.Set("copy_js_env_vars", "true")
+				})
+
+				It("copies the JS env vars of the source deployment", func() {
+					doRequest()
+
+					_, depl := clonedProjectAndDeployment()
+					Expect(depl.JsEnvVars).To(MatchJSON(`{"API_URL":"https://api.example.com"}`))
+				})
+			})
+
+			Context("when copy_settings is true", func() {
+				BeforeEach(func() {
+					params.Set("copy_settings", "true")
+				})
+
+				It("copies the settings of the source project", func() {
+					doRequest()
+
+					proj, _ := clonedProjectAndDeployment()
+					Expect(proj.ForceHTTPS).To(BeTrue())
+					Expect(proj.SkipBuild).To(BeTrue())
+				})
+
+				It("does not copy the watermark setting, which only admins can change", func() {
+					Expect(db.Model(srcProj).UpdateColumn("watermark", false).Error).To(BeNil())
+
+					doRequest()
+
+					proj, _ := clonedProjectAndDeployment()
+					Expect(proj.Watermark).To(BeTrue())
+				})
+
+				It("enqueues a deploy job for the deployment, since the project skips builds", func() {
+					doRequest()
+
+					_, depl := clonedProjectAndDeployment()
+					Expect(depl.State).To(Equal(deployment.StatePendingDeploy))
+
+					d := testhelper.ConsumeQueue(mq, queues.Deploy)
+					Expect(d).NotTo(BeNil())
+					Expect(d.Body).To(MatchJSON(fmt.Sprintf(`{
+						"deployment_id": %d,
+						"skip_webroot_upload": false,
+						"skip_invalidation": false,
+						"use_raw_bundle": true,
+						"archive_format": "tar.gz",
+						"request_id": %q
+					}`, depl.ID, res.Header.Get("X-Request-Id"))))
+				})
+			})
+
+			Context("when from_deployment is given", func() {
+				var otherBun *rawbundle.RawBundle
+
+				BeforeEach(func() {
+					otherBun = factories.RawBundle(db, srcProj)
+					otherDepl := factories.DeploymentWithAttrs(db, srcProj, u, deployment.Deployment{
+						State:       deployment.StateDeployed,
+						RawBundleID: &otherBun.ID,
+					})
+					params.Set("from_deployment", strconv.Itoa(int(otherDepl.ID)))
+				})
+
+				It("clones that deployment", func() {
+					doRequest()
+
+					Expect(res.StatusCode).To(Equal(http.StatusCreated))
+
+					copyCall := fakeS3.CopyCalls.NthCall(1)
+					Expect(copyCall).NotTo(BeNil())
+					Expect(copyCall.Arguments[2]).To(Equal(otherBun.UploadedPath))
+				})
+			})
+
+			Context("when from_deployment is a deployment of another project", func() {
+				BeforeEach(func() {
+					bun := factories.RawBundle(db, nil)
+					depl := factories.DeploymentWithAttrs(db, nil, u, deployment.Deployment{
+						State:       deployment.StateDeployed,
+						RawBundleID: &bun.ID,
+					})
+					params.Set("from_deployment", strconv.Itoa(int(depl.ID)))
+				})
+
+				It("returns 422 unprocessable entity", func() {
+					doRequest()
+
+					b := &bytes.Buffer{}
+					_, err := b.ReadFrom(res.Body)
+					Expect(err).To(BeNil())
+
+					Expect(res.StatusCode).To(Equal(422))
+					Expect(b.String()).To(MatchJSON(`{
+						"error": "invalid_params",
+						"errors": {
+							"from_deployment": "could not be found"
+						}
+					}`))
+				})
+			})
+
+			Context("when the source project has no active deployment", func() {
+				BeforeEach(func() {
+					Expect(db.Model(srcProj).UpdateColumn("active_deployment_id", gorm.Expr("NULL")).Error).To(BeNil())
+				})
+
+				It("returns 422 unprocessable entity", func() {
+					doRequest()
+
+					b := &bytes.Buffer{}
+					_, err := b.ReadFrom(res.Body)
+					Expect(err).To(BeNil())
+
+					Expect(res.StatusCode).To(Equal(422))
+					Expect(b.String()).To(MatchJSON(`{
+						"error": "invalid_params",
+						"errors": {
+							"from_project": "has no active deployment"
+						}
+					}`))
+				})
+			})
+
+			Context("when the current user is a collaborator of the source project", func() {
+				BeforeEach(func() {
+					owner := factories.User(db)
+					Expect(db.Model(srcProj).UpdateColumn("user_id", owner.ID).Error).To(BeNil())
+					Expect(srcProj.AddCollaborator(db, u)).To(Succeed())
+				})
+
+				It("returns 201 created", func() {
+					doRequest()
+
+					Expect(res.StatusCode).To(Equal(http.StatusCreated))
+				})
+			})
+
+			Context("when the current user is neither the owner nor a collaborator of the source project", func() {
+				BeforeEach(func() {
+					owner := factories.User(db)
+					Expect(db.Model(srcProj).UpdateColumn("user_id", owner.ID).Error).To(BeNil())
+				})
+
+				It("returns 422 unprocessable entity and does not create the project", func() {
+					doRequest()
+
+					b := &bytes.Buffer{}
+					_, err := b.ReadFrom(res.Body)
+					Expect(err).To(BeNil())
+
+					Expect(res.StatusCode).To(Equal(422))
+					Expect(b.String()).To(MatchJSON(`{
+						"error": "invalid_params",
+						"errors": {
+							"from_project": "could not be found"
+						}
+					}`))
+
+					proj, err := project.FindByName(db, "foo-bar-express")
+					Expect(err).To(BeNil())
+					Expect(proj).To(BeNil())
+					Expect(fakeS3.CopyCalls.Count()).To(Equal(0))
+				})
+			})
+
+			Context("when the raw bundle cannot be copied", func() {
+				BeforeEach(func() {
+					fakeS3.CopyError = errors.New("access denied")
+				})
+
+				It("returns 500 and does not create the project", func() {
+					doRequest()
+
+					Expect(res.StatusCode).To(Equal(http.StatusInternalServerError))
+
+					proj, err := project.FindByName(db, "foo-bar-express")
+					Expect(err).To(BeNil())
+					Expect(proj).To(BeNil())
+				})
+			})
+		})
+
 		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
 			return db, u, &headers
 		}, func() *http.Response {
@@ -382,6 +849,18 @@ var _ = Describe("Projects", func() {
 					"default_domain_enabled": true,
 					"force_https": false,
 					"skip_build": false,
+					"optimizer": "docker",
+					"image_quality": 0,
+					"fingerprint_assets": false,
+					"fail_on_broken_links": false,
+					"build_error_policy": "raw_bundle",
+					"max_total_size": 0,
+					"max_js_size": 0,
+					"max_css_size": 0,
+					"max_page_weight": 0,
+					"fail_over_budget": false,
+					"max_deploys_kept": 0,
+					"deploy_retention_days": 0,
 					"created_at": %s
 				}
 			}`, proj.Name, createdAtJSON)))
@@ -394,19 +873,42 @@ var _ = Describe("Projects", func() {
 			return res
 		}, nil)
 
-		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
-			return db, u, proj
-		}, func() *http.Response {
-			doRequest()
-			return res
-		}, nil)
-	})
+		Context("when the project belongs to an organization", func() {
+			var org *organization.Organization
 
-	Describe("GET /projects", func() {
-		var (
-			headers http.Header
+			BeforeEach(func() {
+				org = factories.Organization(db, nil)
+				proj = factories.OrganizationProject(db, org, nil)
+			})
 
-			anotherU *user.User
+			It("returns 200 OK to a member of the organization", func() {
+				Expect(org.AddMember(db, u, organization.RoleMember)).To(Succeed())
+
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+			})
+
+			It("returns 404 not found to a user outside of the organization", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+			})
+		})
+
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
+
+	Describe("GET /projects", func() {
+		var (
+			headers http.Header
+
+			anotherU *user.User
 
 			proj  *project.Project
 			proj2 *project.Project
@@ -460,6 +962,18 @@ var _ = Describe("Projects", func() {
 						"default_domain_enabled": true,
 						"force_https": false,
 						"skip_build": false,
+						"optimizer": "docker",
+						"image_quality": 0,
+						"fingerprint_assets": false,
+						"fail_on_broken_links": false,
+						"build_error_policy": "raw_bundle",
+						"max_total_size": 0,
+						"max_js_size": 0,
+						"max_css_size": 0,
+						"max_page_weight": 0,
+						"fail_over_budget": false,
+						"max_deploys_kept": 0,
+						"deploy_retention_days": 0,
 						"created_at": %s
 					},
 					{
@@ -467,10 +981,23 @@ var _ = Describe("Projects", func() {
 						"default_domain_enabled": true,
 						"force_https": false,
 						"skip_build": false,
+						"optimizer": "docker",
+						"image_quality": 0,
+						"fingerprint_assets": false,
+						"fail_on_broken_links": false,
+						"build_error_policy": "raw_bundle",
+						"max_total_size": 0,
+						"max_js_size": 0,
+						"max_css_size": 0,
+						"max_page_weight": 0,
+						"fail_over_budget": false,
+						"max_deploys_kept": 0,
+						"deploy_retention_days": 0,
 						"created_at": %s
 					}
 				],
-				"shared_projects": []
+				"shared_projects": [],
+				"organization_projects": []
 			}`, proj.Name, createdAtJSON, proj3.Name, createdAt3JSON)))
 		})
 
@@ -538,6 +1065,18 @@ var _ = Describe("Projects", func() {
 							"default_domain_enabled": true,
 							"force_https": false,
 							"skip_build": false,
+							"optimizer": "docker",
+							"image_quality": 0,
+							"fingerprint_assets": false,
+							"fail_on_broken_links": false,
+							"build_error_policy": "raw_bundle",
+							"max_total_size": 0,
+							"max_js_size": 0,
+							"max_css_size": 0,
+							"max_page_weight": 0,
+							"fail_over_budget": false,
+							"max_deploys_kept": 0,
+							"deploy_retention_days": 0,
 							"created_at": %s
 						},
 						{
@@ -545,6 +1084,18 @@ var _ = Describe("Projects", func() {
 							"default_domain_enabled": true,
 							"force_https": false,
 							"skip_build": false,
+							"optimizer": "docker",
+							"image_quality": 0,
+							"fingerprint_assets": false,
+							"fail_on_broken_links": false,
+							"build_error_policy": "raw_bundle",
+							"max_total_size": 0,
+							"max_js_size": 0,
+							"max_css_size": 0,
+							"max_page_weight": 0,
+							"fail_over_budget": false,
+							"max_deploys_kept": 0,
+							"deploy_retention_days": 0,
 							"created_at": %s
 						}
 					],
@@ -554,6 +1105,18 @@ var _ = Describe("Projects", func() {
 							"default_domain_enabled": true,
 							"force_https": false,
 							"skip_build": false,
+							"optimizer": "docker",
+							"image_quality": 0,
+							"fingerprint_assets": false,
+							"fail_on_broken_links": false,
+							"build_error_policy": "raw_bundle",
+							"max_total_size": 0,
+							"max_js_size": 0,
+							"max_css_size": 0,
+							"max_page_weight": 0,
+							"fail_over_budget": false,
+							"max_deploys_kept": 0,
+							"deploy_retention_days": 0,
 							"created_at": %s
 						},
 						{
@@ -561,9 +1124,22 @@ var _ = Describe("Projects", func() {
 							"default_domain_enabled": true,
 							"force_https": false,
 							"skip_build": false,
+							"optimizer": "docker",
+							"image_quality": 0,
+							"fingerprint_assets": false,
+							"fail_on_broken_links": false,
+							"build_error_policy": "raw_bundle",
+							"max_total_size": 0,
+							"max_js_size": 0,
+							"max_css_size": 0,
+							"max_page_weight": 0,
+							"fail_over_budget": false,
+							"max_deploys_kept": 0,
+							"deploy_retention_days": 0,
 							"created_at": %s
 						}
-					]
+					],
+					"organization_projects": []
 				}`, proj.Name, createdAtJSON, proj3.Name, createdAt3JSON, proj4.Name, createdAt4JSON, proj5.Name, createdAt5JSON)))
 			})
 		})
@@ -625,6 +1201,18 @@ var _ = Describe("Projects", func() {
 							"default_domain_enabled": true,
 							"force_https": false,
 							"skip_build": false,
+							"optimizer": "docker",
+							"image_quality": 0,
+							"fingerprint_assets": false,
+							"fail_on_broken_links": false,
+							"build_error_policy": "raw_bundle",
+							"max_total_size": 0,
+							"max_js_size": 0,
+							"max_css_size": 0,
+							"max_page_weight": 0,
+							"fail_over_budget": false,
+							"max_deploys_kept": 0,
+							"deploy_retention_days": 0,
 							"created_at": %s,
 							"deployed_at": %s
 						},
@@ -633,6 +1221,18 @@ var _ = Describe("Projects", func() {
 							"default_domain_enabled": true,
 							"force_https": false,
 							"skip_build": false,
+							"optimizer": "docker",
+							"image_quality": 0,
+							"fingerprint_assets": false,
+							"fail_on_broken_links": false,
+							"build_error_policy": "raw_bundle",
+							"max_total_size": 0,
+							"max_js_size": 0,
+							"max_css_size": 0,
+							"max_page_weight": 0,
+							"fail_over_budget": false,
+							"max_deploys_kept": 0,
+							"deploy_retention_days": 0,
 							"created_at": %s
 						}
 					],
@@ -642,10 +1242,23 @@ var _ = Describe("Projects", func() {
 							"default_domain_enabled": true,
 							"force_https": false,
 							"skip_build": false,
+							"optimizer": "docker",
+							"image_quality": 0,
+							"fingerprint_assets": false,
+							"fail_on_broken_links": false,
+							"build_error_policy": "raw_bundle",
+							"max_total_size": 0,
+							"max_js_size": 0,
+							"max_css_size": 0,
+							"max_page_weight": 0,
+							"fail_over_budget": false,
+							"max_deploys_kept": 0,
+							"deploy_retention_days": 0,
 							"created_at": %s,
 							"deployed_at": %s
 						}
-					]
+					],
+					"organization_projects": []
 				}`, proj.Name, createdAtJSON, deployedAtJSON,
 					proj3.Name, createdAt3JSON,
 					proj4.Name, createdAt4JSON, deployedAt4JSON,
@@ -731,6 +1344,18 @@ var _ = Describe("Projects", func() {
 						"default_domain_enabled": false,
 						"force_https": false,
 						"skip_build": false,
+						"optimizer": "docker",
+						"image_quality": 0,
+						"fingerprint_assets": false,
+						"fail_on_broken_links": false,
+						"build_error_policy": "raw_bundle",
+						"max_total_size": 0,
+						"max_js_size": 0,
+						"max_css_size": 0,
+						"max_page_weight": 0,
+						"fail_over_budget": false,
+						"max_deploys_kept": 0,
+						"deploy_retention_days": 0,
 						"created_at": "%s"
 					}
 				}`, proj.Name, proj.CreatedAt.Format(time.RFC3339Nano))))
@@ -764,8 +1389,36 @@ var _ = Describe("Projects", func() {
 					d := testhelper.ConsumeQueue(mq, invalidationQueueName)
 					Expect(d).NotTo(BeNil())
 					Expect(d.Body).To(MatchJSON(fmt.Sprintf(`{
-						"domains": ["%s"]
-					}`, proj.Name+"."+shared.DefaultDomain)))
+						"domains": ["%s"],
+						"request_id": "%s"
+					}`, proj.Name+"."+shared.DefaultDomain, res.Header.Get("X-Request-Id"))))
+				})
+
+				Context("when the project has been renamed recently", func() {
+					BeforeEach(func() {
+						_, err := projectrename.Create(db, proj.ID, "old-name", proj.Name)
+						Expect(err).To(BeNil())
+					})
+
+					It("stops redirecting the former default domain too", func() {
+						doRequest()
+
+						Expect(fakeS3.DeleteCalls.Count()).To(Equal(1))
+
+						deleteCall := fakeS3.DeleteCalls.NthCall(1)
+						Expect(deleteCall).NotTo(BeNil())
+						Expect(deleteCall.Arguments[2:]).To(Equal(fake.List{
+							"/domains/" + proj.Name + "." + shared.DefaultDomain + "/meta.json",
+							"/domains/old-name." + shared.DefaultDomain + "/meta.json",
+						}))
+
+						d := testhelper.ConsumeQueue(mq, invalidationQueueName)
+						Expect(d).NotTo(BeNil())
+						Expect(d.Body).To(MatchJSON(fmt.Sprintf(`{
+							"domains": ["%s", "%s"],
+							"request_id": "%s"
+						}`, proj.Name+"."+shared.DefaultDomain, "old-name."+shared.DefaultDomain, res.Header.Get("X-Request-Id"))))
+					})
 				})
 			})
 
@@ -813,6 +1466,18 @@ var _ = Describe("Projects", func() {
 						"default_domain_enabled": true,
 						"force_https": false,
 						"skip_build": false,
+						"optimizer": "docker",
+						"image_quality": 0,
+						"fingerprint_assets": false,
+						"fail_on_broken_links": false,
+						"build_error_policy": "raw_bundle",
+						"max_total_size": 0,
+						"max_js_size": 0,
+						"max_css_size": 0,
+						"max_page_weight": 0,
+						"fail_over_budget": false,
+						"max_deploys_kept": 0,
+						"deploy_retention_days": 0,
 						"created_at": "%s"
 					}
 				}`, proj.Name, proj.CreatedAt.Format(time.RFC3339Nano))))
@@ -836,8 +1501,9 @@ var _ = Describe("Projects", func() {
 						"deployment_id": %d,
 						"skip_webroot_upload": true,
 						"skip_invalidation": true,
-						"use_raw_bundle": false
-					}`, *proj.ActiveDeploymentID)))
+						"use_raw_bundle": false,
+						"request_id": %q
+					}`, *proj.ActiveDeploymentID, res.Header.Get("X-Request-Id"))))
 				})
 			})
 
@@ -879,6 +1545,18 @@ var _ = Describe("Projects", func() {
 						"default_domain_enabled": true,
 						"force_https": true,
 						"skip_build": false,
+						"optimizer": "docker",
+						"image_quality": 0,
+						"fingerprint_assets": false,
+						"fail_on_broken_links": false,
+						"build_error_policy": "raw_bundle",
+						"max_total_size": 0,
+						"max_js_size": 0,
+						"max_css_size": 0,
+						"max_page_weight": 0,
+						"fail_over_budget": false,
+						"max_deploys_kept": 0,
+						"deploy_retention_days": 0,
 						"created_at": "%s"
 					}
 				}`, proj.Name, proj.CreatedAt.Format(time.RFC3339Nano))))
@@ -902,102 +1580,690 @@ var _ = Describe("Projects", func() {
 						"deployment_id": %d,
 						"skip_webroot_upload": true,
 						"skip_invalidation": false,
-						"use_raw_bundle": false
-					}`, *proj.ActiveDeploymentID)))
+						"use_raw_bundle": false,
+						"request_id": %q
+					}`, *proj.ActiveDeploymentID, res.Header.Get("X-Request-Id"))))
+				})
+			})
+		})
+
+		Context("when force_https is newly disabled (i.e. it was enabled)", func() {
+			BeforeEach(func() {
+				proj.ForceHTTPS = true
+				Expect(db.Save(proj).Error).To(BeNil())
+				params = url.Values{
+					"force_https": {"false"},
+				}
+			})
+
+			It("returns 200 OK", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				err = db.First(proj, proj.ID).Error
+				Expect(err).To(BeNil())
+				Expect(proj.DefaultDomainEnabled).To(Equal(true))
+
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"project":{
+						"name": "%s",
+						"default_domain_enabled": true,
+						"force_https": false,
+						"skip_build": false,
+						"optimizer": "docker",
+						"image_quality": 0,
+						"fingerprint_assets": false,
+						"fail_on_broken_links": false,
+						"build_error_policy": "raw_bundle",
+						"max_total_size": 0,
+						"max_js_size": 0,
+						"max_css_size": 0,
+						"max_page_weight": 0,
+						"fail_over_budget": false,
+						"max_deploys_kept": 0,
+						"deploy_retention_days": 0,
+						"created_at": "%s"
+					}
+				}`, proj.Name, proj.CreatedAt.Format(time.RFC3339Nano))))
+			})
+
+			Context("when there is an active deployment", func() {
+				var depl *deployment.Deployment
+
+				BeforeEach(func() {
+					depl = factories.Deployment(db, proj, u, deployment.StateDeployed)
+					err := db.Model(proj).Update("active_deployment_id", depl.ID).Error
+					Expect(err).To(BeNil())
+				})
+
+				It("enqueues a deploy job to update meta.json", func() {
+					doRequest()
+
+					d := testhelper.ConsumeQueue(mq, queues.Deploy)
+					Expect(d).NotTo(BeNil())
+					Expect(d.Body).To(MatchJSON(fmt.Sprintf(`{
+						"deployment_id": %d,
+						"skip_webroot_upload": true,
+						"skip_invalidation": false,
+						"use_raw_bundle": false,
+						"request_id": %q
+					}`, *proj.ActiveDeploymentID, res.Header.Get("X-Request-Id"))))
+				})
+			})
+		})
+
+		Context("when skip_build set to true", func() {
+			BeforeEach(func() {
+				proj.SkipBuild = false
+				Expect(db.Save(proj).Error).To(BeNil())
+				params = url.Values{
+					"skip_build": {"true"},
+				}
+			})
+
+			It("returns 200 OK", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				err = db.First(proj, proj.ID).Error
+				Expect(err).To(BeNil())
+				Expect(proj.DefaultDomainEnabled).To(Equal(true))
+
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"project":{
+						"name": "%s",
+						"default_domain_enabled": true,
+						"force_https": false,
+						"skip_build": true,
+						"optimizer": "docker",
+						"image_quality": 0,
+						"fingerprint_assets": false,
+						"fail_on_broken_links": false,
+						"build_error_policy": "raw_bundle",
+						"max_total_size": 0,
+						"max_js_size": 0,
+						"max_css_size": 0,
+						"max_page_weight": 0,
+						"fail_over_budget": false,
+						"max_deploys_kept": 0,
+						"deploy_retention_days": 0,
+						"created_at": "%s"
+					}
+				}`, proj.Name, proj.CreatedAt.Format(time.RFC3339Nano))))
+			})
+
+		})
+
+		Context("when optimizer is set to native", func() {
+			BeforeEach(func() {
+				params = url.Values{
+					"optimizer": {"native"},
+				}
+			})
+
+			It("returns 200 OK and updates the optimizer", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				Expect(db.First(proj, proj.ID).Error).To(BeNil())
+				Expect(proj.Optimizer).To(Equal(project.OptimizerNative))
+			})
+		})
+
+		Context("when image_quality is set", func() {
+			BeforeEach(func() {
+				params = url.Values{
+					"image_quality": {"80"},
+				}
+			})
+
+			It("returns 200 OK and updates the image quality", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				Expect(db.First(proj, proj.ID).Error).To(BeNil())
+				Expect(proj.ImageQuality).To(Equal(80))
+			})
+		})
+
+		Context("when fingerprint_assets is set to true", func() {
+			BeforeEach(func() {
+				params = url.Values{
+					"fingerprint_assets": {"true"},
+				}
+			})
+
+			It("returns 200 OK and enables asset fingerprinting", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				Expect(db.First(proj, proj.ID).Error).To(BeNil())
+				Expect(proj.FingerprintAssets).To(BeTrue())
+			})
+		})
+
+		Context("when fail_on_broken_links is set to true", func() {
+			BeforeEach(func() {
+				params = url.Values{
+					"fail_on_broken_links": {"true"},
+				}
+			})
+
+			It("returns 200 OK and makes builds with broken links fail", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				Expect(db.First(proj, proj.ID).Error).To(BeNil())
+				Expect(proj.FailOnBrokenLinks).To(BeTrue())
+			})
+		})
+
+		Context("when build_error_policy is set to block", func() {
+			BeforeEach(func() {
+				params = url.Values{
+					"build_error_policy": {"block"},
+				}
+			})
+
+			It("returns 200 OK and updates the build error policy", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				Expect(db.First(proj, proj.ID).Error).To(BeNil())
+				Expect(proj.BuildErrorPolicy).To(Equal(project.BuildErrorPolicyBlock))
+			})
+		})
+
+		Context("when build_error_policy is invalid", func() {
+			BeforeEach(func() {
+				params = url.Values{
+					"build_error_policy": {"ignore"},
+				}
+			})
+
+			It("returns 422", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_params",
+					"errors": {
+						"build_error_policy": "is invalid"
+					}
+				}`))
+			})
+		})
+
+		Context("when budgets are set", func() {
+			BeforeEach(func() {
+				params = url.Values{
+					"max_js_size":      {"100000"},
+					"max_page_weight":  {"500000"},
+					"fail_over_budget": {"true"},
+				}
+			})
+
+			It("returns 200 OK and updates the budgets", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				Expect(db.First(proj, proj.ID).Error).To(BeNil())
+				Expect(proj.MaxTotalSize).To(Equal(int64(0)))
+				Expect(proj.MaxJSSize).To(Equal(int64(100000)))
+				Expect(proj.MaxCSSSize).To(Equal(int64(0)))
+				Expect(proj.MaxPageWeight).To(Equal(int64(500000)))
+				Expect(proj.FailOverBudget).To(BeTrue())
+			})
+		})
+
+		Context("when a budget is not a number", func() {
+			BeforeEach(func() {
+				params = url.Values{
+					"max_css_size": {"100kB"},
+				}
+			})
+
+			It("returns 422", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_params",
+					"errors": {
+						"max_css_size": "is not a number"
+					}
+				}`))
+			})
+		})
+
+		Context("when a budget is negative", func() {
+			BeforeEach(func() {
+				params = url.Values{
+					"max_total_size": {"-1"},
+				}
+			})
+
+			It("returns 422", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_params",
+					"errors": {
+						"max_total_size": "must not be negative"
+					}
+				}`))
+			})
+		})
+
+		Context("when a retention policy is set", func() {
+			BeforeEach(func() {
+				params = url.Values{
+					"max_deploys_kept":      {"5"},
+					"deploy_retention_days": {"30"},
+				}
+			})
+
+			It("returns 200 OK and updates the retention policy", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				var j map[string]map[string]interface{}
+				Expect(json.Unmarshal(b.Bytes(), &j)).To(Succeed())
+				Expect(j["project"]["max_deploys_kept"]).To(BeEquivalentTo(5))
+				Expect(j["project"]["deploy_retention_days"]).To(BeEquivalentTo(30))
+
+				Expect(db.First(proj, proj.ID).Error).To(BeNil())
+				Expect(proj.MaxDeploysKept).To(Equal(uint(5)))
+				Expect(proj.DeployRetentionDays).To(Equal(uint(30)))
+			})
+		})
+
+		Context("when a retention policy is turned off", func() {
+			BeforeEach(func() {
+				proj.MaxDeploysKept = 10
+				Expect(db.Save(proj).Error).To(BeNil())
+
+				params = url.Values{
+					"max_deploys_kept": {"0"},
+				}
+			})
+
+			It("returns 200 OK and updates the retention policy", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				Expect(db.First(proj, proj.ID).Error).To(BeNil())
+				Expect(proj.MaxDeploysKept).To(Equal(uint(0)))
+			})
+		})
+
+		Context("when a retention policy is not a number", func() {
+			BeforeEach(func() {
+				params = url.Values{
+					"deploy_retention_days": {"a month"},
+				}
+			})
+
+			It("returns 422", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_params",
+					"errors": {
+						"deploy_retention_days": "is not a number"
+					}
+				}`))
+			})
+		})
+
+		Context("when a retention policy is negative", func() {
+			BeforeEach(func() {
+				params = url.Values{
+					"max_deploys_kept": {"-1"},
+				}
+			})
+
+			It("returns 422", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_params",
+					"errors": {
+						"max_deploys_kept": "must not be negative"
+					}
+				}`))
+			})
+		})
+
+		Context("when image_quality is out of range", func() {
+			BeforeEach(func() {
+				params = url.Values{
+					"image_quality": {"101"},
+				}
+			})
+
+			It("returns 422", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_params",
+					"errors": {
+						"image_quality": "must be between 0 and 100"
+					}
+				}`))
+
+				Expect(db.First(proj, proj.ID).Error).To(BeNil())
+				Expect(proj.ImageQuality).To(Equal(0))
+			})
+		})
+
+		Context("when the name is changed", func() {
+			var oldName string
+
+			BeforeEach(func() {
+				oldName = proj.Name
+				params = url.Values{
+					"name": {"Panda-Express"},
+				}
+			})
+
+			It("returns 200 OK and renames the project", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				Expect(db.First(proj, proj.ID).Error).To(BeNil())
+				Expect(proj.Name).To(Equal("panda-express"))
+
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"project":{
+						"name": "panda-express",
+						"default_domain_enabled": true,
+						"force_https": false,
+						"skip_build": false,
+						"optimizer": "docker",
+						"image_quality": 0,
+						"fingerprint_assets": false,
+						"fail_on_broken_links": false,
+						"build_error_policy": "raw_bundle",
+						"max_total_size": 0,
+						"max_js_size": 0,
+						"max_css_size": 0,
+						"max_page_weight": 0,
+						"fail_over_budget": false,
+						"max_deploys_kept": 0,
+						"deploy_retention_days": 0,
+						"created_at": "%s"
+					}
+				}`, proj.CreatedAt.Format(time.RFC3339Nano))))
+			})
+
+			It("reserves the old name", func() {
+				doRequest()
+
+				r, err := projectrename.FindActiveByOldName(db, oldName)
+				Expect(err).To(BeNil())
+				Expect(r).NotTo(BeNil())
+				Expect(r.ProjectID).To(Equal(proj.ID))
+			})
+
+			It("tracks a 'Renamed Project' event", func() {
+				doRequest()
+
+				trackCall := fakeTracker.TrackCalls.NthCall(1)
+				Expect(trackCall).NotTo(BeNil())
+				Expect(trackCall.Arguments[0]).To(Equal(fmt.Sprintf("%d", u.ID)))
+				Expect(trackCall.Arguments[1]).To(Equal("Renamed Project"))
+				Expect(trackCall.Arguments[2]).To(Equal(""))
+
+				t := trackCall.Arguments[3]
+				props, ok := t.(map[string]interface{})
+				Expect(ok).To(BeTrue())
+				Expect(props["projectName"]).To(Equal("panda-express"))
+				Expect(props["oldProjectName"]).To(Equal(oldName))
+
+				Expect(trackCall.ReturnValues[0]).To(BeNil())
+			})
+
+			Context("when there is an active deployment", func() {
+				var depl *deployment.Deployment
+
+				BeforeEach(func() {
+					depl = factories.Deployment(db, proj, u, deployment.StateDeployed)
+					err := db.Model(proj).Update("active_deployment_id", depl.ID).Error
+					Expect(err).To(BeNil())
+				})
+
+				It("enqueues a deploy job to upload meta.json for the new default domain and redirect the old one", func() {
+					doRequest()
+
+					d := testhelper.ConsumeQueue(mq, queues.Deploy)
+					Expect(d).NotTo(BeNil())
+					Expect(d.Body).To(MatchJSON(fmt.Sprintf(`{
+						"deployment_id": %d,
+						"skip_webroot_upload": true,
+						"skip_invalidation": false,
+						"use_raw_bundle": false,
+						"request_id": %q
+					}`, depl.ID, res.Header.Get("X-Request-Id"))))
+				})
+
+				It("leaves uploading meta.json and invalidating the domains to the deployer", func() {
+					doRequest()
+
+					Expect(fakeS3.UploadCalls.Count()).To(Equal(0))
+
+					d := testhelper.ConsumeQueue(mq, invalidationQueueName)
+					Expect(d).To(BeNil())
+				})
+			})
+
+			Context("when there is no active deployment", func() {
+				It("does not upload or enqueue anything", func() {
+					doRequest()
+
+					Expect(fakeS3.UploadCalls.Count()).To(Equal(0))
+
+					d := testhelper.ConsumeQueue(mq, queues.Deploy)
+					Expect(d).To(BeNil())
+				})
+			})
+
+			Context("when the name is taken", func() {
+				BeforeEach(func() {
+					factories.Project(db, u, "panda-express")
+				})
+
+				It("returns 422 and does not rename the project", func() {
+					doRequest()
+
+					b := &bytes.Buffer{}
+					_, err := b.ReadFrom(res.Body)
+					Expect(err).To(BeNil())
+
+					Expect(res.StatusCode).To(Equal(422))
+					Expect(b.String()).To(MatchJSON(`{
+						"error": "invalid_params",
+						"errors": {
+							"name": "is taken"
+						}
+					}`))
+
+					Expect(db.First(proj, proj.ID).Error).To(BeNil())
+					Expect(proj.Name).To(Equal(oldName))
+				})
+			})
+
+			Context("when the name is blacklisted", func() {
+				BeforeEach(func() {
+					factories.BlacklistedName(db, "panda-express")
+				})
+
+				It("returns 422 and does not rename the project", func() {
+					doRequest()
+
+					b := &bytes.Buffer{}
+					_, err := b.ReadFrom(res.Body)
+					Expect(err).To(BeNil())
+
+					Expect(res.StatusCode).To(Equal(422))
+					Expect(b.String()).To(MatchJSON(`{
+						"error": "invalid_params",
+						"errors": {
+							"name": "is taken"
+						}
+					}`))
+
+					Expect(db.First(proj, proj.ID).Error).To(BeNil())
+					Expect(proj.Name).To(Equal(oldName))
 				})
 			})
-		})
 
-		Context("when force_https is newly disabled (i.e. it was enabled)", func() {
-			BeforeEach(func() {
-				proj.ForceHTTPS = true
-				Expect(db.Save(proj).Error).To(BeNil())
-				params = url.Values{
-					"force_https": {"false"},
-				}
+			Context("when the name is a former name of another project", func() {
+				BeforeEach(func() {
+					_, err := projectrename.Create(db, factories.Project(db, u).ID, "panda-express", "panda-express-2")
+					Expect(err).To(BeNil())
+				})
+
+				It("returns 422 and does not rename the project", func() {
+					doRequest()
+
+					Expect(res.StatusCode).To(Equal(422))
+
+					Expect(db.First(proj, proj.ID).Error).To(BeNil())
+					Expect(proj.Name).To(Equal(oldName))
+				})
 			})
 
-			It("returns 200 OK", func() {
-				doRequest()
+			Context("when the name is a former name of the project", func() {
+				BeforeEach(func() {
+					_, err := projectrename.Create(db, proj.ID, "panda-express", oldName)
+					Expect(err).To(BeNil())
+				})
 
-				b := &bytes.Buffer{}
-				_, err := b.ReadFrom(res.Body)
-				Expect(err).To(BeNil())
+				It("renames the project back and releases the name", func() {
+					doRequest()
 
-				Expect(res.StatusCode).To(Equal(http.StatusOK))
+					Expect(res.StatusCode).To(Equal(http.StatusOK))
 
-				err = db.First(proj, proj.ID).Error
-				Expect(err).To(BeNil())
-				Expect(proj.DefaultDomainEnabled).To(Equal(true))
+					Expect(db.First(proj, proj.ID).Error).To(BeNil())
+					Expect(proj.Name).To(Equal("panda-express"))
 
-				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
-					"project":{
-						"name": "%s",
-						"default_domain_enabled": true,
-						"force_https": false,
-						"skip_build": false,
-						"created_at": "%s"
-					}
-				}`, proj.Name, proj.CreatedAt.Format(time.RFC3339Nano))))
+					r, err := projectrename.FindActiveByOldName(db, "panda-express")
+					Expect(err).To(BeNil())
+					Expect(r).To(BeNil())
+				})
 			})
 
-			Context("when there is an active deployment", func() {
-				var depl *deployment.Deployment
-
+			Context("when the name is invalid", func() {
 				BeforeEach(func() {
-					depl = factories.Deployment(db, proj, u, deployment.StateDeployed)
-					err := db.Model(proj).Update("active_deployment_id", depl.ID).Error
-					Expect(err).To(BeNil())
+					params = url.Values{
+						"name": {"panda_express"},
+					}
 				})
 
-				It("enqueues a deploy job to update meta.json", func() {
+				It("returns 422 and does not rename the project", func() {
 					doRequest()
 
-					d := testhelper.ConsumeQueue(mq, queues.Deploy)
-					Expect(d).NotTo(BeNil())
-					Expect(d.Body).To(MatchJSON(fmt.Sprintf(`{
-						"deployment_id": %d,
-						"skip_webroot_upload": true,
-						"skip_invalidation": false,
-						"use_raw_bundle": false
-					}`, *proj.ActiveDeploymentID)))
+					b := &bytes.Buffer{}
+					_, err := b.ReadFrom(res.Body)
+					Expect(err).To(BeNil())
+
+					Expect(res.StatusCode).To(Equal(422))
+					Expect(b.String()).To(MatchJSON(`{
+						"error": "invalid_params",
+						"errors": {
+							"name": "is invalid"
+						}
+					}`))
+
+					Expect(db.First(proj, proj.ID).Error).To(BeNil())
+					Expect(proj.Name).To(Equal(oldName))
 				})
 			})
 		})
 
-		Context("when skip_build set to true", func() {
+		Context("when optimizer is invalid", func() {
 			BeforeEach(func() {
-				proj.SkipBuild = false
-				Expect(db.Save(proj).Error).To(BeNil())
 				params = url.Values{
-					"skip_build": {"true"},
+					"optimizer":   {"gulp"},
+					"force_https": {"true"},
 				}
 			})
 
-			It("returns 200 OK", func() {
+			It("returns 422 and does not update the project", func() {
 				doRequest()
 
 				b := &bytes.Buffer{}
 				_, err := b.ReadFrom(res.Body)
 				Expect(err).To(BeNil())
 
-				Expect(res.StatusCode).To(Equal(http.StatusOK))
-
-				err = db.First(proj, proj.ID).Error
-				Expect(err).To(BeNil())
-				Expect(proj.DefaultDomainEnabled).To(Equal(true))
-
-				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
-					"project":{
-						"name": "%s",
-						"default_domain_enabled": true,
-						"force_https": false,
-						"skip_build": true,
-						"created_at": "%s"
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_params",
+					"errors": {
+						"optimizer": "is invalid"
 					}
-				}`, proj.Name, proj.CreatedAt.Format(time.RFC3339Nano))))
-			})
+				}`))
 
+				Expect(db.First(proj, proj.ID).Error).To(BeNil())
+				Expect(proj.Optimizer).To(Equal(project.OptimizerDocker))
+				Expect(proj.ForceHTTPS).To(BeFalse())
+			})
 		})
 
 		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
@@ -1105,7 +2371,23 @@ var _ = Describe("Projects", func() {
 			Expect(count).To(Equal(0))
 		})
 
-		It("deletes meta.json and ssl certs for the associated domains from s3", func() {
+		It("keeps the domains and certs of the project so that it can be restored", func() {
+			doRequest()
+
+			var count int
+			Expect(db.Unscoped().Model(domain.Domain{}).Where("project_id = ?", proj.ID).Count(&count).Error).To(BeNil())
+			Expect(count).To(Equal(2))
+
+			Expect(db.Unscoped().Model(cert.Cert{}).Where("domain_id IN (?,?)", dm1.ID, dm2.ID).Count(&count).Error).To(BeNil())
+			Expect(count).To(Equal(2))
+
+			deletedProj, err := project.FindDeletedByName(db, proj.Name)
+			Expect(err).To(BeNil())
+			Expect(deletedProj).NotTo(BeNil())
+			Expect(deletedProj.ID).To(Equal(proj.ID))
+		})
+
+		It("deletes meta.json for the associated domains from s3", func() {
 			doRequest()
 
 			Expect(fakeS3.DeleteCalls.Count()).To(Equal(1))
@@ -1116,19 +2398,11 @@ var _ = Describe("Projects", func() {
 			Expect(deleteCall.Arguments[1]).To(Equal(s3client.BucketName))
 			Expect(deleteCall.ReturnValues[0]).To(BeNil())
 
-			filesToDelete := []string{
+			Expect(deleteCall.Arguments[2:]).To(Equal(fake.List{
 				"domains/" + proj.DefaultDomainName() + "/meta.json",
 				"domains/" + dm1.Name + "/meta.json",
-				"certs/" + dm1.Name + "/ssl.crt",
-				"certs/" + dm1.Name + "/ssl.key",
 				"domains/" + dm2.Name + "/meta.json",
-				"certs/" + dm2.Name + "/ssl.crt",
-				"certs/" + dm2.Name + "/ssl.key",
-			}
-
-			for i, path := range filesToDelete {
-				Expect(deleteCall.Arguments[2+i]).To(Equal(path))
-			}
+			}))
 		})
 
 		It("deletes the given project", func() {
@@ -1142,8 +2416,9 @@ var _ = Describe("Projects", func() {
 			d := testhelper.ConsumeQueue(mq, invalidationQueueName)
 			Expect(d).NotTo(BeNil())
 			Expect(d.Body).To(MatchJSON(fmt.Sprintf(`{
-				"domains": ["%s", "%s", "%s"]
-			}`, proj.Name+"."+shared.DefaultDomain, dm1.Name, dm2.Name)))
+				"domains": ["%s", "%s", "%s"],
+				"request_id": "%s"
+			}`, proj.Name+"."+shared.DefaultDomain, dm1.Name, dm2.Name, res.Header.Get("X-Request-Id"))))
 		})
 
 		It("tracks a 'Deleted Project' event", func() {
@@ -1169,6 +2444,28 @@ var _ = Describe("Projects", func() {
 			Expect(trackCall.ReturnValues[0]).To(BeNil())
 		})
 
+		Context("when the project has been renamed", func() {
+			BeforeEach(func() {
+				_, err := projectrename.Create(db, proj.ID, "old-name", proj.Name)
+				Expect(err).To(BeNil())
+			})
+
+			It("deletes meta.json for the former default domain and invalidates it", func() {
+				doRequest()
+
+				deleteCall := fakeS3.DeleteCalls.NthCall(1)
+				Expect(deleteCall).NotTo(BeNil())
+				Expect(deleteCall.Arguments).To(ContainElement("domains/old-name." + shared.DefaultDomain + "/meta.json"))
+
+				d := testhelper.ConsumeQueue(mq, invalidationQueueName)
+				Expect(d).NotTo(BeNil())
+				Expect(d.Body).To(MatchJSON(fmt.Sprintf(`{
+					"domains": ["%s", "%s", "%s", "%s"],
+					"request_id": "%s"
+				}`, proj.Name+"."+shared.DefaultDomain, dm1.Name, dm2.Name, "old-name."+shared.DefaultDomain, res.Header.Get("X-Request-Id"))))
+			})
+		})
+
 		Context("when there are associated raw bundles", func() {
 			var (
 				bun1 *rawbundle.RawBundle
@@ -1180,36 +2477,19 @@ var _ = Describe("Projects", func() {
 				bun2 = factories.RawBundle(db, proj)
 			})
 
-			It("deletes associated raw bundles from DB and S3", func() {
+			It("soft-deletes associated raw bundles and keeps them in S3", func() {
 				doRequest()
 
 				Expect(db.First(bun1, bun1.ID).Error).To(Equal(gorm.RecordNotFound))
 				Expect(db.First(bun2, bun2.ID).Error).To(Equal(gorm.RecordNotFound))
 
-				Expect(fakeS3.DeleteCalls.Count()).To(Equal(1))
+				Expect(db.Unscoped().First(bun1, bun1.ID).Error).To(BeNil())
+				Expect(db.Unscoped().First(bun2, bun2.ID).Error).To(BeNil())
 
 				deleteCall := fakeS3.DeleteCalls.NthCall(1)
 				Expect(deleteCall).NotTo(BeNil())
-				Expect(deleteCall.Arguments[0]).To(Equal(s3client.BucketRegion))
-				Expect(deleteCall.Arguments[1]).To(Equal(s3client.BucketName))
-				Expect(deleteCall.ReturnValues[0]).To(BeNil())
-
-				filesToDelete := []string{
-					"domains/" + proj.DefaultDomainName() + "/meta.json",
-					"domains/" + dm1.Name + "/meta.json",
-					"certs/" + dm1.Name + "/ssl.crt",
-					"certs/" + dm1.Name + "/ssl.key",
-					"domains/" + dm2.Name + "/meta.json",
-					"certs/" + dm2.Name + "/ssl.crt",
-					"certs/" + dm2.Name + "/ssl.key",
-
-					bun1.UploadedPath,
-					bun2.UploadedPath,
-				}
-
-				for i, path := range filesToDelete {
-					Expect(deleteCall.Arguments[2+i]).To(Equal(path))
-				}
+				Expect(deleteCall.Arguments).NotTo(ContainElement(bun1.UploadedPath))
+				Expect(deleteCall.Arguments).NotTo(ContainElement(bun2.UploadedPath))
 			})
 		})
 
@@ -1313,8 +2593,9 @@ var _ = Describe("Projects", func() {
 						"deployment_id": %d,
 						"skip_webroot_upload": true,
 						"skip_invalidation": false,
-						"use_raw_bundle": false
-					}`, *proj.ActiveDeploymentID)))
+						"use_raw_bundle": false,
+						"request_id": %q
+					}`, *proj.ActiveDeploymentID, res.Header.Get("X-Request-Id"))))
 				})
 			})
 		})
@@ -1381,6 +2662,218 @@ var _ = Describe("Projects", func() {
 		}, nil)
 	})
 
+	Describe("POST /projects/:name/restore", func() {
+		var (
+			mq *amqp.Connection
+
+			proj *project.Project
+			dm   *domain.Domain
+
+			headers http.Header
+		)
+
+		BeforeEach(func() {
+			mq, err = mqconn.MQ()
+			Expect(err).To(BeNil())
+
+			testhelper.DeleteQueue(mq, queues.All...)
+
+			headers = http.Header{
+				"Authorization": {"Bearer " + t.Token},
+			}
+
+			proj = factories.Project(db, u)
+			dm = factories.Domain(db, proj)
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("POST", s.URL+"/projects/"+proj.Name+"/restore", nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		Context("when the project has been deleted", func() {
+			BeforeEach(func() {
+				Expect(proj.Destroy(db)).To(BeNil())
+			})
+
+			It("returns 200 with the project", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				var j map[string]map[string]interface{}
+				Expect(json.Unmarshal(b.Bytes(), &j)).To(Succeed())
+				Expect(j["project"]["name"]).To(Equal(proj.Name))
+			})
+
+			It("restores the project and its domains", func() {
+				doRequest()
+
+				Expect(db.First(proj, proj.ID).Error).To(BeNil())
+				Expect(db.First(dm, dm.ID).Error).To(BeNil())
+			})
+
+			It("does not enqueue any job", func() {
+				doRequest()
+
+				d := testhelper.ConsumeQueue(mq, queues.Deploy)
+				Expect(d).To(BeNil())
+			})
+
+			It("tracks a 'Restored Project' event", func() {
+				doRequest()
+
+				trackCall := fakeTracker.TrackCalls.NthCall(1)
+				Expect(trackCall).NotTo(BeNil())
+				Expect(trackCall.Arguments[0]).To(Equal(fmt.Sprintf("%d", u.ID)))
+				Expect(trackCall.Arguments[1]).To(Equal("Restored Project"))
+
+				props, ok := trackCall.Arguments[3].(map[string]interface{})
+				Expect(ok).To(BeTrue())
+				Expect(props["projectName"]).To(Equal(proj.Name))
+			})
+
+			Context("when the project had an active deployment", func() {
+				var depl *deployment.Deployment
+
+				BeforeEach(func() {
+					Expect(db.Unscoped().First(proj, proj.ID).Error).To(BeNil())
+
+					depl = factories.Deployment(db, proj, u, deployment.StateDeployed)
+					Expect(db.Unscoped().Model(depl).UpdateColumn("deleted_at", proj.DeletedAt).Error).To(BeNil())
+					Expect(db.Unscoped().Model(proj).UpdateColumn("active_deployment_id", depl.ID).Error).To(BeNil())
+				})
+
+				It("restores the deployment", func() {
+					doRequest()
+
+					Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				})
+
+				It("enqueues a deploy job to upload meta.json and invalidate the domains", func() {
+					doRequest()
+
+					d := testhelper.ConsumeQueue(mq, queues.Deploy)
+					Expect(d).NotTo(BeNil())
+					Expect(d.Body).To(MatchJSON(fmt.Sprintf(`{
+						"deployment_id": %d,
+						"skip_webroot_upload": true,
+						"skip_invalidation": false,
+						"use_raw_bundle": false,
+						"request_id": %q
+					}`, depl.ID, res.Header.Get("X-Request-Id"))))
+				})
+			})
+
+			Context("when another project has taken one of its domains", func() {
+				BeforeEach(func() {
+					factories.Domain(db, factories.Project(db, u), dm.Name)
+				})
+
+				It("returns 409 conflict and keeps the project deleted", func() {
+					doRequest()
+
+					b := &bytes.Buffer{}
+					_, err := b.ReadFrom(res.Body)
+					Expect(err).To(BeNil())
+
+					Expect(res.StatusCode).To(Equal(http.StatusConflict))
+					Expect(b.String()).To(MatchJSON(`{
+						"error": "already_exists",
+						"error_description": "domain of project has been taken"
+					}`))
+
+					Expect(db.First(proj, proj.ID).Error).To(Equal(gorm.RecordNotFound))
+				})
+			})
+
+			Context("when the user has the max number of projects", func() {
+				var origMaxProjectPerUser int
+
+				BeforeEach(func() {
+					factories.Project(db, u)
+					origMaxProjectPerUser = project.MaxProjectPerUser
+					project.MaxProjectPerUser = 1
+				})
+
+				AfterEach(func() {
+					project.MaxProjectPerUser = origMaxProjectPerUser
+				})
+
+				It("returns 403 invalid request", func() {
+					doRequest()
+
+					b := &bytes.Buffer{}
+					_, err := b.ReadFrom(res.Body)
+					Expect(err).To(BeNil())
+
+					Expect(res.StatusCode).To(Equal(http.StatusForbidden))
+					Expect(b.String()).To(MatchJSON(`{
+						"error": "invalid_request",
+						"error_description": "maximum number of projects reached"
+					}`))
+
+					Expect(db.First(proj, proj.ID).Error).To(Equal(gorm.RecordNotFound))
+				})
+			})
+
+			Context("when the project was deleted before the restore window", func() {
+				BeforeEach(func() {
+					err := db.Unscoped().Model(proj).UpdateColumn("deleted_at", time.Now().Add(-project.RestoreWindow-time.Hour)).Error
+					Expect(err).To(BeNil())
+				})
+
+				It("returns 404 not found", func() {
+					doRequest()
+
+					b := &bytes.Buffer{}
+					_, err := b.ReadFrom(res.Body)
+					Expect(err).To(BeNil())
+
+					Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+					Expect(b.String()).To(MatchJSON(`{
+						"error": "not_found",
+						"error_description": "deleted project could not be found"
+					}`))
+				})
+			})
+
+			Context("when the current user is not the owner of the project", func() {
+				BeforeEach(func() {
+					u2 := factories.User(db)
+					Expect(db.Unscoped().Model(proj).UpdateColumn("user_id", u2.ID).Error).To(BeNil())
+				})
+
+				It("returns 404 not found", func() {
+					doRequest()
+
+					Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+					Expect(db.First(proj, proj.ID).Error).To(Equal(gorm.RecordNotFound))
+				})
+			})
+
+			sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+				return db, u, &headers
+			}, func() *http.Response {
+				doRequest()
+				return res
+			}, nil)
+		})
+
+		Context("when the project has not been deleted", func() {
+			It("returns 404 not found", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+			})
+		})
+	})
+
 	Describe("DELETE /projects/:name/auth", func() {
 		var (
 			mq *amqp.Connection
@@ -1453,8 +2946,9 @@ var _ = Describe("Projects", func() {
 						"deployment_id": %d,
 						"skip_webroot_upload": true,
 						"skip_invalidation": false,
-						"use_raw_bundle": false
-					}`, *proj.ActiveDeploymentID)))
+						"use_raw_bundle": false,
+						"request_id": %q
+					}`, *proj.ActiveDeploymentID, res.Header.Get("X-Request-Id"))))
 				})
 			})
 
@@ -0,0 +1,49 @@
+package projects
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/projectrename"
+	"github.com/nitrous-io/rise-server/pkg/job"
+	"github.com/nitrous-io/rise-server/shared/messages"
+	"github.com/nitrous-io/rise-server/shared/queues"
+)
+
+// isNameReserved returns whether name is the name of a deleted project that
+// has not been purged yet, or a former name of a project other than the one
+// with the given ID, whose grace period has not ended.
+func isNameReserved(db *gorm.DB, name string, projectID uint) (bool, error) {
+	deleted, err := project.IsNameOfDeletedProject(db, name)
+	if err != nil || deleted {
+		return deleted, err
+	}
+
+	r, err := projectrename.FindActiveByOldName(db, name)
+	if err != nil {
+		return false, err
+	}
+
+	return r != nil && r.ProjectID != projectID, nil
+}
+
+// redirectFormerDefaultDomains is called after a project is renamed. It
+// enqueues a deploy job to upload meta.json for the new default domain, make
+// the former default domains of the project redirect to it until their grace
+// period ends, and invalidate them all.
+func redirectFormerDefaultDomains(proj *project.Project, requestID string) error {
+	// Nothing is served from the default domain.
+	if !proj.DefaultDomainEnabled || proj.ActiveDeploymentID == nil {
+		return nil
+	}
+
+	j, err := job.NewWithJSON(queues.Deploy, &messages.DeployJobData{
+		DeploymentID:      *proj.ActiveDeploymentID,
+		SkipWebrootUpload: true,
+		RequestID:         requestID,
+	})
+	if err != nil {
+		return err
+	}
+
+	return j.Enqueue()
+}
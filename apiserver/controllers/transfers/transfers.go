@@ -0,0 +1,371 @@
+// Package transfers lets the owner of a project hand it over to another user.
+// The owner requests a transfer, and the recipient accepts it with the token
+// that is emailed to them.
+package transfers
+
+import (
+	"net/http"
+	"strconv"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/projecttransfer"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+)
+
+// Create requests that the project be transferred to the confirmed user with
+// the given email, and emails them the token to accept it with.
+func Create(c *gin.Context) {
+	u := controllers.CurrentUser(c)
+	proj := controllers.CurrentProject(c)
+
+	email := c.PostForm("email")
+	if email == "" {
+		c.JSON(422, gin.H{
+			"error": "invalid_params",
+			"errors": map[string]string{
+				"email": "is required",
+			},
+		})
+		return
+	}
+
+	if proj.OrganizationID != nil {
+		c.JSON(422, gin.H{
+			"error":             "invalid_request",
+			"error_description": "projects of organizations cannot be transferred",
+		})
+		return
+	}
+
+	var keepOwnerAsCollaborator bool
+	if v := c.PostForm("keep_owner_as_collaborator"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			c.JSON(422, gin.H{
+				"error": "invalid_params",
+				"errors": map[string]string{
+					"keep_owner_as_collaborator": "is invalid",
+				},
+			})
+			return
+		}
+		keepOwnerAsCollaborator = b
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	recipient, err := user.FindByEmail(db, email)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+	if recipient == nil || recipient.ConfirmedAt == nil {
+		c.JSON(422, gin.H{
+			"error":             "invalid_params",
+			"error_description": "email is not found",
+		})
+		return
+	}
+
+	if recipient.ID == proj.UserID {
+		c.JSON(422, gin.H{
+			"error":             "invalid_request",
+			"error_description": "project cannot be transferred to its owner",
+		})
+		return
+	}
+
+	canAdd, err := project.CanAddProject(db, recipient)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+	if !canAdd {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":             "invalid_request",
+			"error_description": "recipient has reached the maximum number of projects",
+		})
+		return
+	}
+
+	tx := db.Begin()
+	if err := tx.Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+	defer tx.Rollback()
+
+	t := &projecttransfer.ProjectTransfer{
+		ProjectID:               proj.ID,
+		FromUserID:              u.ID,
+		ToUserID:                recipient.ID,
+		KeepOwnerAsCollaborator: keepOwnerAsCollaborator,
+	}
+	if err := projecttransfer.Create(tx, t); err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if err := sendTransferEmail(t, proj, u, recipient); err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	track(c, u, "Requested Project Transfer", map[string]interface{}{
+		"projectName":    proj.Name,
+		"recipientEmail": recipient.Email,
+	})
+
+	c.JSON(http.StatusCreated, gin.H{
+		"transfer": t.AsJSON(proj.Name, u.Email, recipient.Email),
+	})
+}
+
+// Destroy cancels the transfer of the project that has not been accepted yet.
+func Destroy(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	t, err := projecttransfer.FindPending(db, proj.ID)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+	if t == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "transfer could not be found",
+		})
+		return
+	}
+
+	if err := projecttransfer.CancelPending(db, proj.ID); err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"canceled": true,
+	})
+}
+
+// Index lists the transfers to the current user that have not been accepted
+// yet. The tokens to accept them with are only sent by email.
+func Index(c *gin.Context) {
+	u := controllers.CurrentUser(c)
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	transfers, err := projecttransfer.PendingTransfersToUser(db, u.ID)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	transfersJSON := []interface{}{}
+	for _, t := range transfers {
+		var proj project.Project
+		if err := db.First(&proj, t.ProjectID).Error; err != nil {
+			if err == gorm.RecordNotFound {
+				continue
+			}
+			controllers.InternalServerError(c, err)
+			return
+		}
+
+		var from user.User
+		if err := db.First(&from, t.FromUserID).Error; err != nil {
+			if err == gorm.RecordNotFound {
+				continue
+			}
+			controllers.InternalServerError(c, err)
+			return
+		}
+
+		transfersJSON = append(transfersJSON, t.AsJSON(proj.Name, from.Email, u.Email))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"transfers": transfersJSON,
+	})
+}
+
+// Accept makes the current user the owner of the project of the transfer with
+// the given token, if they are its recipient.
+func Accept(c *gin.Context) {
+	recipient := controllers.CurrentUser(c)
+
+	token := c.PostForm("token")
+	if token == "" {
+		c.JSON(422, gin.H{
+			"error": "invalid_params",
+			"errors": map[string]string{
+				"token": "is required",
+			},
+		})
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	notFound := func() {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "transfer could not be found",
+		})
+	}
+
+	t, err := projecttransfer.FindPendingByToken(db, token)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+	// Someone else who got hold of the token must not be able to tell that it
+	// is valid.
+	if t == nil || t.ToUserID != recipient.ID {
+		notFound()
+		return
+	}
+
+	var proj project.Project
+	if err := db.First(&proj, t.ProjectID).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			notFound()
+			return
+		}
+		controllers.InternalServerError(c, err)
+		return
+	}
+	// The project has changed hands since the transfer was requested.
+	if proj.UserID != t.FromUserID {
+		notFound()
+		return
+	}
+
+	canAdd, err := project.CanAddProject(db, recipient)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+	if !canAdd {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":             "invalid_request",
+			"error_description": "maximum number of projects reached",
+		})
+		return
+	}
+
+	acquired, err := proj.Lock(db)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+	if !acquired {
+		c.JSON(423, gin.H{
+			"error":             "locked",
+			"error_description": "project is locked",
+		})
+		return
+	}
+	defer func() {
+		if err := proj.Unlock(db); err != nil {
+			log.Errorf("failed to unlock project %d, err: %v", proj.ID, err)
+		}
+	}()
+
+	tx := db.Begin()
+	if err := tx.Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+	defer tx.Rollback()
+
+	if err := proj.TransferTo(tx, recipient, t.KeepOwnerAsCollaborator); err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if err := t.MarkAccepted(tx); err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	track(c, recipient, "Accepted Project Transfer", map[string]interface{}{
+		"projectName": proj.Name,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"project": proj.AsJSON(),
+	})
+}
+
+func track(c *gin.Context, u *user.User, event string, props map[string]interface{}) {
+	context := map[string]interface{}{
+		"ip":         common.GetIP(c.Request),
+		"user_agent": c.Request.UserAgent(),
+	}
+	if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
+		log.Errorf("failed to track %q event for user ID %d, err: %v",
+			event, u.ID, err)
+	}
+}
+
+func sendTransferEmail(t *projecttransfer.ProjectTransfer, proj *project.Project, from, to *user.User) error {
+	subject := from.Email + " would like to transfer " + proj.Name + " to you on PubStorm"
+
+	txt := from.Email + " would like to make you the owner of the PubStorm project \"" + proj.Name + "\".\n\n" +
+		"To accept the transfer, please use the following code with the PubStorm CLI or API:\n\n" +
+		t.Token + "\n\n" +
+		"This code expires on " + t.ExpiresAt().UTC().Format("January 2, 2006") + ". If you do not wish to own this project, you can ignore this email.\n\n" +
+		"Thanks,\n" +
+		"PubStorm"
+
+	html := "<p>" + from.Email + " would like to make you the owner of the PubStorm project \"" + proj.Name + "\".</p>" +
+		"<p>To accept the transfer, please use the following code with the PubStorm CLI or API:</p>" +
+		"<p><strong>" + t.Token + "</strong></p>" +
+		"<p>This code expires on " + t.ExpiresAt().UTC().Format("January 2, 2006") + ". If you do not wish to own this project, you can ignore this email.</p>" +
+		"<p>Thanks,<br />" +
+		"PubStorm</p>"
+
+	return common.SendMail(
+		[]string{to.Email}, // tos
+		nil,                // ccs
+		nil,                // bccs
+		subject,            // subject
+		txt,                // text body
+		html,               // html body
+	)
+}
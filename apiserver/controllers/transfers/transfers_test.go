@@ -0,0 +1,616 @@
+package transfers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/collab"
+	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/projecttransfer"
+	"github.com/nitrous-io/rise-server/apiserver/models/repo"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/apiserver/server"
+	"github.com/nitrous-io/rise-server/pkg/mailer"
+	"github.com/nitrous-io/rise-server/pkg/tracker"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+	"github.com/nitrous-io/rise-server/testhelper/fake"
+	"github.com/nitrous-io/rise-server/testhelper/sharedexamples"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "transfers")
+}
+
+var _ = Describe("Transfers", func() {
+	var (
+		db *gorm.DB
+
+		s   *httptest.Server
+		res *http.Response
+		err error
+
+		u  *user.User
+		u2 *user.User
+		t  *oauthtoken.OauthToken
+
+		headers http.Header
+		proj    *project.Project
+
+		fakeMailer *fake.Mailer
+		origMailer mailer.Mailer
+
+		fakeTracker *fake.Tracker
+		origTracker tracker.Trackable
+	)
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+
+		testhelper.TruncateTables(db.DB())
+		u, _, t = factories.AuthTrio(db)
+		u2 = factories.User(db)
+
+		proj = &project.Project{
+			Name:   "foo-bar-express",
+			UserID: u.ID,
+		}
+		Expect(db.Create(proj).Error).To(BeNil())
+
+		headers = http.Header{
+			"Authorization": {"Bearer " + t.Token},
+		}
+
+		origMailer = common.Mailer
+		fakeMailer = &fake.Mailer{}
+		common.Mailer = fakeMailer
+
+		origTracker = common.Tracker
+		fakeTracker = &fake.Tracker{}
+		common.Tracker = fakeTracker
+	})
+
+	AfterEach(func() {
+		common.Mailer = origMailer
+		common.Tracker = origTracker
+
+		if res != nil {
+			res.Body.Close()
+		}
+		s.Close()
+	})
+
+	readBody := func() string {
+		b := &bytes.Buffer{}
+		_, err := b.ReadFrom(res.Body)
+		Expect(err).To(BeNil())
+		return b.String()
+	}
+
+	requestTransfer := func(to *user.User, keepOwnerAsCollaborator bool) *projecttransfer.ProjectTransfer {
+		tr := &projecttransfer.ProjectTransfer{
+			ProjectID:               proj.ID,
+			FromUserID:              proj.UserID,
+			ToUserID:                to.ID,
+			KeepOwnerAsCollaborator: keepOwnerAsCollaborator,
+		}
+		Expect(projecttransfer.Create(db, tr)).To(Succeed())
+		return tr
+	}
+
+	Describe("POST /projects/:project_name/transfer", func() {
+		var params url.Values
+
+		BeforeEach(func() {
+			params = url.Values{
+				"email":                      {u2.Email},
+				"keep_owner_as_collaborator": {"true"},
+			}
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("POST", s.URL+"/projects/foo-bar-express/transfer", params, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		It("returns 201 with the transfer", func() {
+			doRequest()
+
+			Expect(res.StatusCode).To(Equal(http.StatusCreated))
+
+			var j struct {
+				Transfer map[string]interface{} `json:"transfer"`
+			}
+			Expect(json.NewDecoder(res.Body).Decode(&j)).To(Succeed())
+
+			Expect(j.Transfer["project_name"]).To(Equal("foo-bar-express"))
+			Expect(j.Transfer["from"]).To(Equal(u.Email))
+			Expect(j.Transfer["to"]).To(Equal(u2.Email))
+			Expect(j.Transfer["keep_owner_as_collaborator"]).To(BeTrue())
+
+			createdAt, err := time.Parse(time.RFC3339Nano, j.Transfer["created_at"].(string))
+			Expect(err).To(BeNil())
+			expiresAt, err := time.Parse(time.RFC3339Nano, j.Transfer["expires_at"].(string))
+			Expect(err).To(BeNil())
+			Expect(expiresAt.Sub(createdAt)).To(Equal(projecttransfer.ExpiresIn))
+			Expect(j.Transfer).NotTo(HaveKey("token"))
+		})
+
+		It("does not transfer the project yet", func() {
+			doRequest()
+
+			Expect(db.First(proj, proj.ID).Error).To(BeNil())
+			Expect(proj.UserID).To(Equal(u.ID))
+		})
+
+		It("emails the token to the recipient", func() {
+			doRequest()
+
+			tr, err := projecttransfer.FindPending(db, proj.ID)
+			Expect(err).To(BeNil())
+			Expect(tr.ToUserID).To(Equal(u2.ID))
+			Expect(tr.KeepOwnerAsCollaborator).To(BeTrue())
+
+			Expect(fakeMailer.SendMailCalled).To(BeTrue())
+			Expect(fakeMailer.From).To(Equal(common.MailerEmail))
+			Expect(fakeMailer.Tos).To(Equal([]string{u2.Email}))
+			Expect(fakeMailer.Subject).To(ContainSubstring("foo-bar-express"))
+			Expect(fakeMailer.Body).To(ContainSubstring(tr.Token))
+			Expect(fakeMailer.HTML).To(ContainSubstring(tr.Token))
+		})
+
+		It("tracks a 'Requested Project Transfer' event", func() {
+			doRequest()
+
+			trackCall := fakeTracker.TrackCalls.NthCall(1)
+			Expect(trackCall).NotTo(BeNil())
+			Expect(trackCall.Arguments[0]).To(Equal(fmt.Sprintf("%d", u.ID)))
+			Expect(trackCall.Arguments[1]).To(Equal("Requested Project Transfer"))
+
+			props, ok := trackCall.Arguments[3].(map[string]interface{})
+			Expect(ok).To(BeTrue())
+			Expect(props["projectName"]).To(Equal("foo-bar-express"))
+		})
+
+		Context("when there is a pending transfer", func() {
+			var tr *projecttransfer.ProjectTransfer
+
+			BeforeEach(func() {
+				tr = requestTransfer(factories.User(db), false)
+			})
+
+			It("replaces it", func() {
+				doRequest()
+				Expect(res.StatusCode).To(Equal(http.StatusCreated))
+
+				found, err := projecttransfer.FindPendingByToken(db, tr.Token)
+				Expect(err).To(BeNil())
+				Expect(found).To(BeNil())
+
+				found, err = projecttransfer.FindPending(db, proj.ID)
+				Expect(err).To(BeNil())
+				Expect(found.ToUserID).To(Equal(u2.ID))
+			})
+		})
+
+		DescribeTable("errors",
+			func(setup func(), expectedCode int, expectedBody string) {
+				setup()
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(expectedCode))
+				Expect(readBody()).To(MatchJSON(expectedBody))
+
+				tr, err := projecttransfer.FindPending(db, proj.ID)
+				Expect(err).To(BeNil())
+				Expect(tr).To(BeNil())
+				Expect(fakeMailer.SendMailCalled).To(BeFalse())
+			},
+			Entry("when email is missing", func() {
+				params.Del("email")
+			}, 422, `{
+				"error": "invalid_params",
+				"errors": {
+					"email": "is required"
+				}
+			}`),
+			Entry("when keep_owner_as_collaborator is invalid", func() {
+				params.Set("keep_owner_as_collaborator", "maybe")
+			}, 422, `{
+				"error": "invalid_params",
+				"errors": {
+					"keep_owner_as_collaborator": "is invalid"
+				}
+			}`),
+			Entry("when the email does not belong to a user", func() {
+				params.Set("email", "nobody@example.com")
+			}, 422, `{
+				"error": "invalid_params",
+				"error_description": "email is not found"
+			}`),
+			Entry("when the recipient has not confirmed their email", func() {
+				Expect(db.Model(u2).Update("confirmed_at", nil).Error).To(BeNil())
+			}, 422, `{
+				"error": "invalid_params",
+				"error_description": "email is not found"
+			}`),
+			Entry("when the recipient is the owner", func() {
+				params.Set("email", u.Email)
+			}, 422, `{
+				"error": "invalid_request",
+				"error_description": "project cannot be transferred to its owner"
+			}`),
+			Entry("when the recipient cannot own any more projects", func() {
+				for i := 0; i < project.MaxProjectPerUser; i++ {
+					factories.Project(db, u2)
+				}
+			}, http.StatusForbidden, `{
+				"error": "invalid_request",
+				"error_description": "recipient has reached the maximum number of projects"
+			}`),
+		)
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProject(func() (*gorm.DB, *project.Project) {
+			return db, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItLocksProject(func() (*gorm.DB, *project.Project) {
+			return db, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
+
+	Describe("DELETE /projects/:project_name/transfer", func() {
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("DELETE", s.URL+"/projects/foo-bar-express/transfer", nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		Context("when there is a pending transfer", func() {
+			BeforeEach(func() {
+				requestTransfer(u2, false)
+			})
+
+			It("cancels it", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				Expect(readBody()).To(MatchJSON(`{"canceled": true}`))
+
+				tr, err := projecttransfer.FindPending(db, proj.ID)
+				Expect(err).To(BeNil())
+				Expect(tr).To(BeNil())
+			})
+		})
+
+		Context("when there is no pending transfer", func() {
+			It("returns 404", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+				Expect(readBody()).To(MatchJSON(`{
+					"error": "not_found",
+					"error_description": "transfer could not be found"
+				}`))
+			})
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProject(func() (*gorm.DB, *project.Project) {
+			return db, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
+
+	Describe("GET /transfers", func() {
+		var tr *projecttransfer.ProjectTransfer
+
+		BeforeEach(func() {
+			u3 := factories.User(db)
+			proj2 := factories.Project(db, u3)
+			tr = &projecttransfer.ProjectTransfer{
+				ProjectID:  proj2.ID,
+				FromUserID: u3.ID,
+				ToUserID:   u.ID,
+			}
+			Expect(projecttransfer.Create(db, tr)).To(Succeed())
+			Expect(db.First(tr, tr.ID).Error).To(BeNil())
+
+			// Transfer to another user.
+			requestTransfer(u2, false)
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("GET", s.URL+"/transfers", nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		It("returns the pending transfers to the current user without their tokens", func() {
+			doRequest()
+
+			var proj2 project.Project
+			Expect(db.First(&proj2, tr.ProjectID).Error).To(BeNil())
+			var from user.User
+			Expect(db.First(&from, tr.FromUserID).Error).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(readBody()).To(MatchJSON(fmt.Sprintf(`{
+				"transfers": [
+					{
+						"project_name": %q,
+						"from": %q,
+						"to": %q,
+						"keep_owner_as_collaborator": false,
+						"created_at": %q,
+						"expires_at": %q
+					}
+				]
+			}`, proj2.Name, from.Email, u.Email,
+				tr.CreatedAt.Format(time.RFC3339Nano),
+				tr.ExpiresAt().Format(time.RFC3339Nano))))
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
+
+	Describe("POST /transfers/accept", func() {
+		var (
+			tr     *projecttransfer.ProjectTransfer
+			params url.Values
+			rp     *repo.Repo
+		)
+
+		BeforeEach(func() {
+			tr = requestTransfer(u2, false)
+			params = url.Values{
+				"token": {tr.Token},
+			}
+
+			// The transfer is accepted by the recipient.
+			t2 := &oauthtoken.OauthToken{
+				UserID:        u2.ID,
+				OauthClientID: t.OauthClientID,
+			}
+			Expect(db.Create(t2).Error).To(BeNil())
+			headers = http.Header{
+				"Authorization": {"Bearer " + t2.Token},
+			}
+
+			factories.Collab(db, proj, u2)
+
+			rp = &repo.Repo{
+				ProjectID: proj.ID,
+				UserID:    u.ID,
+				URI:       "https://github.com/PubStorm/pubstorm-www.git",
+			}
+			Expect(db.Create(rp).Error).To(BeNil())
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("POST", s.URL+"/transfers/accept", params, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		collabUserIDs := func() []uint {
+			cols := []collab.Collab{}
+			Expect(db.Where("project_id = ?", proj.ID).Find(&cols).Error).To(BeNil())
+
+			ids := []uint{}
+			for _, col := range cols {
+				ids = append(ids, col.UserID)
+			}
+			return ids
+		}
+
+		It("transfers the project to the recipient", func() {
+			doRequest()
+
+			Expect(db.First(proj, proj.ID).Error).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(readBody()).To(ContainSubstring(`"name":"foo-bar-express"`))
+
+			Expect(proj.UserID).To(Equal(u2.ID))
+			Expect(proj.LockedAt).To(BeNil())
+			Expect(collabUserIDs()).To(BeEmpty())
+
+			Expect(db.First(rp, rp.ID).Error).To(BeNil())
+			Expect(rp.UserID).To(Equal(u2.ID))
+
+			Expect(db.First(tr, tr.ID).Error).To(BeNil())
+			Expect(tr.AcceptedAt).NotTo(BeNil())
+		})
+
+		It("tracks an 'Accepted Project Transfer' event", func() {
+			doRequest()
+
+			trackCall := fakeTracker.TrackCalls.NthCall(1)
+			Expect(trackCall).NotTo(BeNil())
+			Expect(trackCall.Arguments[0]).To(Equal(fmt.Sprintf("%d", u2.ID)))
+			Expect(trackCall.Arguments[1]).To(Equal("Accepted Project Transfer"))
+
+			props, ok := trackCall.Arguments[3].(map[string]interface{})
+			Expect(ok).To(BeTrue())
+			Expect(props["projectName"]).To(Equal("foo-bar-express"))
+		})
+
+		It("cannot be accepted twice", func() {
+			doRequest()
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			res.Body.Close()
+			s.Close()
+
+			doRequest()
+			Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+		})
+
+		Context("when the previous owner is kept as a collaborator", func() {
+			BeforeEach(func() {
+				tr = requestTransfer(u2, true)
+				params.Set("token", tr.Token)
+			})
+
+			It("adds the previous owner as a collaborator", func() {
+				doRequest()
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				Expect(collabUserIDs()).To(Equal([]uint{u.ID}))
+			})
+		})
+
+		Context("when the project has changed hands since the transfer was requested", func() {
+			var u3 *user.User
+
+			BeforeEach(func() {
+				u3 = factories.User(db)
+				Expect(db.Model(proj).UpdateColumn("user_id", u3.ID).Error).To(BeNil())
+			})
+
+			It("returns 404", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+				Expect(readBody()).To(MatchJSON(`{
+					"error": "not_found",
+					"error_description": "transfer could not be found"
+				}`))
+
+				Expect(db.First(proj, proj.ID).Error).To(BeNil())
+				Expect(proj.UserID).To(Equal(u3.ID))
+			})
+		})
+
+		DescribeTable("errors",
+			func(setup func(), expectedCode int, expectedBody string) {
+				setup()
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(expectedCode))
+				Expect(readBody()).To(MatchJSON(expectedBody))
+
+				Expect(db.Unscoped().First(proj, proj.ID).Error).To(BeNil())
+				Expect(proj.UserID).To(Equal(u.ID))
+			},
+			Entry("when the current user is not the recipient", func() {
+				_, _, t3 := factories.AuthTrio(db)
+				headers.Set("Authorization", "Bearer "+t3.Token)
+			}, http.StatusNotFound, `{
+				"error": "not_found",
+				"error_description": "transfer could not be found"
+			}`),
+			Entry("when the current user is the owner of the project", func() {
+				headers.Set("Authorization", "Bearer "+t.Token)
+			}, http.StatusNotFound, `{
+				"error": "not_found",
+				"error_description": "transfer could not be found"
+			}`),
+			Entry("when token is missing", func() {
+				params.Del("token")
+			}, 422, `{
+				"error": "invalid_params",
+				"errors": {
+					"token": "is required"
+				}
+			}`),
+			Entry("when token is wrong", func() {
+				params.Set("token", "wrong")
+			}, http.StatusNotFound, `{
+				"error": "not_found",
+				"error_description": "transfer could not be found"
+			}`),
+			Entry("when the transfer has expired", func() {
+				createdAt := time.Now().Add(-projecttransfer.ExpiresIn - time.Minute)
+				Expect(db.Model(tr).UpdateColumn("created_at", createdAt).Error).To(BeNil())
+			}, http.StatusNotFound, `{
+				"error": "not_found",
+				"error_description": "transfer could not be found"
+			}`),
+			Entry("when the transfer has been canceled", func() {
+				Expect(projecttransfer.CancelPending(db, proj.ID)).To(Succeed())
+			}, http.StatusNotFound, `{
+				"error": "not_found",
+				"error_description": "transfer could not be found"
+			}`),
+			Entry("when the project has been deleted", func() {
+				Expect(db.Delete(proj).Error).To(BeNil())
+			}, http.StatusNotFound, `{
+				"error": "not_found",
+				"error_description": "transfer could not be found"
+			}`),
+			Entry("when the recipient cannot own any more projects", func() {
+				for i := 0; i < project.MaxProjectPerUser; i++ {
+					factories.Project(db, u2)
+				}
+			}, http.StatusForbidden, `{
+				"error": "invalid_request",
+				"error_description": "maximum number of projects reached"
+			}`),
+			Entry("when the project is locked", func() {
+				now := time.Now()
+				proj.LockedAt = &now
+				Expect(db.Save(proj).Error).To(BeNil())
+			}, 423, `{
+				"error": "locked",
+				"error_description": "project is locked"
+			}`),
+		)
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u2, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, func() {
+			Expect(db.First(proj, proj.ID).Error).To(BeNil())
+			Expect(proj.UserID).To(Equal(u.ID))
+
+			Expect(db.First(tr, tr.ID).Error).To(BeNil())
+			Expect(tr.AcceptedAt).To(BeNil())
+		})
+	})
+})
@@ -8,10 +8,12 @@ import (
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
 	"github.com/nitrous-io/rise-server/apiserver/common"
 	"github.com/nitrous-io/rise-server/apiserver/controllers"
 	"github.com/nitrous-io/rise-server/apiserver/dbconn"
 	"github.com/nitrous-io/rise-server/apiserver/models/blacklistedemail"
+	"github.com/nitrous-io/rise-server/apiserver/models/invitation"
 	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
 	"github.com/nitrous-io/rise-server/apiserver/models/user"
 )
@@ -163,6 +165,8 @@ func Confirm(c *gin.Context) {
 	{
 		u, err := user.FindByEmail(db, email)
 		if err == nil {
+			acceptInvitations(db, u, c.PostForm("invitation_token"))
+
 			var (
 				anonymousID = c.PostForm("anonymous_id")
 				event       = "Confirmed Email"
@@ -496,3 +500,41 @@ func sendPasswordResetToken(u *user.User) error {
 		html,              // html body
 	)
 }
+
+// acceptInvitations makes u a collaborator of the projects that their email,
+// or the given invitation token, has been invited to. Errors are logged rather
+// than returned, as they should not fail the confirmation of the user.
+func acceptInvitations(db *gorm.DB, u *user.User, token string) {
+	invs, err := invitation.InvitationsByEmail(db, u.Email)
+	if err != nil {
+		log.Errorf("failed to find invitations for user ID %d, err: %v", u.ID, err)
+		return
+	}
+
+	if token != "" {
+		inv, err := invitation.FindByToken(db, token, common.AesKey)
+		if err != nil {
+			log.Errorf("failed to find invitation by token for user ID %d, err: %v", u.ID, err)
+		} else if inv != nil && inv.Email != u.Email {
+			invs = append(invs, inv)
+		}
+	}
+
+	for _, inv := range invs {
+		tx := db.Begin()
+		if err := tx.Error; err != nil {
+			log.Errorf("failed to begin transaction, err: %v", err)
+			return
+		}
+
+		if err := inv.Accept(tx, u); err != nil {
+			tx.Rollback()
+			log.Errorf("failed to accept invitation ID %d for user ID %d, err: %v", inv.ID, u.ID, err)
+			continue
+		}
+
+		if err := tx.Commit().Error; err != nil {
+			log.Errorf("failed to accept invitation ID %d for user ID %d, err: %v", inv.ID, u.ID, err)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
 	"time"
 
@@ -14,8 +15,11 @@ import (
 	"github.com/nitrous-io/rise-server/apiserver/common"
 	"github.com/nitrous-io/rise-server/apiserver/controllers/users"
 	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/collab"
+	"github.com/nitrous-io/rise-server/apiserver/models/invitation"
 	"github.com/nitrous-io/rise-server/apiserver/models/oauthclient"
 	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
 	"github.com/nitrous-io/rise-server/apiserver/models/user"
 	"github.com/nitrous-io/rise-server/apiserver/server"
 	"github.com/nitrous-io/rise-server/pkg/mailer"
@@ -406,6 +410,85 @@ var _ = Describe("Users", func() {
 
 				Expect(trackCall.ReturnValues[0]).To(BeNil())
 			})
+
+			Context("when the email has been invited to collaborate on projects", func() {
+				var (
+					proj  *project.Project
+					proj2 *project.Project
+				)
+
+				BeforeEach(func() {
+					proj = factories.Project(db, nil)
+					proj2 = factories.Project(db, nil)
+
+					for _, p := range []*project.Project{proj, proj2} {
+						inv, err := invitation.New(p.ID, p.UserID, u.Email, collab.RoleDeployer, common.AesKey)
+						Expect(err).To(BeNil())
+						Expect(invitation.Create(db, inv)).To(Succeed())
+					}
+				})
+
+				It("makes the user a collaborator of the projects", func() {
+					doRequest()
+
+					Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+					for _, p := range []*project.Project{proj, proj2} {
+						role, err := p.CollaboratorRole(db, u)
+						Expect(err).To(BeNil())
+						Expect(role).To(Equal(collab.RoleDeployer))
+					}
+
+					invs, err := invitation.InvitationsByEmail(db, u.Email)
+					Expect(err).To(BeNil())
+					Expect(invs).To(BeEmpty())
+				})
+			})
+
+			Context("when an invitation token for another email is provided", func() {
+				var (
+					proj *project.Project
+					inv  *invitation.Invitation
+				)
+
+				BeforeEach(func() {
+					proj = factories.Project(db, nil)
+
+					inv, err = invitation.New(proj.ID, proj.UserID, "foo@work.example.com", collab.RoleViewer, common.AesKey)
+					Expect(err).To(BeNil())
+					Expect(invitation.Create(db, inv)).To(Succeed())
+				})
+
+				It("makes the user a collaborator of the project", func() {
+					params.Set("invitation_token", inv.Token)
+
+					doRequest()
+
+					Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+					role, err := proj.CollaboratorRole(db, u)
+					Expect(err).To(BeNil())
+					Expect(role).To(Equal(collab.RoleViewer))
+
+					found, err := invitation.FindByEmail(db, proj.ID, "foo@work.example.com")
+					Expect(err).To(BeNil())
+					Expect(found).To(BeNil())
+				})
+
+				It("ignores the token if its signature is invalid", func() {
+					forged := strings.SplitN(inv.Token, ".", 2)[0] + "." + strings.Repeat("0", 64)
+					Expect(db.Model(inv).Update("token", forged).Error).To(BeNil())
+					params.Set("invitation_token", forged)
+
+					doRequest()
+
+					Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+					role, err := proj.CollaboratorRole(db, u)
+					Expect(err).To(BeNil())
+					Expect(role).To(Equal(""))
+				})
+			})
 		})
 	})
 
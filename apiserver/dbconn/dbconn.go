@@ -9,8 +9,19 @@ import (
 )
 
 var (
-	db     *gorm.DB
-	dbLock sync.Mutex
+	db       *gorm.DB
+	dbLock   sync.Mutex
+	poolSize int
+)
+
+const (
+	// connsPerJob is how many connections a job may use at the same time,
+	// e.g. one for a transaction and one for queries outside of it.
+	connsPerJob = 2
+
+	// poolHeadroom is how many connections are kept for work other than
+	// jobs, such as health checks, so that it is not held up by busy jobs.
+	poolHeadroom = 2
 )
 
 // DB returns gorm DB handle
@@ -26,6 +37,34 @@ func DB() (*gorm.DB, error) {
 			d.LogMode(false)
 		}
 		db = &d
+		applyPoolSize()
 	}
 	return db, nil
 }
+
+// SetPoolSize sizes the connection pool for a worker that processes n jobs at
+// the same time. It allows enough open connections for every job to use a
+// transaction alongside other queries, with headroom for health checks, and
+// keeps up to n idle connections around for reuse. A size of 0 means no
+// limit.
+func SetPoolSize(n int) {
+	dbLock.Lock()
+	defer dbLock.Unlock()
+	poolSize = n
+	if db != nil {
+		applyPoolSize()
+	}
+}
+
+func applyPoolSize() {
+	if poolSize > 0 {
+		db.DB().SetMaxOpenConns(maxOpenConns(poolSize))
+		db.DB().SetMaxIdleConns(poolSize)
+	}
+}
+
+// maxOpenConns returns the number of open connections that a worker that
+// processes n jobs at the same time is limited to.
+func maxOpenConns(n int) int {
+	return n*connsPerJob + poolHeadroom
+}
@@ -0,0 +1,15 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+)
+
+// AllowDeployToken is a Gin middleware that lets the routes after it be
+// authenticated with the deploy token of a project, as well as with an OAuth
+// token. It must be used before RequireToken.
+func AllowDeployToken(c *gin.Context) {
+	c.Set(controllers.AllowDeployTokenKey, true)
+
+	c.Next()
+}
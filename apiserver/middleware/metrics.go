@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nitrous-io/rise-server/pkg/metrics"
+)
+
+var (
+	requestsTotal = metrics.NewCounter(
+		"http_requests_total",
+		"Number of HTTP requests served, by route and status code.",
+		"method", "route", "status",
+	)
+	requestDuration = metrics.NewHistogram(
+		"http_request_duration_seconds",
+		"Time taken to serve HTTP requests, by route.",
+		nil,
+		"method", "route",
+	)
+)
+
+// Metrics returns a middleware that records the number of requests and their
+// latency for each route of r.
+func Metrics(r *gin.Engine) gin.HandlerFunc {
+	var (
+		once   sync.Once
+		routes map[string]bool
+	)
+
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		// Routes are drawn after the middleware is added, so look them up on
+		// the first request.
+		once.Do(func() {
+			routes = map[string]bool{}
+			for _, ri := range r.Routes() {
+				routes[ri.Method+" "+ri.Path] = true
+			}
+		})
+
+		route := routePattern(c)
+		if !routes[c.Request.Method+" "+route] {
+			// Group requests that did not match a route together so that
+			// arbitrary paths do not each get their own time series.
+			route = "unmatched"
+		}
+
+		requestsTotal.Inc(c.Request.Method, route, strconv.Itoa(c.Writer.Status()))
+		requestDuration.ObserveSince(start, c.Request.Method, route)
+	}
+}
+
+// routePattern returns the pattern of the route that matched the request, e.g.
+// "/projects/:project_name", by replacing path parameter values with their
+// names.
+func routePattern(c *gin.Context) string {
+	path := c.Request.URL.Path
+	if len(c.Params) == 0 {
+		return path
+	}
+
+	segments := strings.Split(path, "/")
+	i := 0
+	for j, seg := range segments {
+		if i < len(c.Params) && seg == c.Params[i].Value {
+			segments[j] = ":" + c.Params[i].Key
+			i++
+		}
+	}
+	return strings.Join(segments, "/")
+}
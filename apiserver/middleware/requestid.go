@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/xtgo/uuid"
+)
+
+const RequestIDHeader = "X-Request-Id"
+
+var requestIDRe = regexp.MustCompile(`\A[A-Za-z0-9\-_.]{1,64}\z`)
+
+// RequestID assigns an ID to every request so that the jobs it enqueues and
+// the log lines they produce can be traced back to it. An ID given by the
+// client in the X-Request-Id header is reused if it looks sane; otherwise a
+// new one is generated. The ID is returned in the X-Request-Id response
+// header.
+func RequestID(c *gin.Context) {
+	id := c.Request.Header.Get(RequestIDHeader)
+	if !requestIDRe.MatchString(id) {
+		id = uuid.NewRandom().String()
+	}
+
+	c.Set(controllers.RequestIDKey, id)
+	c.Header(RequestIDHeader, id)
+	c.Next()
+}
@@ -0,0 +1,91 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/middleware"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "middleware")
+}
+
+var _ = Describe("RequestID", func() {
+	var (
+		r         *gin.Engine
+		reqHeader string
+		seenID    string
+		res       *httptest.ResponseRecorder
+	)
+
+	BeforeEach(func() {
+		gin.SetMode(gin.TestMode)
+		r = gin.New()
+		r.Use(middleware.RequestID)
+		r.GET("/", func(c *gin.Context) {
+			seenID = controllers.RequestID(c)
+			c.String(http.StatusOK, "ok")
+		})
+
+		reqHeader = ""
+		seenID = ""
+	})
+
+	doRequest := func() {
+		req, err := http.NewRequest("GET", "/", nil)
+		Expect(err).To(BeNil())
+		if reqHeader != "" {
+			req.Header.Set(middleware.RequestIDHeader, reqHeader)
+		}
+		res = httptest.NewRecorder()
+		r.ServeHTTP(res, req)
+	}
+
+	It("generates a request ID and returns it in the response", func() {
+		doRequest()
+
+		Expect(seenID).To(MatchRegexp(`\A[0-9a-f\-]{36}\z`))
+		Expect(res.Header().Get(middleware.RequestIDHeader)).To(Equal(seenID))
+	})
+
+	It("generates a different ID for every request", func() {
+		doRequest()
+		first := seenID
+		doRequest()
+		Expect(seenID).NotTo(Equal(first))
+	})
+
+	Context("when the client sends a request ID", func() {
+		BeforeEach(func() {
+			reqHeader = "abc-123"
+		})
+
+		It("uses it", func() {
+			doRequest()
+
+			Expect(seenID).To(Equal("abc-123"))
+			Expect(res.Header().Get(middleware.RequestIDHeader)).To(Equal("abc-123"))
+		})
+	})
+
+	Context("when the client sends an invalid request ID", func() {
+		BeforeEach(func() {
+			reqHeader = "<script>alert(1)</script>"
+		})
+
+		It("ignores it and generates a new one", func() {
+			doRequest()
+
+			Expect(seenID).NotTo(Equal(reqHeader))
+			Expect(seenID).To(MatchRegexp(`\A[0-9a-f\-]{36}\z`))
+		})
+	})
+})
@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+)
+
+// RequireDeletedProject is a Gin middleware that checks that the
+// "project_name" parameter in the path is the name of a project that was
+// deleted within project.RestoreWindow, and that the current user owns it.
+func RequireDeletedProject(c *gin.Context) {
+	u := controllers.CurrentUser(c)
+	if u == nil {
+		controllers.InternalServerError(c, nil)
+		c.Abort()
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		c.Abort()
+		return
+	}
+
+	name := c.Param("project_name")
+	proj, err := project.FindDeletedByName(db, name)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		c.Abort()
+		return
+	}
+
+	isOwner := false
+	if proj != nil {
+		isOwner, err = isProjectOwner(db, proj, u)
+		if err != nil {
+			controllers.InternalServerError(c, err)
+			c.Abort()
+			return
+		}
+	}
+
+	if !isOwner {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "deleted project could not be found",
+		})
+		c.Abort()
+		return
+	}
+
+	c.Set(controllers.CurrentProjectKey, proj)
+
+	c.Next()
+}
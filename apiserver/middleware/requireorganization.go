@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/organization"
+)
+
+// RequireOrganizationMember is a Gin middleware that:
+// 1. checks that the "org_name" parameter in the path is the name of a valid
+//    organization, and
+// 2. ensures that the current user is a member of the organization.
+func RequireOrganizationMember(c *gin.Context) {
+	requireOrganization(c, false)
+}
+
+// RequireOrganizationOwner is a Gin middleware that:
+// 1. checks that the "org_name" parameter in the path is the name of a valid
+//    organization, and
+// 2. ensures that the current user is an owner of the organization.
+func RequireOrganizationOwner(c *gin.Context) {
+	requireOrganization(c, true)
+}
+
+func requireOrganization(c *gin.Context, ownerOnly bool) {
+	u := controllers.CurrentUser(c)
+	if u == nil {
+		controllers.InternalServerError(c, nil)
+		c.Abort()
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		c.Abort()
+		return
+	}
+
+	org, err := organization.FindByName(db, c.Param("org_name"))
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		c.Abort()
+		return
+	}
+
+	var m *organization.Member
+	if org != nil {
+		m, err = organization.Membership(db, org.ID, u.ID)
+		if err != nil {
+			controllers.InternalServerError(c, err)
+			c.Abort()
+			return
+		}
+	}
+
+	if m == nil || (ownerOnly && m.Role != organization.RoleOwner) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "organization could not be found",
+		})
+		c.Abort()
+		return
+	}
+
+	c.Set(controllers.CurrentOrganizationKey, org)
+
+	c.Next()
+}
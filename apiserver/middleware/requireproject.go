@@ -4,15 +4,19 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
 	"github.com/nitrous-io/rise-server/apiserver/controllers"
 	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/organization"
 	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
 )
 
 // RequireProject is a Gin middleware that:
 // 1. checks that the "project_name" parameter in the path is the name of a
 //    valid project, and
-// 2. ensures that the project is owned by the current user.
+// 2. ensures that the project is owned by the current user, or by an
+//    organization that the current user is an owner of.
 func RequireProject(c *gin.Context) {
 	u := controllers.CurrentUser(c)
 	if u == nil {
@@ -36,7 +40,17 @@ func RequireProject(c *gin.Context) {
 		return
 	}
 
-	if proj == nil || proj.UserID != u.ID {
+	isOwner := false
+	if proj != nil {
+		isOwner, err = isProjectOwner(db, proj, u)
+		if err != nil {
+			controllers.InternalServerError(c, err)
+			c.Abort()
+			return
+		}
+	}
+
+	if !isOwner {
 		c.JSON(http.StatusNotFound, gin.H{
 			"error":             "not_found",
 			"error_description": "project could not be found",
@@ -49,3 +63,19 @@ func RequireProject(c *gin.Context) {
 
 	c.Next()
 }
+
+// isProjectOwner returns whether u owns proj. The projects of an organization
+// are owned by the owners of the organization, rather than by the user who
+// created them.
+func isProjectOwner(db *gorm.DB, proj *project.Project, u *user.User) (bool, error) {
+	if proj.OrganizationID == nil {
+		return proj.UserID == u.ID, nil
+	}
+
+	m, err := organization.Membership(db, *proj.OrganizationID, u.ID)
+	if err != nil {
+		return false, err
+	}
+
+	return m != nil && m.Role == organization.RoleOwner, nil
+}
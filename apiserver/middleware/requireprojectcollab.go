@@ -4,17 +4,24 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
 	"github.com/nitrous-io/rise-server/apiserver/controllers"
 	"github.com/nitrous-io/rise-server/apiserver/dbconn"
 	"github.com/nitrous-io/rise-server/apiserver/models/collab"
+	"github.com/nitrous-io/rise-server/apiserver/models/organization"
 	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
 )
 
 // RequireProjectCollab is a Gin middleware that:
 // 1. checks that the "project_name" parameter in the path is the name of a
 //    valid project, and
 // 2. ensures that the current user is the owner or a collaborator of the
-//    project.
+//    project, or a member of the organization that owns it, and
+// 3. sets the role of the current user in the project, which owners and
+//    members of the organization have the admin role in, and deploy tokens of
+//    the project have the deployer role in while the user who created them
+//    can still deploy to the project.
 func RequireProjectCollab(c *gin.Context) {
 	u := controllers.CurrentUser(c)
 	if u == nil {
@@ -46,16 +53,20 @@ func RequireProjectCollab(c *gin.Context) {
 		return
 	}
 
-	if proj.UserID != u.ID {
-		// If user is not the project owner, check if he is a collaborator.
-		cnt := 0
-		if err := db.Model(collab.Collab{}).Where("project_id = ? AND user_id = ?", proj.ID, u.ID).Count(&cnt).Error; err != nil {
-			controllers.InternalServerError(c, err)
-			c.Abort()
-			return
+	// A deploy token only has the deployer role in its own project, and only
+	// while the user who created it can still deploy to the project.
+	if t := controllers.CurrentDeployToken(c); t != nil {
+		role := ""
+		if t.ProjectID == proj.ID {
+			role, err = projectRole(db, proj, u)
+			if err != nil {
+				controllers.InternalServerError(c, err)
+				c.Abort()
+				return
+			}
 		}
 
-		if cnt == 0 {
+		if !collab.HasRole(role, collab.RoleDeployer) {
 			c.JSON(http.StatusNotFound, gin.H{
 				"error":             "not_found",
 				"error_description": "project could not be found",
@@ -63,9 +74,65 @@ func RequireProjectCollab(c *gin.Context) {
 			c.Abort()
 			return
 		}
+
+		c.Set(controllers.CurrentProjectKey, proj)
+		c.Set(controllers.CurrentProjectRoleKey, collab.RoleDeployer)
+
+		c.Next()
+		return
+	}
+
+	role, err := projectRole(db, proj, u)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		c.Abort()
+		return
+	}
+
+	if role == "" {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "project could not be found",
+		})
+		c.Abort()
+		return
 	}
 
 	c.Set(controllers.CurrentProjectKey, proj)
+	c.Set(controllers.CurrentProjectRoleKey, role)
 
 	c.Next()
 }
+
+// projectRole returns the role of u in the project, or an empty string if
+// they are neither the owner nor a collaborator of the project, nor a member
+// of the organization that owns it.
+func projectRole(db *gorm.DB, proj *project.Project, u *user.User) (string, error) {
+	isOwner, err := isProjectOwner(db, proj, u)
+	if err != nil {
+		return "", err
+	}
+	if isOwner {
+		return collab.RoleAdmin, nil
+	}
+
+	// If user is not the project owner, check if he is a collaborator.
+	role, err := proj.CollaboratorRole(db, u)
+	if err != nil {
+		return "", err
+	}
+
+	// If user is not a collaborator either, check if he is a member of the
+	// organization that owns the project.
+	if role == "" && proj.OrganizationID != nil {
+		m, err := organization.Membership(db, *proj.OrganizationID, u.ID)
+		if err != nil {
+			return "", err
+		}
+		if m != nil {
+			role = collab.RoleAdmin
+		}
+	}
+
+	return role, nil
+}
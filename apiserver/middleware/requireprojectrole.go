@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/models/collab"
+)
+
+// RequireProjectRole returns a Gin middleware that ensures that the current
+// user has at least the given collaborator role in the current project. It
+// must be used after RequireProjectCollab.
+func RequireProjectRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !collab.HasRole(controllers.CurrentProjectRole(c), role) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":             "forbidden",
+				"error_description": "you do not have permission to perform this action",
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
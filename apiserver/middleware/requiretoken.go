@@ -8,6 +8,7 @@ import (
 	"github.com/jinzhu/gorm"
 	"github.com/nitrous-io/rise-server/apiserver/controllers"
 	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/deploytoken"
 	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
 	"github.com/nitrous-io/rise-server/apiserver/models/user"
 )
@@ -42,6 +43,11 @@ func RequireToken(c *gin.Context) {
 	}
 
 	if t == nil {
+		if _, allowed := c.Get(controllers.AllowDeployTokenKey); allowed {
+			requireDeployToken(c, db, match[1])
+			return
+		}
+
 		c.Header("WWW-Authenticate", `Bearer realm="rise-user"`)
 		c.JSON(http.StatusUnauthorized, gin.H{
 			"error":             "invalid_token",
@@ -72,3 +78,47 @@ func RequireToken(c *gin.Context) {
 
 	c.Next()
 }
+
+// requireDeployToken authenticates the request with the deploy token of a
+// project, as the user who created it, and records that it has been used.
+func requireDeployToken(c *gin.Context, db *gorm.DB, token string) {
+	t, err := deploytoken.FindByToken(db, token)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		c.Abort()
+		return
+	}
+
+	u := &user.User{}
+	if t != nil {
+		if err := db.First(u, t.CreatedByID).Error; err != nil {
+			if err != gorm.RecordNotFound {
+				controllers.InternalServerError(c, err)
+				c.Abort()
+				return
+			}
+			t = nil
+		}
+	}
+
+	if t == nil {
+		c.Header("WWW-Authenticate", `Bearer realm="rise-user"`)
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error":             "invalid_token",
+			"error_description": "access token is invalid",
+		})
+		c.Abort()
+		return
+	}
+
+	if err := t.Touch(db); err != nil {
+		controllers.InternalServerError(c, err)
+		c.Abort()
+		return
+	}
+
+	c.Set(controllers.CurrentDeployTokenKey, t)
+	c.Set(controllers.CurrentUserKey, u)
+
+	c.Next()
+}
@@ -0,0 +1 @@
+ALTER TABLE projects ADD COLUMN optimizer character varying(255) DEFAULT 'docker' NOT NULL;
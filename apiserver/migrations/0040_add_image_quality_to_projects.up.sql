@@ -0,0 +1 @@
+ALTER TABLE projects ADD COLUMN image_quality integer DEFAULT 0 NOT NULL;
@@ -0,0 +1 @@
+ALTER TABLE projects ADD COLUMN fingerprint_assets boolean DEFAULT false NOT NULL;
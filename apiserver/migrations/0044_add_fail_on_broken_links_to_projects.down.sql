@@ -0,0 +1 @@
+ALTER TABLE projects DROP COLUMN fail_on_broken_links;
@@ -0,0 +1 @@
+ALTER TABLE projects ADD COLUMN fail_on_broken_links boolean DEFAULT false NOT NULL;
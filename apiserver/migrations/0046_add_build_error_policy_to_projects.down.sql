@@ -0,0 +1 @@
+ALTER TABLE projects DROP COLUMN build_error_policy;
@@ -0,0 +1 @@
+ALTER TABLE projects ADD COLUMN build_error_policy character varying(255) DEFAULT 'raw_bundle' NOT NULL;
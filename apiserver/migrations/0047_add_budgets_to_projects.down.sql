@@ -0,0 +1,5 @@
+ALTER TABLE projects DROP COLUMN fail_over_budget;
+ALTER TABLE projects DROP COLUMN max_page_weight;
+ALTER TABLE projects DROP COLUMN max_css_size;
+ALTER TABLE projects DROP COLUMN max_js_size;
+ALTER TABLE projects DROP COLUMN max_total_size;
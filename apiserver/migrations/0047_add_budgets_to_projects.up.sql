@@ -0,0 +1,5 @@
+ALTER TABLE projects ADD COLUMN max_total_size bigint DEFAULT 0 NOT NULL;
+ALTER TABLE projects ADD COLUMN max_js_size bigint DEFAULT 0 NOT NULL;
+ALTER TABLE projects ADD COLUMN max_css_size bigint DEFAULT 0 NOT NULL;
+ALTER TABLE projects ADD COLUMN max_page_weight bigint DEFAULT 0 NOT NULL;
+ALTER TABLE projects ADD COLUMN fail_over_budget boolean DEFAULT false NOT NULL;
@@ -0,0 +1 @@
+ALTER TABLE projects DROP COLUMN encrypted_build_env_vars;
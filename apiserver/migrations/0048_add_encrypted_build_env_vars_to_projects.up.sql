@@ -0,0 +1 @@
+ALTER TABLE projects ADD COLUMN encrypted_build_env_vars text DEFAULT '' NOT NULL;
@@ -0,0 +1,4 @@
+DROP INDEX index_project_transfers_on_token;
+DROP INDEX index_project_transfers_on_to_user_id;
+DROP INDEX index_project_transfers_on_project_id;
+DROP TABLE project_transfers;
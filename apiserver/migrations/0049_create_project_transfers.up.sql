@@ -0,0 +1,19 @@
+CREATE TABLE project_transfers (
+  id bigserial PRIMARY KEY NOT NULL,
+
+  project_id bigint REFERENCES projects(id) NOT NULL,
+  from_user_id bigint REFERENCES users(id) NOT NULL,
+  to_user_id bigint REFERENCES users(id) NOT NULL,
+  keep_owner_as_collaborator boolean DEFAULT false NOT NULL,
+
+  token character varying(255) DEFAULT encode(gen_random_bytes(16), 'hex') NOT NULL,
+  accepted_at timestamp without time zone,
+
+  created_at timestamp without time zone DEFAULT now() NOT NULL,
+  updated_at timestamp without time zone DEFAULT now() NOT NULL,
+  deleted_at timestamp without time zone
+);
+
+CREATE INDEX index_project_transfers_on_project_id ON project_transfers USING btree (project_id);
+CREATE INDEX index_project_transfers_on_to_user_id ON project_transfers USING btree (to_user_id);
+CREATE UNIQUE INDEX index_project_transfers_on_token ON project_transfers USING btree (token) WHERE deleted_at IS NULL;
@@ -0,0 +1,2 @@
+DROP INDEX index_organizations_on_name;
+DROP TABLE organizations;
@@ -0,0 +1,11 @@
+CREATE TABLE organizations (
+  id bigserial PRIMARY KEY NOT NULL,
+
+  name character varying(255) NOT NULL,
+
+  created_at timestamp without time zone DEFAULT now() NOT NULL,
+  updated_at timestamp without time zone DEFAULT now() NOT NULL,
+  deleted_at timestamp without time zone
+);
+
+CREATE UNIQUE INDEX index_organizations_on_name ON organizations USING btree (name) WHERE deleted_at IS NULL;
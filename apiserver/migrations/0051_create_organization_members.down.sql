@@ -0,0 +1,3 @@
+DROP INDEX index_organization_members_on_organization_id_and_user_id;
+DROP INDEX index_organization_members_on_user_id;
+DROP TABLE organization_members;
@@ -0,0 +1,14 @@
+CREATE TABLE organization_members (
+  id bigserial PRIMARY KEY NOT NULL,
+
+  organization_id bigint REFERENCES organizations(id) NOT NULL,
+  user_id bigint REFERENCES users(id) NOT NULL,
+  role character varying(255) DEFAULT 'member' NOT NULL,
+
+  created_at timestamp without time zone DEFAULT now() NOT NULL,
+  updated_at timestamp without time zone DEFAULT now() NOT NULL,
+  deleted_at timestamp without time zone
+);
+
+CREATE INDEX index_organization_members_on_user_id ON organization_members USING btree (user_id);
+CREATE UNIQUE INDEX index_organization_members_on_organization_id_and_user_id ON organization_members USING btree (organization_id, user_id) WHERE deleted_at IS NULL;
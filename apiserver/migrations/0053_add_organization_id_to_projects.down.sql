@@ -0,0 +1,3 @@
+DROP INDEX index_projects_on_organization_id;
+
+ALTER TABLE projects DROP COLUMN organization_id;
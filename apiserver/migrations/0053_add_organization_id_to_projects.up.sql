@@ -0,0 +1,3 @@
+ALTER TABLE projects ADD COLUMN organization_id bigint REFERENCES organizations(id);
+
+CREATE INDEX index_projects_on_organization_id ON projects USING btree (organization_id);
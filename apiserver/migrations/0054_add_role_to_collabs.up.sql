@@ -0,0 +1 @@
+ALTER TABLE collabs ADD COLUMN role varchar(255) DEFAULT 'admin' NOT NULL;
@@ -0,0 +1,17 @@
+CREATE TABLE invitations (
+  id bigserial PRIMARY KEY NOT NULL,
+
+  project_id bigint REFERENCES projects(id) NOT NULL,
+  invited_by_id bigint REFERENCES users(id) NOT NULL,
+  email character varying(255) NOT NULL,
+  role character varying(255) DEFAULT 'admin' NOT NULL,
+  token character varying(255) NOT NULL,
+
+  created_at timestamp without time zone DEFAULT now() NOT NULL,
+  updated_at timestamp without time zone DEFAULT now() NOT NULL,
+  deleted_at timestamp without time zone
+);
+
+CREATE INDEX index_invitations_on_email ON invitations USING btree (email);
+CREATE UNIQUE INDEX index_invitations_on_project_id_and_email ON invitations USING btree (project_id, email) WHERE deleted_at IS NULL;
+CREATE UNIQUE INDEX index_invitations_on_token ON invitations USING btree (token) WHERE deleted_at IS NULL;
@@ -0,0 +1,14 @@
+CREATE TABLE project_renames (
+  id bigserial PRIMARY KEY NOT NULL,
+
+  project_id bigint REFERENCES projects(id) NOT NULL,
+  old_name character varying(255) NOT NULL,
+  expires_at timestamp without time zone NOT NULL,
+
+  created_at timestamp without time zone DEFAULT now() NOT NULL,
+  updated_at timestamp without time zone DEFAULT now() NOT NULL,
+  deleted_at timestamp without time zone
+);
+
+CREATE INDEX index_project_renames_on_project_id ON project_renames USING btree (project_id);
+CREATE INDEX index_project_renames_on_old_name ON project_renames USING btree (old_name);
@@ -0,0 +1 @@
+DROP TABLE deploy_tokens;
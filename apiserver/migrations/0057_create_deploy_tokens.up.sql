@@ -0,0 +1,16 @@
+CREATE TABLE deploy_tokens (
+  id bigserial PRIMARY KEY NOT NULL,
+
+  project_id bigint REFERENCES projects(id) NOT NULL,
+  created_by_id bigint REFERENCES users(id) NOT NULL,
+  name character varying(255) NOT NULL,
+  token character varying(255) DEFAULT encode(gen_random_bytes(32), 'hex') NOT NULL,
+  last_used_at timestamp without time zone,
+
+  created_at timestamp without time zone DEFAULT now() NOT NULL,
+  updated_at timestamp without time zone DEFAULT now() NOT NULL,
+  deleted_at timestamp without time zone
+);
+
+CREATE INDEX index_deploy_tokens_on_project_id ON deploy_tokens USING btree (project_id);
+CREATE UNIQUE INDEX index_deploy_tokens_on_token ON deploy_tokens USING btree (token) WHERE deleted_at IS NULL;
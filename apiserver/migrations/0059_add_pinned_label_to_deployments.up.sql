@@ -0,0 +1 @@
+ALTER TABLE deployments ADD COLUMN pinned_label varchar(255);
@@ -2,9 +2,37 @@ package collab
 
 import "github.com/jinzhu/gorm"
 
+// Roles of collaborators, from the least to the most privileged. Viewers have
+// read-only access to a project, deployers can also deploy and roll back, and
+// admins can also manage its settings, domains, certs and environment
+// variables.
+const (
+	RoleViewer   = "viewer"
+	RoleDeployer = "deployer"
+	RoleAdmin    = "admin"
+)
+
+var roleRanks = map[string]int{
+	RoleViewer:   1,
+	RoleDeployer: 2,
+	RoleAdmin:    3,
+}
+
 type Collab struct {
 	gorm.Model
 
 	UserID    uint
 	ProjectID uint
+	Role      string `sql:"default:'admin'"`
+}
+
+// IsValidRole returns whether role is a role that collaborators can have.
+func IsValidRole(role string) bool {
+	_, ok := roleRanks[role]
+	return ok
+}
+
+// HasRole returns whether role grants at least the access of required.
+func HasRole(role, required string) bool {
+	return IsValidRole(role) && roleRanks[role] >= roleRanks[required]
 }
@@ -0,0 +1,44 @@
+package collab_test
+
+import (
+	"testing"
+
+	"github.com/nitrous-io/rise-server/apiserver/models/collab"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "collab")
+}
+
+var _ = Describe("Collab", func() {
+	DescribeTable("IsValidRole()",
+		func(role string, expected bool) {
+			Expect(collab.IsValidRole(role)).To(Equal(expected))
+		},
+		Entry("viewer", collab.RoleViewer, true),
+		Entry("deployer", collab.RoleDeployer, true),
+		Entry("admin", collab.RoleAdmin, true),
+		Entry("owner", "owner", false),
+		Entry("empty", "", false),
+	)
+
+	DescribeTable("HasRole()",
+		func(role, required string, expected bool) {
+			Expect(collab.HasRole(role, required)).To(Equal(expected))
+		},
+		Entry("viewer for viewer", collab.RoleViewer, collab.RoleViewer, true),
+		Entry("viewer for deployer", collab.RoleViewer, collab.RoleDeployer, false),
+		Entry("viewer for admin", collab.RoleViewer, collab.RoleAdmin, false),
+		Entry("deployer for viewer", collab.RoleDeployer, collab.RoleViewer, true),
+		Entry("deployer for deployer", collab.RoleDeployer, collab.RoleDeployer, true),
+		Entry("deployer for admin", collab.RoleDeployer, collab.RoleAdmin, false),
+		Entry("admin for admin", collab.RoleAdmin, collab.RoleAdmin, true),
+		Entry("invalid role", "owner", collab.RoleViewer, false),
+		Entry("no role", "", collab.RoleViewer, false),
+	)
+})
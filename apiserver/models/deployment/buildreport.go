@@ -0,0 +1,247 @@
+package deployment
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Budgets that a deployment can be over, which are named after the settings
+// of projects.
+const (
+	BudgetTotalSize  = "max_total_size"
+	BudgetJSSize     = "max_js_size"
+	BudgetCSSSize    = "max_css_size"
+	BudgetPageWeight = "max_page_weight"
+)
+
+// BuildReport describes what the builder did to the files of a deployment.
+type BuildReport struct {
+	// TotalSize is the total size of the files of the deployment after it
+	// was built, and OriginalSize what it was before.
+	TotalSize    int64 `json:"total_size"`
+	OriginalSize int64 `json:"original_size"`
+
+	// SizesByType are the total sizes of the files of each type, such as
+	// "js" or "image".
+	SizesByType map[string]int64 `json:"sizes_by_type,omitempty"`
+
+	// LargestFiles lists the largest files, largest first.
+	LargestFiles []*FileSize `json:"largest_files,omitempty"`
+
+	// HeaviestPages lists the HTML documents with the largest page weight,
+	// which includes the stylesheets, scripts, images and fonts they load,
+	// heaviest first.
+	HeaviestPages []*FileSize `json:"heaviest_pages,omitempty"`
+
+	// OverBudget lists the budgets of the project that the deployment is
+	// over.
+	OverBudget []*BudgetViolation `json:"over_budget,omitempty"`
+
+	// Optimized lists the files that were made smaller.
+	Optimized []*OptimizedFile `json:"optimized"`
+
+	// Fingerprinted maps the paths of the files that were copied to
+	// content-hashed names to the paths of their copies.
+	Fingerprinted map[string]string `json:"fingerprinted,omitempty"`
+
+	// BrokenLinks lists the links in HTML documents that lead nowhere.
+	BrokenLinks []*BrokenLink `json:"broken_links,omitempty"`
+}
+
+// BrokenLink is a link to a file that does not exist, or to a fragment that
+// no element has as its ID.
+type BrokenLink struct {
+	Path string `json:"path"` // of the document with the link
+	Line int    `json:"line"`
+	Link string `json:"link"`
+}
+
+func (l *BrokenLink) String() string {
+	return fmt.Sprintf("%s:%d: %s", l.Path, l.Line, l.Link)
+}
+
+// FileSize is the size of a file, or the page weight of an HTML document.
+type FileSize struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// BudgetViolation is a budget of the project that a deployment is over.
+type BudgetViolation struct {
+	Budget string `json:"budget"`
+	Path   string `json:"path,omitempty"` // of the page, for BudgetPageWeight
+	Limit  int64  `json:"limit"`
+	Size   int64  `json:"size"`
+}
+
+func (v *BudgetViolation) String() string {
+	var what string
+	switch v.Budget {
+	case BudgetTotalSize:
+		what = "total size"
+	case BudgetJSSize:
+		what = "total size of scripts"
+	case BudgetCSSSize:
+		what = "total size of stylesheets"
+	case BudgetPageWeight:
+		what = "page weight of " + v.Path
+	default:
+		what = v.Budget
+	}
+	return fmt.Sprintf("%s is %d bytes, over the budget of %d bytes", what, v.Size, v.Limit)
+}
+
+// OptimizedFile is a file that was made smaller when it was built.
+type OptimizedFile struct {
+	Path          string `json:"path"`
+	Stage         string `json:"stage"`
+	OriginalSize  int64  `json:"original_size"`
+	OptimizedSize int64  `json:"optimized_size"`
+	BytesSaved    int64  `json:"bytes_saved"`
+}
+
+// AddOptimizedFile records that the file at path was made smaller by stage.
+func (r *BuildReport) AddOptimizedFile(path, stage string, originalSize, optimizedSize int64) {
+	r.Optimized = append(r.Optimized, &OptimizedFile{
+		Path:          path,
+		Stage:         stage,
+		OriginalSize:  originalSize,
+		OptimizedSize: optimizedSize,
+		BytesSaved:    originalSize - optimizedSize,
+	})
+}
+
+// SetSizes records the sizes of the files of the deployment by their paths,
+// the page weights of its HTML documents, and the type of each file given by
+// fileType. Up to n of the largest files and heaviest pages are listed.
+func (r *BuildReport) SetSizes(files, pages map[string]int64, fileType func(path string) string, n int) {
+	r.TotalSize = 0
+	r.SizesByType = map[string]int64{}
+	for p, size := range files {
+		r.TotalSize += size
+		r.SizesByType[fileType(p)] += size
+	}
+	r.OriginalSize = r.TotalSize + r.BytesSaved()
+
+	r.LargestFiles = largest(files, n)
+	r.HeaviestPages = largest(pages, n)
+}
+
+// AddBudgetViolation records that the deployment is over budget.
+func (r *BuildReport) AddBudgetViolation(budget, path string, limit, size int64) {
+	r.OverBudget = append(r.OverBudget, &BudgetViolation{
+		Budget: budget,
+		Path:   path,
+		Limit:  limit,
+		Size:   size,
+	})
+}
+
+// AddBrokenLink records that the document at path has a broken link on line.
+func (r *BuildReport) AddBrokenLink(path string, line int, link string) {
+	r.BrokenLinks = append(r.BrokenLinks, &BrokenLink{
+		Path: path,
+		Line: line,
+		Link: link,
+	})
+}
+
+// BytesSaved returns the total number of bytes saved by optimizing files.
+func (r *BuildReport) BytesSaved() int64 {
+	var n int64
+	for _, f := range r.Optimized {
+		n += f.BytesSaved
+	}
+	return n
+}
+
+// ReportComparison is how the sizes in a build report have changed since an
+// earlier deployment.
+type ReportComparison struct {
+	DeploymentID uint  `json:"deployment_id"`
+	Version      int64 `json:"version"`
+
+	TotalSize         int64            `json:"total_size"` // of the earlier deployment
+	TotalSizeChange   int64            `json:"total_size_change"`
+	SizesByTypeChange map[string]int64 `json:"sizes_by_type_change"`
+}
+
+// CompareWith returns how the sizes in r have changed since the deployment
+// prev with the report prevReport.
+func (r *BuildReport) CompareWith(prev *Deployment, prevReport *BuildReport) *ReportComparison {
+	c := &ReportComparison{
+		DeploymentID:      prev.ID,
+		Version:           prev.Version,
+		TotalSize:         prevReport.TotalSize,
+		TotalSizeChange:   r.TotalSize - prevReport.TotalSize,
+		SizesByTypeChange: map[string]int64{},
+	}
+
+	for typ, size := range r.SizesByType {
+		c.SizesByTypeChange[typ] = size - prevReport.SizesByType[typ]
+	}
+	for typ, size := range prevReport.SizesByType {
+		if _, ok := r.SizesByType[typ]; !ok {
+			c.SizesByTypeChange[typ] = -size
+		}
+	}
+	return c
+}
+
+// largest returns up to n of the sizes, largest first, and in order of path
+// if they are the same.
+func largest(sizes map[string]int64, n int) []*FileSize {
+	list := make([]*FileSize, 0, len(sizes))
+	for p, size := range sizes {
+		list = append(list, &FileSize{Path: p, Size: size})
+	}
+	sort.Sort(bySizeDesc(list))
+
+	if len(list) > n {
+		list = list[:n]
+	}
+	return list
+}
+
+type bySizeDesc []*FileSize
+
+func (s bySizeDesc) Len() int      { return len(s) }
+func (s bySizeDesc) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s bySizeDesc) Less(i, j int) bool {
+	if s[i].Size != s[j].Size {
+		return s[i].Size > s[j].Size
+	}
+	return s[i].Path < s[j].Path
+}
+
+// SaveBuildReport stores the report of building the deployment.
+func (d *Deployment) SaveBuildReport(db *gorm.DB, r *BuildReport) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	if err := db.Model(Deployment{}).Where("id = ?", d.ID).Update("build_report", string(b)).Error; err != nil {
+		return err
+	}
+
+	d.BuildReport = b
+	return nil
+}
+
+// Report returns the report of building the deployment, or nil if it was not
+// built.
+func (d *Deployment) Report() (*BuildReport, error) {
+	if len(d.BuildReport) == 0 {
+		return nil, nil
+	}
+
+	r := &BuildReport{}
+	if err := json.Unmarshal(d.BuildReport, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
@@ -0,0 +1,89 @@
+package deployment_test
+
+import (
+	"path"
+
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("BuildReport", func() {
+	fileType := func(p string) string {
+		return path.Ext(p)[1:]
+	}
+
+	Describe("SetSizes()", func() {
+		It("records the total sizes and lists the largest files and heaviest pages", func() {
+			r := &deployment.BuildReport{}
+			r.AddOptimizedFile("js/app.js", "js", 500, 300)
+
+			r.SetSizes(map[string]int64{
+				"index.html": 100,
+				"about.html": 50,
+				"js/app.js":  300,
+				"js/lib.js":  300,
+				"app.css":    200,
+			}, map[string]int64{
+				"index.html": 600,
+				"about.html": 50,
+			}, fileType, 3)
+
+			Expect(r.TotalSize).To(Equal(int64(950)))
+			Expect(r.OriginalSize).To(Equal(int64(1150)))
+			Expect(r.SizesByType).To(Equal(map[string]int64{
+				"html": 150,
+				"js":   600,
+				"css":  200,
+			}))
+			Expect(r.LargestFiles).To(Equal([]*deployment.FileSize{
+				{Path: "js/app.js", Size: 300},
+				{Path: "js/lib.js", Size: 300},
+				{Path: "app.css", Size: 200},
+			}))
+			Expect(r.HeaviestPages).To(Equal([]*deployment.FileSize{
+				{Path: "index.html", Size: 600},
+				{Path: "about.html", Size: 50},
+			}))
+		})
+	})
+
+	Describe("CompareWith()", func() {
+		It("returns how the sizes have changed", func() {
+			prev := &deployment.Deployment{Version: 3}
+			prev.ID = 12
+			prevReport := &deployment.BuildReport{
+				TotalSize:   1000,
+				SizesByType: map[string]int64{"js": 600, "css": 200, "font": 200},
+			}
+			r := &deployment.BuildReport{
+				TotalSize:   1100,
+				SizesByType: map[string]int64{"js": 800, "css": 100, "image": 200},
+			}
+
+			Expect(r.CompareWith(prev, prevReport)).To(Equal(&deployment.ReportComparison{
+				DeploymentID:    12,
+				Version:         3,
+				TotalSize:       1000,
+				TotalSizeChange: 100,
+				SizesByTypeChange: map[string]int64{
+					"js":    200,
+					"css":   -100,
+					"image": 200,
+					"font":  -200,
+				},
+			}))
+		})
+	})
+})
+
+var _ = Describe("BudgetViolation", func() {
+	It("describes the budget that is exceeded", func() {
+		v := &deployment.BudgetViolation{Budget: deployment.BudgetJSSize, Limit: 1000, Size: 1200}
+		Expect(v.String()).To(Equal("total size of scripts is 1200 bytes, over the budget of 1000 bytes"))
+
+		v = &deployment.BudgetViolation{Budget: deployment.BudgetPageWeight, Path: "index.html", Limit: 1000, Size: 1200}
+		Expect(v.String()).To(Equal("page weight of index.html is 1200 bytes, over the budget of 1000 bytes"))
+	})
+})
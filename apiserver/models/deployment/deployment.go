@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/pkg/metrics"
 )
 
 // Allowed deployment states.
@@ -22,6 +23,12 @@ const (
 	StatePendingUpdateConfig = "pending_update_config"
 )
 
+var stateTransitions = metrics.NewCounter(
+	"deployment_state_transitions_total",
+	"Number of deployment state changes, by previous and new state.",
+	"from", "to",
+)
+
 // Errors returned from this package.
 var (
 	ErrInvalidState = errors.New("state is not valid")
@@ -42,12 +49,16 @@ type Deployment struct {
 	RawBundleID *uint
 	TemplateID  *uint
 
-	JsEnvVars []byte `sql:"default:{}"`
+	JsEnvVars     []byte `sql:"default:{}"`
+	BuildReport   []byte
+	ProjectConfig []byte // contents of the pubstorm.json in the bundle
+	Diagnostics   []byte
 
 	DeployedAt *time.Time
 	PurgedAt   *time.Time
 
 	ErrorMessage *string
+	PinnedLabel  *string // deployments with a label are never deleted by a retention policy
 }
 
 // JSON specifies which fields of a deployment will be marshaled to JSON.
@@ -58,6 +69,9 @@ type JSON struct {
 	Active       bool       `json:"active,omitempty"`
 	DeployedAt   *time.Time `json:"deployed_at,omitempty"`
 	ErrorMessage *string    `json:"error_message,omitempty"`
+	PinnedLabel  *string    `json:"pinned_label,omitempty"`
+
+	Diagnostics []*Diagnostic `json:"diagnostics,omitempty"`
 }
 
 // AsJSON returns a struct that can be converted to JSON
@@ -68,6 +82,7 @@ func (d *Deployment) AsJSON() *JSON {
 		Version:      d.Version,
 		DeployedAt:   d.DeployedAt,
 		ErrorMessage: d.ErrorMessage,
+		PinnedLabel:  d.PinnedLabel,
 	}
 }
 
@@ -96,6 +111,23 @@ func (d *Deployment) PreviousCompletedDeployment(db *gorm.DB) (*Deployment, erro
 	return &prevDepl, nil
 }
 
+// PreviousBuiltDeployment returns the latest deployment of the project that
+// was created before d and has a build report, or nil if there is none.
+func (d *Deployment) PreviousBuiltDeployment(db *gorm.DB) (*Deployment, error) {
+	var prevDepl Deployment
+
+	if err := db.Where("project_id = ? AND id < ? AND build_report IS NOT NULL", d.ProjectID, d.ID).
+		Order("id DESC").
+		First(&prevDepl).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &prevDepl, nil
+}
+
 // CompletedDeployments returns completed deployments up to the given limit.
 // A limit of 0 implies no limit (i.e. all deployments will be returned).
 // Apologies for the magic number, but who'd ask for 0 deployments anyway.
@@ -112,15 +144,34 @@ func CompletedDeployments(db *gorm.DB, projectID, limit uint) ([]*Deployment, er
 	return depls, nil
 }
 
-// DeleteExceptLastN deletes all but the last n deployed deployments.
-func DeleteExceptLastN(db *gorm.DB, projectID, n uint) error {
-	q := db.Exec(`
+// DeleteExpired soft-deletes the deployed deployments of a project that its
+// retention policy does not keep. A deployment is kept if it is one of the
+// last keepLastN deployments or was deployed in the last keepDays days, so
+// with both set, a deployment has to fall outside of both to be deleted. A
+// value of 0 turns a rule off, and with both off, every deployment is kept.
+// Pinned deployments and the active deployment of the project are always
+// kept.
+func DeleteExpired(db *gorm.DB, projectID, keepLastN, keepDays uint) error {
+	if keepLastN == 0 && keepDays == 0 {
+		return nil
+	}
+
+	sql := `
 		UPDATE deployments
 		SET deleted_at = now()
 		WHERE
 			project_id = ?
 			AND state = ?
 			AND deleted_at IS NULL
+			AND pinned_label IS NULL
+			AND id NOT IN (
+				SELECT active_deployment_id FROM projects
+				WHERE id = ? AND active_deployment_id IS NOT NULL
+			)`
+	args := []interface{}{projectID, StateDeployed, projectID}
+
+	if keepLastN > 0 {
+		sql += `
 			AND deployed_at <= (
 				SELECT deployed_at FROM deployments
 				WHERE
@@ -129,8 +180,39 @@ func DeleteExceptLastN(db *gorm.DB, projectID, n uint) error {
 					AND deleted_at IS NULL
 				ORDER BY deployed_at DESC
 				LIMIT 1 OFFSET ?
-			);`, projectID, StateDeployed, projectID, StateDeployed, n)
-	return q.Error
+			)`
+		args = append(args, projectID, StateDeployed, keepLastN)
+	}
+
+	if keepDays > 0 {
+		sql += `
+			AND deployed_at < ?`
+		args = append(args, time.Now().Add(-time.Duration(keepDays)*24*time.Hour))
+	}
+
+	return db.Exec(sql+";", args...).Error
+}
+
+// Pin labels the deployment so that it is never deleted by a retention
+// policy.
+func (d *Deployment) Pin(db *gorm.DB, label string) error {
+	if err := db.Model(Deployment{}).Where("id = ?", d.ID).UpdateColumn("pinned_label", label).Error; err != nil {
+		return err
+	}
+
+	d.PinnedLabel = &label
+	return nil
+}
+
+// Unpin removes the label of the deployment, so that it can be deleted by a
+// retention policy again.
+func (d *Deployment) Unpin(db *gorm.DB) error {
+	if err := db.Model(Deployment{}).Where("id = ?", d.ID).UpdateColumn("pinned_label", gorm.Expr("NULL")).Error; err != nil {
+		return err
+	}
+
+	d.PinnedLabel = nil
+	return nil
 }
 
 // UpdateState updates deployment state
@@ -151,10 +233,23 @@ func (d *Deployment) UpdateState(db *gorm.DB, state string) error {
 		q = q.Update("raw_bundle_id", d.RawBundleID)
 	}
 
+	from := d.State
 	if err := q.Scan(d).Error; err != nil {
 		return err
 	}
 
+	stateTransitions.Inc(from, state)
+	return nil
+}
+
+// SaveProjectConfig stores the contents of the pubstorm.json that the
+// deployment was deployed with.
+func (d *Deployment) SaveProjectConfig(db *gorm.DB, b []byte) error {
+	if err := db.Model(Deployment{}).Where("id = ?", d.ID).Update("project_config", string(b)).Error; err != nil {
+		return err
+	}
+
+	d.ProjectConfig = b
 	return nil
 }
 
@@ -70,6 +70,40 @@ var _ = Describe("Deployment", func() {
 		})
 	})
 
+	Describe("PreviousBuiltDeployment()", func() {
+		var (
+			d1 *deployment.Deployment
+			d3 *deployment.Deployment
+		)
+
+		BeforeEach(func() {
+			u := factories.User(db)
+			proj := factories.Project(db, u)
+			d1 = factories.Deployment(db, proj, u, deployment.StateDeployed)
+			Expect(d1.SaveBuildReport(db, &deployment.BuildReport{TotalSize: 100})).To(Succeed())
+			factories.Deployment(db, proj, u, deployment.StateDeployed)
+			d3 = factories.Deployment(db, proj, u, deployment.StatePendingDeploy)
+			Expect(d3.SaveBuildReport(db, &deployment.BuildReport{TotalSize: 200})).To(Succeed())
+
+			otherProj := factories.Project(db, u)
+			d := factories.Deployment(db, otherProj, u, deployment.StateDeployed)
+			Expect(d.SaveBuildReport(db, &deployment.BuildReport{TotalSize: 300})).To(Succeed())
+		})
+
+		It("returns the latest earlier deployment with a build report", func() {
+			prevDepl, err := d3.PreviousBuiltDeployment(db)
+			Expect(err).To(BeNil())
+			Expect(prevDepl).NotTo(BeNil())
+			Expect(prevDepl.ID).To(Equal(d1.ID))
+		})
+
+		It("returns nil if there is none", func() {
+			prevDepl, err := d1.PreviousBuiltDeployment(db)
+			Expect(err).To(BeNil())
+			Expect(prevDepl).To(BeNil())
+		})
+	})
+
 	Describe("CompletedDeployments()", func() {
 		var (
 			proj *project.Project
@@ -109,7 +143,7 @@ var _ = Describe("Deployment", func() {
 		})
 	})
 
-	Describe("DeleteExceptLastN()", func() {
+	Describe("DeleteExpired()", func() {
 		var (
 			proj *project.Project
 
@@ -119,19 +153,12 @@ var _ = Describe("Deployment", func() {
 			d4 *deployment.Deployment
 		)
 
-		BeforeEach(func() {
-			u := factories.User(db)
-			proj = factories.Project(db, u)
-			d1 = factories.Deployment(db, proj, u, deployment.StateDeployed)
-			d2 = factories.Deployment(db, proj, u, deployment.StatePendingDeploy)
-			d3 = factories.Deployment(db, proj, u, deployment.StateDeployed)
-			d4 = factories.Deployment(db, proj, u, deployment.StateDeployed)
-		})
-
-		It("deletes all completed deployments except the last N deployments, ordered by deployed time", func() {
-			err := deployment.DeleteExceptLastN(db, proj.ID, 2)
-			Expect(err).To(BeNil())
+		daysAgo := func(n int) *time.Time {
+			t := time.Now().Add(-time.Duration(n)*24*time.Hour - time.Minute)
+			return &t
+		}
 
+		remainingIDs := func() []uint {
 			var depls []*deployment.Deployment
 			q := db.Where("project_id = ? AND state = ?", proj.ID, deployment.StateDeployed).Find(&depls)
 			Expect(q.Error).To(BeNil())
@@ -140,43 +167,138 @@ var _ = Describe("Deployment", func() {
 			for _, depl := range depls {
 				ids = append(ids, depl.ID)
 			}
+			return ids
+		}
+
+		BeforeEach(func() {
+			u := factories.User(db)
+			proj = factories.Project(db, u)
+			d1 = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				State:      deployment.StateDeployed,
+				DeployedAt: daysAgo(10),
+			})
+			d2 = factories.Deployment(db, proj, u, deployment.StatePendingDeploy)
+			d3 = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				State:      deployment.StateDeployed,
+				DeployedAt: daysAgo(5),
+			})
+			d4 = factories.Deployment(db, proj, u, deployment.StateDeployed)
+		})
 
+		It("deletes all completed deployments except the last N deployments, ordered by deployed time", func() {
+			err := deployment.DeleteExpired(db, proj.ID, 2, 0)
+			Expect(err).To(BeNil())
+
+			ids := remainingIDs()
 			Expect(ids).To(HaveLen(2))
 			Expect(ids).To(ConsistOf(d3.ID, d4.ID))
 		})
 
 		It("does not delete any records if there are N deployments", func() {
-			err := deployment.DeleteExceptLastN(db, proj.ID, 3)
+			err := deployment.DeleteExpired(db, proj.ID, 3, 0)
 			Expect(err).To(BeNil())
 
-			var depls []*deployment.Deployment
-			q := db.Where("project_id = ? AND state = ?", proj.ID, deployment.StateDeployed).Find(&depls)
-			Expect(q.Error).To(BeNil())
+			ids := remainingIDs()
+			Expect(ids).To(HaveLen(3))
+			Expect(ids).To(ConsistOf(d1.ID, d3.ID, d4.ID))
+		})
 
-			var ids []uint
-			for _, depl := range depls {
-				ids = append(ids, depl.ID)
-			}
+		It("does not delete any records if there are fewer than N deployments", func() {
+			err := deployment.DeleteExpired(db, proj.ID, 4, 0)
+			Expect(err).To(BeNil())
 
+			ids := remainingIDs()
 			Expect(ids).To(HaveLen(3))
 			Expect(ids).To(ConsistOf(d1.ID, d3.ID, d4.ID))
 		})
 
-		It("does not delete any records if there are fewer than N deployments", func() {
-			err := deployment.DeleteExceptLastN(db, proj.ID, 4)
+		It("deletes completed deployments deployed more than D days ago", func() {
+			err := deployment.DeleteExpired(db, proj.ID, 0, 7)
 			Expect(err).To(BeNil())
 
-			var depls []*deployment.Deployment
-			q := db.Where("project_id = ? AND state = ?", proj.ID, deployment.StateDeployed).Find(&depls)
-			Expect(q.Error).To(BeNil())
+			ids := remainingIDs()
+			Expect(ids).To(HaveLen(2))
+			Expect(ids).To(ConsistOf(d3.ID, d4.ID))
+		})
 
-			var ids []uint
-			for _, depl := range depls {
-				ids = append(ids, depl.ID)
-			}
+		It("keeps deployments that either rule keeps when both are set", func() {
+			err := deployment.DeleteExpired(db, proj.ID, 1, 7)
+			Expect(err).To(BeNil())
+
+			ids := remainingIDs()
+			Expect(ids).To(HaveLen(2))
+			Expect(ids).To(ConsistOf(d3.ID, d4.ID))
 
+			err = deployment.DeleteExpired(db, proj.ID, 2, 1)
+			Expect(err).To(BeNil())
+
+			ids = remainingIDs()
+			Expect(ids).To(HaveLen(2))
+			Expect(ids).To(ConsistOf(d3.ID, d4.ID))
+		})
+
+		It("does not delete any records if there is no retention policy", func() {
+			err := deployment.DeleteExpired(db, proj.ID, 0, 0)
+			Expect(err).To(BeNil())
+
+			ids := remainingIDs()
 			Expect(ids).To(HaveLen(3))
-			Expect(ids).To(ConsistOf(d1.ID, d3.ID, d4.ID))
+		})
+
+		It("does not delete pinned deployments", func() {
+			Expect(d1.Pin(db, "launch")).To(Succeed())
+
+			err := deployment.DeleteExpired(db, proj.ID, 1, 0)
+			Expect(err).To(BeNil())
+
+			ids := remainingIDs()
+			Expect(ids).To(HaveLen(2))
+			Expect(ids).To(ConsistOf(d1.ID, d4.ID))
+		})
+
+		It("does not delete the active deployment", func() {
+			Expect(db.Model(proj).UpdateColumn("active_deployment_id", d1.ID).Error).To(BeNil())
+
+			err := deployment.DeleteExpired(db, proj.ID, 1, 0)
+			Expect(err).To(BeNil())
+
+			ids := remainingIDs()
+			Expect(ids).To(HaveLen(2))
+			Expect(ids).To(ConsistOf(d1.ID, d4.ID))
+		})
+
+		It("does not delete deployments that are not completed", func() {
+			err := deployment.DeleteExpired(db, proj.ID, 1, 0)
+			Expect(err).To(BeNil())
+
+			Expect(db.First(d2, d2.ID).Error).To(BeNil())
+		})
+	})
+
+	Describe("Pin()", func() {
+		It("labels the deployment", func() {
+			d := factories.Deployment(db, nil, nil, deployment.StateDeployed)
+
+			Expect(d.Pin(db, "v1 launch")).To(Succeed())
+			Expect(d.PinnedLabel).NotTo(BeNil())
+			Expect(*d.PinnedLabel).To(Equal("v1 launch"))
+
+			Expect(db.First(d, d.ID).Error).To(BeNil())
+			Expect(d.PinnedLabel).NotTo(BeNil())
+			Expect(*d.PinnedLabel).To(Equal("v1 launch"))
+		})
+	})
+
+	Describe("Unpin()", func() {
+		It("removes the label of the deployment", func() {
+			d := factories.Deployment(db, nil, nil, deployment.StateDeployed)
+			Expect(d.Pin(db, "v1 launch")).To(Succeed())
+
+			Expect(d.Unpin(db)).To(Succeed())
+			Expect(d.PinnedLabel).To(BeNil())
+
+			Expect(db.First(d, d.ID).Error).To(BeNil())
+			Expect(d.PinnedLabel).To(BeNil())
 		})
 	})
 
@@ -0,0 +1,65 @@
+package deployment
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Severities of diagnostics.
+const (
+	SeverityWarning = "warning"
+	SeverityError   = "error"
+)
+
+// Diagnostic is a warning or an error about the files of a deployment that
+// was found when it was built.
+type Diagnostic struct {
+	Severity string `json:"severity"`
+	Stage    string `json:"stage,omitempty"`
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	Message  string `json:"message"`
+}
+
+// SaveDiagnostics stores the diagnostics found when building the deployment.
+func (d *Deployment) SaveDiagnostics(db *gorm.DB, diags []*Diagnostic) error {
+	b, err := json.Marshal(diags)
+	if err != nil {
+		return err
+	}
+
+	if err := db.Model(Deployment{}).Where("id = ?", d.ID).Update("diagnostics", string(b)).Error; err != nil {
+		return err
+	}
+
+	d.Diagnostics = b
+	return nil
+}
+
+// BuildDiagnostics returns the diagnostics found when building the
+// deployment, or nil if it was not built.
+func (d *Deployment) BuildDiagnostics() ([]*Diagnostic, error) {
+	if len(d.Diagnostics) == 0 {
+		return nil, nil
+	}
+
+	var diags []*Diagnostic
+	if err := json.Unmarshal(d.Diagnostics, &diags); err != nil {
+		return nil, err
+	}
+	return diags, nil
+}
+
+// String formats d as "file:line: message", leaving out the file and line if
+// they are not known.
+func (d *Diagnostic) String() string {
+	switch {
+	case d.File == "":
+		return d.Message
+	case d.Line == 0:
+		return d.File + ": " + d.Message
+	}
+	return fmt.Sprintf("%s:%d: %s", d.File, d.Line, d.Message)
+}
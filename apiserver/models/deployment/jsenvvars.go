@@ -0,0 +1,110 @@
+package deployment
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// JsEnvVarChange is a JS environment variable that was added, changed or
+// removed. OldValue is nil if it was added, and NewValue if it was removed.
+type JsEnvVarChange struct {
+	Key      string  `json:"key"`
+	OldValue *string `json:"old_value"`
+	NewValue *string `json:"new_value"`
+}
+
+// JsEnvVarsVersion is a deployment that changed the JS environment variables
+// of a project.
+type JsEnvVarsVersion struct {
+	DeploymentID uint              `json:"deployment_id"`
+	Version      int64             `json:"version"`
+	UserID       uint              `json:"-"`
+	ChangedBy    string            `json:"changed_by,omitempty"` // email of the user
+	ChangedAt    time.Time         `json:"changed_at"`
+	Changes      []*JsEnvVarChange `json:"changes"`
+}
+
+// JsEnvVarsMap returns the JS environment variables of the deployment.
+func (d *Deployment) JsEnvVarsMap() (map[string]string, error) {
+	vars := map[string]string{}
+	if len(d.JsEnvVars) == 0 {
+		return vars, nil
+	}
+
+	if err := json.Unmarshal(d.JsEnvVars, &vars); err != nil {
+		return nil, err
+	}
+	return vars, nil
+}
+
+// DiffJsEnvVars returns the changes that turn oldVars into newVars, sorted by
+// key.
+func DiffJsEnvVars(oldVars, newVars map[string]string) []*JsEnvVarChange {
+	var keys []string
+	for key := range oldVars {
+		keys = append(keys, key)
+	}
+	for key := range newVars {
+		if _, ok := oldVars[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var changes []*JsEnvVarChange
+	for _, key := range keys {
+		oldValue, inOld := oldVars[key]
+		newValue, inNew := newVars[key]
+		if inOld && inNew && oldValue == newValue {
+			continue
+		}
+
+		change := &JsEnvVarChange{Key: key}
+		if inOld {
+			change.OldValue = &oldValue
+		}
+		if inNew {
+			change.NewValue = &newValue
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// JsEnvVarsHistory returns the deployments of the project that changed its JS
+// environment variables when they went live, latest first. Deployments are
+// compared in the order they were last deployed rather than by version, so
+// that rolling back to an older deployment shows up as a change. Because
+// deployed_at is reset every time a deployment goes live, only its latest
+// activation is part of the history. Deleted deployments are included, so
+// that the history is not lost when old deployments are cleaned up.
+func JsEnvVarsHistory(db *gorm.DB, projectID uint) ([]*JsEnvVarsVersion, error) {
+	var depls []*Deployment
+	if err := db.Unscoped().Where("project_id = ? AND deployed_at IS NOT NULL", projectID).Order("deployed_at ASC, id ASC").Find(&depls).Error; err != nil {
+		return nil, err
+	}
+
+	var history []*JsEnvVarsVersion
+	prevVars := map[string]string{}
+	for _, d := range depls {
+		vars, err := d.JsEnvVarsMap()
+		if err != nil {
+			return nil, err
+		}
+
+		if changes := DiffJsEnvVars(prevVars, vars); len(changes) > 0 {
+			history = append([]*JsEnvVarsVersion{{
+				DeploymentID: d.ID,
+				Version:      d.Version,
+				UserID:       d.UserID,
+				ChangedAt:    *d.DeployedAt,
+				Changes:      changes,
+			}}, history...)
+		}
+		prevVars = vars
+	}
+	return history, nil
+}
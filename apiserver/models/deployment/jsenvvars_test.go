@@ -0,0 +1,144 @@
+package deployment_test
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("JS environment variables", func() {
+	strPtr := func(s string) *string {
+		return &s
+	}
+
+	Describe("DiffJsEnvVars()", func() {
+		It("returns the variables that were added, changed and removed, sorted by key", func() {
+			changes := deployment.DiffJsEnvVars(map[string]string{
+				"foo":  "bar",
+				"baz":  "qux",
+				"same": "value",
+			}, map[string]string{
+				"foo":  "BAR",
+				"same": "value",
+				"new":  "",
+			})
+
+			Expect(changes).To(Equal([]*deployment.JsEnvVarChange{
+				{Key: "baz", OldValue: strPtr("qux"), NewValue: nil},
+				{Key: "foo", OldValue: strPtr("bar"), NewValue: strPtr("BAR")},
+				{Key: "new", OldValue: nil, NewValue: strPtr("")},
+			}))
+		})
+
+		It("returns nothing if nothing changed", func() {
+			Expect(deployment.DiffJsEnvVars(map[string]string{"foo": "bar"}, map[string]string{"foo": "bar"})).To(BeEmpty())
+		})
+	})
+
+	Describe("JsEnvVarsHistory()", func() {
+		var (
+			db  *gorm.DB
+			err error
+		)
+
+		BeforeEach(func() {
+			db, err = dbconn.DB()
+			Expect(err).To(BeNil())
+			testhelper.TruncateTables(db.DB())
+		})
+
+		It("returns the deployed versions that changed the variables, latest first", func() {
+			u1 := factories.User(db)
+			u2 := factories.User(db)
+			proj := factories.Project(db, u1)
+
+			d1 := factories.DeploymentWithAttrs(db, proj, u1, deployment.Deployment{
+				State:     deployment.StateDeployed,
+				JsEnvVars: []byte(`{"foo": "bar"}`),
+			})
+			// Deploying again without changing the variables.
+			factories.DeploymentWithAttrs(db, proj, u1, deployment.Deployment{
+				State:     deployment.StateDeployed,
+				JsEnvVars: []byte(`{"foo": "bar"}`),
+			})
+			// Not deployed.
+			factories.DeploymentWithAttrs(db, proj, u2, deployment.Deployment{
+				State:     deployment.StateBuildFailed,
+				JsEnvVars: []byte(`{"foo": "broken"}`),
+			})
+			d4 := factories.DeploymentWithAttrs(db, proj, u2, deployment.Deployment{
+				State:     deployment.StateDeployed,
+				JsEnvVars: []byte(`{"foo": "baz", "qux": "quux"}`),
+			})
+			Expect(db.Delete(d1).Error).To(BeNil())
+
+			factories.DeploymentWithAttrs(db, nil, nil, deployment.Deployment{
+				State:     deployment.StateDeployed,
+				JsEnvVars: []byte(`{"other": "project"}`),
+			})
+
+			history, err := deployment.JsEnvVarsHistory(db, proj.ID)
+			Expect(err).To(BeNil())
+			Expect(history).To(HaveLen(2))
+
+			Expect(history[0].DeploymentID).To(Equal(d4.ID))
+			Expect(history[0].Version).To(Equal(d4.Version))
+			Expect(history[0].UserID).To(Equal(u2.ID))
+			Expect(history[0].Changes).To(Equal([]*deployment.JsEnvVarChange{
+				{Key: "foo", OldValue: strPtr("bar"), NewValue: strPtr("baz")},
+				{Key: "qux", OldValue: nil, NewValue: strPtr("quux")},
+			}))
+
+			Expect(history[1].DeploymentID).To(Equal(d1.ID))
+			Expect(history[1].UserID).To(Equal(u1.ID))
+			Expect(history[1].Changes).To(Equal([]*deployment.JsEnvVarChange{
+				{Key: "foo", OldValue: nil, NewValue: strPtr("bar")},
+			}))
+		})
+
+		It("compares deployments in the order they went live, so that rollbacks show up", func() {
+			u := factories.User(db)
+			proj := factories.Project(db, u)
+
+			now := time.Now()
+			twoHoursAgo := now.Add(-2 * time.Hour)
+			oneHourAgo := now.Add(-1 * time.Hour)
+
+			d1 := factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				State:      deployment.StateDeployed,
+				JsEnvVars:  []byte(`{"foo": "bar"}`),
+				DeployedAt: &twoHoursAgo,
+			})
+			d2 := factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				State:      deployment.StateDeployed,
+				JsEnvVars:  []byte(`{"foo": "baz"}`),
+				DeployedAt: &oneHourAgo,
+			})
+
+			// Roll back to d1.
+			Expect(d1.UpdateState(db, deployment.StateDeployed)).To(BeNil())
+
+			history, err := deployment.JsEnvVarsHistory(db, proj.ID)
+			Expect(err).To(BeNil())
+			Expect(history).To(HaveLen(2))
+
+			Expect(history[0].DeploymentID).To(Equal(d1.ID))
+			Expect(history[0].ChangedAt).To(BeTemporally(">", oneHourAgo))
+			Expect(history[0].Changes).To(Equal([]*deployment.JsEnvVarChange{
+				{Key: "foo", OldValue: strPtr("baz"), NewValue: strPtr("bar")},
+			}))
+
+			Expect(history[1].DeploymentID).To(Equal(d2.ID))
+			Expect(history[1].Changes).To(Equal([]*deployment.JsEnvVarChange{
+				{Key: "foo", OldValue: nil, NewValue: strPtr("baz")},
+			}))
+		})
+	})
+})
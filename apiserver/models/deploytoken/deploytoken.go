@@ -0,0 +1,93 @@
+package deploytoken
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// DeployToken is a database model representing a credential that can only
+// create deployments of one project and read their status, so that CI
+// systems do not need the OAuth token of a user.
+type DeployToken struct {
+	gorm.Model
+
+	ProjectID   uint
+	CreatedByID uint
+	Name        string
+	Token       string `sql:"default:encode(gen_random_bytes(32), 'hex')"`
+	LastUsedAt  *time.Time
+}
+
+// Validate validates DeployToken, returning a map of field names to error
+// messages, or nil if it is valid.
+func (t *DeployToken) Validate() map[string]string {
+	errors := map[string]string{}
+
+	if t.Name == "" {
+		errors["name"] = "is required"
+	} else if len(t.Name) > 255 {
+		errors["name"] = "is too long (max. 255 characters)"
+	}
+
+	if len(errors) == 0 {
+		return nil
+	}
+	return errors
+}
+
+// JSON specifies which fields of a deploy token will be marshaled to JSON.
+// The token itself is only shown when it is created.
+type JSON struct {
+	ID         uint       `json:"id"`
+	Name       string     `json:"name"`
+	CreatedBy  string     `json:"created_by"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// FindByToken returns the deploy token with the given token, or nil if there
+// is none.
+func FindByToken(db *gorm.DB, token string) (*DeployToken, error) {
+	var t DeployToken
+	if err := db.Where("token = ?", token).First(&t).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+// FindByID returns the deploy token of the project with the given ID, or nil
+// if there is none.
+func FindByID(db *gorm.DB, projectID, id uint) (*DeployToken, error) {
+	var t DeployToken
+	if err := db.Where("project_id = ? AND id = ?", projectID, id).First(&t).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+// TokensByProjectID returns the deploy tokens of the project with the given
+// ID with the email of the user who created each, oldest first.
+func TokensByProjectID(db *gorm.DB, projectID uint) ([]*JSON, error) {
+	tokens := []*JSON{}
+	err := db.Model(DeployToken{}).Select("deploy_tokens.id, deploy_tokens.name, users.email AS created_by, deploy_tokens.last_used_at, deploy_tokens.created_at").
+		Joins("JOIN users ON users.id = deploy_tokens.created_by_id").
+		Where("deploy_tokens.project_id = ?", projectID).
+		Order("deploy_tokens.created_at ASC").
+		Scan(&tokens).Error
+
+	return tokens, err
+}
+
+// Touch records that the deploy token has just been used.
+func (t *DeployToken) Touch(db *gorm.DB) error {
+	return db.Model(t).UpdateColumn("last_used_at", time.Now()).Error
+}
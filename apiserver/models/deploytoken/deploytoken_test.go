@@ -0,0 +1,126 @@
+package deploytoken_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/deploytoken"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "deploytoken")
+}
+
+var _ = Describe("DeployToken", func() {
+	var (
+		db  *gorm.DB
+		err error
+
+		u    *user.User
+		proj *project.Project
+	)
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+		testhelper.TruncateTables(db.DB())
+
+		u = factories.User(db)
+		proj = factories.Project(db, u)
+	})
+
+	DescribeTable("Validate()",
+		func(name, expectedErr string) {
+			errs := (&deploytoken.DeployToken{Name: name}).Validate()
+			if expectedErr == "" {
+				Expect(errs).To(BeNil())
+			} else {
+				Expect(errs).To(Equal(map[string]string{"name": expectedErr}))
+			}
+		},
+		Entry("valid name", "CircleCI", ""),
+		Entry("missing name", "", "is required"),
+		Entry("long name", strings.Repeat("a", 256), "is too long (max. 255 characters)"),
+	)
+
+	It("generates a token when it is created", func() {
+		t1 := factories.DeployToken(db, proj, u)
+		t2 := factories.DeployToken(db, proj, u)
+
+		Expect(t1.Token).To(HaveLen(64))
+		Expect(t2.Token).To(HaveLen(64))
+		Expect(t1.Token).NotTo(Equal(t2.Token))
+	})
+
+	Describe("FindByToken()", func() {
+		It("returns the deploy token with the token", func() {
+			t := factories.DeployToken(db, proj, u)
+
+			found, err := deploytoken.FindByToken(db, t.Token)
+			Expect(err).To(BeNil())
+			Expect(found).NotTo(BeNil())
+			Expect(found.ID).To(Equal(t.ID))
+		})
+
+		It("returns nil if the deploy token has been revoked", func() {
+			t := factories.DeployToken(db, proj, u)
+			Expect(db.Delete(t).Error).To(BeNil())
+
+			found, err := deploytoken.FindByToken(db, t.Token)
+			Expect(err).To(BeNil())
+			Expect(found).To(BeNil())
+		})
+	})
+
+	Describe("FindByID()", func() {
+		It("returns nil if the deploy token belongs to another project", func() {
+			t := factories.DeployToken(db, factories.Project(db, u), u)
+
+			found, err := deploytoken.FindByID(db, proj.ID, t.ID)
+			Expect(err).To(BeNil())
+			Expect(found).To(BeNil())
+		})
+	})
+
+	Describe("TokensByProjectID()", func() {
+		It("returns the deploy tokens of the project with the email of their creators", func() {
+			u2 := factories.User(db)
+			t1 := factories.DeployToken(db, proj, u)
+			t2 := factories.DeployToken(db, proj, u2)
+			factories.DeployToken(db, factories.Project(db, u), u) // another project
+
+			tokens, err := deploytoken.TokensByProjectID(db, proj.ID)
+			Expect(err).To(BeNil())
+			Expect(tokens).To(HaveLen(2))
+			Expect(tokens[0].ID).To(Equal(t1.ID))
+			Expect(tokens[0].Name).To(Equal(t1.Name))
+			Expect(tokens[0].CreatedBy).To(Equal(u.Email))
+			Expect(tokens[0].LastUsedAt).To(BeNil())
+			Expect(tokens[1].ID).To(Equal(t2.ID))
+			Expect(tokens[1].CreatedBy).To(Equal(u2.Email))
+		})
+	})
+
+	Describe("Touch()", func() {
+		It("records when the deploy token was last used", func() {
+			t := factories.DeployToken(db, proj, u)
+			Expect(t.Touch(db)).To(Succeed())
+
+			Expect(db.First(t, t.ID).Error).To(BeNil())
+			Expect(t.LastUsedAt).NotTo(BeNil())
+			Expect(*t.LastUsedAt).To(BeTemporally("~", time.Now(), time.Minute))
+		})
+	})
+})
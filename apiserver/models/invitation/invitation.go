@@ -0,0 +1,169 @@
+package invitation
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+)
+
+// Errors returned from this package.
+var (
+	ErrInvitationAlreadyExists = errors.New("email has already been invited to this project")
+)
+
+// Invitation is a database model representing an invitation to collaborate on
+// a project, sent to an email that does not belong to a user yet. It is
+// accepted when a user with that email confirms their account, or with its
+// Token, which is emailed to the invitee.
+type Invitation struct {
+	gorm.Model
+
+	ProjectID   uint
+	InvitedByID uint
+	Email       string
+	Role        string `sql:"default:'admin'"`
+	Token       string
+}
+
+// New returns an invitation with a token signed with key.
+func New(projectID, invitedByID uint, email, role, key string) (*Invitation, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	nonce := hex.EncodeToString(b)
+
+	return &Invitation{
+		ProjectID:   projectID,
+		InvitedByID: invitedByID,
+		Email:       email,
+		Role:        role,
+		Token:       nonce + "." + sign(nonce, projectID, email, key),
+	}, nil
+}
+
+// sign returns the signature of an invitation to the project for the email.
+func sign(nonce string, projectID uint, email, key string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(fmt.Sprintf("%s:%d:%s", nonce, projectID, email)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Create saves the invitation.
+func Create(db *gorm.DB, i *Invitation) error {
+	err := db.Create(i).Error
+
+	if e, ok := err.(*pq.Error); ok && e.Code.Name() == "unique_violation" && e.Constraint == "index_invitations_on_project_id_and_email" {
+		return ErrInvitationAlreadyExists
+	}
+
+	return err
+}
+
+// JSON specifies which fields of an invitation will be marshaled to JSON.
+type JSON struct {
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	InvitedBy string    `json:"invited_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// FindByEmail returns the invitation of the email to the project with the
+// given ID, or nil if there is none.
+func FindByEmail(db *gorm.DB, projectID uint, email string) (*Invitation, error) {
+	var i Invitation
+	if err := db.Where("project_id = ? AND email = ?", projectID, email).First(&i).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &i, nil
+}
+
+// FindByToken returns the invitation with the given token if it was signed
+// with key, or nil otherwise.
+func FindByToken(db *gorm.DB, token, key string) (*Invitation, error) {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return nil, nil
+	}
+
+	var i Invitation
+	if err := db.Where("token = ?", token).First(&i).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if !hmac.Equal([]byte(parts[1]), []byte(sign(parts[0], i.ProjectID, i.Email, key))) {
+		return nil, nil
+	}
+
+	return &i, nil
+}
+
+// InvitationsByEmail returns the invitations of the email to any project,
+// oldest first.
+func InvitationsByEmail(db *gorm.DB, email string) ([]*Invitation, error) {
+	invs := []*Invitation{}
+	err := db.Where("email = ?", email).Order("created_at ASC").Find(&invs).Error
+	return invs, err
+}
+
+// InvitationsByProjectID returns the invitations to the project with the
+// given ID, ordered by email.
+func InvitationsByProjectID(db *gorm.DB, projectID uint) ([]*JSON, error) {
+	invs := []*JSON{}
+	err := db.Model(Invitation{}).Select("invitations.email, invitations.role, users.email AS invited_by, invitations.created_at").
+		Joins("JOIN users ON users.id = invitations.invited_by_id").
+		Where("invitations.project_id = ?", projectID).
+		Order("invitations.email ASC").
+		Scan(&invs).Error
+
+	return invs, err
+}
+
+// Accept makes u a collaborator of the project with the role of the
+// invitation, and deletes the invitation. It does nothing else if u already
+// owns or collaborates on the project, or if the project has been deleted. It
+// should be called in a transaction.
+func (i *Invitation) Accept(db *gorm.DB, u *user.User) error {
+	if err := db.Delete(i).Error; err != nil {
+		return err
+	}
+
+	var proj project.Project
+	if err := db.First(&proj, i.ProjectID).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return nil
+		}
+		return err
+	}
+
+	if proj.UserID == u.ID {
+		return nil
+	}
+
+	role, err := proj.CollaboratorRole(db, u)
+	if err != nil {
+		return err
+	}
+	if role != "" {
+		return nil
+	}
+
+	return proj.AddCollaboratorWithRole(db, u, i.Role)
+}
@@ -0,0 +1,157 @@
+package invitation_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/collab"
+	"github.com/nitrous-io/rise-server/apiserver/models/invitation"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "invitation")
+}
+
+var _ = Describe("Invitation", func() {
+	const key = "something-something-something-32"
+
+	var (
+		db  *gorm.DB
+		err error
+
+		u    *user.User
+		proj *project.Project
+		inv  *invitation.Invitation
+	)
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+		testhelper.TruncateTables(db.DB())
+
+		u = factories.User(db)
+		proj = factories.Project(db, u)
+
+		inv, err = invitation.New(proj.ID, u.ID, "invitee@example.com", collab.RoleDeployer, key)
+		Expect(err).To(BeNil())
+	})
+
+	Describe("New()", func() {
+		It("returns an invitation with a signed token", func() {
+			Expect(inv.ProjectID).To(Equal(proj.ID))
+			Expect(inv.InvitedByID).To(Equal(u.ID))
+			Expect(inv.Email).To(Equal("invitee@example.com"))
+			Expect(inv.Role).To(Equal(collab.RoleDeployer))
+
+			parts := strings.Split(inv.Token, ".")
+			Expect(parts).To(HaveLen(2))
+			Expect(parts[0]).To(HaveLen(32))
+			Expect(parts[1]).To(HaveLen(64))
+
+			inv2, err := invitation.New(proj.ID, u.ID, "invitee@example.com", collab.RoleDeployer, key)
+			Expect(err).To(BeNil())
+			Expect(inv2.Token).NotTo(Equal(inv.Token))
+		})
+	})
+
+	Describe("Create()", func() {
+		It("returns an error if the email has already been invited to the project", func() {
+			Expect(invitation.Create(db, inv)).To(Succeed())
+
+			inv2, err := invitation.New(proj.ID, u.ID, "invitee@example.com", collab.RoleAdmin, key)
+			Expect(err).To(BeNil())
+			Expect(invitation.Create(db, inv2)).To(Equal(invitation.ErrInvitationAlreadyExists))
+		})
+	})
+
+	Describe("FindByToken()", func() {
+		BeforeEach(func() {
+			Expect(invitation.Create(db, inv)).To(Succeed())
+		})
+
+		It("returns the invitation with the token", func() {
+			found, err := invitation.FindByToken(db, inv.Token, key)
+			Expect(err).To(BeNil())
+			Expect(found).NotTo(BeNil())
+			Expect(found.ID).To(Equal(inv.ID))
+		})
+
+		It("returns nil if the token was signed with another key", func() {
+			found, err := invitation.FindByToken(db, inv.Token, key+"x")
+			Expect(err).To(BeNil())
+			Expect(found).To(BeNil())
+		})
+
+		It("returns nil if the token is malformed", func() {
+			found, err := invitation.FindByToken(db, "foo", key)
+			Expect(err).To(BeNil())
+			Expect(found).To(BeNil())
+		})
+	})
+
+	Describe("InvitationsByProjectID()", func() {
+		It("returns the invitations to the project with the email of the inviter", func() {
+			Expect(invitation.Create(db, inv)).To(Succeed())
+
+			inv2, err := invitation.New(factories.Project(db, u).ID, u.ID, "other@example.com", collab.RoleAdmin, key)
+			Expect(err).To(BeNil())
+			Expect(invitation.Create(db, inv2)).To(Succeed())
+
+			invs, err := invitation.InvitationsByProjectID(db, proj.ID)
+			Expect(err).To(BeNil())
+			Expect(invs).To(HaveLen(1))
+			Expect(invs[0].Email).To(Equal("invitee@example.com"))
+			Expect(invs[0].Role).To(Equal(collab.RoleDeployer))
+			Expect(invs[0].InvitedBy).To(Equal(u.Email))
+		})
+	})
+
+	Describe("Accept()", func() {
+		var invitee *user.User
+
+		BeforeEach(func() {
+			Expect(invitation.Create(db, inv)).To(Succeed())
+			invitee = factories.User(db)
+		})
+
+		It("makes the user a collaborator with the role and deletes the invitation", func() {
+			Expect(inv.Accept(db, invitee)).To(Succeed())
+
+			role, err := proj.CollaboratorRole(db, invitee)
+			Expect(err).To(BeNil())
+			Expect(role).To(Equal(collab.RoleDeployer))
+
+			found, err := invitation.FindByEmail(db, proj.ID, "invitee@example.com")
+			Expect(err).To(BeNil())
+			Expect(found).To(BeNil())
+		})
+
+		It("keeps the role of a user who is already a collaborator", func() {
+			Expect(proj.AddCollaboratorWithRole(db, invitee, collab.RoleViewer)).To(Succeed())
+
+			Expect(inv.Accept(db, invitee)).To(Succeed())
+
+			role, err := proj.CollaboratorRole(db, invitee)
+			Expect(err).To(BeNil())
+			Expect(role).To(Equal(collab.RoleViewer))
+		})
+
+		It("does nothing else if the user owns the project", func() {
+			Expect(inv.Accept(db, u)).To(Succeed())
+
+			role, err := proj.CollaboratorRole(db, u)
+			Expect(err).To(BeNil())
+			Expect(role).To(Equal(""))
+		})
+	})
+})
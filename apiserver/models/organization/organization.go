@@ -0,0 +1,317 @@
+package organization
+
+import (
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+)
+
+// Roles of the members of an organization. Owners manage its members, and
+// have the same access to its projects as the owner of a project. Members have
+// the same access as a collaborator.
+const (
+	RoleOwner  = "owner"
+	RoleMember = "member"
+)
+
+var (
+	nameRe = regexp.MustCompile(`\A[a-z0-9][a-z0-9\-]{1,61}[a-z0-9]\z`)
+)
+
+// Errors returned from this package.
+var (
+	ErrMemberAlreadyExists     = errors.New("user is already a member of this organization")
+	ErrNotMember               = errors.New("user is not a member of this organization")
+	ErrLastOwner               = errors.New("organization must have an owner")
+	ErrInvitationAlreadyExists = errors.New("user has already been invited to this organization")
+)
+
+// Organization is a database model representing a team of users that own
+// projects together.
+type Organization struct {
+	gorm.Model
+
+	Name string
+}
+
+// Member is a database model representing a user who belongs to an
+// organization.
+type Member struct {
+	gorm.Model
+
+	OrganizationID uint
+	UserID         uint
+	Role           string `sql:"default:'member'"`
+}
+
+// TableName returns the name of the table members are stored in.
+func (m *Member) TableName() string {
+	return "organization_members"
+}
+
+// Invitation is a database model representing a user who has been invited to
+// become a member of an organization, but has not accepted yet.
+type Invitation struct {
+	gorm.Model
+
+	OrganizationID uint
+	UserID         uint
+	InvitedByID    uint
+	Role           string `sql:"default:'member'"`
+}
+
+// TableName returns the name of the table invitations are stored in.
+func (i *Invitation) TableName() string {
+	return "organization_invitations"
+}
+
+// JSON specifies which fields of an organization will be marshaled to JSON.
+type JSON struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AsJSON returns a struct that can be converted to JSON
+func (o *Organization) AsJSON() *JSON {
+	return &JSON{
+		Name:      o.Name,
+		CreatedAt: o.CreatedAt,
+	}
+}
+
+// Validate validates Organization, if there are invalid fields, it returns a
+// map of <field, errors> and returns nil if valid
+func (o *Organization) Validate() map[string]string {
+	errors := map[string]string{}
+
+	if o.Name == "" {
+		errors["name"] = "is required"
+	} else if len(o.Name) < 3 {
+		errors["name"] = "is too short (min. 3 characters)"
+	} else if len(o.Name) > 63 {
+		errors["name"] = "is too long (max. 63 characters)"
+	} else if !nameRe.MatchString(o.Name) {
+		errors["name"] = "is invalid"
+	}
+
+	if len(errors) == 0 {
+		return nil
+	}
+	return errors
+}
+
+// IsValidRole returns whether role is a role that members can have.
+func IsValidRole(role string) bool {
+	return role == RoleOwner || role == RoleMember
+}
+
+// FindByName returns the organization with the given name, or nil if there
+// is none.
+func FindByName(db *gorm.DB, name string) (*Organization, error) {
+	var o Organization
+	if err := db.Where("name = ?", name).First(&o).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &o, nil
+}
+
+// Membership returns the membership of the user in the organization with the
+// given ID, or nil if they are not a member.
+func Membership(db *gorm.DB, orgID, userID uint) (*Member, error) {
+	var m Member
+	if err := db.Where("organization_id = ? AND user_id = ?", orgID, userID).First(&m).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+// AddMember adds the user to the organization with the given role.
+func (o *Organization) AddMember(db *gorm.DB, u *user.User, role string) error {
+	err := db.Create(&Member{
+		OrganizationID: o.ID,
+		UserID:         u.ID,
+		Role:           role,
+	}).Error
+
+	if e, ok := err.(*pq.Error); ok && e.Code.Name() == "unique_violation" && e.Constraint == "index_organization_members_on_organization_id_and_user_id" {
+		return ErrMemberAlreadyExists
+	}
+
+	return err
+}
+
+// RemoveMember removes the user from the organization. The last owner cannot
+// be removed.
+func (o *Organization) RemoveMember(db *gorm.DB, u *user.User) error {
+	m, err := Membership(db, o.ID, u.ID)
+	if err != nil {
+		return err
+	}
+	if m == nil {
+		return ErrNotMember
+	}
+
+	if m.Role == RoleOwner {
+		var owners int
+		if err := db.Model(Member{}).Where("organization_id = ? AND role = ?", o.ID, RoleOwner).Count(&owners).Error; err != nil {
+			return err
+		}
+		if owners <= 1 {
+			return ErrLastOwner
+		}
+	}
+
+	return db.Delete(m).Error
+}
+
+// MemberWithEmail is a member of an organization with the email of the user.
+type MemberWithEmail struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+// Members returns the members of the organization, ordered by email.
+func (o *Organization) Members(db *gorm.DB) ([]*MemberWithEmail, error) {
+	members := []*MemberWithEmail{}
+	err := db.Model(Member{}).Select("users.email, organization_members.role").
+		Joins("JOIN users ON users.id = organization_members.user_id").
+		Where("organization_members.organization_id = ? AND users.deleted_at IS NULL", o.ID).
+		Order("users.email ASC").
+		Scan(&members).Error
+
+	return members, err
+}
+
+// OrganizationWithRole is an organization with the role of a member in it.
+type OrganizationWithRole struct {
+	Organization
+	Role string
+}
+
+// TableName returns the name of the table organizations are stored in.
+func (ow *OrganizationWithRole) TableName() string {
+	return "organizations"
+}
+
+// AsJSON returns a struct that can be converted to JSON
+func (ow *OrganizationWithRole) AsJSON() interface{} {
+	return struct {
+		*JSON
+		Role string `json:"role"`
+	}{
+		ow.Organization.AsJSON(),
+		ow.Role,
+	}
+}
+
+// OrganizationsByUserID returns the organizations the user is a member of,
+// ordered by name.
+func OrganizationsByUserID(db *gorm.DB, userID uint) ([]*OrganizationWithRole, error) {
+	orgs := []*OrganizationWithRole{}
+	err := db.Select("organizations.*, organization_members.role").
+		Joins("JOIN organization_members ON organization_members.organization_id = organizations.id").
+		Where("organization_members.deleted_at IS NULL").
+		Where("organization_members.user_id = ?", userID).
+		Order("organizations.name ASC").
+		Find(&orgs).Error
+
+	return orgs, err
+}
+
+// Invite invites the user to become a member of the organization with the
+// given role.
+func (o *Organization) Invite(db *gorm.DB, u, invitedBy *user.User, role string) (*Invitation, error) {
+	m, err := Membership(db, o.ID, u.ID)
+	if err != nil {
+		return nil, err
+	}
+	if m != nil {
+		return nil, ErrMemberAlreadyExists
+	}
+
+	inv := &Invitation{
+		OrganizationID: o.ID,
+		UserID:         u.ID,
+		InvitedByID:    invitedBy.ID,
+		Role:           role,
+	}
+	err = db.Create(inv).Error
+
+	if e, ok := err.(*pq.Error); ok && e.Code.Name() == "unique_violation" && e.Constraint == "index_organization_invitations_on_organization_id_and_user_id" {
+		return nil, ErrInvitationAlreadyExists
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return inv, nil
+}
+
+// FindInvitation returns the invitation of the user to the organization with
+// the given ID, or nil if there is none.
+func FindInvitation(db *gorm.DB, orgID, userID uint) (*Invitation, error) {
+	var inv Invitation
+	if err := db.Where("organization_id = ? AND user_id = ?", orgID, userID).First(&inv).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &inv, nil
+}
+
+// InvitationWithNames is an invitation with the name of the organization and
+// the email of the user who sent it.
+type InvitationWithNames struct {
+	Organization string    `json:"organization"`
+	InvitedBy    string    `json:"invited_by"`
+	Role         string    `json:"role"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// InvitationsByUserID returns the invitations to the user that have not been
+// accepted yet, oldest first.
+func InvitationsByUserID(db *gorm.DB, userID uint) ([]*InvitationWithNames, error) {
+	invs := []*InvitationWithNames{}
+	err := db.Model(Invitation{}).Select("organizations.name AS organization, users.email AS invited_by, organization_invitations.role, organization_invitations.created_at").
+		Joins("JOIN organizations ON organizations.id = organization_invitations.organization_id JOIN users ON users.id = organization_invitations.invited_by_id").
+		Where("organizations.deleted_at IS NULL").
+		Where("organization_invitations.user_id = ?", userID).
+		Order("organization_invitations.created_at ASC").
+		Scan(&invs).Error
+
+	return invs, err
+}
+
+// Accept makes the invited user a member of the organization. It should be
+// called in a transaction.
+func (i *Invitation) Accept(db *gorm.DB) error {
+	if err := db.Delete(i).Error; err != nil {
+		return err
+	}
+
+	err := db.Create(&Member{
+		OrganizationID: i.OrganizationID,
+		UserID:         i.UserID,
+		Role:           i.Role,
+	}).Error
+
+	if e, ok := err.(*pq.Error); ok && e.Code.Name() == "unique_violation" && e.Constraint == "index_organization_members_on_organization_id_and_user_id" {
+		return ErrMemberAlreadyExists
+	}
+
+	return err
+}
@@ -0,0 +1,197 @@
+package organization_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/organization"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "organization")
+}
+
+var _ = Describe("Organization", func() {
+	var (
+		db  *gorm.DB
+		err error
+
+		owner *user.User
+		org   *organization.Organization
+	)
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+		testhelper.TruncateTables(db.DB())
+
+		owner = factories.User(db)
+		org = factories.Organization(db, owner)
+	})
+
+	DescribeTable("Validate()",
+		func(name, expectedErr string) {
+			errs := (&organization.Organization{Name: name}).Validate()
+			if expectedErr == "" {
+				Expect(errs).To(BeNil())
+			} else {
+				Expect(errs).To(Equal(map[string]string{"name": expectedErr}))
+			}
+		},
+		Entry("valid name", "acme-inc", ""),
+		Entry("missing name", "", "is required"),
+		Entry("short name", "ab", "is too short (min. 3 characters)"),
+		Entry("long name", strings.Repeat("a", 64), "is too long (max. 63 characters)"),
+		Entry("name with invalid characters", "acme_inc", "is invalid"),
+		Entry("name starting with a hyphen", "-acme", "is invalid"),
+	)
+
+	Describe("AddMember()", func() {
+		It("adds the user as a member with the given role", func() {
+			u := factories.User(db)
+			Expect(org.AddMember(db, u, organization.RoleMember)).To(Succeed())
+
+			m, err := organization.Membership(db, org.ID, u.ID)
+			Expect(err).To(BeNil())
+			Expect(m).NotTo(BeNil())
+			Expect(m.Role).To(Equal(organization.RoleMember))
+		})
+
+		It("returns an error if the user is already a member", func() {
+			err := org.AddMember(db, owner, organization.RoleMember)
+			Expect(err).To(Equal(organization.ErrMemberAlreadyExists))
+		})
+	})
+
+	Describe("RemoveMember()", func() {
+		var u *user.User
+
+		BeforeEach(func() {
+			u = factories.User(db)
+			Expect(org.AddMember(db, u, organization.RoleMember)).To(Succeed())
+		})
+
+		It("removes the member", func() {
+			Expect(org.RemoveMember(db, u)).To(Succeed())
+
+			m, err := organization.Membership(db, org.ID, u.ID)
+			Expect(err).To(BeNil())
+			Expect(m).To(BeNil())
+		})
+
+		It("returns an error if the user is not a member", func() {
+			err := org.RemoveMember(db, factories.User(db))
+			Expect(err).To(Equal(organization.ErrNotMember))
+		})
+
+		It("does not remove the last owner", func() {
+			err := org.RemoveMember(db, owner)
+			Expect(err).To(Equal(organization.ErrLastOwner))
+		})
+
+		It("removes an owner if there is another", func() {
+			owner2 := factories.User(db)
+			Expect(org.AddMember(db, owner2, organization.RoleOwner)).To(Succeed())
+
+			Expect(org.RemoveMember(db, owner)).To(Succeed())
+		})
+	})
+
+	Describe("Members()", func() {
+		It("returns the members with their emails and roles", func() {
+			u := factories.User(db)
+			Expect(org.AddMember(db, u, organization.RoleMember)).To(Succeed())
+			factories.Organization(db, factories.User(db)) // another organization
+
+			members, err := org.Members(db)
+			Expect(err).To(BeNil())
+			Expect(members).To(Equal([]*organization.MemberWithEmail{
+				{Email: owner.Email, Role: organization.RoleOwner},
+				{Email: u.Email, Role: organization.RoleMember},
+			}))
+		})
+	})
+
+	Describe("OrganizationsByUserID()", func() {
+		It("returns the organizations the user is a member of with their roles", func() {
+			u := factories.User(db)
+			Expect(org.AddMember(db, u, organization.RoleMember)).To(Succeed())
+			org2 := factories.Organization(db, u)
+			factories.Organization(db, owner)
+
+			orgs, err := organization.OrganizationsByUserID(db, u.ID)
+			Expect(err).To(BeNil())
+			Expect(orgs).To(HaveLen(2))
+			Expect(orgs[0].ID).To(Equal(org.ID))
+			Expect(orgs[0].Role).To(Equal(organization.RoleMember))
+			Expect(orgs[1].ID).To(Equal(org2.ID))
+			Expect(orgs[1].Role).To(Equal(organization.RoleOwner))
+		})
+	})
+
+	Describe("Invite()", func() {
+		var u *user.User
+
+		BeforeEach(func() {
+			u = factories.User(db)
+		})
+
+		It("invites the user", func() {
+			inv, err := org.Invite(db, u, owner, organization.RoleOwner)
+			Expect(err).To(BeNil())
+			Expect(inv.Role).To(Equal(organization.RoleOwner))
+
+			found, err := organization.FindInvitation(db, org.ID, u.ID)
+			Expect(err).To(BeNil())
+			Expect(found.ID).To(Equal(inv.ID))
+
+			invs, err := organization.InvitationsByUserID(db, u.ID)
+			Expect(err).To(BeNil())
+			Expect(invs).To(HaveLen(1))
+			Expect(invs[0].Organization).To(Equal(org.Name))
+			Expect(invs[0].InvitedBy).To(Equal(owner.Email))
+			Expect(invs[0].Role).To(Equal(organization.RoleOwner))
+		})
+
+		It("returns an error if the user is already a member", func() {
+			_, err := org.Invite(db, owner, owner, organization.RoleMember)
+			Expect(err).To(Equal(organization.ErrMemberAlreadyExists))
+		})
+
+		It("returns an error if the user has already been invited", func() {
+			_, err := org.Invite(db, u, owner, organization.RoleMember)
+			Expect(err).To(BeNil())
+
+			_, err = org.Invite(db, u, owner, organization.RoleMember)
+			Expect(err).To(Equal(organization.ErrInvitationAlreadyExists))
+		})
+	})
+
+	Describe("Accept()", func() {
+		It("makes the user a member and deletes the invitation", func() {
+			u := factories.User(db)
+			inv, err := org.Invite(db, u, owner, organization.RoleMember)
+			Expect(err).To(BeNil())
+
+			Expect(inv.Accept(db)).To(Succeed())
+
+			m, err := organization.Membership(db, org.ID, u.ID)
+			Expect(err).To(BeNil())
+			Expect(m.Role).To(Equal(organization.RoleMember))
+
+			found, err := organization.FindInvitation(db, org.ID, u.ID)
+			Expect(err).To(BeNil())
+			Expect(found).To(BeNil())
+		})
+	})
+})
@@ -0,0 +1,99 @@
+package project
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	"github.com/nitrous-io/rise-server/pkg/aesencrypter"
+)
+
+// ReservedBuildEnvVars are the names of the environment variables that are
+// set by the builder or that change how programs in the optimizer container
+// run, which cannot be used for build-time variables.
+var ReservedBuildEnvVars = map[string]bool{
+	"DOMAIN_NAMES_WITH_PROTOCOL": true,
+	"HOME":                       true,
+	"PATH":                       true,
+}
+
+// ReservedBuildEnvVarPrefixes are the prefixes of the names of environment
+// variables that configure Docker or the dynamic linker, which cannot be used
+// for build-time variables either.
+var ReservedBuildEnvVarPrefixes = []string{"DOCKER_", "LD_"}
+
+var envVarNameRe = regexp.MustCompile(`\A[A-Za-z_][A-Za-z0-9_]*\z`)
+
+// BuildEnvVars returns the environment variables that assets of the project
+// are optimized with, decrypted with aesKey.
+func (p *Project) BuildEnvVars(aesKey string) (map[string]string, error) {
+	vars := map[string]string{}
+	if p.EncryptedBuildEnvVars == "" {
+		return vars, nil
+	}
+
+	cipherText, err := base64.StdEncoding.DecodeString(p.EncryptedBuildEnvVars)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := aesencrypter.Decrypt(cipherText, []byte(aesKey))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, &vars); err != nil {
+		return nil, err
+	}
+	return vars, nil
+}
+
+// SetBuildEnvVars replaces the build-time environment variables of the
+// project, encrypting them with aesKey. The project is not saved.
+func (p *Project) SetBuildEnvVars(vars map[string]string, aesKey string) error {
+	if len(vars) == 0 {
+		p.EncryptedBuildEnvVars = ""
+		return nil
+	}
+
+	b, err := json.Marshal(vars)
+	if err != nil {
+		return err
+	}
+
+	cipherText, err := aesencrypter.Encrypt(b, []byte(aesKey))
+	if err != nil {
+		return err
+	}
+
+	p.EncryptedBuildEnvVars = base64.StdEncoding.EncodeToString(cipherText)
+	return nil
+}
+
+// ValidateBuildEnvVar returns why name cannot be the name of a build-time
+// environment variable, or an empty string if it can.
+func ValidateBuildEnvVar(name string) string {
+	if !envVarNameRe.MatchString(name) {
+		return "is not a valid environment variable name"
+	}
+	if ReservedBuildEnvVars[name] {
+		return "is reserved"
+	}
+	for _, prefix := range ReservedBuildEnvVarPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return "is reserved"
+		}
+	}
+	return ""
+}
+
+// ValidateBuildEnvVarValue returns why value cannot be the value of a
+// build-time environment variable, or an empty string if it can. Values are
+// passed to the optimizer one per line, so they cannot span lines.
+func ValidateBuildEnvVarValue(value string) string {
+	if strings.ContainsAny(value, "\r\n") {
+		return "must not contain line breaks"
+	}
+	return ""
+}
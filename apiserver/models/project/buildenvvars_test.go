@@ -0,0 +1,63 @@
+package project_test
+
+import (
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Build-time environment variables", func() {
+	const aesKey = "something-something-something-32"
+
+	It("are encrypted", func() {
+		proj := &project.Project{}
+		Expect(proj.SetBuildEnvVars(map[string]string{"API_TOKEN": "s3cr3t"}, aesKey)).To(Succeed())
+		Expect(proj.EncryptedBuildEnvVars).NotTo(BeEmpty())
+		Expect(proj.EncryptedBuildEnvVars).NotTo(ContainSubstring("s3cr3t"))
+
+		vars, err := proj.BuildEnvVars(aesKey)
+		Expect(err).To(BeNil())
+		Expect(vars).To(Equal(map[string]string{"API_TOKEN": "s3cr3t"}))
+	})
+
+	It("are empty if none are set", func() {
+		proj := &project.Project{}
+		vars, err := proj.BuildEnvVars(aesKey)
+		Expect(err).To(BeNil())
+		Expect(vars).To(BeEmpty())
+
+		Expect(proj.SetBuildEnvVars(map[string]string{"API_TOKEN": "s3cr3t"}, aesKey)).To(Succeed())
+		Expect(proj.SetBuildEnvVars(map[string]string{}, aesKey)).To(Succeed())
+		Expect(proj.EncryptedBuildEnvVars).To(BeEmpty())
+	})
+
+	Describe("ValidateBuildEnvVar()", func() {
+		It("returns why a name cannot be used", func() {
+			Expect(project.ValidateBuildEnvVar("API_TOKEN")).To(BeEmpty())
+			Expect(project.ValidateBuildEnvVar("_private2")).To(BeEmpty())
+			Expect(project.ValidateBuildEnvVar("2FAST")).To(Equal("is not a valid environment variable name"))
+			Expect(project.ValidateBuildEnvVar("API-TOKEN")).To(Equal("is not a valid environment variable name"))
+			Expect(project.ValidateBuildEnvVar("")).To(Equal("is not a valid environment variable name"))
+			Expect(project.ValidateBuildEnvVar("DOMAIN_NAMES_WITH_PROTOCOL")).To(Equal("is reserved"))
+		})
+
+		It("rejects names that would change how the optimizer runs", func() {
+			for _, name := range []string{"PATH", "HOME", "DOCKER_HOST", "DOCKER_CONFIG", "LD_PRELOAD", "LD_LIBRARY_PATH"} {
+				Expect(project.ValidateBuildEnvVar(name)).To(Equal("is reserved"), name)
+			}
+
+			Expect(project.ValidateBuildEnvVar("MY_DOCKER_HOST")).To(BeEmpty())
+			Expect(project.ValidateBuildEnvVar("LDAP_URL")).To(BeEmpty())
+		})
+	})
+
+	Describe("ValidateBuildEnvVarValue()", func() {
+		It("returns why a value cannot be used", func() {
+			Expect(project.ValidateBuildEnvVarValue("s3cr3t")).To(BeEmpty())
+			Expect(project.ValidateBuildEnvVarValue("")).To(BeEmpty())
+			Expect(project.ValidateBuildEnvVarValue("s3cr3t\nPATH=/tmp")).To(Equal("must not contain line breaks"))
+			Expect(project.ValidateBuildEnvVarValue("s3cr3t\r")).To(Equal("must not contain line breaks"))
+		})
+	})
+})
@@ -11,23 +11,46 @@ import (
 
 	"github.com/lib/pq"
 	"github.com/nitrous-io/rise-server/apiserver/models/collab"
-	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
 	"github.com/nitrous-io/rise-server/apiserver/models/domain"
-	"github.com/nitrous-io/rise-server/apiserver/models/rawbundle"
+	"github.com/nitrous-io/rise-server/apiserver/models/projectrename"
+	"github.com/nitrous-io/rise-server/apiserver/models/repo"
 	"github.com/nitrous-io/rise-server/apiserver/models/user"
 	"github.com/nitrous-io/rise-server/shared"
 
 	"github.com/jinzhu/gorm"
 )
 
+// Optimizers that can be used to optimize the assets of a project when it is
+// built.
+const (
+	OptimizerDocker = "docker"
+	OptimizerNative = "native"
+)
+
+// What happens to a deployment when building it fails.
+const (
+	// BuildErrorPolicyRawBundle deploys the files as they were uploaded.
+	BuildErrorPolicyRawBundle = "raw_bundle"
+	// BuildErrorPolicyBlock does not deploy anything.
+	BuildErrorPolicyBlock = "block"
+)
+
 var (
-	MaxProjectPerUser = 10
+	MaxProjectPerUser         = 10
+	MaxProjectPerOrganization = 50
+
+	// RestoreWindow is how long a deleted project can be restored for, after
+	// which it is purged and its name and domains can be taken again.
+	RestoreWindow = 30 * 24 * time.Hour
 
 	projectNameRe = regexp.MustCompile(`\A[a-z0-9][a-z0-9\-]{1,61}[a-z0-9]\z`)
 
 	ErrCollaboratorIsOwner       = errors.New("owner of project cannot be added as a collaborator")
 	ErrCollaboratorAlreadyExists = errors.New("collaborator already exists")
 	ErrNotCollaborator           = errors.New("user is not a collaborator of this project")
+	ErrTransferToOwner           = errors.New("project cannot be transferred to its owner")
+	ErrNameTaken                 = errors.New("project name has been taken")
+	ErrDomainTaken               = errors.New("domain of project has been taken")
 
 	ErrBasicAuthCredentialRequired = errors.New("basic_auth_username or basic_auth_password is empty")
 )
@@ -37,11 +60,23 @@ type Project struct {
 
 	Name                 string
 	UserID               uint
-	DefaultDomainEnabled bool `sql:"default:true"`
-	ForceHTTPS           bool `sql:"column:force_https"`
-	SkipBuild            bool `sql:"default:true"`
-	Watermark            bool `sql:"default:true"`
-	MaxDeploysKept       uint
+	OrganizationID       *uint  // organization that owns the project, if any
+	DefaultDomainEnabled bool   `sql:"default:true"`
+	ForceHTTPS           bool   `sql:"column:force_https"`
+	SkipBuild            bool   `sql:"default:true"`
+	Watermark            bool   `sql:"default:true"`
+	Optimizer            string `sql:"default:'docker'"`
+	ImageQuality         int    // quality JPEG images are re-encoded at when building; 0 to only optimize losslessly
+	FingerprintAssets    bool   // whether assets are copied to content-hashed names when building
+	FailOnBrokenLinks    bool   // whether builds with broken links fail, rather than only warn
+	BuildErrorPolicy     string `sql:"default:'raw_bundle'"`
+	MaxTotalSize         int64  // budgets in bytes for the files of a deployment; 0 for no budget
+	MaxJSSize            int64  `sql:"column:max_js_size"`
+	MaxCSSSize           int64  `sql:"column:max_css_size"`
+	MaxPageWeight        int64
+	FailOverBudget       bool // whether builds that are over a budget fail, rather than only warn
+	MaxDeploysKept       uint // retention policy: keep the last N deployments; 0 to turn off
+	DeployRetentionDays  uint // retention policy: keep deployments deployed in the last D days; 0 to turn off
 	LastDigestSentAt     *time.Time
 
 	ActiveDeploymentID *uint // pointer to be nullable. remember to dereference by using *ActiveDeploymentID to get actual value
@@ -49,6 +84,7 @@ type Project struct {
 	BasicAuthPassword  string `sql:"-"`
 
 	EncryptedBasicAuthPassword *string
+	EncryptedBuildEnvVars      string // see BuildEnvVars
 
 	LockedAt *time.Time
 }
@@ -58,6 +94,19 @@ type JSON struct {
 	DefaultDomainEnabled bool       `json:"default_domain_enabled"`
 	ForceHTTPS           bool       `json:"force_https"`
 	SkipBuild            bool       `json:"skip_build"`
+	Optimizer            string     `json:"optimizer"`
+	ImageQuality         int        `json:"image_quality"`
+	FingerprintAssets    bool       `json:"fingerprint_assets"`
+	FailOnBrokenLinks    bool       `json:"fail_on_broken_links"`
+	BuildErrorPolicy     string     `json:"build_error_policy"`
+	MaxTotalSize         int64      `json:"max_total_size"`
+	MaxJSSize            int64      `json:"max_js_size"`
+	MaxCSSSize           int64      `json:"max_css_size"`
+	MaxPageWeight        int64      `json:"max_page_weight"`
+	FailOverBudget       bool       `json:"fail_over_budget"`
+	MaxDeploysKept       uint       `json:"max_deploys_kept"`
+	DeployRetentionDays  uint       `json:"deploy_retention_days"`
+	Organization         string     `json:"organization,omitempty"`
 	CreatedAt            time.Time  `json:"created_at"`
 	DeployedAt           *time.Time `json:"deployed_at,omitempty"`
 }
@@ -85,6 +134,29 @@ func (p *Project) Validate() map[string]string {
 		}
 	}
 
+	if p.Optimizer != "" && p.Optimizer != OptimizerDocker && p.Optimizer != OptimizerNative {
+		errors["optimizer"] = "is invalid"
+	}
+
+	if p.BuildErrorPolicy != "" && p.BuildErrorPolicy != BuildErrorPolicyRawBundle && p.BuildErrorPolicy != BuildErrorPolicyBlock {
+		errors["build_error_policy"] = "is invalid"
+	}
+
+	if p.ImageQuality < 0 || p.ImageQuality > 100 {
+		errors["image_quality"] = "must be between 0 and 100"
+	}
+
+	for field, budget := range map[string]int64{
+		"max_total_size":  p.MaxTotalSize,
+		"max_js_size":     p.MaxJSSize,
+		"max_css_size":    p.MaxCSSSize,
+		"max_page_weight": p.MaxPageWeight,
+	} {
+		if budget < 0 {
+			errors[field] = "must not be negative"
+		}
+	}
+
 	if len(errors) == 0 {
 		return nil
 	}
@@ -98,10 +170,44 @@ func (p *Project) AsJSON() interface{} {
 		DefaultDomainEnabled: p.DefaultDomainEnabled,
 		ForceHTTPS:           p.ForceHTTPS,
 		SkipBuild:            p.SkipBuild,
+		Optimizer:            p.Optimizer,
+		ImageQuality:         p.ImageQuality,
+		FingerprintAssets:    p.FingerprintAssets,
+		FailOnBrokenLinks:    p.FailOnBrokenLinks,
+		BuildErrorPolicy:     p.BuildErrorPolicy,
+		MaxTotalSize:         p.MaxTotalSize,
+		MaxJSSize:            p.MaxJSSize,
+		MaxCSSSize:           p.MaxCSSSize,
+		MaxPageWeight:        p.MaxPageWeight,
+		FailOverBudget:       p.FailOverBudget,
+		MaxDeploysKept:       p.MaxDeploysKept,
+		DeployRetentionDays:  p.DeployRetentionDays,
 		CreatedAt:            p.CreatedAt,
 	}
 }
 
+// CopySettingsFrom copies the settings of src that users can change with the
+// API to p, for projects that are cloned from src. Its name, owner, domains,
+// basic auth credentials and build environment variables are not copied, and
+// neither are the settings that only admins can change, such as Watermark.
+func (p *Project) CopySettingsFrom(src *Project) {
+	p.DefaultDomainEnabled = src.DefaultDomainEnabled
+	p.ForceHTTPS = src.ForceHTTPS
+	p.SkipBuild = src.SkipBuild
+	p.Optimizer = src.Optimizer
+	p.ImageQuality = src.ImageQuality
+	p.FingerprintAssets = src.FingerprintAssets
+	p.FailOnBrokenLinks = src.FailOnBrokenLinks
+	p.BuildErrorPolicy = src.BuildErrorPolicy
+	p.MaxTotalSize = src.MaxTotalSize
+	p.MaxJSSize = src.MaxJSSize
+	p.MaxCSSSize = src.MaxCSSSize
+	p.MaxPageWeight = src.MaxPageWeight
+	p.FailOverBudget = src.FailOverBudget
+	p.MaxDeploysKept = src.MaxDeploysKept
+	p.DeployRetentionDays = src.DeployRetentionDays
+}
+
 // Returns list of domain names for this project
 func (p *Project) DomainNames(db *gorm.DB) ([]string, error) {
 	doms := []*domain.Domain{}
@@ -193,6 +299,12 @@ func (p *Project) Unlock(db *gorm.DB) error {
 }
 
 func (p *Project) AddCollaborator(db *gorm.DB, u *user.User) error {
+	return p.AddCollaboratorWithRole(db, u, collab.RoleAdmin)
+}
+
+// AddCollaboratorWithRole adds u as a collaborator of the project with the
+// given role.
+func (p *Project) AddCollaboratorWithRole(db *gorm.DB, u *user.User, role string) error {
 	if u.ID == p.UserID {
 		return ErrCollaboratorIsOwner
 	}
@@ -200,6 +312,7 @@ func (p *Project) AddCollaborator(db *gorm.DB, u *user.User) error {
 	collab := &collab.Collab{
 		UserID:    u.ID,
 		ProjectID: p.ID,
+		Role:      role,
 	}
 
 	err := db.Create(&collab).Error
@@ -225,6 +338,71 @@ func (p *Project) RemoveCollaborator(db *gorm.DB, u *user.User) error {
 	return nil
 }
 
+// SetCollaboratorRole changes the role of u, who is a collaborator of the
+// project.
+func (p *Project) SetCollaboratorRole(db *gorm.DB, u *user.User, role string) error {
+	q := db.Model(collab.Collab{}).Where("project_id = ? AND user_id = ?", p.ID, u.ID).Update("role", role)
+	if err := q.Error; err != nil {
+		return err
+	}
+
+	if q.RowsAffected == 0 {
+		return ErrNotCollaborator
+	}
+
+	return nil
+}
+
+// CollaboratorRole returns the role of u in the project, or an empty string
+// if they are not a collaborator.
+func (p *Project) CollaboratorRole(db *gorm.DB, u *user.User) (string, error) {
+	var c collab.Collab
+	if err := db.Where("project_id = ? AND user_id = ?", p.ID, u.ID).First(&c).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return "", nil
+		}
+		return "", err
+	}
+
+	return c.Role, nil
+}
+
+// TransferTo makes u the owner of the project. If u is a collaborator, they
+// stop being one, and if keepOwnerAsCollaborator is true, the previous owner
+// becomes one. Repositories that the previous owner linked to the project are
+// moved to u. It should be called in a transaction.
+func (p *Project) TransferTo(db *gorm.DB, u *user.User, keepOwnerAsCollaborator bool) error {
+	if u.ID == p.UserID {
+		return ErrTransferToOwner
+	}
+
+	prevOwnerID := p.UserID
+
+	if err := db.Delete(collab.Collab{}, "project_id = ? AND user_id = ?", p.ID, u.ID).Error; err != nil {
+		return err
+	}
+
+	if err := db.Model(Project{}).Where("id = ?", p.ID).Update("user_id", u.ID).Error; err != nil {
+		return err
+	}
+	p.UserID = u.ID
+
+	if err := db.Model(repo.Repo{}).Where("project_id = ? AND user_id = ?", p.ID, prevOwnerID).Update("user_id", u.ID).Error; err != nil {
+		return err
+	}
+
+	if keepOwnerAsCollaborator {
+		if err := db.Create(&collab.Collab{
+			UserID:    prevOwnerID,
+			ProjectID: p.ID,
+		}).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // Atomically increments version_counter and returns next deployment version
 func (p *Project) NextVersion(db *gorm.DB) (int64, error) {
 	r := struct{ V int64 }{}
@@ -236,32 +414,153 @@ func (p *Project) NextVersion(db *gorm.DB) (int64, error) {
 	return r.V, nil
 }
 
-// Destroy a project
+// Destroy soft-deletes a project along with its domains, certs, deployments
+// and raw bundles. It can be undone with Restore until the project is purged.
 func (p *Project) Destroy(db *gorm.DB) error {
-	if err := db.Exec("UPDATE certs c SET deleted_at = now() FROM domains d WHERE c.domain_id = d.id AND d.project_id = ?", p.ID).Error; err != nil {
+	// Everything is deleted at the same time, so that Restore can tell what was
+	// deleted along with the project.
+	now := time.Now()
+
+	if err := db.Exec("UPDATE certs c SET deleted_at = ? FROM domains d WHERE c.domain_id = d.id AND d.project_id = ? AND c.deleted_at IS NULL AND d.deleted_at IS NULL", now, p.ID).Error; err != nil {
+		return err
+	}
+
+	if err := db.Exec("UPDATE acme_certs c SET deleted_at = ? FROM domains d WHERE c.domain_id = d.id AND d.project_id = ? AND c.deleted_at IS NULL AND d.deleted_at IS NULL", now, p.ID).Error; err != nil {
 		return err
 	}
 
-	if err := db.Exec("UPDATE acme_certs c SET deleted_at = now() FROM domains d WHERE c.domain_id = d.id AND d.project_id = ?", p.ID).Error; err != nil {
+	for _, table := range []string{"raw_bundles", "domains", "deployments"} {
+		if err := db.Exec("UPDATE "+table+" SET deleted_at = ? WHERE project_id = ? AND deleted_at IS NULL", now, p.ID).Error; err != nil {
+			return err
+		}
+	}
+
+	if err := db.Delete(projectrename.ProjectRename{}, "project_id = ?", p.ID).Error; err != nil {
 		return err
 	}
 
-	if err := db.Delete(rawbundle.RawBundle{}, "project_id = ?", p.ID).Error; err != nil {
+	if err := db.Exec("UPDATE projects SET deleted_at = ? WHERE id = ?", now, p.ID).Error; err != nil {
 		return err
 	}
+	p.DeletedAt = &now
+
+	return nil
+}
+
+// FindDeletedByName returns the project with the given name that was deleted
+// within RestoreWindow, or nil if there is none.
+func FindDeletedByName(db *gorm.DB, name string) (*Project, error) {
+	var proj Project
+	q := db.Unscoped().
+		Where("name = ? AND deleted_at > ?", name, time.Now().Add(-RestoreWindow)).
+		Order("deleted_at DESC").
+		First(&proj)
+	if err := q.Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &proj, nil
+}
+
+// IsNameOfDeletedProject returns whether name is the name of a deleted
+// project that has not been purged yet, and so cannot be taken.
+func IsNameOfDeletedProject(db *gorm.DB, name string) (bool, error) {
+	var count int
+	if err := db.Unscoped().Model(Project{}).Where("name = ? AND deleted_at IS NOT NULL", name).Count(&count).Error; err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// IsDomainOfDeletedProject returns whether name is the name of a domain that
+// was deleted along with a project that has not been purged yet, and so
+// cannot be taken.
+func IsDomainOfDeletedProject(db *gorm.DB, name string) (bool, error) {
+	var count int
+	q := db.Table("domains").
+		Joins("JOIN projects ON projects.id = domains.project_id").
+		Where("domains.name = ? AND projects.deleted_at IS NOT NULL AND domains.deleted_at = projects.deleted_at", name).
+		Count(&count)
+	if err := q.Error; err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
 
-	if err := db.Delete(domain.Domain{}, "project_id = ?", p.ID).Error; err != nil {
+// Restore undoes Destroy, restoring the domains, certs, deployments and raw
+// bundles that were deleted along with the project. It returns ErrNameTaken or
+// ErrDomainTaken if another project has taken the name of the project or of
+// one of its domains. It should be called in a transaction.
+func (p *Project) Restore(db *gorm.DB) error {
+	if p.DeletedAt == nil {
+		return nil
+	}
+	deletedAt := *p.DeletedAt
+
+	if err := db.Exec("UPDATE projects SET deleted_at = NULL WHERE id = ?", p.ID).Error; err != nil {
+		if e, ok := err.(*pq.Error); ok && e.Code.Name() == "unique_violation" {
+			return ErrNameTaken
+		}
+		return err
+	}
+
+	if err := db.Exec("UPDATE domains SET deleted_at = NULL WHERE project_id = ? AND deleted_at = ?", p.ID, deletedAt).Error; err != nil {
+		if e, ok := err.(*pq.Error); ok && e.Code.Name() == "unique_violation" {
+			return ErrDomainTaken
+		}
 		return err
 	}
 
-	if err := db.Delete(deployment.Deployment{}, "project_id = ?", p.ID).Error; err != nil {
+	if err := db.Exec("UPDATE certs c SET deleted_at = NULL FROM domains d WHERE c.domain_id = d.id AND d.project_id = ? AND c.deleted_at = ?", p.ID, deletedAt).Error; err != nil {
 		return err
 	}
 
-	if err := db.Delete(p).Error; err != nil {
+	if err := db.Exec("UPDATE acme_certs c SET deleted_at = NULL FROM domains d WHERE c.domain_id = d.id AND d.project_id = ? AND c.deleted_at = ?", p.ID, deletedAt).Error; err != nil {
 		return err
 	}
 
+	for _, table := range []string{"raw_bundles", "deployments"} {
+		if err := db.Exec("UPDATE "+table+" SET deleted_at = NULL WHERE project_id = ? AND deleted_at = ?", p.ID, deletedAt).Error; err != nil {
+			return err
+		}
+	}
+
+	p.DeletedAt = nil
+
+	return nil
+}
+
+// Purge permanently deletes a deleted project and everything that belongs to
+// it from the database, which releases its name and domains. Its files must
+// have been deleted from S3 first. It should be called in a transaction.
+func (p *Project) Purge(db *gorm.DB) error {
+	for _, q := range []string{
+		"UPDATE projects SET active_deployment_id = NULL WHERE id = ?",
+		"DELETE FROM pushes WHERE repo_id IN (SELECT id FROM repos WHERE project_id = ?)",
+		"DELETE FROM pushes WHERE deployment_id IN (SELECT id FROM deployments WHERE project_id = ?)",
+		"DELETE FROM repos WHERE project_id = ?",
+		"DELETE FROM certs c USING domains d WHERE c.domain_id = d.id AND d.project_id = ?",
+		"DELETE FROM acme_certs c USING domains d WHERE c.domain_id = d.id AND d.project_id = ?",
+		"DELETE FROM domains WHERE project_id = ?",
+		"DELETE FROM collabs WHERE project_id = ?",
+		"DELETE FROM project_transfers WHERE project_id = ?",
+		"DELETE FROM invitations WHERE project_id = ?",
+		"DELETE FROM project_renames WHERE project_id = ?",
+		"DELETE FROM deploy_tokens WHERE project_id = ?",
+		"DELETE FROM deployments WHERE project_id = ?",
+		"DELETE FROM raw_bundles WHERE project_id = ?",
+		"DELETE FROM projects WHERE id = ?",
+	} {
+		if err := db.Exec(q, p.ID).Error; err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -312,20 +611,33 @@ func (p *Project) DomainNamesWithProtocol(db *gorm.DB) ([]string, error) {
 	return domNames, nil
 }
 
-// Returns whether more projects can be added for this user
+// Returns whether more projects can be added for this user. Projects that
+// belong to organizations are not counted.
 func CanAddProject(db *gorm.DB, u *user.User) (bool, error) {
 	var count int
-	if err := db.Model(Project{}).Where("user_id = ?", u.ID).Count(&count).Error; err != nil {
+	if err := db.Model(Project{}).Where("user_id = ? AND organization_id IS NULL", u.ID).Count(&count).Error; err != nil {
 		return false, err
 	}
 
 	return count < MaxProjectPerUser, nil
 }
 
+// Returns whether more projects can be added to the organization with the
+// given ID
+func CanAddOrganizationProject(db *gorm.DB, orgID uint) (bool, error) {
+	var count int
+	if err := db.Model(Project{}).Where("organization_id = ?", orgID).Count(&count).Error; err != nil {
+		return false, err
+	}
+
+	return count < MaxProjectPerOrganization, nil
+}
+
 // Project with deployed time
 type ProjectWithDeployedAt struct {
 	Project
-	DeployedAt *time.Time
+	DeployedAt       *time.Time
+	OrganizationName string // only set by OrganizationProjectsByUserID
 }
 
 // TableName return table name for database
@@ -340,6 +652,19 @@ func (pd *ProjectWithDeployedAt) AsJSON() interface{} {
 		DefaultDomainEnabled: pd.DefaultDomainEnabled,
 		ForceHTTPS:           pd.ForceHTTPS,
 		SkipBuild:            pd.SkipBuild,
+		Optimizer:            pd.Optimizer,
+		ImageQuality:         pd.ImageQuality,
+		FingerprintAssets:    pd.FingerprintAssets,
+		FailOnBrokenLinks:    pd.FailOnBrokenLinks,
+		BuildErrorPolicy:     pd.BuildErrorPolicy,
+		MaxTotalSize:         pd.MaxTotalSize,
+		MaxJSSize:            pd.MaxJSSize,
+		MaxCSSSize:           pd.MaxCSSSize,
+		MaxPageWeight:        pd.MaxPageWeight,
+		FailOverBudget:       pd.FailOverBudget,
+		MaxDeploysKept:       pd.MaxDeploysKept,
+		DeployRetentionDays:  pd.DeployRetentionDays,
+		Organization:         pd.OrganizationName,
 		CreatedAt:            pd.CreatedAt,
 		DeployedAt:           pd.DeployedAt,
 	}
@@ -352,12 +677,31 @@ func ProjectsByUserID(db *gorm.DB, userID uint) ([]*ProjectWithDeployedAt, error
 		Group("projects.id").
 		Order("projects.name ASC").
 		Where("deployments.deleted_at IS NULL").
-		Where("projects.user_id = ?", userID).
+		Where("projects.user_id = ? AND projects.organization_id IS NULL", userID).
 		Find(&projects).Error
 
 	return projects, err
 }
 
+// OrganizationProjectsByUserID returns the projects of the organizations that
+// the user is a member of.
+func OrganizationProjectsByUserID(db *gorm.DB, userID uint) ([]*ProjectWithDeployedAt, error) {
+	orgProjects := []*ProjectWithDeployedAt{}
+	err := db.Select("projects.*, max(deployments.deployed_at) AS deployed_at, organizations.name AS organization_name").
+		Joins(`LEFT JOIN deployments ON projects.id = deployments.project_id
+			JOIN organizations ON organizations.id = projects.organization_id
+			JOIN organization_members ON organization_members.organization_id = organizations.id`).
+		Where("deployments.deleted_at IS NULL").
+		Where("organizations.deleted_at IS NULL").
+		Where("organization_members.deleted_at IS NULL").
+		Where("organization_members.user_id = ?", userID).
+		Order("organizations.name ASC, projects.name ASC").
+		Group("projects.id, organizations.name").
+		Find(&orgProjects).Error
+
+	return orgProjects, err
+}
+
 func SharedProjectsByUserID(db *gorm.DB, userID uint) ([]*ProjectWithDeployedAt, error) {
 	sharedProjects := []*ProjectWithDeployedAt{}
 	err := db.Select("projects.*, max(deployments.deployed_at) AS deployed_at").
@@ -14,8 +14,11 @@ import (
 	"github.com/nitrous-io/rise-server/apiserver/models/collab"
 	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
 	"github.com/nitrous-io/rise-server/apiserver/models/domain"
+	"github.com/nitrous-io/rise-server/apiserver/models/organization"
 	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/projectrename"
 	"github.com/nitrous-io/rise-server/apiserver/models/rawbundle"
+	"github.com/nitrous-io/rise-server/apiserver/models/repo"
 	"github.com/nitrous-io/rise-server/apiserver/models/user"
 	"github.com/nitrous-io/rise-server/shared"
 	"github.com/nitrous-io/rise-server/testhelper"
@@ -308,6 +311,7 @@ var _ = Describe("Project", func() {
 				Expect(len(cols)).To(Equal(1))
 				Expect(cols[0].UserID).To(Equal(anotherU.ID))
 				Expect(cols[0].ProjectID).To(Equal(proj.ID))
+				Expect(cols[0].Role).To(Equal(collab.RoleAdmin))
 
 				// it doesn't affect other projects
 				cols = []collab.Collab{}
@@ -318,6 +322,51 @@ var _ = Describe("Project", func() {
 		})
 	})
 
+	Describe("AddCollaboratorWithRole()", func() {
+		It("adds the user as a collaborator with the given role", func() {
+			anotherU := factories.User(db)
+
+			err := proj.AddCollaboratorWithRole(db, anotherU, collab.RoleViewer)
+			Expect(err).To(BeNil())
+
+			role, err := proj.CollaboratorRole(db, anotherU)
+			Expect(err).To(BeNil())
+			Expect(role).To(Equal(collab.RoleViewer))
+		})
+	})
+
+	Describe("SetCollaboratorRole()", func() {
+		var anotherU *user.User
+
+		BeforeEach(func() {
+			anotherU = factories.User(db)
+		})
+
+		It("changes the role of the collaborator", func() {
+			factories.Collab(db, proj, anotherU)
+
+			err := proj.SetCollaboratorRole(db, anotherU, collab.RoleDeployer)
+			Expect(err).To(BeNil())
+
+			role, err := proj.CollaboratorRole(db, anotherU)
+			Expect(err).To(BeNil())
+			Expect(role).To(Equal(collab.RoleDeployer))
+		})
+
+		It("returns an error if the user is not a collaborator", func() {
+			err := proj.SetCollaboratorRole(db, anotherU, collab.RoleDeployer)
+			Expect(err).To(Equal(project.ErrNotCollaborator))
+		})
+	})
+
+	Describe("CollaboratorRole()", func() {
+		It("returns an empty string if the user is not a collaborator", func() {
+			role, err := proj.CollaboratorRole(db, factories.User(db))
+			Expect(err).To(BeNil())
+			Expect(role).To(Equal(""))
+		})
+	})
+
 	Describe("RemoveCollaborator()", func() {
 		var (
 			u2, u3, u4 *user.User
@@ -362,6 +411,72 @@ var _ = Describe("Project", func() {
 		})
 	})
 
+	Describe("TransferTo()", func() {
+		var (
+			u2, u3 *user.User
+			rp     *repo.Repo
+		)
+
+		BeforeEach(func() {
+			u2 = factories.User(db)
+			u3 = factories.User(db)
+
+			factories.Collab(db, proj, u2)
+			factories.Collab(db, proj, u3)
+
+			rp = &repo.Repo{
+				ProjectID: proj.ID,
+				UserID:    u.ID,
+				URI:       "https://github.com/PubStorm/pubstorm-www.git",
+			}
+			Expect(db.Create(rp).Error).To(BeNil())
+		})
+
+		collabUserIDs := func() []uint {
+			cols := []collab.Collab{}
+			Expect(db.Where("project_id = ?", proj.ID).Order("user_id ASC").Find(&cols).Error).To(BeNil())
+
+			var ids []uint
+			for _, col := range cols {
+				ids = append(ids, col.UserID)
+			}
+			return ids
+		}
+
+		It("returns an error when transferring the project to its owner", func() {
+			err := proj.TransferTo(db, u, false)
+			Expect(err).To(Equal(project.ErrTransferToOwner))
+		})
+
+		It("makes the user the owner instead of a collaborator", func() {
+			err := proj.TransferTo(db, u2, false)
+			Expect(err).To(BeNil())
+			Expect(proj.UserID).To(Equal(u2.ID))
+
+			Expect(db.First(proj, proj.ID).Error).To(BeNil())
+			Expect(proj.UserID).To(Equal(u2.ID))
+
+			Expect(collabUserIDs()).To(Equal([]uint{u3.ID}))
+		})
+
+		It("moves the repositories linked by the previous owner", func() {
+			err := proj.TransferTo(db, u2, false)
+			Expect(err).To(BeNil())
+
+			Expect(db.First(rp, rp.ID).Error).To(BeNil())
+			Expect(rp.UserID).To(Equal(u2.ID))
+		})
+
+		Context("when the previous owner is kept as a collaborator", func() {
+			It("adds the previous owner as a collaborator", func() {
+				err := proj.TransferTo(db, u2, true)
+				Expect(err).To(BeNil())
+
+				Expect(collabUserIDs()).To(Equal([]uint{u.ID, u3.ID}))
+			})
+		})
+	})
+
 	Describe("NextVersion()", func() {
 		var proj2 *project.Project
 
@@ -411,6 +526,23 @@ var _ = Describe("Project", func() {
 			Expect(count).To(Equal(0))
 		})
 
+		It("deletes the former names of the project", func() {
+			_, err := projectrename.Create(db, proj.ID, "old-name", proj.Name)
+			Expect(err).To(BeNil())
+			_, err = projectrename.Create(db, proj2.ID, "other-old-name", proj2.Name)
+			Expect(err).To(BeNil())
+
+			Expect(proj.Destroy(db)).To(BeNil())
+
+			r, err := projectrename.FindActiveByOldName(db, "old-name")
+			Expect(err).To(BeNil())
+			Expect(r).To(BeNil())
+
+			r, err = projectrename.FindActiveByOldName(db, "other-old-name")
+			Expect(err).To(BeNil())
+			Expect(r).NotTo(BeNil())
+		})
+
 		Context("when a project has domains, certs and deployments", func() {
 			var (
 				dm1  *domain.Domain
@@ -503,6 +635,234 @@ var _ = Describe("Project", func() {
 				Expect(db.Model(deployment.Deployment{}).Where("id = ?", d4.ID).Count(&count).Error).To(BeNil())
 				Expect(count).To(Equal(1))
 			})
+
+			It("keeps them so that they can be restored by Restore()", func() {
+				// A domain that was removed before the project was deleted.
+				Expect(db.Delete(dm2).Error).To(BeNil())
+
+				Expect(proj.Destroy(db)).To(BeNil())
+				Expect(proj.DeletedAt).NotTo(BeNil())
+
+				Expect(proj.Restore(db)).To(BeNil())
+				Expect(proj.DeletedAt).To(BeNil())
+
+				var count int
+				Expect(db.Model(project.Project{}).Where("id = ?", proj.ID).Count(&count).Error).To(BeNil())
+				Expect(count).To(Equal(1))
+
+				Expect(db.Model(domain.Domain{}).Where("project_id = ?", proj.ID).Count(&count).Error).To(BeNil())
+				Expect(count).To(Equal(1))
+
+				Expect(db.Model(cert.Cert{}).Where("domain_id = ?", dm1.ID).Count(&count).Error).To(BeNil())
+				Expect(count).To(Equal(1))
+
+				Expect(db.Model(acmecert.AcmeCert{}).Where("domain_id = ?", dm1.ID).Count(&count).Error).To(BeNil())
+				Expect(count).To(Equal(1))
+
+				Expect(db.Model(rawbundle.RawBundle{}).Where("id = ?", bun1.ID).Count(&count).Error).To(BeNil())
+				Expect(count).To(Equal(1))
+
+				Expect(db.Model(deployment.Deployment{}).Where("project_id = ?", proj.ID).Count(&count).Error).To(BeNil())
+				Expect(count).To(Equal(3))
+			})
+		})
+	})
+
+	Describe("Restore()", func() {
+		var (
+			proj *project.Project
+			dm   *domain.Domain
+		)
+
+		BeforeEach(func() {
+			proj = factories.Project(db, u)
+			dm = factories.Domain(db, proj)
+			Expect(proj.Destroy(db)).To(BeNil())
+		})
+
+		It("returns ErrNameTaken if another project has taken the name", func() {
+			factories.Project(db, u, proj.Name)
+
+			Expect(proj.Restore(db)).To(Equal(project.ErrNameTaken))
+		})
+
+		It("returns ErrDomainTaken if another project has taken one of the domains", func() {
+			factories.Domain(db, factories.Project(db, u), dm.Name)
+
+			Expect(proj.Restore(db)).To(Equal(project.ErrDomainTaken))
+		})
+	})
+
+	Describe("Purge()", func() {
+		It("permanently deletes the project and everything that belongs to it", func() {
+			proj := factories.Project(db, u)
+			dm := factories.Domain(db, proj)
+			factories.Cert(db, dm)
+			factories.RawBundle(db, proj)
+			depl := factories.Deployment(db, proj, u, deployment.StateDeployed)
+			Expect(db.Model(proj).UpdateColumn("active_deployment_id", depl.ID).Error).To(BeNil())
+			Expect(proj.AddCollaborator(db, factories.User(db))).To(BeNil())
+			factories.DeployToken(db, proj, u)
+
+			proj2 := factories.Project(db, u)
+			factories.Domain(db, proj2)
+
+			Expect(proj.Destroy(db)).To(BeNil())
+			Expect(proj.Purge(db)).To(BeNil())
+
+			for _, table := range []string{"domains", "deployments", "raw_bundles", "collabs", "deploy_tokens"} {
+				var count int
+				Expect(db.Unscoped().Table(table).Where("project_id = ?", proj.ID).Count(&count).Error).To(BeNil())
+				Expect(count).To(Equal(0))
+			}
+
+			var count int
+			Expect(db.Unscoped().Model(cert.Cert{}).Where("domain_id = ?", dm.ID).Count(&count).Error).To(BeNil())
+			Expect(count).To(Equal(0))
+
+			Expect(db.Unscoped().Model(project.Project{}).Where("id = ?", proj.ID).Count(&count).Error).To(BeNil())
+			Expect(count).To(Equal(0))
+
+			// Make sure it does not delete other projects
+			Expect(db.Model(project.Project{}).Where("id = ?", proj2.ID).Count(&count).Error).To(BeNil())
+			Expect(count).To(Equal(1))
+
+			Expect(db.Model(domain.Domain{}).Where("project_id = ?", proj2.ID).Count(&count).Error).To(BeNil())
+			Expect(count).To(Equal(1))
+		})
+	})
+
+	Describe("FindDeletedByName()", func() {
+		var proj *project.Project
+
+		BeforeEach(func() {
+			proj = factories.Project(db, u)
+		})
+
+		It("returns the project if it was deleted within the restore window", func() {
+			Expect(proj.Destroy(db)).To(BeNil())
+
+			found, err := project.FindDeletedByName(db, proj.Name)
+			Expect(err).To(BeNil())
+			Expect(found).NotTo(BeNil())
+			Expect(found.ID).To(Equal(proj.ID))
+		})
+
+		It("returns nil if the project has not been deleted", func() {
+			found, err := project.FindDeletedByName(db, proj.Name)
+			Expect(err).To(BeNil())
+			Expect(found).To(BeNil())
+		})
+
+		It("returns nil if the project was deleted before the restore window", func() {
+			Expect(proj.Destroy(db)).To(BeNil())
+			err := db.Unscoped().Model(proj).UpdateColumn("deleted_at", time.Now().Add(-project.RestoreWindow-time.Hour)).Error
+			Expect(err).To(BeNil())
+
+			found, err := project.FindDeletedByName(db, proj.Name)
+			Expect(err).To(BeNil())
+			Expect(found).To(BeNil())
+		})
+	})
+
+	Describe("IsNameOfDeletedProject()", func() {
+		It("returns whether a deleted project that has not been purged has the name", func() {
+			proj := factories.Project(db, u)
+
+			taken, err := project.IsNameOfDeletedProject(db, proj.Name)
+			Expect(err).To(BeNil())
+			Expect(taken).To(BeFalse())
+
+			Expect(proj.Destroy(db)).To(BeNil())
+
+			taken, err = project.IsNameOfDeletedProject(db, proj.Name)
+			Expect(err).To(BeNil())
+			Expect(taken).To(BeTrue())
+		})
+	})
+
+	Describe("IsDomainOfDeletedProject()", func() {
+		var (
+			proj *project.Project
+			dm1  *domain.Domain
+			dm2  *domain.Domain
+		)
+
+		BeforeEach(func() {
+			proj = factories.Project(db, u)
+			dm1 = factories.Domain(db, proj)
+			dm2 = factories.Domain(db, proj)
+		})
+
+		It("returns true for domains that were deleted along with the project", func() {
+			Expect(proj.Destroy(db)).To(BeNil())
+
+			taken, err := project.IsDomainOfDeletedProject(db, dm1.Name)
+			Expect(err).To(BeNil())
+			Expect(taken).To(BeTrue())
+		})
+
+		It("returns false for domains that were removed before the project was deleted", func() {
+			Expect(db.Delete(dm2).Error).To(BeNil())
+			Expect(proj.Destroy(db)).To(BeNil())
+
+			taken, err := project.IsDomainOfDeletedProject(db, dm2.Name)
+			Expect(err).To(BeNil())
+			Expect(taken).To(BeFalse())
+		})
+
+		It("returns false if the project has not been deleted", func() {
+			taken, err := project.IsDomainOfDeletedProject(db, dm1.Name)
+			Expect(err).To(BeNil())
+			Expect(taken).To(BeFalse())
+		})
+	})
+
+	Describe("CopySettingsFrom()", func() {
+		It("copies the settings of the source project without copying its identity or admin-only settings", func() {
+			username := "alice"
+			src := &project.Project{
+				Name:                 "source-project",
+				UserID:               1,
+				DefaultDomainEnabled: false,
+				ForceHTTPS:           true,
+				SkipBuild:            true,
+				Watermark:            false,
+				Optimizer:            project.OptimizerNative,
+				ImageQuality:         80,
+				BuildErrorPolicy:     project.BuildErrorPolicyBlock,
+				MaxTotalSize:         1024,
+				FailOverBudget:       true,
+				MaxDeploysKept:       5,
+				DeployRetentionDays:  30,
+				BasicAuthUsername:    &username,
+			}
+
+			p := &project.Project{
+				Name:                 "new-project",
+				UserID:               2,
+				DefaultDomainEnabled: true,
+				Watermark:            true,
+				Optimizer:            project.OptimizerDocker,
+				BuildErrorPolicy:     project.BuildErrorPolicyRawBundle,
+			}
+			p.CopySettingsFrom(src)
+
+			Expect(p.Name).To(Equal("new-project"))
+			Expect(p.UserID).To(Equal(uint(2)))
+			Expect(p.BasicAuthUsername).To(BeNil())
+			Expect(p.Watermark).To(BeTrue())
+
+			Expect(p.DefaultDomainEnabled).To(BeFalse())
+			Expect(p.ForceHTTPS).To(BeTrue())
+			Expect(p.SkipBuild).To(BeTrue())
+			Expect(p.Optimizer).To(Equal(project.OptimizerNative))
+			Expect(p.ImageQuality).To(Equal(80))
+			Expect(p.BuildErrorPolicy).To(Equal(project.BuildErrorPolicyBlock))
+			Expect(p.MaxTotalSize).To(Equal(int64(1024)))
+			Expect(p.FailOverBudget).To(BeTrue())
+			Expect(p.MaxDeploysKept).To(Equal(uint(5)))
+			Expect(p.DeployRetentionDays).To(Equal(uint(30)))
 		})
 	})
 
@@ -673,6 +1033,53 @@ var _ = Describe("Project", func() {
 				Expect(canCreate).To(BeFalse())
 			})
 		})
+
+		Context("when the user has created projects for an organization", func() {
+			BeforeEach(func() {
+				org := factories.Organization(db, u)
+				factories.OrganizationProject(db, org, u)
+				factories.OrganizationProject(db, org, u)
+			})
+
+			It("does not count them", func() {
+				canCreate, err := project.CanAddProject(db, u)
+				Expect(err).To(BeNil())
+				Expect(canCreate).To(BeTrue())
+			})
+		})
+	})
+
+	Describe("CanAddOrganizationProject()", func() {
+		var (
+			origMaxProjectPerOrganization int
+
+			org *organization.Organization
+		)
+
+		BeforeEach(func() {
+			origMaxProjectPerOrganization = project.MaxProjectPerOrganization
+			project.MaxProjectPerOrganization = 1
+
+			org = factories.Organization(db, u)
+		})
+
+		AfterEach(func() {
+			project.MaxProjectPerOrganization = origMaxProjectPerOrganization
+		})
+
+		It("returns true when the organization has fewer than the max number of projects allowed", func() {
+			canCreate, err := project.CanAddOrganizationProject(db, org.ID)
+			Expect(err).To(BeNil())
+			Expect(canCreate).To(BeTrue())
+		})
+
+		It("returns false when the organization already has the max number of projects allowed", func() {
+			factories.OrganizationProject(db, org, u)
+
+			canCreate, err := project.CanAddOrganizationProject(db, org.ID)
+			Expect(err).To(BeNil())
+			Expect(canCreate).To(BeFalse())
+		})
 	})
 
 	Describe("ProjectsByUserID", func() {
@@ -802,4 +1209,28 @@ var _ = Describe("Project", func() {
 			})
 		})
 	})
+
+	Describe("OrganizationProjectsByUserID", func() {
+		It("returns the projects of the organizations the user is a member of", func() {
+			u2 := factories.User(db)
+			org := factories.Organization(db, u2)
+			Expect(org.AddMember(db, u, organization.RoleMember)).To(Succeed())
+			orgProj := factories.OrganizationProject(db, org, u2)
+
+			otherOrg := factories.Organization(db, u2)
+			factories.OrganizationProject(db, otherOrg, u2)
+
+			projs, err := project.OrganizationProjectsByUserID(db, u.ID)
+			Expect(err).To(BeNil())
+
+			Expect(projs).To(HaveLen(1))
+			Expect(projs[0].ID).To(Equal(orgProj.ID))
+			Expect(projs[0].OrganizationName).To(Equal(org.Name))
+			Expect(projs[0].DeployedAt).To(BeNil())
+
+			projs, err = project.ProjectsByUserID(db, u2.ID)
+			Expect(err).To(BeNil())
+			Expect(projs).To(BeEmpty())
+		})
+	})
 })
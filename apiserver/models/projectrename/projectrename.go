@@ -0,0 +1,72 @@
+package projectrename
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/shared"
+)
+
+// GracePeriod is how long the old default domain of a renamed project keeps
+// redirecting to the new one, during which its old name cannot be taken by
+// another project.
+var GracePeriod = 30 * 24 * time.Hour
+
+// ProjectRename is a database model representing a former name of a project.
+type ProjectRename struct {
+	gorm.Model
+
+	ProjectID uint
+	OldName   string
+	ExpiresAt time.Time
+}
+
+// DefaultDomainName returns the default domain of the project under its old
+// name.
+func (r *ProjectRename) DefaultDomainName() string {
+	return r.OldName + "." + shared.DefaultDomain
+}
+
+// Create records that the project with the given ID was renamed from oldName
+// to newName. A former name of the project that is taken back is no longer
+// reserved.
+func Create(db *gorm.DB, projectID uint, oldName, newName string) (*ProjectRename, error) {
+	if err := db.Delete(ProjectRename{}, "project_id = ? AND old_name = ?", projectID, newName).Error; err != nil {
+		return nil, err
+	}
+
+	r := &ProjectRename{
+		ProjectID: projectID,
+		OldName:   oldName,
+		ExpiresAt: time.Now().Add(GracePeriod),
+	}
+	if err := db.Create(r).Error; err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// FindActiveByOldName returns the rename from the given name whose grace
+// period has not ended, or nil if there is none.
+func FindActiveByOldName(db *gorm.DB, name string) (*ProjectRename, error) {
+	var r ProjectRename
+	if err := db.Where("old_name = ? AND expires_at > ?", name, time.Now()).First(&r).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// ActiveByProjectID returns the renames of the project with the given ID
+// whose grace period has not ended, oldest first.
+func ActiveByProjectID(db *gorm.DB, projectID uint) ([]*ProjectRename, error) {
+	renames := []*ProjectRename{}
+	err := db.Where("project_id = ? AND expires_at > ?", projectID, time.Now()).
+		Order("created_at ASC").
+		Find(&renames).Error
+	return renames, err
+}
@@ -0,0 +1,106 @@
+package projectrename_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/projectrename"
+	"github.com/nitrous-io/rise-server/shared"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "projectrename")
+}
+
+var _ = Describe("ProjectRename", func() {
+	var (
+		db  *gorm.DB
+		err error
+
+		proj *project.Project
+	)
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+		testhelper.TruncateTables(db.DB())
+
+		proj = factories.Project(db, factories.User(db))
+	})
+
+	Describe("DefaultDomainName()", func() {
+		It("returns the default domain under the old name", func() {
+			r := &projectrename.ProjectRename{OldName: "old-name"}
+			Expect(r.DefaultDomainName()).To(Equal("old-name." + shared.DefaultDomain))
+		})
+	})
+
+	Describe("Create()", func() {
+		It("records the old name until the grace period ends", func() {
+			r, err := projectrename.Create(db, proj.ID, "old-name", proj.Name)
+			Expect(err).To(BeNil())
+			Expect(r.ProjectID).To(Equal(proj.ID))
+			Expect(r.OldName).To(Equal("old-name"))
+			Expect(r.ExpiresAt).To(BeTemporally("~", time.Now().Add(projectrename.GracePeriod), time.Minute))
+		})
+
+		It("releases a former name that the project is renamed back to", func() {
+			_, err := projectrename.Create(db, proj.ID, "old-name", "new-name")
+			Expect(err).To(BeNil())
+
+			_, err = projectrename.Create(db, proj.ID, "new-name", "old-name")
+			Expect(err).To(BeNil())
+
+			r, err := projectrename.FindActiveByOldName(db, "old-name")
+			Expect(err).To(BeNil())
+			Expect(r).To(BeNil())
+
+			r, err = projectrename.FindActiveByOldName(db, "new-name")
+			Expect(err).To(BeNil())
+			Expect(r).NotTo(BeNil())
+		})
+	})
+
+	Describe("FindActiveByOldName()", func() {
+		It("does not return renames whose grace period has ended", func() {
+			r, err := projectrename.Create(db, proj.ID, "old-name", proj.Name)
+			Expect(err).To(BeNil())
+			Expect(db.Model(r).Update("expires_at", time.Now().Add(-time.Minute)).Error).To(BeNil())
+
+			found, err := projectrename.FindActiveByOldName(db, "old-name")
+			Expect(err).To(BeNil())
+			Expect(found).To(BeNil())
+		})
+	})
+
+	Describe("ActiveByProjectID()", func() {
+		It("returns the renames of the project whose grace period has not ended", func() {
+			r1, err := projectrename.Create(db, proj.ID, "first-name", "second-name")
+			Expect(err).To(BeNil())
+			r2, err := projectrename.Create(db, proj.ID, "second-name", proj.Name)
+			Expect(err).To(BeNil())
+
+			r3, err := projectrename.Create(db, proj.ID, "expired-name", "first-name")
+			Expect(err).To(BeNil())
+			Expect(db.Model(r3).Update("expires_at", time.Now().Add(-time.Minute)).Error).To(BeNil())
+
+			_, err = projectrename.Create(db, factories.Project(db, factories.User(db)).ID, "other-name", "another-name")
+			Expect(err).To(BeNil())
+
+			renames, err := projectrename.ActiveByProjectID(db, proj.ID)
+			Expect(err).To(BeNil())
+			Expect(renames).To(HaveLen(2))
+			Expect(renames[0].ID).To(Equal(r1.ID))
+			Expect(renames[1].ID).To(Equal(r2.ID))
+		})
+	})
+})
@@ -0,0 +1,109 @@
+package projecttransfer
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// ExpiresIn is how long a transfer can be accepted for after it is requested.
+var ExpiresIn = 7 * 24 * time.Hour
+
+// ProjectTransfer is a database model representing a request from the owner of
+// a project to make another user its owner. It is accepted with its Token,
+// which is emailed to the recipient.
+type ProjectTransfer struct {
+	gorm.Model
+
+	ProjectID               uint
+	FromUserID              uint
+	ToUserID                uint
+	KeepOwnerAsCollaborator bool
+
+	Token      string `sql:"default:encode(gen_random_bytes(16), 'hex')"`
+	AcceptedAt *time.Time
+}
+
+// JSON specifies which fields of a transfer will be marshaled to JSON.
+type JSON struct {
+	ProjectName             string    `json:"project_name"`
+	From                    string    `json:"from"` // email of the owner
+	To                      string    `json:"to"`   // email of the recipient
+	KeepOwnerAsCollaborator bool      `json:"keep_owner_as_collaborator"`
+	CreatedAt               time.Time `json:"created_at"`
+	ExpiresAt               time.Time `json:"expires_at"`
+}
+
+// AsJSON returns a struct that can be converted to JSON, given the name of the
+// project and the emails of its owner and the recipient.
+func (t *ProjectTransfer) AsJSON(projectName, from, to string) *JSON {
+	return &JSON{
+		ProjectName:             projectName,
+		From:                    from,
+		To:                      to,
+		KeepOwnerAsCollaborator: t.KeepOwnerAsCollaborator,
+		CreatedAt:               t.CreatedAt,
+		ExpiresAt:               t.ExpiresAt(),
+	}
+}
+
+// ExpiresAt returns when the transfer can no longer be accepted.
+func (t *ProjectTransfer) ExpiresAt() time.Time {
+	return t.CreatedAt.Add(ExpiresIn)
+}
+
+// Create requests a transfer, replacing any transfer of the same project that
+// has not been accepted yet.
+func Create(db *gorm.DB, t *ProjectTransfer) error {
+	if err := CancelPending(db, t.ProjectID); err != nil {
+		return err
+	}
+
+	return db.Create(t).Error
+}
+
+// CancelPending deletes the transfer of the project that has not been
+// accepted yet, if there is one.
+func CancelPending(db *gorm.DB, projectID uint) error {
+	return db.Delete(ProjectTransfer{}, "project_id = ? AND accepted_at IS NULL", projectID).Error
+}
+
+// FindPending returns the transfer of the project that has not been accepted
+// yet and has not expired, or nil if there is none.
+func FindPending(db *gorm.DB, projectID uint) (*ProjectTransfer, error) {
+	return findPending(db.Where("project_id = ?", projectID))
+}
+
+// FindPendingByToken returns the transfer with the given token if it has not
+// been accepted yet and has not expired, or nil otherwise.
+func FindPendingByToken(db *gorm.DB, token string) (*ProjectTransfer, error) {
+	return findPending(db.Where("token = ?", token))
+}
+
+// PendingTransfersToUser returns the transfers to the user that have not been
+// accepted yet and have not expired, oldest first.
+func PendingTransfersToUser(db *gorm.DB, userID uint) ([]*ProjectTransfer, error) {
+	transfers := []*ProjectTransfer{}
+	if err := db.Where("to_user_id = ? AND accepted_at IS NULL AND created_at > ?", userID, time.Now().Add(-ExpiresIn)).
+		Order("created_at ASC").
+		Find(&transfers).Error; err != nil {
+		return nil, err
+	}
+	return transfers, nil
+}
+
+// MarkAccepted records that the transfer has been accepted.
+func (t *ProjectTransfer) MarkAccepted(db *gorm.DB) error {
+	return db.Model(ProjectTransfer{}).Where("id = ?", t.ID).Update("accepted_at", gorm.Expr("now()")).Scan(t).Error
+}
+
+func findPending(q *gorm.DB) (*ProjectTransfer, error) {
+	var t ProjectTransfer
+	if err := q.Where("accepted_at IS NULL AND created_at > ?", time.Now().Add(-ExpiresIn)).First(&t).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &t, nil
+}
@@ -0,0 +1,133 @@
+package projecttransfer_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/projecttransfer"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "projecttransfer")
+}
+
+var _ = Describe("ProjectTransfer", func() {
+	var (
+		db  *gorm.DB
+		err error
+
+		u1, u2 *user.User
+		proj   *project.Project
+	)
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+		testhelper.TruncateTables(db.DB())
+
+		u1 = factories.User(db)
+		u2 = factories.User(db)
+		proj = factories.Project(db, u1)
+	})
+
+	newTransfer := func(proj *project.Project, to *user.User) *projecttransfer.ProjectTransfer {
+		t := &projecttransfer.ProjectTransfer{
+			ProjectID:  proj.ID,
+			FromUserID: proj.UserID,
+			ToUserID:   to.ID,
+		}
+		Expect(projecttransfer.Create(db, t)).To(Succeed())
+		return t
+	}
+
+	expire := func(t *projecttransfer.ProjectTransfer) {
+		createdAt := time.Now().Add(-projecttransfer.ExpiresIn - time.Minute)
+		Expect(db.Model(t).UpdateColumn("created_at", createdAt).Error).To(BeNil())
+	}
+
+	Describe("Create()", func() {
+		It("generates a token", func() {
+			t := newTransfer(proj, u2)
+			Expect(t.Token).To(HaveLen(32))
+		})
+
+		It("replaces the pending transfer of the project", func() {
+			t1 := newTransfer(proj, u2)
+			t2 := newTransfer(proj, factories.User(db))
+
+			t, err := projecttransfer.FindPending(db, proj.ID)
+			Expect(err).To(BeNil())
+			Expect(t.ID).To(Equal(t2.ID))
+
+			t, err = projecttransfer.FindPendingByToken(db, t1.Token)
+			Expect(err).To(BeNil())
+			Expect(t).To(BeNil())
+		})
+	})
+
+	Describe("FindPendingByToken()", func() {
+		var t *projecttransfer.ProjectTransfer
+
+		BeforeEach(func() {
+			t = newTransfer(proj, u2)
+		})
+
+		It("returns the transfer", func() {
+			found, err := projecttransfer.FindPendingByToken(db, t.Token)
+			Expect(err).To(BeNil())
+			Expect(found.ID).To(Equal(t.ID))
+		})
+
+		It("returns nil if the token is wrong", func() {
+			found, err := projecttransfer.FindPendingByToken(db, "wrong")
+			Expect(err).To(BeNil())
+			Expect(found).To(BeNil())
+		})
+
+		It("returns nil if the transfer has been accepted", func() {
+			Expect(t.MarkAccepted(db)).To(Succeed())
+			Expect(t.AcceptedAt).NotTo(BeNil())
+
+			found, err := projecttransfer.FindPendingByToken(db, t.Token)
+			Expect(err).To(BeNil())
+			Expect(found).To(BeNil())
+		})
+
+		It("returns nil if the transfer has expired", func() {
+			expire(t)
+
+			found, err := projecttransfer.FindPendingByToken(db, t.Token)
+			Expect(err).To(BeNil())
+			Expect(found).To(BeNil())
+		})
+	})
+
+	Describe("PendingTransfersToUser()", func() {
+		It("returns the pending transfers to the user, oldest first", func() {
+			proj2 := factories.Project(db, u1)
+			proj3 := factories.Project(db, u1)
+			proj4 := factories.Project(db, u1)
+
+			t1 := newTransfer(proj, u2)
+			t2 := newTransfer(proj2, u2)
+			expire(newTransfer(proj3, u2))
+			newTransfer(proj4, factories.User(db))
+
+			transfers, err := projecttransfer.PendingTransfersToUser(db, u2.ID)
+			Expect(err).To(BeNil())
+			Expect(transfers).To(HaveLen(2))
+			Expect(transfers[0].ID).To(Equal(t1.ID))
+			Expect(transfers[1].ID).To(Equal(t2.ID))
+		})
+	})
+})
@@ -66,7 +66,7 @@ func (u *User) Validate() map[string]string {
 
 	if u.Email == "" {
 		errors["email"] = "is required"
-	} else if len(u.Email) < 5 || !emailRe.MatchString(u.Email) {
+	} else if !IsValidEmail(u.Email) {
 		errors["email"] = "is invalid"
 	}
 
@@ -76,6 +76,11 @@ func (u *User) Validate() map[string]string {
 	return errors
 }
 
+// IsValidEmail returns whether email looks like a valid email address.
+func IsValidEmail(email string) bool {
+	return len(email) >= 5 && emailRe.MatchString(email)
+}
+
 // Insert saves the record to the DB, encrypting the Password field
 func (u *User) Insert(db *gorm.DB) error {
 	err := db.Raw(`INSERT INTO users (
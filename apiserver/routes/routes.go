@@ -3,12 +3,16 @@ package routes
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/nitrous-io/rise-server/apiserver/controllers/acme"
+	"github.com/nitrous-io/rise-server/apiserver/controllers/buildenvvars"
 	"github.com/nitrous-io/rise-server/apiserver/controllers/certs"
 	"github.com/nitrous-io/rise-server/apiserver/controllers/deployments"
+	"github.com/nitrous-io/rise-server/apiserver/controllers/deploytokens"
 	"github.com/nitrous-io/rise-server/apiserver/controllers/domains"
+	"github.com/nitrous-io/rise-server/apiserver/controllers/health"
 	"github.com/nitrous-io/rise-server/apiserver/controllers/hooks"
 	"github.com/nitrous-io/rise-server/apiserver/controllers/jsenvvars"
 	"github.com/nitrous-io/rise-server/apiserver/controllers/oauth"
+	"github.com/nitrous-io/rise-server/apiserver/controllers/organizations"
 	"github.com/nitrous-io/rise-server/apiserver/controllers/ping"
 	"github.com/nitrous-io/rise-server/apiserver/controllers/projects"
 	"github.com/nitrous-io/rise-server/apiserver/controllers/rawbundles"
@@ -16,8 +20,10 @@ import (
 	"github.com/nitrous-io/rise-server/apiserver/controllers/root"
 	"github.com/nitrous-io/rise-server/apiserver/controllers/stats"
 	"github.com/nitrous-io/rise-server/apiserver/controllers/templates"
+	"github.com/nitrous-io/rise-server/apiserver/controllers/transfers"
 	"github.com/nitrous-io/rise-server/apiserver/controllers/users"
 	"github.com/nitrous-io/rise-server/apiserver/middleware"
+	"github.com/nitrous-io/rise-server/apiserver/models/collab"
 )
 
 func Draw(r *gin.Engine) {
@@ -26,10 +32,14 @@ func Draw(r *gin.Engine) {
 		r.Use(gin.Recovery())
 	}
 
+	r.Use(middleware.RequestID)
+	r.Use(middleware.Metrics(r))
 	r.Use(middleware.CORS)
 
 	r.GET("/", root.Root)
 	r.GET("/ping", ping.Ping)
+	r.GET("/health/live", health.Live)
+	r.GET("/health/ready", health.Ready)
 	r.POST("/users", users.Create)
 	r.POST("/user/confirm", users.Confirm)
 	r.POST("/user/confirm/resend", users.ResendConfirmationCode)
@@ -42,6 +52,21 @@ func Draw(r *gin.Engine) {
 
 	r.POST("/hooks/github/:path", hooks.GitHubPush)
 
+	{ // Routes that accept the deploy token of a project as well as a OAuth Token
+		deployable := r.Group("/projects/:project_name", middleware.AllowDeployToken, middleware.RequireToken, middleware.RequireProjectCollab)
+
+		deployable.GET("/deployments/:id", deployments.Show)
+
+		{ // Routes that require the deployer role
+			deployer := deployable.Group("", middleware.RequireProjectRole(collab.RoleDeployer))
+
+			{ // Routes that lock a project
+				lock := deployer.Group("", middleware.LockProject)
+				lock.POST("/deployments", deployments.Create)
+			}
+		}
+	}
+
 	{ // Routes that require a OAuth Token
 		authorized := r.Group("", middleware.RequireToken)
 		authorized.DELETE("/oauth/token", oauth.DestroyToken)
@@ -51,37 +76,81 @@ func Draw(r *gin.Engine) {
 		authorized.PUT("/user", users.Update)
 		authorized.GET("/templates", templates.Index)
 		authorized.GET("/domains", domains.DomainsByUser)
+		authorized.GET("/transfers", transfers.Index)
+		authorized.POST("/transfers/accept", transfers.Accept)
+		authorized.POST("/organizations", organizations.Create)
+		authorized.GET("/organizations", organizations.Index)
+		authorized.POST("/organizations/:org_name/invitation/accept", organizations.AcceptInvitation)
+
+		{ // Routes that organization members can access
+			orgMember := authorized.Group("/organizations/:org_name", middleware.RequireOrganizationMember)
+
+			orgMember.GET("", organizations.Show)
+			orgMember.DELETE("/members/:email", organizations.RemoveMember)
+		}
+
+		{ // Routes that only organization owners can access
+			orgOwner := authorized.Group("/organizations/:org_name", middleware.RequireOrganizationOwner)
+
+			orgOwner.POST("/invitations", organizations.Invite)
+			orgOwner.DELETE("/invitations/:email", organizations.RevokeInvitation)
+		}
 
 		{ // Routes that either project owners or collaborators can access
 			projCollab := authorized.Group("/projects/:project_name", middleware.RequireProjectCollab)
 
 			projCollab.GET("", projects.Get)
 			projCollab.GET("/deployments/:id/download", deployments.Download)
-			projCollab.GET("/deployments/:id", deployments.Show)
+			projCollab.GET("/deployments/:id/report", deployments.Report)
 			projCollab.GET("/deployments", deployments.Index)
 			projCollab.GET("repos", repos.Show)
-			projCollab.POST("/repos", repos.Link)
-			projCollab.DELETE("/repos", repos.Unlink)
 			projCollab.GET("/domains", domains.Index)
 			projCollab.GET("/collaborators", projects.ListCollaborators)
+			projCollab.GET("/invitations", projects.ListInvitations)
 			projCollab.GET("/domains/:name/cert", certs.Show)
-			projCollab.POST("/domains/:name/cert", certs.Create)
-			projCollab.POST("/domains/:name/cert/letsencrypt", certs.LetsEncrypt)
-			projCollab.DELETE("/domains/:name/cert", certs.Destroy)
 			projCollab.GET("/raw_bundles/:bundle_checksum", rawbundles.Get)
 			projCollab.GET("/jsenvvars", jsenvvars.Index)
+			projCollab.GET("/jsenvvars/history", jsenvvars.History)
+			projCollab.GET("/buildenvvars", buildenvvars.Index)
 
-			{ // Routes that lock a project
-				lock := projCollab.Group("", middleware.LockProject)
-				lock.PUT("", projects.Update)
-				lock.POST("/deployments", deployments.Create)
-				lock.POST("/domains", domains.Create)
-				lock.DELETE("/domains/:name", domains.Destroy)
-				lock.POST("/rollback", deployments.Rollback)
-				lock.POST("/auth", projects.CreateAuth)
-				lock.DELETE("/auth", projects.DeleteAuth)
-				lock.PUT("/jsenvvars/add", jsenvvars.Add)
-				lock.PUT("/jsenvvars/delete", jsenvvars.Delete)
+			{ // Routes that require the deployer role
+				deployer := projCollab.Group("", middleware.RequireProjectRole(collab.RoleDeployer))
+
+				deployer.GET("/deploy_tokens", deploytokens.Index)
+				deployer.POST("/deploy_tokens", deploytokens.Create)
+				deployer.DELETE("/deploy_tokens/:id", deploytokens.Destroy)
+				deployer.PUT("/deployments/:id/pin", deployments.Pin)
+				deployer.DELETE("/deployments/:id/pin", deployments.Unpin)
+
+				{ // Routes that lock a project
+					lock := deployer.Group("", middleware.LockProject)
+					lock.POST("/rollback", deployments.Rollback)
+				}
+			}
+
+			{ // Routes that require the admin role
+				admin := projCollab.Group("", middleware.RequireProjectRole(collab.RoleAdmin))
+
+				admin.POST("/repos", repos.Link)
+				admin.DELETE("/repos", repos.Unlink)
+				admin.POST("/domains/:name/cert", certs.Create)
+				admin.POST("/domains/:name/cert/letsencrypt", certs.LetsEncrypt)
+				admin.DELETE("/domains/:name/cert", certs.Destroy)
+
+				{ // Routes that lock a project
+					lock := admin.Group("", middleware.LockProject)
+					lock.PUT("", projects.Update)
+					lock.POST("/domains", domains.Create)
+					lock.DELETE("/domains/:name", domains.Destroy)
+					lock.POST("/auth", projects.CreateAuth)
+					lock.DELETE("/auth", projects.DeleteAuth)
+					lock.PUT("/jsenvvars/add", jsenvvars.Add)
+					lock.PUT("/jsenvvars/delete", jsenvvars.Delete)
+					lock.PUT("/jsenvvars", jsenvvars.Replace)
+					lock.POST("/jsenvvars/rollback", jsenvvars.Rollback)
+					lock.PUT("/buildenvvars/add", buildenvvars.Add)
+					lock.PUT("/buildenvvars/delete", buildenvvars.Delete)
+				}
 			}
 		}
 
@@ -89,12 +158,22 @@ func Draw(r *gin.Engine) {
 			projOwner := authorized.Group("/projects/:project_name", middleware.RequireProject)
 
 			projOwner.POST("/collaborators", projects.AddCollaborator)
+			projOwner.PUT("/collaborators/:email", projects.UpdateCollaborator)
 			projOwner.DELETE("/collaborators/:email", projects.RemoveCollaborator)
+			projOwner.DELETE("/invitations/:email", projects.RevokeInvitation)
+			projOwner.DELETE("/transfer", transfers.Destroy)
 
 			{ // Routes that lock a project
 				lock := projOwner.Group("", middleware.LockProject)
 				lock.DELETE("", projects.Destroy) // DELETE /projects/:project_name
+				lock.POST("/transfer", transfers.Create)
 			}
 		}
+
+		{ // Routes for projects that the owner has deleted
+			deletedProj := authorized.Group("/projects/:project_name", middleware.RequireDeletedProject)
+
+			deletedProj.POST("/restore", projects.Restore)
+		}
 	}
 }
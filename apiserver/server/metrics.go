@@ -0,0 +1,28 @@
+package server
+
+import (
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/pkg/metrics"
+)
+
+// StartMetricsServer serves metrics in the Prometheus text format on
+// common.MetricsAddr in the background, so that they are not exposed by the
+// public API. If the address is empty, nothing is served.
+func StartMetricsServer() {
+	if common.MetricsAddr == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+
+	go func() {
+		log.Infof("Serving metrics on %s...", common.MetricsAddr)
+		if err := http.ListenAndServe(common.MetricsAddr, mux); err != nil {
+			log.Errorln("Failed to start metrics server:", err)
+		}
+	}()
+}
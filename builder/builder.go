@@ -4,12 +4,14 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
-	"time"
 
+	healthctrl "github.com/nitrous-io/rise-server/apiserver/controllers/health"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
 	"github.com/nitrous-io/rise-server/builder/builder"
-	"github.com/nitrous-io/rise-server/pkg/mqconn"
+	"github.com/nitrous-io/rise-server/pkg/broker"
+	"github.com/nitrous-io/rise-server/pkg/health"
+	"github.com/nitrous-io/rise-server/pkg/worker"
 	"github.com/nitrous-io/rise-server/shared/queues"
-	"github.com/streadway/amqp"
 
 	log "github.com/Sirupsen/logrus"
 )
@@ -20,105 +22,42 @@ func main() {
 }
 
 func run() {
-	mq, err := mqconn.MQ()
-	if err != nil {
-		log.Errorln("Failed to connect to mq:", err)
-		return
-	}
-	connErrCh := mq.NotifyClose(make(chan *amqp.Error))
-
-	ch, err := mq.Channel()
-	if err != nil {
-		log.Errorln("Failed to obtain channel:", err)
-		return
-	}
-
-	defer func() {
-		err = ch.Close()
-		if err != nil {
-			log.Errorln("Failed to close channel:", err)
-		}
-	}()
-
-	err = ch.Qos(
-		1,     // prefetch count
-		0,     // prefetch size
-		false, // global
-	)
-
-	if err != nil {
-		log.Errorln("Failed to set qos to channel:", err)
-		return
-	}
-
 	queueName := queues.Build
 
-	q, err := ch.QueueDeclare(
-		queueName,
-		true,  // durable
-		false, // delete when unused
-		false, // exclusive
-		false, // noWait
-		nil,
-	)
-	if err != nil {
-		log.Errorf("Failed to declare queue(%s): %v", queueName, err)
-		return
-	}
-
-	msgCh, err := ch.Consume(
-		q.Name, // queue
-		"",     // consumer
-		false,  // auto-ack
-		false,  // exclusive
-		false,  // no-local
-		false,  // no-wait
-		nil,    // args
-	)
+	concurrency := worker.DefaultConcurrency
+	dbconn.SetPoolSize(concurrency)
 
+	sub, err := broker.Default.Consume(queueName, concurrency)
 	if err != nil {
-		log.Errorf("Failed to start consuming message from queue(%s): %v", q.Name, err)
+		log.Errorf("Failed to start consuming message from queue(%s): %v", queueName, err)
 		return
 	}
 
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
 
-	log.Infof("Worker started listening to queue(%s)...", q.Name)
+	stop := make(chan struct{})
+	go func() {
+		sig := <-sigCh
+		log.Errorln("Caught signal:", sig)
+		close(stop)
+	}()
 
-	for {
-		select {
-		case d := <-msgCh:
-			err = builder.Work(d.Body)
-			if err != nil {
-				// failure
-				log.Warnln("Work failed", err, string(d.Body))
+	checker := health.NewChecker()
+	checker.Add("database", healthctrl.Database)
+	checker.Add("mq", healthctrl.MQ)
+	checker.Add("storage", healthctrl.Storage)
+	worker.StartHTTPServer(checker)
 
-				if err == builder.ErrRecordNotFound || err == builder.ErrUnarchiveFailed {
-					if err := d.Ack(false); err != nil {
-						log.WithFields(log.Fields{"queue": queueName}).Warnln("Failed to Ack message:", err)
-					}
-				} else {
-					go func() {
-						// nack after a delay to prevent thrashing
-						time.Sleep(1 * time.Second)
-						if err := d.Nack(false, true); err != nil {
-							log.WithFields(log.Fields{"queue": queueName}).Warnln("Failed to Nack message:", err)
-						}
-					}()
-				}
-			} else {
-				// success
-				if err := d.Ack(false); err != nil {
-					log.WithFields(log.Fields{"queue": queueName}).Warnln("Failed to Ack message:", err)
-				}
-			}
-		case err := <-connErrCh:
-			log.Errorln(err)
-			return
-		case sig := <-sigCh:
-			log.Errorln("Caught signal:", sig)
-			return
-		}
+	log.Infof("Worker started listening to queue(%s)...", queueName)
+
+	w := &worker.Worker{
+		Name:        queueName,
+		Work:        builder.WorkContext,
+		Retryable:   builder.Retryable,
+		Concurrency: concurrency,
+	}
+	if err := w.Run(sub, stop); err != nil {
+		log.Errorln(err)
 	}
 }
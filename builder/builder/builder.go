@@ -3,36 +3,44 @@ package builder
 import (
 	"archive/tar"
 	"archive/zip"
+	"bytes"
 	"compress/gzip"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
+	log "github.com/Sirupsen/logrus"
 	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
 	"github.com/nitrous-io/rise-server/apiserver/dbconn"
 	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
 	"github.com/nitrous-io/rise-server/apiserver/models/project"
 	"github.com/nitrous-io/rise-server/apiserver/models/rawbundle"
 	"github.com/nitrous-io/rise-server/pkg/filetransfer"
 	"github.com/nitrous-io/rise-server/pkg/job"
+	"github.com/nitrous-io/rise-server/pkg/metrics"
+	"github.com/nitrous-io/rise-server/pkg/optimizer"
+	"github.com/nitrous-io/rise-server/pkg/projectconfig"
 	"github.com/nitrous-io/rise-server/shared/messages"
 	"github.com/nitrous-io/rise-server/shared/queues"
 	"github.com/nitrous-io/rise-server/shared/s3client"
+	"golang.org/x/net/context"
 )
 
 const (
 	OptimizePath         = "/tmp/optimizer/build"
 	OptimizerDockerImage = "quay.io/nitrous/pubstorm-optimizer"
-	ErrorMessagePrefix   = "[Error] "
+	ErrorMessagePrefix   = optimizer.ErrorMessagePrefix
+	WarningMessagePrefix = optimizer.WarningMessagePrefix
 )
 
 func init() {
@@ -58,19 +66,59 @@ var (
 	ErrRecordNotFound   = errors.New("project or deployment is deleted")
 	ErrUnarchiveFailed  = errors.New("Failed to unarchive file")
 
-	OptimizerCmd = func(containerName string, srcDir string, domainNames []string) *exec.Cmd {
-		return exec.Command("docker", "run", "--name", containerName, "-v", srcDir+":"+OptimizePath, "-e", "DOMAIN_NAMES_WITH_PROTOCOL="+strings.Join(domainNames, ","), "--rm", OptimizerDockerImage)
+	// OptimizerCmd returns the command that runs the Docker optimizer. The
+	// build-time environment variables of the project are read from envFile,
+	// if it is not empty, so that they are set in the container only, and
+	// cannot be seen in the list of processes.
+	OptimizerCmd = func(containerName string, srcDir string, domainNames []string, envFile string) *exec.Cmd {
+		args := []string{"run", "--name", containerName, "-v", srcDir + ":" + OptimizePath, "-e", "DOMAIN_NAMES_WITH_PROTOCOL=" + strings.Join(domainNames, ",")}
+		if envFile != "" {
+			args = append(args, "--env-file", envFile)
+		}
+
+		return exec.Command("docker", append(args, "--rm", OptimizerDockerImage)...)
 	}
 
+	// NativeOptimizer is used instead of OptimizerCmd for projects that use
+	// the native optimizer.
+	NativeOptimizer = optimizer.Default()
+
 	OptimizerTimeout = 5 * 60 * time.Second // 5 mins
+
+	// LargestFilesReported is how many of the largest files and heaviest
+	// pages are listed in build reports.
+	LargestFilesReported = 10
+
+	optimizerDuration = metrics.NewHistogram(
+		"builder_optimizer_duration_seconds",
+		"Time taken to run the optimizer, by optimizer and result (success, failure or timeout).",
+		[]float64{1, 5, 10, 30, 60, 120, 180, 240, 300},
+		"optimizer", "result",
+	)
 )
 
+// Retryable reports whether a build job that failed with err should be
+// retried.
+func Retryable(err error) bool {
+	return err != ErrRecordNotFound && err != ErrUnarchiveFailed
+}
+
 func Work(data []byte) error {
+	return WorkContext(context.Background(), data)
+}
+
+// WorkContext is like Work, but stops early with ctx.Err() if ctx is cancelled
+// before the build is complete. The deployment is then left in the
+// pending_build state, and the project is unlocked, so that the job can be
+// retried.
+func WorkContext(ctx context.Context, data []byte) error {
 	d := &messages.BuildJobData{}
 	if err := json.Unmarshal(data, d); err != nil {
 		return err
 	}
 
+	logger := log.WithFields(log.Fields{"request_id": d.RequestID})
+
 	db, err := dbconn.DB()
 	if err != nil {
 		return err
@@ -103,7 +151,7 @@ func Work(data []byte) error {
 
 	defer func() {
 		if err := proj.Unlock(db); err != nil {
-			log.Printf("failed to unlock project %d due to %v", proj.ID, err)
+			logger.Printf("failed to unlock project %d due to %v", proj.ID, err)
 		}
 	}()
 
@@ -155,6 +203,10 @@ func Work(data []byte) error {
 		return err
 	}
 
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	if archiveFormat == "tar.gz" {
 		gr, err := gzip.NewReader(f)
 		if err != nil {
@@ -234,6 +286,36 @@ func Work(data []byte) error {
 		}
 	}
 
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	cfg, err := projectconfig.Load(dirName)
+	if err != nil {
+		if verr, ok := err.(*projectconfig.ValidationError); ok {
+			diags := make([]*deployment.Diagnostic, len(verr.Problems))
+			for i, problem := range verr.Problems {
+				diags[i] = &deployment.Diagnostic{
+					Severity: deployment.SeverityError,
+					File:     projectconfig.FileName,
+					Message:  problem,
+				}
+			}
+			if err := depl.SaveDiagnostics(db, diags); err != nil {
+				return err
+			}
+
+			errorMessage := verr.Error()
+			depl.ErrorMessage = &errorMessage
+			return depl.UpdateState(db, deployment.StateBuildFailed)
+		}
+		return err
+	}
+
+	if err := removeIgnoredFiles(dirName, cfg); err != nil {
+		return err
+	}
+
 	optimizedBundleArchive, err := ioutil.TempFile("", "optimized-bundle."+archiveFormat)
 	if err != nil {
 		return err
@@ -243,6 +325,7 @@ func Work(data []byte) error {
 	deployJobMsg := messages.DeployJobData{
 		DeploymentID:  depl.ID,
 		ArchiveFormat: archiveFormat,
+		RequestID:     d.RequestID,
 	}
 
 	nextState := deployment.StateBuilt
@@ -253,43 +336,142 @@ func Work(data []byte) error {
 		return err
 	}
 
-	output, err := runOptimizer(fmt.Sprintf("%s-%d", prefixID, time.Now().Unix()), dirName, domainNames)
+	unminified, err := readUnminifiedFiles(dirName, cfg)
+	if err != nil {
+		return err
+	}
+
+	buildEnvVars, err := proj.BuildEnvVars(common.AesKey)
+	if err != nil {
+		return err
+	}
+
+	res, err := runOptimizer(ctx, proj.Optimizer, fmt.Sprintf("%s-%d", prefixID, time.Now().Unix()), dirName, domainNames, buildEnvVars)
 	if err == nil {
-		var errorMessages []string
-		outputs := strings.Split(output, "\n")
-		for _, output := range outputs {
-			if strings.HasPrefix(output, ErrorMessagePrefix) {
-				errorMessages = append(errorMessages, strings.TrimLeft(output, ErrorMessagePrefix))
-			}
+		if err := restoreFiles(unminified); err != nil {
+			return err
 		}
 
-		if len(errorMessages) > 0 {
+		diags := diagnostics(res.Messages)
+		if errs := errorDiagnostics(diags); len(errs) > 0 {
+			errorMessage := strings.Join(errs, "\n")
+			logger.Printf("error on optimizing: %v", errorMessage)
+			depl.ErrorMessage = &errorMessage
+
+			if err := depl.SaveDiagnostics(db, diags); err != nil {
+				return err
+			}
+
+			if proj.BuildErrorPolicy == project.BuildErrorPolicyBlock {
+				return depl.UpdateState(db, deployment.StateBuildFailed)
+			}
+
+			// Deploy the files as they were uploaded, rather than the
+			// partially optimized ones.
 			nextState = deployment.StateBuildFailed
-			errorMessage := strings.Join(errorMessages, "\n")
-			log.Printf("error on optimizing: %v", errorMessage)
-		}
+			deployJobMsg.UseRawBundle = true
+		} else {
+			report := &deployment.BuildReport{}
+			for _, saving := range res.Savings {
+				// Files that were restored were not made any smaller.
+				if _, ok := unminified[filepath.Join(dirName, filepath.FromSlash(saving.File))]; !ok {
+					report.AddOptimizedFile(saving.File, saving.Stage, saving.OriginalSize, saving.Size)
+				}
+			}
 
-		if err := pack(optimizedBundleArchive, dirName, archiveFormat); err != nil {
-			return err
-		}
+			imgMsgs, err := optimizeImages(ctx, logger, dirName, cfg.ImageQuality(proj.ImageQuality), report)
+			if err != nil {
+				return err
+			}
+			diags = append(diags, diagnostics(imgMsgs)...)
 
-		if err := S3.Upload(s3client.BucketRegion, s3client.BucketName, "deployments/"+prefixID+"/optimized-bundle."+archiveFormat, optimizedBundleArchive, "", "private"); err != nil {
-			return err
-		}
+			if proj.FingerprintAssets {
+				if err := fingerprintAssets(ctx, dirName, report); err != nil {
+					return err
+				}
+			}
+
+			linkMsgs, err := checkLinks(ctx, dirName, cfg, report)
+			if err != nil {
+				return err
+			}
+			diags = append(diags, diagnostics(linkMsgs)...)
 
+			pageWeights, err := measureSizes(dirName, report)
+			if err != nil {
+				return err
+			}
+
+			checkBudgets(proj, pageWeights, report)
+			for _, v := range report.OverBudget {
+				diags = append(diags, &deployment.Diagnostic{
+					Severity: deployment.SeverityWarning,
+					Stage:    "budgets",
+					File:     v.Path,
+					Message:  v.String(),
+				})
+			}
+
+			if err := depl.SaveBuildReport(db, report); err != nil {
+				return err
+			}
+
+			if err := depl.SaveDiagnostics(db, diags); err != nil {
+				return err
+			}
+
+			if len(report.BrokenLinks) > 0 && proj.FailOnBrokenLinks {
+				errorMessage := "Broken links found:"
+				for _, l := range report.BrokenLinks {
+					errorMessage += "\n" + l.String()
+				}
+				depl.ErrorMessage = &errorMessage
+				return depl.UpdateState(db, deployment.StateBuildFailed)
+			}
+
+			if len(report.OverBudget) > 0 && proj.FailOverBudget {
+				errorMessage := "Over budget:"
+				for _, v := range report.OverBudget {
+					errorMessage += "\n" + v.String()
+				}
+				depl.ErrorMessage = &errorMessage
+				return depl.UpdateState(db, deployment.StateBuildFailed)
+			}
+
+			if err := pack(optimizedBundleArchive, dirName, archiveFormat); err != nil {
+				return err
+			}
+
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			if err := S3.Upload(s3client.BucketRegion, s3client.BucketName, "deployments/"+prefixID+"/optimized-bundle."+archiveFormat, optimizedBundleArchive, "", "private"); err != nil {
+				return err
+			}
+		}
 	} else if err == ErrOptimizerTimeout {
-		if err := depl.UpdateState(db, deployment.StateBuildFailed); err != nil {
-			return err
+		errorMessage := ErrOptimizerTimeout.Error()
+		depl.ErrorMessage = &errorMessage
+
+		if proj.BuildErrorPolicy == project.BuildErrorPolicyBlock {
+			return depl.UpdateState(db, deployment.StateBuildFailed)
 		}
 
+		// The state is only changed below, so that the job can still be
+		// retried if it is cancelled before then.
 		nextState = deployment.StateBuildFailed
-		errorMessage := ErrOptimizerTimeout.Error()
-		depl.ErrorMessage = &errorMessage
 		deployJobMsg.UseRawBundle = true
 	} else {
 		return err
 	}
 
+	// Once the deployment has moved on from pending_build, we see the job
+	// through rather than cancelling it halfway.
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	if err := depl.UpdateState(db, nextState); err != nil {
 		return err
 	}
@@ -420,35 +602,375 @@ func pack(writer io.Writer, dirName, archiveFormat string) error {
 	}
 }
 
-func runOptimizer(containerName, srcDir string, domainNames []string) (output string, err error) {
-	outCh := make(chan string)
-	errCh := make(chan error)
-	cmd := OptimizerCmd(containerName, srcDir, domainNames)
+// runOptimizer optimizes the assets in srcDir in place with the given
+// optimizer (project.OptimizerDocker if empty) and the build-time environment
+// variables of the project, which only the Docker optimizer uses. It returns
+// the errors and warnings about the files it could not optimize, along with
+// the bytes saved if it is known.
+func runOptimizer(ctx context.Context, optimizerName, containerName, srcDir string, domainNames []string, envVars map[string]string) (res *optimizer.Result, err error) {
+	if optimizerName == "" {
+		optimizerName = project.OptimizerDocker
+	}
+
+	start := time.Now()
+	defer func() {
+		result := "success"
+		switch {
+		case err == ErrOptimizerTimeout:
+			result = "timeout"
+		case err != nil:
+			result = "failure"
+		}
+		optimizerDuration.ObserveSince(start, optimizerName, result)
+	}()
+
+	if optimizerName == project.OptimizerNative {
+		return runNativeOptimizer(ctx, srcDir, domainNames)
+	}
+	return runDockerOptimizer(ctx, containerName, srcDir, domainNames, envVars)
+}
+
+func runNativeOptimizer(ctx context.Context, srcDir string, domainNames []string) (*optimizer.Result, error) {
+	optCtx, cancel := context.WithTimeout(ctx, OptimizerTimeout)
+	defer cancel()
+
+	res, err := NativeOptimizer.Run(optCtx, srcDir, domainNames)
+	if err != nil {
+		if err == context.DeadlineExceeded && ctx.Err() == nil {
+			return nil, ErrOptimizerTimeout
+		}
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// runDockerOptimizer runs the optimizer in a Docker container, and parses the
+// errors and warnings out of its output. It does not report the bytes saved.
+func runDockerOptimizer(ctx context.Context, containerName, srcDir string, domainNames []string, envVars map[string]string) (*optimizer.Result, error) {
+	// Buffered so that the goroutine below does not leak when we stop waiting
+	// for it.
+	outCh := make(chan string, 1)
+	errCh := make(chan error, 1)
+
+	envFile, err := writeEnvFile(envVars)
+	if err != nil {
+		return nil, err
+	}
+	if envFile != "" {
+		defer os.Remove(envFile)
+	}
+
+	cmd := OptimizerCmd(containerName, srcDir, domainNames, envFile)
 
 	go func() {
 		out, err := cmd.CombinedOutput()
 		if err != nil {
 			combinedErr := err
-			if output != "" {
-				combinedErr = errors.New(err.Error() + ":" + output)
+			if len(out) > 0 {
+				combinedErr = errors.New(err.Error() + ":" + string(out))
 			}
 			errCh <- combinedErr
+			return
 		}
 		outCh <- string(out)
 	}()
 
 	select {
 	case output := <-outCh:
-		return output, nil
+		return &optimizer.Result{Messages: optimizer.ParseMessages(output)}, nil
 	case err := <-errCh:
-		return "", err
+		return nil, err
 	case <-time.After(OptimizerTimeout):
-		if _, err := exec.Command("docker", "rm", "-f", containerName).CombinedOutput(); err != nil {
-			if cmd.Process != nil {
-				cmd.Process.Kill()
+		stopOptimizer(cmd, containerName)
+		return nil, ErrOptimizerTimeout
+	case <-ctx.Done():
+		stopOptimizer(cmd, containerName)
+		return nil, ctx.Err()
+	}
+}
+
+// writeEnvFile writes envVars to a temporary file that only the current user
+// can read, in the format of "docker run --env-file", and returns its path. It
+// returns an empty path if there are no variables. The file is kept outside of
+// the source directory, which is mounted in the container.
+func writeEnvFile(envVars map[string]string) (string, error) {
+	if len(envVars) == 0 {
+		return "", nil
+	}
+
+	names := make([]string, 0, len(envVars))
+	for name := range envVars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		// Values are validated when they are set, but a line break would let
+		// a value set another variable.
+		if strings.ContainsAny(envVars[name], "\r\n") {
+			return "", fmt.Errorf("value of build-time environment variable %s contains a line break", name)
+		}
+		buf.WriteString(name + "=" + envVars[name] + "\n")
+	}
+
+	// ioutil.TempFile creates the file with mode 0600.
+	f, err := ioutil.TempFile("", "optimizer-env-")
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	return f.Name(), nil
+}
+
+// optimizeImages recompresses the images in srcDir, records the bytes saved in
+// report, and returns warnings about the images that could not be
+// recompressed. Running out of time is not an error, since every image that
+// has been recompressed by then is complete.
+func optimizeImages(ctx context.Context, logger *log.Entry, srcDir string, jpegQuality int, report *deployment.BuildReport) ([]*optimizer.Message, error) {
+	imgCtx, cancel := context.WithTimeout(ctx, OptimizerTimeout)
+	defer cancel()
+
+	res, err := optimizer.New(optimizer.Images(jpegQuality)).Run(imgCtx, srcDir, nil)
+	if err != nil {
+		if err == context.DeadlineExceeded && ctx.Err() == nil {
+			logger.Printf("timed out on optimizing images")
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	for _, saving := range res.Savings {
+		report.AddOptimizedFile(saving.File, saving.Stage, saving.OriginalSize, saving.Size)
+	}
+	return res.Messages, nil
+}
+
+// fingerprintAssets copies the assets in srcDir to content-hashed names,
+// rewriting the references to them, and records the copies in report so that
+// the deployer can upload them with a long-lived Cache-Control.
+func fingerprintAssets(ctx context.Context, srcDir string, report *deployment.BuildReport) error {
+	res, err := optimizer.New(optimizer.Fingerprint()).Run(ctx, srcDir, nil)
+	if err != nil {
+		return err
+	}
+
+	report.Fingerprinted = res.Copies
+	return nil
+}
+
+// minifiedExts maps the types of files that can be minified to their
+// extensions.
+var minifiedExts = map[string][]string{
+	projectconfig.TypeHTML: {".html", ".htm"},
+	projectconfig.TypeCSS:  {".css"},
+	projectconfig.TypeJS:   {".js"},
+}
+
+// removeIgnoredFiles removes the files in srcDir that cfg says are not to be
+// deployed.
+func removeIgnoredFiles(srcDir string, cfg *projectconfig.Config) error {
+	if len(cfg.Ignore) == 0 {
+		return nil
+	}
+
+	var ignored []string
+	err := filepath.Walk(srcDir, func(absPath string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(srcDir, absPath)
+		if err != nil {
+			return err
+		}
+		if cfg.Ignored(filepath.ToSlash(relPath)) {
+			ignored = append(ignored, absPath)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, p := range ignored {
+		if err := os.Remove(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readUnminifiedFiles returns the contents of the files in srcDir of the
+// types that cfg says are not to be minified, by their paths, so that they
+// can be restored after the optimizer has run.
+func readUnminifiedFiles(srcDir string, cfg *projectconfig.Config) (map[string][]byte, error) {
+	var exts []string
+	for typ, typeExts := range minifiedExts {
+		if !cfg.MinifyEnabled(typ) {
+			exts = append(exts, typeExts...)
+		}
+	}
+	if len(exts) == 0 {
+		return nil, nil
+	}
+
+	files := map[string][]byte{}
+	err := filepath.Walk(srcDir, func(absPath string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+
+		ext := strings.ToLower(filepath.Ext(absPath))
+		for _, e := range exts {
+			if ext == e {
+				b, err := ioutil.ReadFile(absPath)
+				if err != nil {
+					return err
+				}
+				files[absPath] = b
+				break
 			}
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
 
-		return "", ErrOptimizerTimeout
+// restoreFiles writes back the contents of files read by readUnminifiedFiles.
+func restoreFiles(files map[string][]byte) error {
+	for p, b := range files {
+		if err := ioutil.WriteFile(p, b, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkLinks records the links in the HTML documents in srcDir that lead
+// nowhere in report, and returns a warning about each of them.
+func checkLinks(ctx context.Context, srcDir string, cfg *projectconfig.Config, report *deployment.BuildReport) ([]*optimizer.Message, error) {
+	var redirected []string
+	for _, r := range cfg.Redirects {
+		redirected = append(redirected, r.From)
+	}
+
+	res, err := optimizer.New(optimizer.CheckLinks(redirected)).Run(ctx, srcDir, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range res.Warnings() {
+		report.AddBrokenLink(m.File, m.Line, strings.TrimPrefix(m.Text, optimizer.BrokenLinkPrefix))
+	}
+	return res.Messages, nil
+}
+
+// measureSizes records the sizes of the files in srcDir in report, and
+// returns the page weights of the HTML documents by their paths.
+func measureSizes(srcDir string, report *deployment.BuildReport) (map[string]int64, error) {
+	sizes, err := optimizer.MeasureSizes(srcDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// The originals of fingerprinted assets are only kept for references that
+	// could not be rewritten, so they are not counted twice.
+	for orig := range report.Fingerprinted {
+		delete(sizes.Files, orig)
+	}
+
+	report.SetSizes(sizes.Files, sizes.Pages, optimizer.FileType, LargestFilesReported)
+	return sizes.Pages, nil
+}
+
+// checkBudgets records the budgets of proj that the sizes in report, or the
+// given page weights, are over.
+func checkBudgets(proj *project.Project, pageWeights map[string]int64, report *deployment.BuildReport) {
+	if proj.MaxTotalSize > 0 && report.TotalSize > proj.MaxTotalSize {
+		report.AddBudgetViolation(deployment.BudgetTotalSize, "", proj.MaxTotalSize, report.TotalSize)
+	}
+
+	if size := report.SizesByType[optimizer.FileTypeJS]; proj.MaxJSSize > 0 && size > proj.MaxJSSize {
+		report.AddBudgetViolation(deployment.BudgetJSSize, "", proj.MaxJSSize, size)
+	}
+
+	if size := report.SizesByType[optimizer.FileTypeCSS]; proj.MaxCSSSize > 0 && size > proj.MaxCSSSize {
+		report.AddBudgetViolation(deployment.BudgetCSSSize, "", proj.MaxCSSSize, size)
+	}
+
+	if proj.MaxPageWeight > 0 {
+		var pages []string
+		for p, weight := range pageWeights {
+			if weight > proj.MaxPageWeight {
+				pages = append(pages, p)
+			}
+		}
+		sort.Strings(pages)
+
+		for _, p := range pages {
+			report.AddBudgetViolation(deployment.BudgetPageWeight, p, proj.MaxPageWeight, pageWeights[p])
+		}
+	}
+}
+
+// diagnostics converts messages from the optimizer to diagnostics to be
+// stored with the deployment.
+func diagnostics(msgs []*optimizer.Message) []*deployment.Diagnostic {
+	diags := make([]*deployment.Diagnostic, len(msgs))
+	for i, m := range msgs {
+		severity := deployment.SeverityWarning
+		if m.Level == optimizer.LevelError {
+			severity = deployment.SeverityError
+		}
+
+		diags[i] = &deployment.Diagnostic{
+			Severity: severity,
+			Stage:    m.Stage,
+			File:     m.File,
+			Line:     m.Line,
+			Message:  m.Text,
+		}
+	}
+	return diags
+}
+
+// errorDiagnostics returns the diagnostics with SeverityError, one per line.
+func errorDiagnostics(diags []*deployment.Diagnostic) []string {
+	var errs []string
+	for _, diag := range diags {
+		if diag.Severity == deployment.SeverityError {
+			errs = append(errs, diag.String())
+		}
+	}
+	return errs
+}
+
+func stopOptimizer(cmd *exec.Cmd, containerName string) {
+	if _, err := exec.Command("docker", "rm", "-f", containerName).CombinedOutput(); err != nil {
+		if cmd.Process != nil {
+			cmd.Process.Kill()
+		}
 	}
 }
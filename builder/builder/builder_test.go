@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
@@ -16,6 +17,7 @@ import (
 	"time"
 
 	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
 	"github.com/nitrous-io/rise-server/apiserver/dbconn"
 	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
 	"github.com/nitrous-io/rise-server/apiserver/models/project"
@@ -24,6 +26,7 @@ import (
 	"github.com/nitrous-io/rise-server/builder/builder"
 	"github.com/nitrous-io/rise-server/pkg/filetransfer"
 	"github.com/nitrous-io/rise-server/pkg/mqconn"
+	"github.com/nitrous-io/rise-server/pkg/optimizer"
 	"github.com/nitrous-io/rise-server/shared/queues"
 	"github.com/nitrous-io/rise-server/shared/s3client"
 	"github.com/nitrous-io/rise-server/testhelper"
@@ -39,6 +42,9 @@ func Test(t *testing.T) {
 	RunSpecs(t, "builder")
 }
 
+// defaultOptimizerCmd is kept before any of the tests replace it.
+var defaultOptimizerCmd = builder.OptimizerCmd
+
 var _ = Describe("Builder", func() {
 	var (
 		fakeS3 *fake.S3
@@ -449,7 +455,7 @@ var _ = Describe("Builder", func() {
 		)
 
 		BeforeEach(func() {
-			builder.OptimizerCmd = func(cn string, srcDir string, domainNames []string) *exec.Cmd {
+			builder.OptimizerCmd = func(cn string, srcDir string, domainNames []string, envFile string) *exec.Cmd {
 				containerName = cn
 				optimizerCmd = exec.Command("docker", "run", "--name", cn, "busybox", "sleep", "10")
 				return optimizerCmd
@@ -537,6 +543,523 @@ var _ = Describe("Builder", func() {
 		})
 	})
 
+	Context("when the project has build-time environment variables", func() {
+		var (
+			origAesKey       string
+			origOptimizerCmd func(string, string, []string, string) *exec.Cmd
+
+			passedSrcDir      string
+			passedEnvFile     string
+			passedEnvFileMode os.FileMode
+			passedEnvVars     string
+		)
+
+		BeforeEach(func() {
+			origAesKey = common.AesKey
+			common.AesKey = "something-something-something-32"
+
+			Expect(proj.SetBuildEnvVars(map[string]string{
+				"API_TOKEN": "s3cr3t",
+				"API_HOST":  "api.example.com",
+			}, common.AesKey)).To(Succeed())
+			Expect(db.Save(proj).Error).To(BeNil())
+
+			origOptimizerCmd = builder.OptimizerCmd
+			builder.OptimizerCmd = func(cn string, srcDir string, domainNames []string, envFile string) *exec.Cmd {
+				passedSrcDir = srcDir
+				passedEnvFile = envFile
+
+				fi, err := os.Stat(envFile)
+				Expect(err).To(BeNil())
+				passedEnvFileMode = fi.Mode()
+
+				b, err := ioutil.ReadFile(envFile)
+				Expect(err).To(BeNil())
+				passedEnvVars = string(b)
+
+				return exec.Command("true")
+			}
+
+			fakeS3.DownloadContent, err = ioutil.ReadFile("../../testhelper/fixtures/website.tar.gz")
+			Expect(err).To(BeNil())
+		})
+
+		AfterEach(func() {
+			common.AesKey = origAesKey
+			builder.OptimizerCmd = origOptimizerCmd
+		})
+
+		It("passes them to the optimizer in a private env file without deploying them", func() {
+			err = builder.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+			Expect(err).To(BeNil())
+
+			Expect(passedEnvVars).To(Equal("API_HOST=api.example.com\nAPI_TOKEN=s3cr3t\n"))
+			Expect(passedEnvFileMode.Perm()).To(Equal(os.FileMode(0600)))
+
+			// The env file is not in the directory that is mounted in the
+			// container, and is deleted once the optimizer has run.
+			Expect(passedEnvFile).NotTo(HavePrefix(passedSrcDir))
+			_, err = os.Stat(passedEnvFile)
+			Expect(os.IsNotExist(err)).To(BeTrue())
+
+			Expect(db.First(depl, depl.ID).Error).To(BeNil())
+			Expect(string(depl.JsEnvVars)).NotTo(ContainSubstring("API_TOKEN"))
+		})
+
+		It("passes their values to the container only, rather than to the Docker client", func() {
+			cmd := defaultOptimizerCmd("foo-bar-1", "/tmp/foo-bar", []string{"https://foo-bar.pubstorm.site"}, "/tmp/optimizer-env-123")
+
+			Expect(cmd.Args).To(Equal([]string{
+				"docker", "run",
+				"--name", "foo-bar-1",
+				"-v", "/tmp/foo-bar:" + builder.OptimizePath,
+				"-e", "DOMAIN_NAMES_WITH_PROTOCOL=https://foo-bar.pubstorm.site",
+				"--env-file", "/tmp/optimizer-env-123",
+				"--rm", builder.OptimizerDockerImage,
+			}))
+			Expect(cmd.Env).To(BeNil())
+		})
+
+		It("does not pass an env file to the container when there are none", func() {
+			cmd := defaultOptimizerCmd("foo-bar-1", "/tmp/foo-bar", []string{"https://foo-bar.pubstorm.site"}, "")
+
+			Expect(cmd.Args).NotTo(ContainElement("--env-file"))
+		})
+	})
+
+	Context("when the bundle has images", func() {
+		BeforeEach(func() {
+			fakeS3.DownloadContent, err = ioutil.ReadFile("../../testhelper/fixtures/website.tar.gz")
+			Expect(err).To(BeNil())
+		})
+
+		It("records the bytes saved by optimizing them in the build report", func() {
+			err = builder.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+			Expect(err).To(BeNil())
+
+			Expect(db.First(depl, depl.ID).Error).To(BeNil())
+			report, err := depl.Report()
+			Expect(err).To(BeNil())
+			Expect(report).NotTo(BeNil())
+
+			for _, f := range report.Optimized {
+				Expect(f.Stage).To(Equal("images"))
+				Expect(f.Path).To(HavePrefix("images/"))
+				Expect(f.OptimizedSize).To(BeNumerically("<", f.OriginalSize))
+				Expect(f.BytesSaved).To(Equal(f.OriginalSize - f.OptimizedSize))
+			}
+		})
+
+		Context("when the project has an image quality set", func() {
+			BeforeEach(func() {
+				proj.ImageQuality = 50
+				Expect(db.Save(proj).Error).To(BeNil())
+			})
+
+			It("re-encodes JPEG images at that quality", func() {
+				err = builder.Work([]byte(fmt.Sprintf(`{
+					"deployment_id": %d,
+					"archive_format": "tar.gz"
+				}`, depl.ID)))
+				Expect(err).To(BeNil())
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				report, err := depl.Report()
+				Expect(err).To(BeNil())
+
+				var paths []string
+				for _, f := range report.Optimized {
+					paths = append(paths, f.Path)
+				}
+				Expect(paths).To(ContainElement("images/rick-astley.jpg"))
+				Expect(report.BytesSaved()).To(BeNumerically(">", 0))
+			})
+		})
+	})
+
+	Context("when the project uses the native optimizer", func() {
+		var origOptimizerCmd func(string, string, []string, string) *exec.Cmd
+
+		BeforeEach(func() {
+			proj.Optimizer = project.OptimizerNative
+			Expect(db.Save(proj).Error).To(BeNil())
+
+			// Make sure that Docker is not used.
+			origOptimizerCmd = builder.OptimizerCmd
+			builder.OptimizerCmd = func(cn string, srcDir string, domainNames []string, envFile string) *exec.Cmd {
+				return exec.Command("false")
+			}
+		})
+
+		AfterEach(func() {
+			builder.OptimizerCmd = origOptimizerCmd
+		})
+
+		uploadedFiles := func() map[string][]byte {
+			uploadCall := fakeS3.UploadCalls.NthCall(1)
+			Expect(uploadCall).NotTo(BeNil())
+			uploadedContent, ok := uploadCall.SideEffects["uploaded_content"].([]byte)
+			Expect(ok).To(BeTrue())
+
+			gr, err := gzip.NewReader(bytes.NewBuffer(uploadedContent))
+			Expect(err).To(BeNil())
+			defer gr.Close()
+
+			files := map[string][]byte{}
+			tr := tar.NewReader(gr)
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				Expect(err).To(BeNil())
+
+				files[hdr.Name], err = ioutil.ReadAll(tr)
+				Expect(err).To(BeNil())
+			}
+			return files
+		}
+
+		// tarball returns a gzipped tarball of the given files.
+		tarball := func(files map[string]string) []byte {
+			var buf bytes.Buffer
+			gw := gzip.NewWriter(&buf)
+			tw := tar.NewWriter(gw)
+			for name, content := range files {
+				Expect(tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))})).To(Succeed())
+				_, err := tw.Write([]byte(content))
+				Expect(err).To(BeNil())
+			}
+			Expect(tw.Close()).To(Succeed())
+			Expect(gw.Close()).To(Succeed())
+			return buf.Bytes()
+		}
+
+		It("optimizes assets in-process", func() {
+			fakeS3.DownloadContent, err = ioutil.ReadFile("../../testhelper/fixtures/website.tar.gz")
+			Expect(err).To(BeNil())
+
+			err = builder.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+			Expect(err).To(BeNil())
+
+			assertUpload(1, "deployments/"+depl.PrefixID()+"/optimized-bundle.tar.gz")
+
+			files := uploadedFiles()
+
+			src, err := ioutil.ReadFile("../../testhelper/fixtures/website/js/app.js")
+			Expect(err).To(BeNil())
+			minified, err := optimizer.MinifyJS(src)
+			Expect(err).To(BeNil())
+			Expect(string(files["js/app.js"])).To(Equal(string(minified)))
+
+			Expect(string(files["sitemap.xml"])).To(ContainSubstring("<loc>https://www.foo-bar.com/sitemap/sitemap-www-foo-bar-com.xml</loc>"))
+			Expect(string(files["sitemap/sitemap-www-foo-bar-com.xml"])).To(ContainSubstring("<loc>https://www.foo-bar.com/</loc>"))
+
+			Expect(db.First(depl, depl.ID).Error).To(BeNil())
+			Expect(depl.State).To(Equal(deployment.StatePendingDeploy))
+
+			assertCleanTempFile(depl.PrefixID())
+		})
+
+		Context("when the project fingerprints assets", func() {
+			BeforeEach(func() {
+				proj.FingerprintAssets = true
+				Expect(db.Save(proj).Error).To(BeNil())
+			})
+
+			It("copies assets to content-hashed names and records them in the build report", func() {
+				fakeS3.DownloadContent, err = ioutil.ReadFile("../../testhelper/fixtures/website.tar.gz")
+				Expect(err).To(BeNil())
+
+				err = builder.Work([]byte(fmt.Sprintf(`{
+					"deployment_id": %d,
+					"archive_format": "tar.gz"
+				}`, depl.ID)))
+				Expect(err).To(BeNil())
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				report, err := depl.Report()
+				Expect(err).To(BeNil())
+				Expect(report.Fingerprinted).To(HaveLen(3))
+				Expect(report.Fingerprinted["js/app.js"]).To(MatchRegexp(`^js/app\.[0-9a-f]{6}\.js$`))
+				Expect(report.Fingerprinted["css/app.css"]).To(MatchRegexp(`^css/app\.[0-9a-f]{6}\.css$`))
+				Expect(report.Fingerprinted["images/rick-astley.jpg"]).To(MatchRegexp(`^images/rick-astley\.[0-9a-f]{6}\.jpg$`))
+
+				files := uploadedFiles()
+				Expect(files).To(HaveKey(report.Fingerprinted["js/app.js"]))
+				Expect(files["js/app.js"]).To(Equal(files[report.Fingerprinted["js/app.js"]]))
+
+				// Images that are not referenced keep their names.
+				Expect(files).To(HaveKey("images/astley.jpg"))
+
+				index := string(files["index.html"])
+				Expect(index).To(ContainSubstring(`src="` + report.Fingerprinted["js/app.js"] + `"`))
+				Expect(index).To(ContainSubstring(`href="` + report.Fingerprinted["css/app.css"] + `"`))
+				Expect(index).To(ContainSubstring(`src="` + report.Fingerprinted["images/rick-astley.jpg"] + `"`))
+			})
+		})
+
+		Context("when the bundle has a pubstorm.json", func() {
+			It("leaves out ignored files and does not minify the types of files that are turned off", func() {
+				fakeS3.DownloadContent = tarball(map[string]string{
+					"pubstorm.json": `{"ignore": ["*.map"], "optimizer": {"minify": {"js": false}}}`,
+					"index.html":    "<p>\n  Hello\n</p>\n",
+					"js/app.js":     "var a = 1;\n\nalert(a);\n",
+					"js/app.js.map": "{}",
+				})
+
+				err = builder.Work([]byte(fmt.Sprintf(`{
+					"deployment_id": %d,
+					"archive_format": "tar.gz"
+				}`, depl.ID)))
+				Expect(err).To(BeNil())
+
+				files := uploadedFiles()
+				Expect(files).NotTo(HaveKey("js/app.js.map"))
+				Expect(files).To(HaveKey("pubstorm.json"))
+				Expect(string(files["js/app.js"])).To(Equal("var a = 1;\n\nalert(a);\n"))
+				Expect(string(files["index.html"])).To(Equal("<p> Hello </p>"))
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StatePendingDeploy))
+			})
+
+			It("fails the build without deploying if the pubstorm.json is invalid", func() {
+				fakeS3.DownloadContent = tarball(map[string]string{
+					"pubstorm.json": `{"optimizer": {"image_quality": 101}}`,
+					"index.html":    "<p>Hello</p>",
+				})
+
+				err = builder.Work([]byte(fmt.Sprintf(`{
+					"deployment_id": %d,
+					"archive_format": "tar.gz"
+				}`, depl.ID)))
+				Expect(err).To(BeNil())
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StateBuildFailed))
+				Expect(*depl.ErrorMessage).To(Equal("pubstorm.json is invalid:\noptimizer.image_quality must be between 0 and 100"))
+
+				Expect(fakeS3.UploadCalls.Count()).To(Equal(0))
+				d := testhelper.ConsumeQueue(mq, queues.Deploy)
+				Expect(d).To(BeNil())
+			})
+		})
+
+		Context("when the site has broken links", func() {
+			BeforeEach(func() {
+				fakeS3.DownloadContent = tarball(map[string]string{
+					"index.html": "<a href=\"/about.html\">About</a>\n<a href=\"/docs/\">Docs</a>",
+					"about.html": "<p>About</p>",
+				})
+			})
+
+			It("records them in the build report and deploys", func() {
+				err = builder.Work([]byte(fmt.Sprintf(`{
+					"deployment_id": %d,
+					"archive_format": "tar.gz"
+				}`, depl.ID)))
+				Expect(err).To(BeNil())
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StatePendingDeploy))
+
+				report, err := depl.Report()
+				Expect(err).To(BeNil())
+				Expect(report.BrokenLinks).To(Equal([]*deployment.BrokenLink{
+					{Path: "index.html", Line: 2, Link: "/docs/"},
+				}))
+			})
+
+			Context("when the project fails builds with broken links", func() {
+				BeforeEach(func() {
+					proj.FailOnBrokenLinks = true
+					Expect(db.Save(proj).Error).To(BeNil())
+				})
+
+				It("fails the build without deploying", func() {
+					err = builder.Work([]byte(fmt.Sprintf(`{
+						"deployment_id": %d,
+						"archive_format": "tar.gz"
+					}`, depl.ID)))
+					Expect(err).To(BeNil())
+
+					Expect(db.First(depl, depl.ID).Error).To(BeNil())
+					Expect(depl.State).To(Equal(deployment.StateBuildFailed))
+					Expect(*depl.ErrorMessage).To(Equal("Broken links found:\nindex.html:2: /docs/"))
+
+					report, err := depl.Report()
+					Expect(err).To(BeNil())
+					Expect(report.BrokenLinks).To(HaveLen(1))
+
+					Expect(fakeS3.UploadCalls.Count()).To(Equal(0))
+					d := testhelper.ConsumeQueue(mq, queues.Deploy)
+					Expect(d).To(BeNil())
+				})
+			})
+		})
+
+		Context("when the project has budgets", func() {
+			BeforeEach(func() {
+				fakeS3.DownloadContent = tarball(map[string]string{
+					"index.html": `<script src="app.js"></script>`,
+					"about.html": "<p>About</p>",
+					"app.js":     "alert(1);" + strings.Repeat("\n", 100),
+				})
+
+				proj.MaxJSSize = 5
+				proj.MaxPageWeight = 20
+				Expect(db.Save(proj).Error).To(BeNil())
+			})
+
+			It("records the sizes and the budgets that are exceeded in the build report, and deploys", func() {
+				err = builder.Work([]byte(fmt.Sprintf(`{
+					"deployment_id": %d,
+					"archive_format": "tar.gz"
+				}`, depl.ID)))
+				Expect(err).To(BeNil())
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StatePendingDeploy))
+
+				report, err := depl.Report()
+				Expect(err).To(BeNil())
+				Expect(report.SizesByType["html"]).To(Equal(int64(len(`<script src="app.js"></script>`) + len("<p>About</p>"))))
+				Expect(report.SizesByType["js"]).To(Equal(int64(len("alert(1);"))))
+				Expect(report.TotalSize).To(BeNumerically(">", report.SizesByType["html"]+report.SizesByType["js"])) // sitemaps
+				Expect(report.OriginalSize).To(Equal(report.TotalSize + 100))
+				Expect(report.LargestFiles).To(ContainElement(&deployment.FileSize{Path: "app.js", Size: 9}))
+				Expect(report.HeaviestPages).To(Equal([]*deployment.FileSize{
+					{Path: "index.html", Size: 39},
+					{Path: "about.html", Size: 12},
+				}))
+
+				Expect(report.OverBudget).To(Equal([]*deployment.BudgetViolation{
+					{Budget: deployment.BudgetJSSize, Limit: 5, Size: 9},
+					{Budget: deployment.BudgetPageWeight, Path: "index.html", Limit: 20, Size: 39},
+				}))
+
+				diags, err := depl.BuildDiagnostics()
+				Expect(err).To(BeNil())
+				Expect(diags).To(HaveLen(2))
+				Expect(diags[0].Stage).To(Equal("budgets"))
+				Expect(diags[0].Severity).To(Equal(deployment.SeverityWarning))
+			})
+
+			Context("when the project fails builds that are over budget", func() {
+				BeforeEach(func() {
+					proj.FailOverBudget = true
+					Expect(db.Save(proj).Error).To(BeNil())
+				})
+
+				It("fails the build without deploying", func() {
+					err = builder.Work([]byte(fmt.Sprintf(`{
+						"deployment_id": %d,
+						"archive_format": "tar.gz"
+					}`, depl.ID)))
+					Expect(err).To(BeNil())
+
+					Expect(db.First(depl, depl.ID).Error).To(BeNil())
+					Expect(depl.State).To(Equal(deployment.StateBuildFailed))
+					Expect(*depl.ErrorMessage).To(Equal("Over budget:\n" +
+						"total size of scripts is 9 bytes, over the budget of 5 bytes\n" +
+						"page weight of index.html is 39 bytes, over the budget of 20 bytes"))
+
+					Expect(fakeS3.UploadCalls.Count()).To(Equal(0))
+					d := testhelper.ConsumeQueue(mq, queues.Deploy)
+					Expect(d).To(BeNil())
+				})
+			})
+		})
+
+		Context("when there are errors", func() {
+			BeforeEach(func() {
+				fakeS3.DownloadContent, err = ioutil.ReadFile("../../testhelper/fixtures/malformed-website.tar.gz")
+				Expect(err).To(BeNil())
+			})
+
+			It("stores the diagnostics and deploys the raw bundle", func() {
+				err = builder.Work([]byte(fmt.Sprintf(`{
+					"deployment_id": %d,
+					"archive_format": "tar.gz"
+				}`, depl.ID)))
+				Expect(err).To(BeNil())
+
+				// The partially optimized files are not uploaded.
+				Expect(fakeS3.UploadCalls.Count()).To(Equal(0))
+
+				d := testhelper.ConsumeQueue(mq, queues.Deploy)
+				Expect(d).NotTo(BeNil())
+				Expect(d.Body).To(MatchJSON(fmt.Sprintf(`{
+					"deployment_id": %d,
+					"skip_webroot_upload": false,
+					"skip_invalidation": false,
+					"use_raw_bundle": true,
+					"archive_format": "tar.gz"
+				}`, depl.ID)))
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StatePendingDeploy))
+				Expect(depl.ErrorMessage).NotTo(BeNil())
+				Expect(*depl.ErrorMessage).To(ContainSubstring("js/app.js:11: "))
+
+				diags, err := depl.BuildDiagnostics()
+				Expect(err).To(BeNil())
+
+				var jsDiag *deployment.Diagnostic
+				for _, diag := range diags {
+					if diag.File == "js/app.js" {
+						jsDiag = diag
+					}
+				}
+				Expect(jsDiag).NotTo(BeNil())
+				Expect(jsDiag.Severity).To(Equal(deployment.SeverityError))
+				Expect(jsDiag.Stage).To(Equal("js"))
+				Expect(jsDiag.Line).To(Equal(11))
+
+				assertCleanTempFile(depl.PrefixID())
+			})
+
+			Context("when the project blocks deploys on errors", func() {
+				BeforeEach(func() {
+					proj.BuildErrorPolicy = project.BuildErrorPolicyBlock
+					Expect(db.Save(proj).Error).To(BeNil())
+				})
+
+				It("fails the build without deploying", func() {
+					err = builder.Work([]byte(fmt.Sprintf(`{
+						"deployment_id": %d,
+						"archive_format": "tar.gz"
+					}`, depl.ID)))
+					Expect(err).To(BeNil())
+
+					Expect(db.First(depl, depl.ID).Error).To(BeNil())
+					Expect(depl.State).To(Equal(deployment.StateBuildFailed))
+					Expect(*depl.ErrorMessage).To(ContainSubstring("js/app.js:11: "))
+
+					diags, err := depl.BuildDiagnostics()
+					Expect(err).To(BeNil())
+					Expect(diags).NotTo(BeEmpty())
+
+					Expect(fakeS3.UploadCalls.Count()).To(Equal(0))
+					d := testhelper.ConsumeQueue(mq, queues.Deploy)
+					Expect(d).To(BeNil())
+
+					assertCleanTempFile(depl.PrefixID())
+				})
+			})
+		})
+	})
+
 	Context("when the project is locked", func() {
 		BeforeEach(func() {
 			lockedTime := time.Now().Add(-time.Minute)
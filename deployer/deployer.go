@@ -4,12 +4,14 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
-	"time"
 
+	healthctrl "github.com/nitrous-io/rise-server/apiserver/controllers/health"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
 	"github.com/nitrous-io/rise-server/deployer/deployer"
-	"github.com/nitrous-io/rise-server/pkg/mqconn"
+	"github.com/nitrous-io/rise-server/pkg/broker"
+	"github.com/nitrous-io/rise-server/pkg/health"
+	"github.com/nitrous-io/rise-server/pkg/worker"
 	"github.com/nitrous-io/rise-server/shared/queues"
-	"github.com/streadway/amqp"
 
 	log "github.com/Sirupsen/logrus"
 )
@@ -20,115 +22,45 @@ func main() {
 }
 
 func run() {
-	mq, err := mqconn.MQ()
-	if err != nil {
-		log.Errorln("Failed to connect to mq:", err)
-		return
-	}
-	connErrCh := mq.NotifyClose(make(chan *amqp.Error))
-
-	ch, err := mq.Channel()
-	if err != nil {
-		log.Errorln("Failed to obtain channel:", err)
-		return
-	}
-
-	defer func() {
-		err = ch.Close()
-		if err != nil {
-			log.Errorln("Failed to close channel:", err)
-		}
-	}()
-
-	err = ch.Qos(
-		1,     // prefetch count
-		0,     // prefetch size
-		false, // global
-	)
-
-	if err != nil {
-		log.Errorln("Failed to set qos to channel:", err)
-		return
-	}
-
 	queueName := os.Getenv("DEPLOY_QUEUE_NAME")
 	if queueName == "" {
 		queueName = queues.Deploy
 	}
 
-	q, err := ch.QueueDeclare(
-		queueName,
-		true,  // durable
-		false, // delete when unused
-		false, // exclusive
-		false, // noWait
-		nil,
-	)
-	if err != nil {
-		log.Errorf("Failed to declare queue(%s): %v", queueName, err)
-		return
-	}
-
-	msgCh, err := ch.Consume(
-		q.Name, // queue
-		"",     // consumer
-		false,  // auto-ack
-		false,  // exclusive
-		false,  // no-local
-		false,  // no-wait
-		nil,    // args
-	)
+	concurrency := worker.DefaultConcurrency
+	dbconn.SetPoolSize(concurrency)
 
+	sub, err := broker.Default.Consume(queueName, concurrency)
 	if err != nil {
-		log.Errorf("Failed to start consuming message from queue(%s): %v", q.Name, err)
+		log.Errorf("Failed to start consuming message from queue(%s): %v", queueName, err)
 		return
 	}
 
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
 
-	log.Infof("Worker started listening to queue(%s)...", q.Name)
-
-	for {
-		select {
-		case d := <-msgCh:
-			err = deployer.Work(d.Body)
-
-			if err != nil {
-				// failure
-				log.Warnln("Work failed", err, string(d.Body))
+	stop := make(chan struct{})
+	go func() {
+		sig := <-sigCh
+		log.Errorln("Caught signal:", sig)
+		close(stop)
+	}()
 
-				// It does not retry for timeout or record not found error or unarchive failed
-				// because it could retry for long time.
-				if err == deployer.ErrTimeout ||
-					err == deployer.ErrRecordNotFound ||
-					err == deployer.ErrUnarchiveFailed {
-					if err := d.Ack(false); err != nil {
-						log.WithFields(log.Fields{"queue": queueName}).Warnln("Failed to Ack message:", err)
-					}
-				} else {
-					go func() {
-						// nack after a delay to prevent thrashing
-						time.Sleep(1 * time.Second)
-						if err := d.Nack(false, true); err != nil {
-							log.WithFields(log.Fields{"queue": queueName}).Warnln("Failed to Nack message:", err)
-						}
-					}()
-				}
-			} else {
-				// success
-				if err := d.Ack(false); err != nil {
-					log.WithFields(log.Fields{"queue": queueName}).Warnln("Failed to Ack message:", err)
-				}
-			}
+	checker := health.NewChecker()
+	checker.Add("database", healthctrl.Database)
+	checker.Add("mq", healthctrl.MQ)
+	checker.Add("storage", healthctrl.Storage)
+	worker.StartHTTPServer(checker)
 
-		case err := <-connErrCh:
-			log.Errorln(err)
-			return
+	log.Infof("Worker started listening to queue(%s)...", queueName)
 
-		case sig := <-sigCh:
-			log.Errorln("Caught signal:", sig)
-			return
-		}
+	w := &worker.Worker{
+		Name:        queueName,
+		Work:        deployer.WorkContext,
+		Retryable:   deployer.Retryable,
+		Concurrency: concurrency,
+	}
+	if err := w.Run(sub, stop); err != nil {
+		log.Errorln(err)
 	}
 }
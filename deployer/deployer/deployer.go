@@ -10,7 +10,6 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"mime"
 	"os"
 	"path"
@@ -20,19 +19,23 @@ import (
 	"strings"
 	"time"
 
+	log "github.com/Sirupsen/logrus"
 	"github.com/jinzhu/gorm"
 	"github.com/nitrous-io/rise-server/apiserver/common"
 	"github.com/nitrous-io/rise-server/apiserver/dbconn"
 	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
 	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/projectrename"
 	"github.com/nitrous-io/rise-server/apiserver/models/rawbundle"
 	"github.com/nitrous-io/rise-server/apiserver/models/user"
 	"github.com/nitrous-io/rise-server/pkg/filetransfer"
+	"github.com/nitrous-io/rise-server/pkg/projectconfig"
 	"github.com/nitrous-io/rise-server/pkg/pubsub"
 	"github.com/nitrous-io/rise-server/shared/exchanges"
 	"github.com/nitrous-io/rise-server/shared/messages"
 	"github.com/nitrous-io/rise-server/shared/mimetypes"
 	"github.com/nitrous-io/rise-server/shared/s3client"
+	"golang.org/x/net/context"
 )
 
 var (
@@ -43,6 +46,26 @@ var (
 
 	MaxFileSizeToWatermark int64 = 5 * 1000 * 1000 // in bytes
 	UploadTimeout                = 3 * time.Minute
+
+	// AssetCacheControl is the Cache-Control that assets with content-hashed
+	// names are served with. They can be cached forever, since their names
+	// change whenever their contents do.
+	AssetCacheControl = "public, max-age=31536000, immutable"
+
+	// HTMLCacheControl is the Cache-Control that HTML pages of deployments
+	// with fingerprinted assets, and the files that the assets were copied
+	// from, are served with, so that browsers pick up changes to them as soon
+	// as they are deployed.
+	HTMLCacheControl = "public, max-age=0, must-revalidate"
+
+	// AcceptedProjectNames lists the only projects that can still be deployed.
+	// If it is nil, every project can be deployed.
+	AcceptedProjectNames = map[string]bool{
+		"help":          true,
+		"pubstorm-blog": true,
+		"pubstorm-www":  true,
+		"nitrous-www":   true,
+	}
 )
 
 var jsenvFormat = `(function(global, env) {
@@ -76,12 +99,31 @@ var (
 	errUnexpectedState = errors.New("deployment is in unexpected state")
 )
 
+// Retryable reports whether a deploy job that failed with err should be
+// retried. It does not retry for timeout or record not found error or
+// unarchive failed because it could retry for long time.
+func Retryable(err error) bool {
+	return err != ErrTimeout &&
+		err != ErrRecordNotFound &&
+		err != ErrUnarchiveFailed
+}
+
 func Work(data []byte) error {
+	return WorkContext(context.Background(), data)
+}
+
+// WorkContext is like Work, but stops early with ctx.Err() if ctx is cancelled
+// before the deployment goes live. The deployment is then left in the
+// pending_deploy state, and the project is unlocked, so that the job can be
+// retried.
+func WorkContext(ctx context.Context, data []byte) error {
 	d := &messages.DeployJobData{}
 	if err := json.Unmarshal(data, d); err != nil {
 		return err
 	}
 
+	logger := log.WithFields(log.Fields{"request_id": d.RequestID})
+
 	db, err := dbconn.DB()
 	if err != nil {
 		return err
@@ -114,11 +156,11 @@ func Work(data []byte) error {
 
 	defer func() {
 		if err := proj.Unlock(db); err != nil {
-			log.Printf("failed to unlock project %d due to %v", proj.ID, err)
+			logger.Printf("failed to unlock project %d due to %v", proj.ID, err)
 		}
 	}()
 
-	if proj.Name != "help" && proj.Name != "pubstorm-blog" && proj.Name != "pubstorm-www" && proj.Name != "nitrous-www" {
+	if AcceptedProjectNames != nil && !AcceptedProjectNames[proj.Name] {
 		var errorMessage = "Project deployments and new account sign ups are no longer accepted. For more information, please visit https://www.pubstorm.com/"
 		depl.ErrorMessage = &errorMessage
 		depl.UpdateState(db, deployment.StateDeployFailed)
@@ -132,6 +174,14 @@ func Work(data []byte) error {
 
 	prefixID := depl.PrefixID()
 
+	cfg := &projectconfig.Config{}
+	if d.SkipWebrootUpload && len(depl.ProjectConfig) > 0 {
+		cfg, err = projectconfig.Parse(depl.ProjectConfig)
+		if err != nil {
+			return err
+		}
+	}
+
 	if !d.SkipWebrootUpload {
 		// Disallow re-deploying a deployed project.
 		if depl.State == deployment.StateDeployed {
@@ -171,6 +221,43 @@ func Work(data []byte) error {
 			return err
 		}
 
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		cfgJSON, err := readProjectConfig(f.Name(), archiveFormat)
+		if err != nil {
+			return err
+		}
+
+		if cfgJSON != nil {
+			cfg, err = projectconfig.Parse(cfgJSON)
+			if err != nil {
+				if verr, ok := err.(*projectconfig.ValidationError); ok {
+					errorMessage := verr.Error()
+					depl.ErrorMessage = &errorMessage
+					return depl.UpdateState(db, deployment.StateBuildFailed)
+				}
+				return err
+			}
+
+			if err := depl.SaveProjectConfig(db, cfgJSON); err != nil {
+				return err
+			}
+		}
+
+		// Assets are only fingerprinted when the optimized bundle is built.
+		var fingerprinted map[string]string
+		if !d.UseRawBundle {
+			report, err := depl.Report()
+			if err != nil {
+				return err
+			}
+			if report != nil {
+				fingerprinted = report.Fingerprinted
+			}
+		}
+
 		// webroot is a publicly readable directory on S3.
 		webroot := "deployments/" + prefixID + "/webroot"
 
@@ -178,7 +265,9 @@ func Work(data []byte) error {
 		// Add @ as an exceptional
 		r := regexp.MustCompile("[^0-9A-Za-z,!_'()\\.\\*\\-@]+")
 		done := make(chan struct{})
-		errCh := make(chan error)
+		// Buffered so that the uploading goroutine does not leak when we stop
+		// waiting for it.
+		errCh := make(chan error, 1)
 		if archiveFormat == "tar.gz" {
 			go func() {
 				gr, err := gzip.NewReader(f)
@@ -203,6 +292,11 @@ func Work(data []byte) error {
 						continue
 					}
 
+					if err := ctx.Err(); err != nil {
+						errCh <- err
+						return
+					}
+
 					fileName := path.Clean(hdr.Name)
 					remotePath := webroot + "/" + fileName
 
@@ -217,7 +311,11 @@ func Work(data []byte) error {
 					}
 
 					if !isValidFileName {
-						log.Printf("filename contains invalid character: %q", fileName)
+						logger.Printf("filename contains invalid character: %q", fileName)
+						continue
+					}
+
+					if fileName == projectconfig.FileName || cfg.Ignored(fileName) {
 						continue
 					}
 
@@ -239,12 +337,12 @@ func Work(data []byte) error {
 						rdr, err = injectWatermark(rdr)
 						if err != nil {
 							// Log and skip this file.
-							log.Printf("failed to inject watermark to %q, err: %v", hdr.Name, err)
+							logger.Printf("failed to inject watermark to %q, err: %v", hdr.Name, err)
 							continue
 						}
 					}
 
-					if err := S3.Upload(s3client.BucketRegion, s3client.BucketName, remotePath, rdr, contentType, "public-read"); err != nil {
+					if err := S3.UploadWithCacheControl(s3client.BucketRegion, s3client.BucketName, remotePath, rdr, contentType, "public-read", cacheControl(fileName, contentType, fingerprinted)); err != nil {
 						errCh <- err
 						return
 					}
@@ -272,6 +370,16 @@ func Work(data []byte) error {
 					if file.FileInfo().IsDir() {
 						continue
 					}
+
+					if err := ctx.Err(); err != nil {
+						errCh <- err
+						return
+					}
+
+					if fileName := path.Clean(file.Name); fileName == projectconfig.FileName || cfg.Ignored(fileName) {
+						continue
+					}
+
 					remotePath := webroot + "/" + file.Name
 
 					contentType := mime.TypeByExtension(filepath.Ext(file.Name))
@@ -292,12 +400,12 @@ func Work(data []byte) error {
 						rdr, err = injectWatermark(rdr)
 						if err != nil {
 							// Log and skip this file.
-							log.Printf("failed to inject watermark to %q, err: %v", file.Name, err)
+							logger.Printf("failed to inject watermark to %q, err: %v", file.Name, err)
 							continue
 						}
 					}
 
-					if err := S3.Upload(s3client.BucketRegion, s3client.BucketName, remotePath, rdr, contentType, "public-read"); err != nil {
+					if err := S3.UploadWithCacheControl(s3client.BucketRegion, s3client.BucketName, remotePath, rdr, contentType, "public-read", cacheControl(path.Clean(file.Name), contentType, fingerprinted)); err != nil {
 						errCh <- err
 						return
 					}
@@ -310,6 +418,8 @@ func Work(data []byte) error {
 		case <-done:
 		case err := <-errCh:
 			return err
+		case <-ctx.Done():
+			return ctx.Err()
 		case <-time.After(UploadTimeout):
 			errorMessage := "Timed out due to too many files"
 			depl.ErrorMessage = &errorMessage
@@ -335,17 +445,30 @@ func Work(data []byte) error {
 		}
 	}
 
+	// Once the metadata files are uploaded, the deployment is live, so we see
+	// the job through rather than cancelling it halfway.
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	// the metadata file is also publicly readable, do not put sensitive data
 	metaJson, err := json.Marshal(struct {
 		Prefix            string  `json:"prefix"`
 		ForceHTTPS        bool    `json:"force_https,omitempty"`
 		BasicAuthUsername *string `json:"basic_auth_username,omitempty"`
 		BasicAuthPassword *string `json:"basic_auth_password,omitempty"`
+
+		Redirects  []*projectconfig.Redirect `json:"redirects,omitempty"`
+		Headers    []*projectconfig.Header   `json:"headers,omitempty"`
+		ErrorPages map[string]string         `json:"error_pages,omitempty"`
 	}{
 		prefixID,
 		proj.ForceHTTPS,
 		proj.BasicAuthUsername,
 		proj.EncryptedBasicAuthPassword,
+		cfg.Redirects,
+		cfg.Headers,
+		cfg.ErrorPages,
 	})
 
 	if err != nil {
@@ -366,9 +489,37 @@ func Work(data []byte) error {
 		}
 	}
 
+	formerDomainNames, err := formerDefaultDomainNames(db, proj)
+	if err != nil {
+		return err
+	}
+
+	if len(formerDomainNames) > 0 {
+		// The edge servers redirect requests for a domain whose metadata file
+		// has redirect_to to that URL, keeping the path and query of the request.
+		redirectJson, err := json.Marshal(struct {
+			RedirectTo string `json:"redirect_to"`
+		}{
+			"https://" + proj.DefaultDomainName(),
+		})
+		if err != nil {
+			return err
+		}
+
+		reader := bytes.NewReader(redirectJson)
+		for _, domain := range formerDomainNames {
+			reader.Seek(0, 0)
+			if err := S3.Upload(s3client.BucketRegion, s3client.BucketName, "domains/"+domain+"/meta.json", reader, "application/json", "public-read"); err != nil {
+				return err
+			}
+		}
+		domainNames = append(domainNames, formerDomainNames...)
+	}
+
 	if !d.SkipInvalidation {
 		m, err := pubsub.NewMessageWithJSON(exchanges.Edges, exchanges.RouteV1Invalidation, &messages.V1InvalidationMessageData{
-			Domains: domainNames,
+			Domains:   domainNames,
+			RequestID: d.RequestID,
 		})
 		if err != nil {
 			return err
@@ -393,12 +544,10 @@ func Work(data []byte) error {
 		return err
 	}
 
-	// If project has exceeded its max number of deployments (N), we soft delete
-	// deployments older than the last N deployments.
-	if proj.MaxDeploysKept > 0 {
-		if err := deployment.DeleteExceptLastN(tx, proj.ID, proj.MaxDeploysKept); err != nil {
-			return err
-		}
+	// Soft delete the deployments that the retention policy of the project no
+	// longer keeps.
+	if err := deployment.DeleteExpired(tx, proj.ID, proj.MaxDeploysKept, proj.DeployRetentionDays); err != nil {
+		return err
 	}
 
 	if err := tx.Commit().Error; err != nil {
@@ -417,11 +566,12 @@ func Work(data []byte) error {
 					"deploymentPrefix":   depl.Prefix,
 					"deploymentVersion":  depl.Version,
 					"timeTakenInSeconds": int64(timeTaken / time.Second),
+					"requestId":          d.RequestID,
 				}
 				context map[string]interface{}
 			)
 			if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
-				log.Printf("failed to track %q event for user ID %d, err: %v",
+				logger.Printf("failed to track %q event for user ID %d, err: %v",
 					event, u.ID, err)
 			}
 		}
@@ -429,3 +579,95 @@ func Work(data []byte) error {
 
 	return nil
 }
+
+// formerDefaultDomainNames returns the former default domains of the project
+// whose grace period has not ended, which redirect to its current default
+// domain. There are none if the default domain is disabled.
+func formerDefaultDomainNames(db *gorm.DB, proj *project.Project) ([]string, error) {
+	if !proj.DefaultDomainEnabled {
+		return nil, nil
+	}
+
+	renames, err := projectrename.ActiveByProjectID(db, proj.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	domainNames := []string{}
+	for _, r := range renames {
+		domainNames = append(domainNames, r.DefaultDomainName())
+	}
+	return domainNames, nil
+}
+
+// readProjectConfig returns the contents of the pubstorm.json at the root of
+// the bundle at bundlePath, or nil if there is none.
+func readProjectConfig(bundlePath, archiveFormat string) ([]byte, error) {
+	if archiveFormat == "zip" {
+		r, err := zip.OpenReader(bundlePath)
+		if err != nil {
+			return nil, ErrUnarchiveFailed
+		}
+		defer r.Close()
+
+		for _, file := range r.File {
+			if path.Clean(file.Name) != projectconfig.FileName {
+				continue
+			}
+
+			rc, err := file.Open()
+			if err != nil {
+				return nil, err
+			}
+			defer rc.Close()
+			return ioutil.ReadAll(rc)
+		}
+		return nil, nil
+	}
+
+	f, err := os.Open(bundlePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, ErrUnarchiveFailed
+	}
+	defer gr.Close()
+
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			if err == io.EOF {
+				return nil, nil
+			}
+			return nil, err
+		}
+
+		if path.Clean(hdr.Name) == projectconfig.FileName {
+			return ioutil.ReadAll(tr)
+		}
+	}
+}
+
+// cacheControl returns the Cache-Control that the file at fileName, which has
+// the given content type, should be served with, or an empty string to leave
+// it up to the edge servers. fingerprinted maps the files that were copied to
+// content-hashed names to their copies.
+func cacheControl(fileName, contentType string, fingerprinted map[string]string) string {
+	if len(fingerprinted) == 0 {
+		return ""
+	}
+	if _, ok := fingerprinted[fileName]; ok || contentType == "text/html" {
+		return HTMLCacheControl
+	}
+	for _, hashed := range fingerprinted {
+		if hashed == fileName {
+			return AssetCacheControl
+		}
+	}
+	return ""
+}
@@ -0,0 +1,426 @@
+package deployer_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/projectrename"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/deployer/deployer"
+	"github.com/nitrous-io/rise-server/pkg/filetransfer"
+	"github.com/nitrous-io/rise-server/pkg/mqconn"
+	"github.com/nitrous-io/rise-server/shared"
+	"github.com/nitrous-io/rise-server/shared/exchanges"
+	"github.com/nitrous-io/rise-server/shared/queues"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+	"github.com/nitrous-io/rise-server/testhelper/fake"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/streadway/amqp"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "deployer")
+}
+
+var _ = Describe("Deployer", func() {
+	var (
+		fakeS3                   *fake.S3
+		origS3                   filetransfer.FileTransfer
+		origAcceptedProjectNames map[string]bool
+		err                      error
+
+		db                    *gorm.DB
+		mq                    *amqp.Connection
+		invalidationQueueName string
+
+		u    *user.User
+		proj *project.Project
+		depl *deployment.Deployment
+	)
+
+	BeforeEach(func() {
+		origS3 = deployer.S3
+		fakeS3 = &fake.S3{}
+		deployer.S3 = fakeS3
+
+		origAcceptedProjectNames = deployer.AcceptedProjectNames
+		deployer.AcceptedProjectNames = nil
+
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+
+		mq, err = mqconn.MQ()
+		Expect(err).To(BeNil())
+
+		testhelper.TruncateTables(db.DB())
+		testhelper.DeleteQueue(mq, queues.All...)
+		testhelper.DeleteExchange(mq, exchanges.All...)
+
+		invalidationQueueName = testhelper.StartQueueWithExchange(mq, exchanges.Edges, exchanges.RouteV1Invalidation)
+
+		u = factories.User(db)
+		proj = factories.Project(db, u, "foo-bar")
+		depl = factories.Deployment(db, proj, u, deployment.StatePendingDeploy)
+		factories.Domain(db, proj, "www.foo-bar.com")
+	})
+
+	AfterEach(func() {
+		deployer.S3 = origS3
+		deployer.AcceptedProjectNames = origAcceptedProjectNames
+	})
+
+	// uploadedContent returns the content uploaded to the given key, or nil if
+	// nothing was uploaded to it.
+	uploadedContent := func(key string) []byte {
+		for i := 1; i <= fakeS3.UploadCalls.Count(); i++ {
+			call := fakeS3.UploadCalls.NthCall(i)
+			if call.Arguments[2] == key {
+				content, ok := call.SideEffects["uploaded_content"].([]byte)
+				Expect(ok).To(BeTrue())
+				return content
+			}
+		}
+		return nil
+	}
+
+	// uploadedCacheControl returns the Cache-Control that the file at the given
+	// key was uploaded with.
+	uploadedCacheControl := func(key string) string {
+		for i := 1; i <= fakeS3.UploadCalls.Count(); i++ {
+			call := fakeS3.UploadCalls.NthCall(i)
+			if call.Arguments[2] == key {
+				return call.Arguments[6].(string)
+			}
+		}
+		Fail("nothing was uploaded to " + key)
+		return ""
+	}
+
+	Describe("Cache-Control", func() {
+		var webroot string
+
+		BeforeEach(func() {
+			webroot = "deployments/" + depl.PrefixID() + "/webroot/"
+
+			fakeS3.DownloadContent = tarGzBundle(map[string]string{
+				"index.html":           "<html></html>",
+				"about/index.html":     "<html></html>",
+				"js/app.js":            "alert('hello');",
+				"js/app-3b18e512.js":   "alert('hello');",
+				"css/app.css":          "body {}",
+				"css/app-9e107d9d.css": "body {}",
+				"robots.txt":           "User-agent: *",
+			})
+		})
+
+		Context("when assets have been fingerprinted", func() {
+			BeforeEach(func() {
+				Expect(depl.SaveBuildReport(db, &deployment.BuildReport{
+					Fingerprinted: map[string]string{
+						"js/app.js":   "js/app-3b18e512.js",
+						"css/app.css": "css/app-9e107d9d.css",
+					},
+				})).To(Succeed())
+			})
+
+			It("lets fingerprinted assets be cached forever", func() {
+				err = deployer.Work([]byte(fmt.Sprintf(`{"deployment_id": %d}`, depl.ID)))
+				Expect(err).To(BeNil())
+
+				Expect(uploadedCacheControl(webroot + "js/app-3b18e512.js")).To(Equal(deployer.AssetCacheControl))
+				Expect(uploadedCacheControl(webroot + "css/app-9e107d9d.css")).To(Equal(deployer.AssetCacheControl))
+			})
+
+			It("makes the assets that were fingerprinted be revalidated under their original names", func() {
+				err = deployer.Work([]byte(fmt.Sprintf(`{"deployment_id": %d}`, depl.ID)))
+				Expect(err).To(BeNil())
+
+				Expect(uploadedCacheControl(webroot + "js/app.js")).To(Equal(deployer.HTMLCacheControl))
+				Expect(uploadedCacheControl(webroot + "css/app.css")).To(Equal(deployer.HTMLCacheControl))
+			})
+
+			It("makes HTML pages be revalidated so that they pick up new assets", func() {
+				err = deployer.Work([]byte(fmt.Sprintf(`{"deployment_id": %d}`, depl.ID)))
+				Expect(err).To(BeNil())
+
+				Expect(uploadedCacheControl(webroot + "index.html")).To(Equal(deployer.HTMLCacheControl))
+				Expect(uploadedCacheControl(webroot + "about/index.html")).To(Equal(deployer.HTMLCacheControl))
+			})
+
+			It("leaves the Cache-Control of other files up to the edge servers", func() {
+				err = deployer.Work([]byte(fmt.Sprintf(`{"deployment_id": %d}`, depl.ID)))
+				Expect(err).To(BeNil())
+
+				Expect(uploadedCacheControl(webroot + "robots.txt")).To(Equal(""))
+				Expect(uploadedCacheControl(webroot + "jsenv.js")).To(Equal(""))
+			})
+
+			It("does not set Cache-Control when the raw bundle is deployed, which has no fingerprinted assets", func() {
+				err = deployer.Work([]byte(fmt.Sprintf(`{
+					"deployment_id": %d,
+					"use_raw_bundle": true
+				}`, depl.ID)))
+				Expect(err).To(BeNil())
+
+				Expect(uploadedCacheControl(webroot + "js/app-3b18e512.js")).To(Equal(""))
+				Expect(uploadedCacheControl(webroot + "index.html")).To(Equal(""))
+			})
+		})
+
+		Context("when no assets have been fingerprinted", func() {
+			It("does not set Cache-Control on any file", func() {
+				err = deployer.Work([]byte(fmt.Sprintf(`{"deployment_id": %d}`, depl.ID)))
+				Expect(err).To(BeNil())
+
+				for _, name := range []string{"index.html", "about/index.html", "js/app-3b18e512.js", "css/app-9e107d9d.css", "robots.txt"} {
+					Expect(uploadedCacheControl(webroot+name)).To(Equal(""), name)
+				}
+			})
+		})
+	})
+
+	Describe("pubstorm.json", func() {
+		var (
+			webroot  string
+			files    map[string]string
+			metaJSON string
+		)
+
+		BeforeEach(func() {
+			webroot = "deployments/" + depl.PrefixID() + "/webroot/"
+
+			files = map[string]string{
+				"pubstorm.json": `{
+					"ignore": ["*.map", "drafts"],
+					"redirects": [{"from": "/old", "to": "/new"}],
+					"headers": [{"path": "/fonts/*", "headers": {"Access-Control-Allow-Origin": "*"}}],
+					"error_pages": {"404": "/404.html"}
+				}`,
+				"index.html":       "<html></html>",
+				"404.html":         "<html></html>",
+				"js/app.js":        "alert('hello');",
+				"js/app.js.map":    "{}",
+				"drafts/post.html": "<html></html>",
+			}
+
+			metaJSON = fmt.Sprintf(`{
+				"prefix": "%s",
+				"redirects": [{"from": "/old", "to": "/new"}],
+				"headers": [{"path": "/fonts/*", "headers": {"Access-Control-Allow-Origin": "*"}}],
+				"error_pages": {"404": "/404.html"}
+			}`, depl.PrefixID())
+		})
+
+		sharedExamples := func(archiveFormat string) {
+			JustBeforeEach(func() {
+				if archiveFormat == "zip" {
+					fakeS3.DownloadContent = zipBundle(files)
+				} else {
+					fakeS3.DownloadContent = tarGzBundle(files)
+				}
+			})
+
+			doWork := func() {
+				err = deployer.Work([]byte(fmt.Sprintf(`{
+					"deployment_id": %d,
+					"archive_format": "%s"
+				}`, depl.ID, archiveFormat)))
+				Expect(err).To(BeNil())
+			}
+
+			It("does not upload pubstorm.json", func() {
+				doWork()
+
+				Expect(uploadedContent(webroot + "index.html")).NotTo(BeNil())
+				Expect(uploadedContent(webroot + "pubstorm.json")).To(BeNil())
+			})
+
+			It("does not upload the files that are ignored", func() {
+				doWork()
+
+				Expect(uploadedContent(webroot + "js/app.js")).NotTo(BeNil())
+				Expect(uploadedContent(webroot + "js/app.js.map")).To(BeNil())
+				Expect(uploadedContent(webroot + "drafts/post.html")).To(BeNil())
+			})
+
+			It("saves the config with the deployment", func() {
+				doWork()
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.ProjectConfig).To(MatchJSON(files["pubstorm.json"]))
+			})
+
+			It("adds the redirects, headers and error pages to meta.json", func() {
+				doWork()
+
+				Expect(uploadedContent("domains/" + proj.DefaultDomainName() + "/meta.json")).To(MatchJSON(metaJSON))
+				Expect(uploadedContent("domains/www.foo-bar.com/meta.json")).To(MatchJSON(metaJSON))
+			})
+
+			Context("when the config is invalid", func() {
+				BeforeEach(func() {
+					files["pubstorm.json"] = `{ "redirects": [{ "from": "/old" }] }`
+				})
+
+				It("fails the build and records what is wrong with the config", func() {
+					doWork()
+
+					Expect(db.First(depl, depl.ID).Error).To(BeNil())
+					Expect(depl.State).To(Equal(deployment.StateBuildFailed))
+					Expect(depl.ErrorMessage).NotTo(BeNil())
+					Expect(*depl.ErrorMessage).To(Equal("pubstorm.json is invalid:\nredirects[0].to is required"))
+				})
+
+				It("does not upload anything", func() {
+					doWork()
+
+					Expect(fakeS3.UploadCalls.Count()).To(Equal(0))
+					Expect(testhelper.ConsumeQueue(mq, invalidationQueueName)).To(BeNil())
+				})
+			})
+		}
+
+		Context("when the bundle is a tarball", func() {
+			sharedExamples("tar.gz")
+		})
+
+		Context("when the bundle is a zip archive", func() {
+			sharedExamples("zip")
+		})
+
+		Context("when the webroot upload is skipped", func() {
+			BeforeEach(func() {
+				Expect(depl.SaveProjectConfig(db, []byte(files["pubstorm.json"]))).To(Succeed())
+				Expect(depl.UpdateState(db, deployment.StateDeployed)).To(Succeed())
+			})
+
+			It("uses the config saved with the deployment for meta.json", func() {
+				err = deployer.Work([]byte(fmt.Sprintf(`{
+					"deployment_id": %d,
+					"skip_webroot_upload": true
+				}`, depl.ID)))
+				Expect(err).To(BeNil())
+
+				Expect(fakeS3.DownloadCalls.Count()).To(Equal(0))
+				Expect(uploadedContent("domains/" + proj.DefaultDomainName() + "/meta.json")).To(MatchJSON(metaJSON))
+			})
+		})
+	})
+
+	It("tags the invalidation message with the ID of the request that caused the deployment", func() {
+		err = deployer.Work([]byte(fmt.Sprintf(`{
+			"deployment_id": %d,
+			"skip_webroot_upload": true,
+			"request_id": "foo-request-id"
+		}`, depl.ID)))
+		Expect(err).To(BeNil())
+
+		d := testhelper.ConsumeQueue(mq, invalidationQueueName)
+		Expect(d).NotTo(BeNil())
+		Expect(d.Body).To(MatchJSON(fmt.Sprintf(`{
+			"domains": ["%s", "www.foo-bar.com"],
+			"request_id": "foo-request-id"
+		}`, proj.DefaultDomainName())))
+	})
+
+	Context("when the project has been renamed recently", func() {
+		var oldDomain string
+
+		BeforeEach(func() {
+			_, err := projectrename.Create(db, proj.ID, "old-name", proj.Name)
+			Expect(err).To(BeNil())
+			oldDomain = "old-name." + shared.DefaultDomain
+
+			Expect(depl.UpdateState(db, deployment.StateDeployed)).To(Succeed())
+		})
+
+		It("redirects its former default domain to the current one", func() {
+			err = deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"skip_webroot_upload": true
+			}`, depl.ID)))
+			Expect(err).To(BeNil())
+
+			Expect(uploadedContent("domains/" + proj.DefaultDomainName() + "/meta.json")).To(MatchJSON(fmt.Sprintf(`{
+				"prefix": "%s"
+			}`, depl.PrefixID())))
+			Expect(uploadedContent("domains/" + oldDomain + "/meta.json")).To(MatchJSON(fmt.Sprintf(`{
+				"redirect_to": "https://%s"
+			}`, proj.DefaultDomainName())))
+
+			d := testhelper.ConsumeQueue(mq, invalidationQueueName)
+			Expect(d).NotTo(BeNil())
+			Expect(d.Body).To(MatchJSON(fmt.Sprintf(`{
+				"domains": ["%s", "www.foo-bar.com", "%s"]
+			}`, proj.DefaultDomainName(), oldDomain)))
+		})
+
+		It("does not redirect its former default domain once the grace period has ended", func() {
+			Expect(db.Model(projectrename.ProjectRename{}).Where("project_id = ?", proj.ID).UpdateColumn("expires_at", gorm.Expr("now() - interval '1 hour'")).Error).To(BeNil())
+
+			err = deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"skip_webroot_upload": true
+			}`, depl.ID)))
+			Expect(err).To(BeNil())
+
+			Expect(uploadedContent("domains/" + oldDomain + "/meta.json")).To(BeNil())
+		})
+
+		It("does not redirect its former default domain when its default domain is disabled", func() {
+			Expect(db.Model(proj).UpdateColumn("default_domain_enabled", false).Error).To(BeNil())
+
+			err = deployer.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"skip_webroot_upload": true
+			}`, depl.ID)))
+			Expect(err).To(BeNil())
+
+			Expect(uploadedContent("domains/" + oldDomain + "/meta.json")).To(BeNil())
+		})
+	})
+})
+
+// tarGzBundle returns a gzipped tarball that contains the given files.
+func tarGzBundle(files map[string]string) []byte {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for name, content := range files {
+		Expect(tw.WriteHeader(&tar.Header{
+			Name: name,
+			Mode: 0644,
+			Size: int64(len(content)),
+		})).To(Succeed())
+		_, err := tw.Write([]byte(content))
+		Expect(err).To(BeNil())
+	}
+	Expect(tw.Close()).To(Succeed())
+	Expect(gw.Close()).To(Succeed())
+	return buf.Bytes()
+}
+
+// zipBundle returns a zip archive that contains the given files.
+func zipBundle(files map[string]string) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		Expect(err).To(BeNil())
+		_, err = w.Write([]byte(content))
+		Expect(err).To(BeNil())
+	}
+	Expect(zw.Close()).To(Succeed())
+	return buf.Bytes()
+}
@@ -10,6 +10,7 @@ import (
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/nitrous-io/rise-server/shared/messages"
+	"golang.org/x/net/context"
 )
 
 var APIHost = "http://127.0.0.1:8081"
@@ -17,12 +18,24 @@ var APIHost = "http://127.0.0.1:8081"
 var errRequestFailed = errors.New("Unexpected error on making invalidation request")
 
 func Work(data []byte) error {
+	return WorkContext(context.Background(), data)
+}
+
+// WorkContext is like Work, but stops with ctx.Err() before invalidating the
+// next domain if ctx is cancelled.
+func WorkContext(ctx context.Context, data []byte) error {
 	j := &messages.V1InvalidationMessageData{}
 	if err := json.Unmarshal(data, j); err != nil {
 		return err
 	}
 
+	logger := log.WithFields(log.Fields{"request_id": j.RequestID})
+
 	for _, domain := range j.Domains {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
 		invalidateURL := fmt.Sprintf("%s/invalidate/%s", APIHost, domain)
 		res, err := http.PostForm(invalidateURL, url.Values{})
 		if err != nil {
@@ -36,7 +49,7 @@ func Work(data []byte) error {
 				output = string(b)
 			}
 
-			log.Errorf("Unexpected error on invalidation request: (%d) %s", res.StatusCode, output)
+			logger.Errorf("Unexpected error on invalidation request: (%d) %s", res.StatusCode, output)
 			return errRequestFailed
 		}
 	}
@@ -21,6 +21,7 @@ import (
 	"github.com/nitrous-io/rise-server/apiserver/models/cert"
 	"github.com/nitrous-io/rise-server/apiserver/models/domain"
 	"github.com/nitrous-io/rise-server/pkg/aesencrypter"
+	"github.com/nitrous-io/rise-server/pkg/metrics"
 	"github.com/nitrous-io/rise-server/pkg/pubsub"
 	"github.com/nitrous-io/rise-server/shared/exchanges"
 	"github.com/nitrous-io/rise-server/shared/messages"
@@ -41,8 +42,15 @@ var (
 	fields          = log.Fields{"job": jobName}
 	expiryThreshold = 30 * 24 * time.Hour // Renew certs that have < 30 days left
 
-	numRenewed int
-	numFailed  int
+	// pushgatewayURL is the Prometheus Pushgateway that metrics are sent to when
+	// the job completes. Metrics are not sent if it is empty.
+	pushgatewayURL = os.Getenv("PUSHGATEWAY_URL")
+
+	renewals = metrics.NewCounter(
+		"acme_renewals_total",
+		"Number of attempted ACME certificate renewals, by result.",
+		"result",
+	)
 )
 
 func main() {
@@ -85,7 +93,13 @@ func main() {
 	wg.Wait()
 
 	log.WithFields(fields).WithField("event", "completed").
-		Infof("Attempted renewal of %d ACME certificates, success: %d, failed: %d", len(acmeCerts), numRenewed, numFailed)
+		Infof("Attempted renewal of %d ACME certificates, success: %v, failed: %v", len(acmeCerts), renewals.Value("success"), renewals.Value("failure"))
+
+	if pushgatewayURL != "" {
+		if err := metrics.Push(pushgatewayURL, jobName); err != nil {
+			log.WithFields(fields).Errorf("failed to push metrics, err: %v", err)
+		}
+	}
 }
 
 // findExpiringAcmeCerts returns AcmeCerts that expire before the deadline.
@@ -118,9 +132,9 @@ func renewer(db *gorm.DB, wg *sync.WaitGroup, jobs chan *acmecert.AcmeCert) {
 
 		if err := renew(db, cert); err != nil {
 			log.WithFields(fields).Errorf("failed to renew ACME cert ID %d, err: %v", cert.ID, err)
-			numFailed++
+			renewals.Inc("failure")
 		} else {
-			numRenewed++
+			renewals.Inc("success")
 		}
 
 		wg.Done()
@@ -10,8 +10,13 @@ import (
 	"github.com/jinzhu/gorm"
 	"github.com/nitrous-io/rise-server/apiserver/dbconn"
 	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/projectrename"
 	"github.com/nitrous-io/rise-server/apiserver/models/rawbundle"
 	"github.com/nitrous-io/rise-server/pkg/filetransfer"
+	"github.com/nitrous-io/rise-server/pkg/pubsub"
+	"github.com/nitrous-io/rise-server/shared/exchanges"
+	"github.com/nitrous-io/rise-server/shared/messages"
 	"github.com/nitrous-io/rise-server/shared/s3client"
 )
 
@@ -49,6 +54,14 @@ func main() {
 		log.WithFields(fields).Fatalf("failed to initialize db, err: %v", err)
 	}
 
+	if err := applyRetentionPolicies(db); err != nil {
+		log.WithFields(fields).Fatalf("failed to apply retention policies, err: %v", err)
+	}
+
+	if err := purgeExpiredRenames(db); err != nil {
+		log.WithFields(fields).Fatalf("failed to stop redirecting expired former default domains, err: %v", err)
+	}
+
 	depls, err := findSoftDeletedDeployments(db)
 	if err != nil {
 		log.WithFields(fields).Fatalf("failed to retrieve soft deleted deployments from db, err: %v", err)
@@ -82,12 +95,79 @@ func main() {
 	log.WithFields(fields).WithField("event", "completed").Infof("Successfully purged %d deployments", len(depls))
 }
 
+// applyRetentionPolicies soft deletes the deployments that the retention
+// policies of projects no longer keep. The deployer applies them when a
+// project is deployed, but deployments also expire with time, and pins can be
+// removed in between.
+func applyRetentionPolicies(db *gorm.DB) error {
+	projs := []*project.Project{}
+	if err := db.Where("max_deploys_kept > 0 OR deploy_retention_days > 0").Find(&projs).Error; err != nil {
+		return err
+	}
+
+	for _, proj := range projs {
+		if err := deployment.DeleteExpired(db, proj.ID, proj.MaxDeploysKept, proj.DeployRetentionDays); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// purgeExpiredRenames stops redirecting the former default domains of renamed
+// projects whose grace period has ended, unless another project has taken the
+// name since, and deletes the renames.
+func purgeExpiredRenames(db *gorm.DB) error {
+	renames := []*projectrename.ProjectRename{}
+	if err := db.Where("expires_at <= ?", time.Now()).Find(&renames).Error; err != nil {
+		return err
+	}
+
+	for _, r := range renames {
+		proj, err := project.FindByName(db, r.OldName)
+		if err != nil {
+			return err
+		}
+
+		if proj == nil {
+			domainName := r.DefaultDomainName()
+			if err := S3.Delete(s3client.BucketRegion, s3client.BucketName, "domains/"+domainName+"/meta.json"); err != nil {
+				return err
+			}
+
+			m, err := pubsub.NewMessageWithJSON(exchanges.Edges, exchanges.RouteV1Invalidation, &messages.V1InvalidationMessageData{
+				Domains: []string{domainName},
+			})
+			if err != nil {
+				return err
+			}
+
+			if err := m.Publish(); err != nil {
+				return err
+			}
+		}
+
+		if err := db.Delete(r).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// findSoftDeletedDeployments returns the deleted deployments that have not
+// been purged yet, except pinned ones and those of deleted projects, which
+// are kept until the projects are purged in case they are restored.
 func findSoftDeletedDeployments(db *gorm.DB) ([]*deployment.Deployment, error) {
 	depls := []*deployment.Deployment{}
 	err := db.Unscoped().
-		Where("deleted_at IS NOT NULL").
-		Where("purged_at IS NULL").
-		Where("state = ?", deployment.StateDeployed).
+		Select("deployments.*").
+		Joins("JOIN projects ON projects.id = deployments.project_id").
+		Where("deployments.deleted_at IS NOT NULL").
+		Where("deployments.purged_at IS NULL").
+		Where("deployments.state = ?", deployment.StateDeployed).
+		Where("deployments.pinned_label IS NULL").
+		Where("projects.deleted_at IS NULL").
 		Find(&depls).Error
 	if err != nil {
 		return nil, err
@@ -8,14 +8,18 @@ import (
 	"github.com/nitrous-io/rise-server/apiserver/dbconn"
 	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
 	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/projectrename"
 	"github.com/nitrous-io/rise-server/apiserver/models/user"
 	"github.com/nitrous-io/rise-server/pkg/filetransfer"
+	"github.com/nitrous-io/rise-server/pkg/mqconn"
+	"github.com/nitrous-io/rise-server/shared/exchanges"
 	"github.com/nitrous-io/rise-server/shared/s3client"
 	"github.com/nitrous-io/rise-server/testhelper"
 	"github.com/nitrous-io/rise-server/testhelper/factories"
 	"github.com/nitrous-io/rise-server/testhelper/fake"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/streadway/amqp"
 )
 
 func Test(t *testing.T) {
@@ -100,6 +104,129 @@ var _ = Describe("purgedeploys", func() {
 			}
 			Expect(ids).To(ConsistOf(depl2.ID, depl4.ID))
 		})
+
+		It("does not return pinned deployments", func() {
+			Expect(db.Unscoped().Model(depl2).UpdateColumn("pinned_label", "launch").Error).To(BeNil())
+
+			depls, err := findSoftDeletedDeployments(db)
+			Expect(err).To(BeNil())
+
+			Expect(depls).To(HaveLen(1))
+			Expect(depls[0].ID).To(Equal(depl4.ID))
+		})
+
+		It("does not return the deployments of deleted projects", func() {
+			proj := &project.Project{}
+			Expect(db.First(proj, depl4.ProjectID).Error).To(BeNil())
+			Expect(proj.Destroy(db)).To(BeNil())
+
+			depls, err := findSoftDeletedDeployments(db)
+			Expect(err).To(BeNil())
+
+			Expect(depls).To(HaveLen(1))
+			Expect(depls[0].ID).To(Equal(depl2.ID))
+		})
+	})
+
+	Describe("applyRetentionPolicies()", func() {
+		It("deletes the deployments that the retention policies of projects no longer keep", func() {
+			proj := factories.Project(db, u)
+			proj.DeployRetentionDays = 7
+			Expect(db.Save(proj).Error).To(BeNil())
+
+			deployedAt := time.Now().Add(-8 * 24 * time.Hour)
+			oldDepl := factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				State:      deployment.StateDeployed,
+				DeployedAt: &deployedAt,
+			})
+			pinnedDepl := factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				State:      deployment.StateDeployed,
+				DeployedAt: &deployedAt,
+			})
+			Expect(pinnedDepl.Pin(db, "launch")).To(Succeed())
+			newDepl := factories.Deployment(db, proj, u, deployment.StateDeployed)
+
+			// A project without a retention policy.
+			otherDepl := factories.DeploymentWithAttrs(db, nil, u, deployment.Deployment{
+				State:      deployment.StateDeployed,
+				DeployedAt: &deployedAt,
+			})
+
+			Expect(applyRetentionPolicies(db)).To(Succeed())
+
+			Expect(db.First(oldDepl, oldDepl.ID).Error).To(Equal(gorm.RecordNotFound))
+			Expect(db.First(pinnedDepl, pinnedDepl.ID).Error).To(BeNil())
+			Expect(db.First(newDepl, newDepl.ID).Error).To(BeNil())
+			Expect(db.First(otherDepl, otherDepl.ID).Error).To(BeNil())
+		})
+	})
+
+	Describe("purgeExpiredRenames()", func() {
+		var (
+			mq                    *amqp.Connection
+			invalidationQueueName string
+
+			expired, active *projectrename.ProjectRename
+		)
+
+		BeforeEach(func() {
+			mq, err = mqconn.MQ()
+			Expect(err).To(BeNil())
+
+			testhelper.DeleteExchange(mq, exchanges.All...)
+			invalidationQueueName = testhelper.StartQueueWithExchange(mq, exchanges.Edges, exchanges.RouteV1Invalidation)
+
+			expired, err = projectrename.Create(db, proj1.ID, "old-name", proj1.Name)
+			Expect(err).To(BeNil())
+			Expect(db.Model(expired).UpdateColumn("expires_at", time.Now().Add(-time.Hour)).Error).To(BeNil())
+
+			active, err = projectrename.Create(db, proj1.ID, "recent-name", proj1.Name)
+			Expect(err).To(BeNil())
+		})
+
+		It("stops redirecting the former default domains whose grace period has ended", func() {
+			Expect(purgeExpiredRenames(db)).To(Succeed())
+
+			Expect(fakeS3.DeleteCalls.Count()).To(Equal(1))
+			deleteCall := fakeS3.DeleteCalls.NthCall(1)
+			Expect(deleteCall).NotTo(BeNil())
+			Expect(deleteCall.Arguments[0]).To(Equal(s3client.BucketRegion))
+			Expect(deleteCall.Arguments[1]).To(Equal(s3client.BucketName))
+			Expect(deleteCall.Arguments[2:]).To(Equal(fake.List{"domains/" + expired.DefaultDomainName() + "/meta.json"}))
+
+			d := testhelper.ConsumeQueue(mq, invalidationQueueName)
+			Expect(d).NotTo(BeNil())
+			Expect(d.Body).To(MatchJSON(`{
+				"domains": ["` + expired.DefaultDomainName() + `"]
+			}`))
+		})
+
+		It("deletes the expired renames only", func() {
+			Expect(purgeExpiredRenames(db)).To(Succeed())
+
+			var count int
+			Expect(db.Model(projectrename.ProjectRename{}).Where("id = ?", expired.ID).Count(&count).Error).To(BeNil())
+			Expect(count).To(Equal(0))
+
+			Expect(db.Model(projectrename.ProjectRename{}).Where("id = ?", active.ID).Count(&count).Error).To(BeNil())
+			Expect(count).To(Equal(1))
+		})
+
+		Context("when another project has taken the old name", func() {
+			BeforeEach(func() {
+				factories.Project(db, u, "old-name")
+			})
+
+			It("leaves the meta.json of its default domain alone", func() {
+				Expect(purgeExpiredRenames(db)).To(Succeed())
+
+				Expect(fakeS3.DeleteCalls.Count()).To(Equal(0))
+
+				var count int
+				Expect(db.Model(projectrename.ProjectRename{}).Where("id = ?", expired.ID).Count(&count).Error).To(BeNil())
+				Expect(count).To(Equal(0))
+			})
+		})
 	})
 
 	Describe("purge()", func() {
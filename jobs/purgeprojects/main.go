@@ -0,0 +1,150 @@
+package main
+
+import (
+	"os"
+	"os/user"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/domain"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/rawbundle"
+	"github.com/nitrous-io/rise-server/pkg/filetransfer"
+	"github.com/nitrous-io/rise-server/shared/s3client"
+)
+
+const jobName = "purge-deleted-projects"
+
+var fields = log.Fields{"job": jobName}
+
+var (
+	S3 filetransfer.FileTransfer = filetransfer.NewS3(s3client.PartSize, s3client.MaxUploadParts)
+)
+
+func init() {
+	riseEnv := os.Getenv("RISE_ENV")
+	if riseEnv == "" {
+		riseEnv = "development"
+		os.Setenv("RISE_ENV", riseEnv)
+	}
+
+	if riseEnv != "test" {
+		if os.Getenv("AWS_ACCESS_KEY_ID") == "" || os.Getenv("AWS_SECRET_ACCESS_KEY") == "" {
+			log.Fatal("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables are required!")
+		}
+	}
+}
+
+func main() {
+	if u, err := user.Current(); err == nil {
+		fields["user"] = u.Username
+	}
+	log.WithFields(fields).WithField("event", "start").
+		Infof("Purging projects deleted more than %s ago...", project.RestoreWindow)
+
+	db, err := dbconn.DB()
+	if err != nil {
+		log.WithFields(fields).Fatalf("failed to initialize db, err: %v", err)
+	}
+
+	projs, err := findExpiredProjects(db)
+	if err != nil {
+		log.WithFields(fields).Fatalf("failed to retrieve deleted projects from db, err: %v", err)
+	}
+	if len(projs) == 0 {
+		log.WithFields(fields).WithField("event", "completed").Infof("No projects to purge, exiting")
+		os.Exit(0)
+	}
+
+	log.WithFields(fields).Infof("Found %d projects to purge", len(projs))
+
+	var nPurged int
+	for i, proj := range projs {
+		log.WithFields(fields).Infof("[%d/%d] Purging project %d (%s)", i+1, len(projs), proj.ID, proj.Name)
+
+		if err := purge(db, proj); err != nil {
+			log.WithFields(fields).Errorf("failed to purge project %d, err: %v", proj.ID, err)
+			continue
+		}
+		nPurged++
+	}
+
+	log.WithFields(fields).WithField("event", "completed").Infof("Successfully purged %d projects", nPurged)
+}
+
+// findExpiredProjects returns the deleted projects that can no longer be
+// restored.
+func findExpiredProjects(db *gorm.DB) ([]*project.Project, error) {
+	projs := []*project.Project{}
+	err := db.Unscoped().
+		Where("deleted_at IS NOT NULL").
+		Where("deleted_at <= ?", time.Now().Add(-project.RestoreWindow)).
+		Order("deleted_at ASC").
+		Find(&projs).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return projs, nil
+}
+
+// purge deletes the files of a deleted project from S3, then deletes the
+// project and everything that belongs to it from the database.
+func purge(db *gorm.DB, proj *project.Project) error {
+	var filesToDelete []string
+
+	// Delete the certs of the domains that were deleted along with the
+	// project, unless another project has added the domain since.
+	doms := []*domain.Domain{}
+	if err := db.Unscoped().Where("project_id = ? AND deleted_at = ?", proj.ID, proj.DeletedAt).Find(&doms).Error; err != nil {
+		return err
+	}
+	for _, dom := range doms {
+		var count int
+		if err := db.Model(domain.Domain{}).Where("name = ?", dom.Name).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			filesToDelete = append(filesToDelete, "certs/"+dom.Name+"/ssl.crt", "certs/"+dom.Name+"/ssl.key")
+		}
+	}
+
+	bundles := []*rawbundle.RawBundle{}
+	if err := db.Unscoped().Where("project_id = ?", proj.ID).Find(&bundles).Error; err != nil {
+		return err
+	}
+	for _, bun := range bundles {
+		filesToDelete = append(filesToDelete, bun.UploadedPath)
+	}
+
+	if len(filesToDelete) > 0 {
+		if err := S3.Delete(s3client.BucketRegion, s3client.BucketName, filesToDelete...); err != nil {
+			return err
+		}
+	}
+
+	depls := []*deployment.Deployment{}
+	if err := db.Unscoped().Where("project_id = ? AND purged_at IS NULL", proj.ID).Find(&depls).Error; err != nil {
+		return err
+	}
+	for _, depl := range depls {
+		if err := S3.DeleteAll(s3client.BucketRegion, s3client.BucketName, "deployments/"+depl.PrefixID()); err != nil {
+			return err
+		}
+	}
+
+	tx := db.Begin()
+	if err := tx.Error; err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := proj.Purge(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit().Error
+}
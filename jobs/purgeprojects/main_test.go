@@ -0,0 +1,205 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/domain"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/rawbundle"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/pkg/filetransfer"
+	"github.com/nitrous-io/rise-server/shared/s3client"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+	"github.com/nitrous-io/rise-server/testhelper/fake"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "purgeprojects")
+}
+
+var _ = Describe("purgeprojects", func() {
+	var (
+		fakeS3 *fake.S3
+		origS3 filetransfer.FileTransfer
+		err    error
+
+		db *gorm.DB
+
+		u                   *user.User
+		proj1, proj2, proj3 *project.Project
+	)
+
+	deleteProject := func(proj *project.Project, deletedAt time.Time) {
+		Expect(proj.Destroy(db)).To(BeNil())
+		for _, table := range []string{"projects", "domains", "deployments", "raw_bundles"} {
+			col := "project_id"
+			if table == "projects" {
+				col = "id"
+			}
+			err := db.Exec("UPDATE "+table+" SET deleted_at = ? WHERE "+col+" = ?", deletedAt, proj.ID).Error
+			Expect(err).To(BeNil())
+		}
+		proj.DeletedAt = &deletedAt
+	}
+
+	BeforeEach(func() {
+		origS3 = S3
+		fakeS3 = &fake.S3{}
+		S3 = fakeS3
+
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+
+		testhelper.TruncateTables(db.DB())
+
+		u = factories.User(db)
+
+		// Live project.
+		proj1 = factories.Project(db, u)
+
+		// Project deleted within the restore window.
+		proj2 = factories.Project(db, u)
+		deleteProject(proj2, time.Now().Add(-project.RestoreWindow+time.Hour))
+
+		// Project deleted before the restore window.
+		proj3 = factories.Project(db, u)
+		deleteProject(proj3, time.Now().Add(-project.RestoreWindow-time.Hour))
+	})
+
+	AfterEach(func() {
+		S3 = origS3
+	})
+
+	Describe("findExpiredProjects()", func() {
+		It("returns deleted projects that can no longer be restored", func() {
+			projs, err := findExpiredProjects(db)
+			Expect(err).To(BeNil())
+
+			Expect(projs).To(HaveLen(1))
+			Expect(projs[0].ID).To(Equal(proj3.ID))
+		})
+	})
+
+	Describe("purge()", func() {
+		var (
+			proj  *project.Project
+			dom1  *domain.Domain
+			dom2  *domain.Domain
+			bun   *rawbundle.RawBundle
+			depl1 *deployment.Deployment
+			depl2 *deployment.Deployment
+		)
+
+		BeforeEach(func() {
+			proj = factories.Project(db, u)
+			dom1 = factories.Domain(db, proj, "www.foo-bar-express.com")
+			dom2 = factories.Domain(db, proj, "www.baz-qux-express.com")
+			bun = factories.RawBundle(db, proj)
+
+			depl1 = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				Prefix:      "a1b2c3",
+				State:       deployment.StateDeployed,
+				RawBundleID: &bun.ID,
+			})
+			Expect(db.Model(proj).UpdateColumn("active_deployment_id", depl1.ID).Error).To(BeNil())
+
+			depl2 = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				Prefix: "d4e5f6",
+				State:  deployment.StateDeployed,
+			})
+
+			deleteProject(proj, time.Now().Add(-project.RestoreWindow-time.Hour))
+
+			// Another project has taken one of the domains since.
+			factories.Domain(db, proj1, dom2.Name)
+		})
+
+		It("deletes the project's files from S3", func() {
+			err := purge(db, proj)
+			Expect(err).To(BeNil())
+
+			Expect(fakeS3.DeleteCalls.Count()).To(Equal(1))
+			deleteCall := fakeS3.DeleteCalls.NthCall(1)
+			Expect(deleteCall).NotTo(BeNil())
+			Expect(deleteCall.Arguments[0]).To(Equal(s3client.BucketRegion))
+			Expect(deleteCall.Arguments[1]).To(Equal(s3client.BucketName))
+			Expect(deleteCall.Arguments[2:]).To(ConsistOf(
+				"certs/www.foo-bar-express.com/ssl.crt",
+				"certs/www.foo-bar-express.com/ssl.key",
+				bun.UploadedPath,
+			))
+
+			Expect(fakeS3.DeleteAllCalls.Count()).To(Equal(2))
+			prefixes := []interface{}{
+				fakeS3.DeleteAllCalls.NthCall(1).Arguments[2],
+				fakeS3.DeleteAllCalls.NthCall(2).Arguments[2],
+			}
+			Expect(prefixes).To(ConsistOf(
+				"deployments/"+depl1.PrefixID(),
+				"deployments/"+depl2.PrefixID(),
+			))
+		})
+
+		It("does not delete the files of deployments that have already been purged", func() {
+			err := db.Unscoped().Model(depl2).UpdateColumn("purged_at", time.Now()).Error
+			Expect(err).To(BeNil())
+
+			err = purge(db, proj)
+			Expect(err).To(BeNil())
+
+			Expect(fakeS3.DeleteAllCalls.Count()).To(Equal(1))
+			Expect(fakeS3.DeleteAllCalls.NthCall(1).Arguments[2]).To(Equal("deployments/" + depl1.PrefixID()))
+		})
+
+		It("deletes the project and everything that belongs to it from the database", func() {
+			err := purge(db, proj)
+			Expect(err).To(BeNil())
+
+			var count int
+			Expect(db.Unscoped().Model(project.Project{}).Where("id = ?", proj.ID).Count(&count).Error).To(BeNil())
+			Expect(count).To(Equal(0))
+
+			Expect(db.Unscoped().Model(domain.Domain{}).Where("project_id = ?", proj.ID).Count(&count).Error).To(BeNil())
+			Expect(count).To(Equal(0))
+
+			Expect(db.Unscoped().Model(deployment.Deployment{}).Where("project_id = ?", proj.ID).Count(&count).Error).To(BeNil())
+			Expect(count).To(Equal(0))
+
+			Expect(db.Unscoped().Model(rawbundle.RawBundle{}).Where("project_id = ?", proj.ID).Count(&count).Error).To(BeNil())
+			Expect(count).To(Equal(0))
+
+			// The domain taken by another project is left alone.
+			Expect(db.Model(domain.Domain{}).Where("name = ?", dom2.Name).Count(&count).Error).To(BeNil())
+			Expect(count).To(Equal(1))
+		})
+
+		It("releases the name and domains of the project", func() {
+			taken, err := project.IsNameOfDeletedProject(db, proj.Name)
+			Expect(err).To(BeNil())
+			Expect(taken).To(BeTrue())
+
+			taken, err = project.IsDomainOfDeletedProject(db, dom1.Name)
+			Expect(err).To(BeNil())
+			Expect(taken).To(BeTrue())
+
+			err = purge(db, proj)
+			Expect(err).To(BeNil())
+
+			taken, err = project.IsNameOfDeletedProject(db, proj.Name)
+			Expect(err).To(BeNil())
+			Expect(taken).To(BeFalse())
+
+			taken, err = project.IsDomainOfDeletedProject(db, dom1.Name)
+			Expect(err).To(BeNil())
+			Expect(taken).To(BeFalse())
+		})
+	})
+})
@@ -0,0 +1,333 @@
+package broker
+
+import (
+	"sync"
+	"time"
+
+	"github.com/nitrous-io/rise-server/pkg/mqconn"
+	"github.com/streadway/amqp"
+)
+
+// AMQP is a Broker backed by RabbitMQ. It uses the connection returned by
+// mqconn.MQ().
+type AMQP struct{}
+
+func NewAMQP() *AMQP {
+	return &AMQP{}
+}
+
+func (b *AMQP) Enqueue(queueName string, data []byte) error {
+	mq, err := mqconn.MQ()
+	if err != nil {
+		return err
+	}
+
+	ch, err := mq.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	q, err := declareQueue(ch, queueName)
+	if err != nil {
+		return err
+	}
+
+	return ch.Publish(
+		"",     // exchange
+		q.Name, // routing key
+		false,  // mandatory
+		false,  // immediate
+		amqp.Publishing{
+			DeliveryMode: amqp.Persistent,
+			ContentType:  "text/plain",
+			Body:         data,
+			Timestamp:    time.Now(),
+		},
+	)
+}
+
+func (b *AMQP) Publish(exchangeName, route string, data []byte) error {
+	mq, err := mqconn.MQ()
+	if err != nil {
+		return err
+	}
+
+	ch, err := mq.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	// This is to make sure the exchange exists
+	if err := declareExchange(ch, exchangeName); err != nil {
+		return err
+	}
+
+	return ch.Publish(
+		exchangeName, // exchange
+		route,        // routing key
+		false,        // mandatory
+		false,        // immediate
+		amqp.Publishing{
+			DeliveryMode: amqp.Persistent,
+			ContentType:  "text/plain",
+			Body:         data,
+			Timestamp:    time.Now(),
+		},
+	)
+}
+
+func (b *AMQP) Consume(queueName string, prefetch int) (Subscription, error) {
+	mq, ch, err := openChannel(prefetch)
+	if err != nil {
+		return nil, err
+	}
+
+	q, err := declareQueue(ch, queueName)
+	if err != nil {
+		ch.Close()
+		return nil, err
+	}
+
+	return newAMQPSubscription(mq, ch, q.Name, nil)
+}
+
+func (b *AMQP) Subscribe(exchangeName, route string, prefetch int) (Subscription, error) {
+	mq, ch, err := openChannel(prefetch)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := declareExchange(ch, exchangeName); err != nil {
+		ch.Close()
+		return nil, err
+	}
+
+	q, err := ch.QueueDeclare(
+		"",    // name
+		true,  // durable
+		true,  // delete when usused
+		false, // exclusive. This should be false to make connection persistent
+		false, // no-wait
+		nil,   // arguments
+	)
+	if err != nil {
+		ch.Close()
+		return nil, err
+	}
+
+	if err := ch.QueueBind(
+		q.Name,       // queue name
+		route,        // routing key
+		exchangeName, // exchange
+		false,
+		nil,
+	); err != nil {
+		ch.Close()
+		return nil, err
+	}
+
+	unbind := func() error {
+		return ch.QueueUnbind(
+			q.Name,       // queue name
+			route,        // routing key
+			exchangeName, // exchange
+			nil,
+		)
+	}
+
+	return newAMQPSubscription(mq, ch, q.Name, unbind)
+}
+
+// openChannel opens a channel to consume from with the given prefetch count.
+func openChannel(prefetch int) (*amqp.Connection, *amqp.Channel, error) {
+	mq, err := mqconn.MQ()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ch, err := mq.Channel()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if prefetch > 0 {
+		if err := ch.Qos(
+			prefetch, // prefetch count
+			0,        // prefetch size
+			false,    // global
+		); err != nil {
+			ch.Close()
+			return nil, nil, err
+		}
+	}
+
+	return mq, ch, nil
+}
+
+func declareQueue(ch *amqp.Channel, queueName string) (amqp.Queue, error) {
+	return ch.QueueDeclare(
+		queueName,
+		true,  // durable
+		false, // delete when unused
+		false, // exclusive
+		false, // noWait
+		nil,
+	)
+}
+
+func declareExchange(ch *amqp.Channel, exchangeName string) error {
+	return ch.ExchangeDeclare(
+		exchangeName, // name
+		"direct",     // type
+		true,         // durable
+		false,        // auto-deleted
+		false,        // internal
+		false,        // no-wait
+		nil,          // arguments
+	)
+}
+
+// consumerTag identifies the consumer on its channel. Every subscription has
+// its own channel, so it does not need to be unique.
+const consumerTag = "rise-consumer"
+
+type amqpSubscription struct {
+	ch     *amqp.Channel
+	unbind func() error
+
+	deliveries chan *Delivery
+	errCh      chan error
+	stop       chan struct{}
+	wg         sync.WaitGroup // tracks the goroutine that forwards deliveries
+
+	mu          sync.Mutex
+	closed      bool
+	outstanding int
+}
+
+func newAMQPSubscription(mq *amqp.Connection, ch *amqp.Channel, queueName string, unbind func() error) (*amqpSubscription, error) {
+	s := &amqpSubscription{
+		ch:     ch,
+		unbind: unbind,
+
+		deliveries: make(chan *Delivery),
+		errCh:      make(chan error, 1),
+		stop:       make(chan struct{}),
+	}
+
+	msgCh, err := ch.Consume(
+		queueName,   // queue
+		consumerTag, // consumer
+		false,       // auto-ack
+		false,       // exclusive
+		false,       // no-local
+		false,       // no-wait
+		nil,         // args
+	)
+	if err != nil {
+		if unbind != nil {
+			unbind()
+		}
+		ch.Close()
+		return nil, err
+	}
+
+	connErrCh := mq.NotifyClose(make(chan *amqp.Error, 1))
+	go func() {
+		if err, ok := <-connErrCh; ok && err != nil {
+			s.errCh <- err
+		}
+	}()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer close(s.deliveries)
+		for d := range msgCh {
+			s.mu.Lock()
+			s.outstanding++
+			s.mu.Unlock()
+
+			select {
+			case s.deliveries <- &Delivery{Body: d.Body, acker: &amqpAcker{d: d, sub: s}}:
+			case <-s.stop:
+				// Nobody is listening anymore, put the message back.
+				d.Nack(false, true)
+				s.settle()
+			}
+		}
+	}()
+
+	return s, nil
+}
+
+func (s *amqpSubscription) Deliveries() <-chan *Delivery {
+	return s.deliveries
+}
+
+func (s *amqpSubscription) Err() <-chan error {
+	return s.errCh
+}
+
+func (s *amqpSubscription) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	close(s.stop)
+
+	if err := s.ch.Cancel(consumerTag, false); err != nil {
+		return err
+	}
+
+	if s.unbind != nil {
+		if err := s.unbind(); err != nil {
+			return err
+		}
+	}
+
+	// Cancelling the consumer closes msgCh. Wait for the messages that were
+	// still buffered in it to be nacked before deciding whether the channel
+	// can be closed, otherwise they would be nacked on a closed channel.
+	s.wg.Wait()
+
+	// The channel is kept open until every delivered message has been acked or
+	// nacked, since acknowledgements have to go through the same channel.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.outstanding == 0 {
+		return s.ch.Close()
+	}
+	return nil
+}
+
+// settle is called once a delivered message has been acked or nacked.
+func (s *amqpSubscription) settle() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.outstanding--
+	if s.closed && s.outstanding == 0 {
+		s.ch.Close()
+	}
+}
+
+type amqpAcker struct {
+	d   amqp.Delivery
+	sub *amqpSubscription
+}
+
+func (a *amqpAcker) ack() error {
+	defer a.sub.settle()
+	return a.d.Ack(false)
+}
+
+func (a *amqpAcker) nack(requeue bool) error {
+	defer a.sub.settle()
+	return a.d.Nack(false, requeue)
+}
@@ -0,0 +1,73 @@
+package broker
+
+import "errors"
+
+// Errors returned from this package.
+var (
+	ErrClosed = errors.New("broker is closed")
+)
+
+// Default is the broker used by the job and pubsub packages. It can be
+// replaced with an in-memory broker (see NewMemory) so that the whole pipeline
+// can run inside a single process.
+var Default Broker = NewAMQP()
+
+// Broker publishes messages to queues and exchanges, and consumes them.
+type Broker interface {
+	// Enqueue publishes a persistent message to the named (durable) queue.
+	Enqueue(queueName string, data []byte) error
+
+	// Publish publishes a message to the named direct exchange with the given
+	// routing key. Messages published to a route with no subscribers are
+	// dropped.
+	Publish(exchangeName, route string, data []byte) error
+
+	// Consume starts consuming messages from the named (durable) queue. At most
+	// prefetch unacknowledged messages are delivered at a time; a prefetch of 0
+	// means no limit.
+	Consume(queueName string, prefetch int) (Subscription, error)
+
+	// Subscribe binds a new anonymous queue to the given exchange and route and
+	// starts consuming messages from it. prefetch works like it does for
+	// Consume.
+	Subscribe(exchangeName, route string, prefetch int) (Subscription, error)
+}
+
+// Subscription is a stream of messages from a queue.
+type Subscription interface {
+	// Deliveries returns the channel on which messages are delivered. It is
+	// closed when the subscription is closed or the connection to the broker
+	// is lost.
+	Deliveries() <-chan *Delivery
+
+	// Err returns a channel that receives an error when the connection to the
+	// broker is lost.
+	Err() <-chan error
+
+	// Close stops consuming messages. Messages that have been delivered but not
+	// yet acknowledged can still be acked or nacked after Close returns.
+	Close() error
+}
+
+// Delivery is a message received from a Subscription.
+type Delivery struct {
+	Body []byte
+
+	acker acknowledger
+}
+
+type acknowledger interface {
+	ack() error
+	nack(requeue bool) error
+}
+
+// Ack acknowledges that the message has been processed.
+func (d *Delivery) Ack() error {
+	return d.acker.ack()
+}
+
+// Nack rejects the message. If requeue is true, the message is put back on the
+// queue to be redelivered.
+func (d *Delivery) Nack(requeue bool) error {
+	return d.acker.nack(requeue)
+}
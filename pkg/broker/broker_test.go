@@ -0,0 +1,279 @@
+package broker_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nitrous-io/rise-server/pkg/broker"
+	"github.com/nitrous-io/rise-server/pkg/mqconn"
+	"github.com/nitrous-io/rise-server/testhelper"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/streadway/amqp"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "broker")
+}
+
+func receive(sub broker.Subscription) *broker.Delivery {
+	select {
+	case d := <-sub.Deliveries():
+		return d
+	case <-time.After(100 * time.Millisecond):
+		return nil
+	}
+}
+
+var _ = Describe("Memory", func() {
+	var b *broker.Memory
+
+	BeforeEach(func() {
+		b = broker.NewMemory()
+	})
+
+	Describe("Enqueue()", func() {
+		It("keeps messages until they are consumed", func() {
+			Expect(b.Enqueue("fooq", []byte("foo"))).To(BeNil())
+			Expect(b.Enqueue("fooq", []byte("bar"))).To(BeNil())
+			Expect(b.Len("fooq")).To(Equal(2))
+
+			sub, err := b.Consume("fooq", 0)
+			Expect(err).To(BeNil())
+			defer sub.Close()
+
+			d1 := receive(sub)
+			Expect(d1).NotTo(BeNil())
+			Expect(string(d1.Body)).To(Equal("foo"))
+			Expect(d1.Ack()).To(BeNil())
+
+			d2 := receive(sub)
+			Expect(d2).NotTo(BeNil())
+			Expect(string(d2.Body)).To(Equal("bar"))
+			Expect(d2.Ack()).To(BeNil())
+
+			Expect(b.Len("fooq")).To(Equal(0))
+		})
+
+		It("returns an error when the broker is closed", func() {
+			Expect(b.Close()).To(BeNil())
+			Expect(b.Enqueue("fooq", []byte("foo"))).To(Equal(broker.ErrClosed))
+		})
+	})
+
+	Describe("Consume()", func() {
+		It("does not deliver more than prefetch unacked messages", func() {
+			for _, m := range []string{"a", "b", "c"} {
+				Expect(b.Enqueue("fooq", []byte(m))).To(BeNil())
+			}
+
+			sub, err := b.Consume("fooq", 2)
+			Expect(err).To(BeNil())
+			defer sub.Close()
+
+			d1 := receive(sub)
+			Expect(d1).NotTo(BeNil())
+			d2 := receive(sub)
+			Expect(d2).NotTo(BeNil())
+			Expect(receive(sub)).To(BeNil())
+
+			Expect(d1.Ack()).To(BeNil())
+
+			d3 := receive(sub)
+			Expect(d3).NotTo(BeNil())
+			Expect(string(d3.Body)).To(Equal("c"))
+		})
+
+		It("redelivers messages that are nacked with requeue", func() {
+			Expect(b.Enqueue("fooq", []byte("foo"))).To(BeNil())
+
+			sub, err := b.Consume("fooq", 1)
+			Expect(err).To(BeNil())
+			defer sub.Close()
+
+			d := receive(sub)
+			Expect(d).NotTo(BeNil())
+			Expect(d.Nack(true)).To(BeNil())
+
+			d = receive(sub)
+			Expect(d).NotTo(BeNil())
+			Expect(string(d.Body)).To(Equal("foo"))
+			Expect(d.Nack(false)).To(BeNil())
+
+			Expect(receive(sub)).To(BeNil())
+			Expect(b.Len("fooq")).To(Equal(0))
+		})
+
+		It("distributes messages among consumers of the same queue", func() {
+			sub1, err := b.Consume("fooq", 1)
+			Expect(err).To(BeNil())
+			defer sub1.Close()
+
+			sub2, err := b.Consume("fooq", 1)
+			Expect(err).To(BeNil())
+			defer sub2.Close()
+
+			Expect(b.Enqueue("fooq", []byte("foo"))).To(BeNil())
+			Expect(b.Enqueue("fooq", []byte("bar"))).To(BeNil())
+
+			d1 := receive(sub1)
+			Expect(d1).NotTo(BeNil())
+			d2 := receive(sub2)
+			Expect(d2).NotTo(BeNil())
+
+			Expect([]string{string(d1.Body), string(d2.Body)}).To(ConsistOf("foo", "bar"))
+		})
+
+		It("stops delivering messages when the subscription is closed", func() {
+			sub, err := b.Consume("fooq", 1)
+			Expect(err).To(BeNil())
+			Expect(sub.Close()).To(BeNil())
+
+			Eventually(sub.Deliveries()).Should(BeClosed())
+
+			Expect(b.Enqueue("fooq", []byte("foo"))).To(BeNil())
+			Expect(b.Len("fooq")).To(Equal(1))
+		})
+	})
+
+	Describe("Publish()", func() {
+		It("delivers the message to every subscriber of the route", func() {
+			sub1, err := b.Subscribe("foo-exchange", "bar-route", 0)
+			Expect(err).To(BeNil())
+			defer sub1.Close()
+
+			sub2, err := b.Subscribe("foo-exchange", "bar-route", 0)
+			Expect(err).To(BeNil())
+			defer sub2.Close()
+
+			sub3, err := b.Subscribe("foo-exchange", "baz-route", 0)
+			Expect(err).To(BeNil())
+			defer sub3.Close()
+
+			Expect(b.Publish("foo-exchange", "bar-route", []byte("chocolates"))).To(BeNil())
+
+			d1 := receive(sub1)
+			Expect(d1).NotTo(BeNil())
+			Expect(string(d1.Body)).To(Equal("chocolates"))
+
+			d2 := receive(sub2)
+			Expect(d2).NotTo(BeNil())
+			Expect(string(d2.Body)).To(Equal("chocolates"))
+
+			Expect(receive(sub3)).To(BeNil())
+		})
+
+		It("drops messages when the route has no subscribers", func() {
+			sub, err := b.Subscribe("foo-exchange", "bar-route", 0)
+			Expect(err).To(BeNil())
+			Expect(sub.Close()).To(BeNil())
+
+			Expect(b.Publish("foo-exchange", "bar-route", []byte("chocolates"))).To(BeNil())
+
+			sub, err = b.Subscribe("foo-exchange", "bar-route", 0)
+			Expect(err).To(BeNil())
+			defer sub.Close()
+
+			Expect(receive(sub)).To(BeNil())
+		})
+	})
+})
+
+var _ = Describe("AMQP", func() {
+	var (
+		mq  *amqp.Connection
+		b   *broker.AMQP
+		err error
+	)
+
+	BeforeEach(func() {
+		mq, err = mqconn.MQ()
+		Expect(err).To(BeNil())
+
+		b = broker.NewAMQP()
+	})
+
+	Describe("Enqueue()", func() {
+		BeforeEach(func() {
+			testhelper.DeleteQueue(mq, "fooq")
+		})
+
+		It("enqueues message to queue", func() {
+			Expect(b.Enqueue("fooq", []byte("bar"))).To(BeNil())
+
+			d := testhelper.ConsumeQueue(mq, "fooq")
+			Expect(d).NotTo(BeNil())
+			Expect(string(d.Body)).To(Equal("bar"))
+		})
+	})
+
+	Describe("Consume()", func() {
+		BeforeEach(func() {
+			testhelper.DeleteQueue(mq, "fooq")
+		})
+
+		It("delivers enqueued messages", func() {
+			sub, err := b.Consume("fooq", 1)
+			Expect(err).To(BeNil())
+			defer sub.Close()
+
+			Expect(b.Enqueue("fooq", []byte("bar"))).To(BeNil())
+
+			var d *broker.Delivery
+			Eventually(sub.Deliveries()).Should(Receive(&d))
+			Expect(string(d.Body)).To(Equal("bar"))
+			Expect(d.Ack()).To(BeNil())
+		})
+
+		It("puts back the messages that were not delivered yet when the subscription is closed", func() {
+			sub, err := b.Consume("fooq", 3)
+			Expect(err).To(BeNil())
+
+			for _, body := range []string{"a", "b", "c"} {
+				Expect(b.Enqueue("fooq", []byte(body))).To(BeNil())
+			}
+
+			var d *broker.Delivery
+			Eventually(sub.Deliveries()).Should(Receive(&d))
+			Expect(string(d.Body)).To(Equal("a"))
+
+			Expect(sub.Close()).To(BeNil())
+
+			// The channel stays open for messages that were already delivered.
+			Expect(d.Ack()).To(BeNil())
+
+			sub2, err := b.Consume("fooq", 3)
+			Expect(err).To(BeNil())
+			defer sub2.Close()
+
+			var bodies []string
+			for i := 0; i < 2; i++ {
+				var d *broker.Delivery
+				Eventually(sub2.Deliveries()).Should(Receive(&d))
+				Expect(d.Ack()).To(BeNil())
+				bodies = append(bodies, string(d.Body))
+			}
+			Expect(bodies).To(ConsistOf("b", "c"))
+		})
+	})
+
+	Describe("Subscribe()", func() {
+		AfterEach(func() {
+			testhelper.DeleteExchange(mq, "foo-exchange")
+		})
+
+		It("delivers messages published to the route", func() {
+			sub, err := b.Subscribe("foo-exchange", "bar-route", 0)
+			Expect(err).To(BeNil())
+			defer sub.Close()
+
+			Expect(b.Publish("foo-exchange", "bar-route", []byte("chocolates"))).To(BeNil())
+
+			var d *broker.Delivery
+			Eventually(sub.Deliveries()).Should(Receive(&d))
+			Expect(string(d.Body)).To(Equal("chocolates"))
+			Expect(d.Ack()).To(BeNil())
+		})
+	})
+})
@@ -0,0 +1,317 @@
+package broker
+
+import "sync"
+
+// Memory is a Broker that keeps queues and exchanges in memory. It is meant for
+// tests and local development, where it lets publishers and consumers run
+// inside a single process without RabbitMQ. Messages are lost when the process
+// exits.
+type Memory struct {
+	mu        sync.Mutex
+	queues    map[string]*memQueue
+	exchanges map[string]map[string][]*memQueue // exchange -> route -> bound queues
+	closed    bool
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		queues:    map[string]*memQueue{},
+		exchanges: map[string]map[string][]*memQueue{},
+	}
+}
+
+func (b *Memory) Enqueue(queueName string, data []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return ErrClosed
+	}
+
+	b.queue(queueName).push(copyBytes(data))
+	return nil
+}
+
+func (b *Memory) Publish(exchangeName, route string, data []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return ErrClosed
+	}
+
+	for _, q := range b.exchanges[exchangeName][route] {
+		q.push(copyBytes(data))
+	}
+	return nil
+}
+
+func (b *Memory) Consume(queueName string, prefetch int) (Subscription, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, ErrClosed
+	}
+
+	return newMemSubscription(b.queue(queueName), prefetch, nil), nil
+}
+
+func (b *Memory) Subscribe(exchangeName, route string, prefetch int) (Subscription, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, ErrClosed
+	}
+
+	routes := b.exchanges[exchangeName]
+	if routes == nil {
+		routes = map[string][]*memQueue{}
+		b.exchanges[exchangeName] = routes
+	}
+
+	q := newMemQueue()
+	routes[route] = append(routes[route], q)
+
+	unbind := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		bound := routes[route]
+		for i, bq := range bound {
+			if bq == q {
+				routes[route] = append(bound[:i], bound[i+1:]...)
+				break
+			}
+		}
+	}
+
+	return newMemSubscription(q, prefetch, unbind), nil
+}
+
+// Close stops the broker. Publishing or consuming afterwards returns ErrClosed.
+func (b *Memory) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	return nil
+}
+
+// Len returns the number of messages waiting to be delivered in the named
+// queue.
+func (b *Memory) Len(queueName string) int {
+	b.mu.Lock()
+	q := b.queues[queueName]
+	b.mu.Unlock()
+
+	if q == nil {
+		return 0
+	}
+	return q.len()
+}
+
+// queue returns the named queue, creating it if it does not exist. b.mu must be
+// held by the caller.
+func (b *Memory) queue(name string) *memQueue {
+	q, ok := b.queues[name]
+	if !ok {
+		q = newMemQueue()
+		b.queues[name] = q
+	}
+	return q
+}
+
+func copyBytes(b []byte) []byte {
+	c := make([]byte, len(b))
+	copy(c, b)
+	return c
+}
+
+type memQueue struct {
+	mu     sync.Mutex
+	msgs   [][]byte
+	notify chan struct{}
+}
+
+func newMemQueue() *memQueue {
+	return &memQueue{notify: make(chan struct{}, 1)}
+}
+
+func (q *memQueue) push(data []byte) {
+	q.mu.Lock()
+	q.msgs = append(q.msgs, data)
+	q.mu.Unlock()
+	q.signal()
+}
+
+// pushFront puts a rejected message back at the head of the queue.
+func (q *memQueue) pushFront(data []byte) {
+	q.mu.Lock()
+	q.msgs = append([][]byte{data}, q.msgs...)
+	q.mu.Unlock()
+	q.signal()
+}
+
+func (q *memQueue) pop() ([]byte, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.msgs) == 0 {
+		return nil, false
+	}
+
+	data := q.msgs[0]
+	q.msgs = q.msgs[1:]
+
+	// Wake up another consumer if there is more work to do.
+	if len(q.msgs) > 0 {
+		q.signal()
+	}
+	return data, true
+}
+
+func (q *memQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.msgs)
+}
+
+func (q *memQueue) signal() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+type memSubscription struct {
+	q      *memQueue
+	slots  chan struct{} // nil if prefetch is unlimited
+	unbind func()
+
+	deliveries chan *Delivery
+	errCh      chan error
+	stop       chan struct{}
+	closeOnce  sync.Once
+}
+
+func newMemSubscription(q *memQueue, prefetch int, unbind func()) *memSubscription {
+	s := &memSubscription{
+		q:      q,
+		unbind: unbind,
+
+		deliveries: make(chan *Delivery),
+		errCh:      make(chan error),
+		stop:       make(chan struct{}),
+	}
+	if prefetch > 0 {
+		s.slots = make(chan struct{}, prefetch)
+	}
+
+	go s.run()
+	return s
+}
+
+func (s *memSubscription) run() {
+	defer close(s.deliveries)
+
+	for {
+		if s.slots != nil {
+			select {
+			case s.slots <- struct{}{}:
+			case <-s.stop:
+				return
+			}
+		}
+
+		data, ok := s.next()
+		if !ok {
+			return
+		}
+
+		d := &Delivery{Body: data, acker: &memAcker{sub: s, data: data}}
+		select {
+		case s.deliveries <- d:
+		case <-s.stop:
+			s.q.pushFront(data)
+			return
+		}
+	}
+}
+
+// next blocks until a message is available or the subscription is closed.
+func (s *memSubscription) next() ([]byte, bool) {
+	for {
+		if data, ok := s.q.pop(); ok {
+			return data, true
+		}
+
+		select {
+		case <-s.q.notify:
+		case <-s.stop:
+			return nil, false
+		}
+	}
+}
+
+func (s *memSubscription) release() {
+	if s.slots != nil {
+		<-s.slots
+	}
+}
+
+func (s *memSubscription) Deliveries() <-chan *Delivery {
+	return s.deliveries
+}
+
+func (s *memSubscription) Err() <-chan error {
+	return s.errCh
+}
+
+func (s *memSubscription) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.stop)
+		if s.unbind != nil {
+			s.unbind()
+		}
+	})
+	return nil
+}
+
+type memAcker struct {
+	sub  *memSubscription
+	data []byte
+
+	mu      sync.Mutex
+	settled bool
+}
+
+func (a *memAcker) ack() error {
+	a.settle()
+	return nil
+}
+
+func (a *memAcker) nack(requeue bool) error {
+	if !a.settle() {
+		return nil
+	}
+	if requeue {
+		a.sub.q.pushFront(a.data)
+	}
+	return nil
+}
+
+// settle marks the message as acked or nacked, and returns false if it already
+// was.
+func (a *memAcker) settle() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.settled {
+		return false
+	}
+	a.settled = true
+	a.sub.release()
+	return true
+}
@@ -7,6 +7,7 @@ import (
 
 type FileTransfer interface {
 	Upload(region, bucket, key string, body io.Reader, contentType, acl string) error
+	UploadWithCacheControl(region, bucket, key string, body io.Reader, contentType, acl, cacheControl string) error
 	Download(region, bucket, key string, out io.WriterAt) error
 	Delete(region, bucket string, keys ...string) error
 	DeleteAll(region, bucket, prefix string) error
@@ -0,0 +1,154 @@
+package filetransfer
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Local is a FileTransfer that stores files on the local disk, under
+// <root>/<bucket>/<key>. The region is ignored. It is meant for local
+// development.
+type Local struct {
+	root string
+}
+
+func NewLocal(root string) *Local {
+	return &Local{root: root}
+}
+
+func (l *Local) Upload(region, bucket, key string, body io.Reader, contentType, acl string) error {
+	return l.UploadWithCacheControl(region, bucket, key, body, contentType, acl, "")
+}
+
+// UploadWithCacheControl is the same as Upload, since files on disk do not
+// have headers.
+func (l *Local) UploadWithCacheControl(region, bucket, key string, body io.Reader, contentType, acl, cacheControl string) error {
+	p := l.path(bucket, key)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.Create(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, body); err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+func (l *Local) Download(region, bucket, key string, out io.WriterAt) error {
+	f, err := os.Open(l.path(bucket, key))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	buf := make([]byte, 32*1024)
+	var off int64
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			if _, err := out.WriteAt(buf[:n], off); err != nil {
+				return err
+			}
+			off += int64(n)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (l *Local) Delete(region, bucket string, keys ...string) error {
+	for _, key := range keys {
+		if err := os.Remove(l.path(bucket, key)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteAll deletes every file whose key starts with prefix, like S3 does.
+func (l *Local) DeleteAll(region, bucket, prefix string) error {
+	bucketDir := filepath.Join(l.root, bucket)
+	prefix = strings.TrimPrefix(prefix, "/")
+
+	var matches []string
+	err := filepath.Walk(bucketDir, func(absPath string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+
+		if fi.IsDir() {
+			return nil
+		}
+
+		key, err := filepath.Rel(bucketDir, absPath)
+		if err != nil {
+			return err
+		}
+
+		if strings.HasPrefix(filepath.ToSlash(key), prefix) {
+			matches = append(matches, absPath)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, m := range matches {
+		if err := os.Remove(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *Local) Copy(region, bucket, srcKey, destKey string) error {
+	src, err := os.Open(l.path(bucket, srcKey))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	return l.Upload(region, bucket, destKey, src, "", "")
+}
+
+func (l *Local) Exists(region, bucket, key string) (bool, error) {
+	if _, err := os.Stat(l.path(bucket, key)); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// PresignedURL returns a file:// URL, since files on the local disk need no
+// signing.
+func (l *Local) PresignedURL(region, bucket, key string, expireTime time.Duration) (string, error) {
+	p, err := filepath.Abs(l.path(bucket, key))
+	if err != nil {
+		return "", err
+	}
+	return "file://" + filepath.ToSlash(p), nil
+}
+
+func (l *Local) path(bucket, key string) string {
+	return filepath.Join(l.root, bucket, filepath.FromSlash(strings.TrimPrefix(key, "/")))
+}
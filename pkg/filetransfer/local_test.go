@@ -0,0 +1,96 @@
+package filetransfer_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/nitrous-io/rise-server/pkg/filetransfer"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "filetransfer")
+}
+
+var _ = Describe("Local", func() {
+	var (
+		root string
+		l    *filetransfer.Local
+		err  error
+	)
+
+	BeforeEach(func() {
+		root, err = ioutil.TempDir("", "filetransfer")
+		Expect(err).To(BeNil())
+
+		l = filetransfer.NewLocal(root)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(root)
+	})
+
+	download := func(key string) string {
+		f, err := ioutil.TempFile("", "download")
+		Expect(err).To(BeNil())
+		defer os.Remove(f.Name())
+		defer f.Close()
+
+		Expect(l.Download("us-west-2", "bucket", key, f)).To(BeNil())
+
+		b, err := ioutil.ReadFile(f.Name())
+		Expect(err).To(BeNil())
+		return string(b)
+	}
+
+	It("uploads, downloads and copies files", func() {
+		err = l.Upload("us-west-2", "bucket", "deployments/a-1/index.html", bytes.NewBufferString("hello"), "text/html", "public-read")
+		Expect(err).To(BeNil())
+
+		Expect(download("deployments/a-1/index.html")).To(Equal("hello"))
+
+		Expect(l.Copy("us-west-2", "bucket", "deployments/a-1/index.html", "deployments/b-2/index.html")).To(BeNil())
+		Expect(download("deployments/b-2/index.html")).To(Equal("hello"))
+	})
+
+	It("reports whether files exist", func() {
+		exists, err := l.Exists("us-west-2", "bucket", "foo.txt")
+		Expect(err).To(BeNil())
+		Expect(exists).To(BeFalse())
+
+		Expect(l.Upload("us-west-2", "bucket", "foo.txt", bytes.NewBufferString("foo"), "", "")).To(BeNil())
+
+		exists, err = l.Exists("us-west-2", "bucket", "foo.txt")
+		Expect(err).To(BeNil())
+		Expect(exists).To(BeTrue())
+
+		Expect(l.Delete("us-west-2", "bucket", "foo.txt", "bar.txt")).To(BeNil())
+
+		exists, err = l.Exists("us-west-2", "bucket", "foo.txt")
+		Expect(err).To(BeNil())
+		Expect(exists).To(BeFalse())
+	})
+
+	It("deletes every file with the given prefix", func() {
+		for _, key := range []string{"deployments/a-1/x.html", "deployments/a-1/y/z.html", "deployments/a-10/x.html", "deployments/b-2/x.html"} {
+			Expect(l.Upload("us-west-2", "bucket", key, bytes.NewBufferString("x"), "", "")).To(BeNil())
+		}
+
+		Expect(l.DeleteAll("us-west-2", "bucket", "deployments/a-1/")).To(BeNil())
+
+		for key, expected := range map[string]bool{
+			"deployments/a-1/x.html":   false,
+			"deployments/a-1/y/z.html": false,
+			"deployments/a-10/x.html":  true,
+			"deployments/b-2/x.html":   true,
+		} {
+			exists, err := l.Exists("us-west-2", "bucket", key)
+			Expect(err).To(BeNil())
+			Expect(exists).To(Equal(expected), key)
+		}
+	})
+})
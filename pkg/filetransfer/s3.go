@@ -10,6 +10,26 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/nitrous-io/rise-server/pkg/metrics"
+)
+
+var (
+	s3UploadBytes = metrics.NewCounter(
+		"filetransfer_s3_upload_bytes_total",
+		"Number of bytes uploaded to S3, by bucket.",
+		"bucket",
+	)
+	s3UploadDuration = metrics.NewHistogram(
+		"filetransfer_s3_upload_duration_seconds",
+		"Time taken to upload files to S3, by bucket.",
+		nil,
+		"bucket",
+	)
+	s3UploadFailures = metrics.NewCounter(
+		"filetransfer_s3_upload_failures_total",
+		"Number of failed uploads to S3, by bucket.",
+		"bucket",
+	)
 )
 
 type S3 struct {
@@ -25,6 +45,12 @@ func NewS3(partSize int64, maxUploadParts int) *S3 {
 }
 
 func (s *S3) Upload(region, bucket, key string, body io.Reader, contentType, acl string) error {
+	return s.UploadWithCacheControl(region, bucket, key, body, contentType, acl, "")
+}
+
+// UploadWithCacheControl is like Upload, but also sets the Cache-Control
+// header that S3 serves the file with, unless cacheControl is empty.
+func (s *S3) UploadWithCacheControl(region, bucket, key string, body io.Reader, contentType, acl, cacheControl string) error {
 	sess := session.New(&aws.Config{Region: aws.String(region)})
 	uploader := s3manager.NewUploader(sess, func(u *s3manager.Uploader) {
 		if s.partSize != 0 {
@@ -43,16 +69,79 @@ func (s *S3) Upload(region, bucket, key string, body io.Reader, contentType, acl
 		acl = "private"
 	}
 
-	_, err := uploader.Upload(&s3manager.UploadInput{
+	// Seekable bodies, such as files, are passed to the uploader as they are,
+	// so that it can tell their size and read parts of them without buffering
+	// them. Only the bodies that cannot be measured up front are counted as
+	// they are read.
+	start := time.Now()
+	size, ok := remainingSize(body)
+	var cr *countingReader
+	if !ok {
+		cr = &countingReader{r: body}
+		body = cr
+	}
+
+	input := &s3manager.UploadInput{
 		Bucket:      aws.String(bucket),
 		Key:         aws.String(key),
 		Body:        body,
 		ACL:         aws.String(acl),
 		ContentType: aws.String(contentType),
-	})
+	}
+	if cacheControl != "" {
+		input.CacheControl = aws.String(cacheControl)
+	}
+	_, err := uploader.Upload(input)
+
+	s3UploadDuration.ObserveSince(start, bucket)
+	if cr != nil {
+		size = cr.n
+	}
+	if err != nil {
+		s3UploadFailures.Inc(bucket)
+	} else {
+		s3UploadBytes.Add(float64(size), bucket)
+	}
 	return err
 }
 
+// remainingSize returns the number of bytes left to read from r, if r is an
+// io.Seeker. The position of r is left as it was.
+func remainingSize(r io.Reader) (int64, bool) {
+	seeker, ok := r.(io.Seeker)
+	if !ok {
+		return 0, false
+	}
+
+	pos, err := seeker.Seek(0, 1)
+	if err != nil {
+		return 0, false
+	}
+
+	end, err := seeker.Seek(0, 2)
+	if err != nil {
+		return 0, false
+	}
+
+	if _, err := seeker.Seek(pos, 0); err != nil {
+		return 0, false
+	}
+
+	return end - pos, true
+}
+
+// countingReader counts the number of bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
 func (s *S3) Download(region, bucket, key string, out io.WriterAt) error {
 	sess := session.New(&aws.Config{Region: aws.String(region)})
 	downloader := s3manager.NewDownloader(sess, func(d *s3manager.Downloader) {
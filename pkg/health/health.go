@@ -0,0 +1,167 @@
+// Package health reports whether a process and the services it depends on are
+// working, so that load balancers and orchestrators can route traffic away
+// from it or restart it.
+package health
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Statuses reported for a process and its dependencies.
+const (
+	StatusOK          = "ok"
+	StatusUnavailable = "unavailable"
+)
+
+// DefaultTimeout is how long each check is given to complete when
+// Checker.Timeout is zero.
+var DefaultTimeout = 5 * time.Second
+
+// Check returns an error if a dependency is not usable.
+type Check func() error
+
+// Result is the outcome of a single check.
+type Result struct {
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// Report is the outcome of all checks of a Checker.
+type Report struct {
+	Status string             `json:"status"`
+	Checks map[string]*Result `json:"checks"`
+}
+
+// OK reports whether every check passed.
+func (r *Report) OK() bool {
+	return r.Status == StatusOK
+}
+
+// Checker runs a set of named checks.
+type Checker struct {
+	// Timeout is how long each check is given to complete before it is
+	// reported as unavailable. If zero, DefaultTimeout is used.
+	Timeout time.Duration
+
+	mu     sync.Mutex
+	checks map[string]Check
+}
+
+// NewChecker returns a Checker without any checks.
+func NewChecker() *Checker {
+	return &Checker{checks: map[string]Check{}}
+}
+
+// Add adds a check under the given name, replacing any check with the same
+// name.
+func (c *Checker) Add(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.checks[name] = check
+}
+
+// Run runs all checks concurrently and waits for them to complete or time
+// out.
+func (c *Checker) Run() *Report {
+	c.mu.Lock()
+	checks := make(map[string]Check, len(c.checks))
+	for name, check := range c.checks {
+		checks[name] = check
+	}
+	c.mu.Unlock()
+
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+
+		report = &Report{
+			Status: StatusOK,
+			Checks: make(map[string]*Result, len(checks)),
+		}
+	)
+
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check Check) {
+			defer wg.Done()
+
+			res := run(check, timeout)
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[name] = res
+			if res.Status != StatusOK {
+				report.Status = StatusUnavailable
+			}
+		}(name, check)
+	}
+
+	wg.Wait()
+	return report
+}
+
+// run runs a single check. A check that times out is left running in the
+// background; its result is discarded.
+func run(check Check, timeout time.Duration) *Result {
+	start := time.Now()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- check()
+	}()
+
+	var err error
+	select {
+	case err = <-errCh:
+	case <-time.After(timeout):
+		err = fmt.Errorf("timed out after %v", timeout)
+	}
+
+	res := &Result{
+		Status:     StatusOK,
+		DurationMS: int64(time.Since(start) / time.Millisecond),
+	}
+	if err != nil {
+		res.Status = StatusUnavailable
+		res.Error = err.Error()
+	}
+	return res
+}
+
+// LiveHandler responds with 200 OK as long as the process is able to serve
+// requests. It does not check any dependencies.
+func LiveHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": StatusOK})
+	})
+}
+
+// ReadyHandler runs the checks and responds with a report of their results,
+// with 200 OK if all of them passed and 503 Service Unavailable otherwise.
+func (c *Checker) ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		report := c.Run()
+
+		code := http.StatusOK
+		if !report.OK() {
+			code = http.StatusServiceUnavailable
+		}
+		writeJSON(w, code, report)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,129 @@
+package health_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nitrous-io/rise-server/pkg/health"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "health")
+}
+
+var _ = Describe("Checker", func() {
+	var checker *health.Checker
+
+	ok := func() error { return nil }
+
+	BeforeEach(func() {
+		checker = health.NewChecker()
+		checker.Timeout = 50 * time.Millisecond
+	})
+
+	Describe("Run()", func() {
+		It("reports ok when there are no checks", func() {
+			report := checker.Run()
+			Expect(report.OK()).To(BeTrue())
+			Expect(report.Checks).To(BeEmpty())
+		})
+
+		It("reports ok when all checks pass", func() {
+			checker.Add("a", ok)
+			checker.Add("b", ok)
+
+			report := checker.Run()
+			Expect(report.OK()).To(BeTrue())
+			Expect(report.Checks).To(HaveLen(2))
+			Expect(report.Checks["a"].Status).To(Equal(health.StatusOK))
+			Expect(report.Checks["a"].Error).To(BeEmpty())
+			Expect(report.Checks["b"].Status).To(Equal(health.StatusOK))
+		})
+
+		It("reports unavailable when a check fails", func() {
+			checker.Add("a", ok)
+			checker.Add("b", func() error { return errors.New("connection refused") })
+
+			report := checker.Run()
+			Expect(report.OK()).To(BeFalse())
+			Expect(report.Status).To(Equal(health.StatusUnavailable))
+			Expect(report.Checks["a"].Status).To(Equal(health.StatusOK))
+			Expect(report.Checks["b"].Status).To(Equal(health.StatusUnavailable))
+			Expect(report.Checks["b"].Error).To(Equal("connection refused"))
+		})
+
+		It("reports unavailable when a check times out", func() {
+			block := make(chan struct{})
+			defer close(block)
+
+			checker.Add("slow", func() error {
+				<-block
+				return nil
+			})
+
+			start := time.Now()
+			report := checker.Run()
+			Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+
+			Expect(report.OK()).To(BeFalse())
+			Expect(report.Checks["slow"].Status).To(Equal(health.StatusUnavailable))
+			Expect(report.Checks["slow"].Error).To(ContainSubstring("timed out"))
+		})
+	})
+
+	Describe("ReadyHandler()", func() {
+		var res *httptest.ResponseRecorder
+
+		doRequest := func() {
+			req, err := http.NewRequest("GET", "/health/ready", nil)
+			Expect(err).To(BeNil())
+			res = httptest.NewRecorder()
+			checker.ReadyHandler().ServeHTTP(res, req)
+		}
+
+		It("responds with 200 OK when all checks pass", func() {
+			checker.Add("database", ok)
+			doRequest()
+
+			Expect(res.Code).To(Equal(http.StatusOK))
+
+			var report health.Report
+			Expect(json.Unmarshal(res.Body.Bytes(), &report)).To(Succeed())
+			Expect(report.Status).To(Equal(health.StatusOK))
+			Expect(report.Checks["database"].Status).To(Equal(health.StatusOK))
+		})
+
+		It("responds with 503 Service Unavailable when a check fails", func() {
+			checker.Add("database", ok)
+			checker.Add("mq", func() error { return errors.New("not connected") })
+			doRequest()
+
+			Expect(res.Code).To(Equal(http.StatusServiceUnavailable))
+
+			var report health.Report
+			Expect(json.Unmarshal(res.Body.Bytes(), &report)).To(Succeed())
+			Expect(report.Status).To(Equal(health.StatusUnavailable))
+			Expect(report.Checks["mq"].Error).To(Equal("not connected"))
+		})
+	})
+})
+
+var _ = Describe("LiveHandler()", func() {
+	It("responds with 200 OK", func() {
+		req, err := http.NewRequest("GET", "/health/live", nil)
+		Expect(err).To(BeNil())
+		res := httptest.NewRecorder()
+		health.LiveHandler().ServeHTTP(res, req)
+
+		Expect(res.Code).To(Equal(http.StatusOK))
+		Expect(res.Body.String()).To(MatchJSON(`{"status": "ok"}`))
+	})
+})
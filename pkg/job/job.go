@@ -2,10 +2,8 @@ package job
 
 import (
 	"encoding/json"
-	"time"
 
-	"github.com/nitrous-io/rise-server/pkg/mqconn"
-	"github.com/streadway/amqp"
+	"github.com/nitrous-io/rise-server/pkg/broker"
 )
 
 type Job struct {
@@ -26,39 +24,5 @@ func NewWithJSON(queueName string, data interface{}) (*Job, error) {
 }
 
 func (j *Job) Enqueue() error {
-	mq, err := mqconn.MQ()
-	if err != nil {
-		return err
-	}
-
-	ch, err := mq.Channel()
-	if err != nil {
-		return err
-	}
-	defer ch.Close()
-
-	q, err := ch.QueueDeclare(
-		j.QueueName,
-		true,  // durable
-		false, // delete when unused
-		false, // exclusive
-		false, // noWait
-		nil,
-	)
-	if err != nil {
-		return err
-	}
-
-	return ch.Publish(
-		"",     // exchange
-		q.Name, // routing key
-		false,  // mandatory
-		false,  // immediate
-		amqp.Publishing{
-			DeliveryMode: amqp.Persistent,
-			ContentType:  "text/plain",
-			Body:         []byte(j.Data),
-			Timestamp:    time.Now(),
-		},
-	)
+	return broker.Default.Enqueue(j.QueueName, j.Data)
 }
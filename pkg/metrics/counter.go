@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+)
+
+// Counter is a value that only goes up, such as the number of requests served.
+// It is partitioned by the label names given when it is created.
+type Counter struct {
+	vec
+	values map[string]float64
+}
+
+// NewCounter creates a counter and adds it to the registry.
+func (r *Registry) NewCounter(name, help string, labelNames ...string) *Counter {
+	c := &Counter{
+		vec:    newVec(name, help, "counter", labelNames),
+		values: map[string]float64{},
+	}
+	r.register(c)
+	return c
+}
+
+// NewCounter creates a counter and adds it to DefaultRegistry.
+func NewCounter(name, help string, labelNames ...string) *Counter {
+	return DefaultRegistry.NewCounter(name, help, labelNames...)
+}
+
+// Inc increments the counter for the given label values by 1.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increments the counter for the given label values by delta, which must
+// not be negative.
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic("metrics: counters cannot decrease")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.values[c.lookup(labelValues)] += delta
+}
+
+// Value returns the current value of the counter for the given label values.
+func (c *Counter) Value(labelValues ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.values[c.key(labelValues)]
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.writeHeader(w)
+	for _, s := range c.sortedSeries() {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.formatLabels(s.labels), formatFloat(c.values[s.key]))
+	}
+}
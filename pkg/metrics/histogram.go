@@ -0,0 +1,108 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+)
+
+// DefaultBuckets are the histogram buckets used when none are given. They are
+// meant for durations in seconds, from a few milliseconds to a few seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Histogram counts observations, such as request durations, in configurable
+// buckets. It is partitioned by the label names given when it is created.
+type Histogram struct {
+	vec
+	buckets []float64
+	values  map[string]*histogramValue
+}
+
+type histogramValue struct {
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// NewHistogram creates a histogram with the given bucket upper bounds and adds
+// it to the registry. If buckets is nil, DefaultBuckets is used.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+
+	h := &Histogram{
+		vec:     newVec(name, help, "histogram", labelNames),
+		buckets: buckets,
+		values:  map[string]*histogramValue{},
+	}
+	r.register(h)
+	return h
+}
+
+// NewHistogram creates a histogram and adds it to DefaultRegistry.
+func NewHistogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	return DefaultRegistry.NewHistogram(name, help, buckets, labelNames...)
+}
+
+// Observe adds a single observation to the histogram for the given label
+// values.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := h.lookup(labelValues)
+	hv, ok := h.values[key]
+	if !ok {
+		hv = &histogramValue{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = hv
+	}
+
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		hv.counts[i]++
+	}
+	hv.count++
+	hv.sum += v
+}
+
+// ObserveSince observes the number of seconds elapsed since start.
+func (h *Histogram) ObserveSince(start time.Time, labelValues ...string) {
+	h.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+// Count returns the number of observations for the given label values.
+func (h *Histogram) Count(labelValues ...string) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if hv, ok := h.values[h.key(labelValues)]; ok {
+		return hv.count
+	}
+	return 0
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.writeHeader(w)
+	for _, s := range h.sortedSeries() {
+		hv, ok := h.values[s.key]
+		if !ok {
+			hv = &histogramValue{counts: make([]uint64, len(h.buckets))}
+		}
+
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += hv.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.formatLabels(s.labels, "le", formatFloat(upper)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.formatLabels(s.labels, "le", formatFloat(math.Inf(1))), hv.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.formatLabels(s.labels), formatFloat(hv.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.formatLabels(s.labels), hv.count)
+	}
+}
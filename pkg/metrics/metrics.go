@@ -0,0 +1,214 @@
+// Package metrics keeps counters and histograms in memory and exposes them in
+// the Prometheus text format, so that they can be scraped from /metrics.
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the content type of the Prometheus text format.
+const ContentType = "text/plain; version=0.0.4"
+
+// DefaultRegistry is the registry that metrics created with the package-level
+// constructors are added to.
+var DefaultRegistry = NewRegistry()
+
+type collector interface {
+	metricName() string
+	write(w io.Writer)
+}
+
+// Registry is a set of metrics that are exposed together.
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+	names      map[string]bool
+}
+
+func NewRegistry() *Registry {
+	return &Registry{names: map[string]bool{}}
+}
+
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.names[c.metricName()] {
+		panic("metrics: duplicate metric " + c.metricName())
+	}
+	r.names[c.metricName()] = true
+	r.collectors = append(r.collectors, c)
+}
+
+// Write writes all metrics in the registry to w in the Prometheus text format.
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	collectors := append([]collector(nil), r.collectors...)
+	r.mu.Unlock()
+
+	var buf bytes.Buffer
+	for _, c := range collectors {
+		c.write(&buf)
+	}
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// Handler returns an http.Handler that serves the metrics in the registry.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		r.Write(w)
+	})
+}
+
+// Push sends the metrics in the registry to a Prometheus Pushgateway, grouped
+// under the given job name. It is meant for short-lived jobs that exit before
+// they can be scraped.
+func (r *Registry) Push(gatewayURL, job string) error {
+	var buf bytes.Buffer
+	if err := r.Write(&buf); err != nil {
+		return err
+	}
+
+	url := strings.TrimRight(gatewayURL, "/") + "/metrics/job/" + job
+	req, err := http.NewRequest("PUT", url, &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", ContentType)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return errors.New("metrics: pushgateway responded with " + resp.Status)
+	}
+	return nil
+}
+
+// Handler returns an http.Handler that serves the metrics in DefaultRegistry.
+func Handler() http.Handler {
+	return DefaultRegistry.Handler()
+}
+
+// Push sends the metrics in DefaultRegistry to a Prometheus Pushgateway.
+func Push(gatewayURL, job string) error {
+	return DefaultRegistry.Push(gatewayURL, job)
+}
+
+// series holds the label values of one time series of a metric.
+type series struct {
+	key    string
+	labels []string
+}
+
+// vec keeps track of the time series of a metric, one for each combination of
+// label values.
+type vec struct {
+	name       string
+	help       string
+	typ        string
+	labelNames []string
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+func newVec(name, help, typ string, labelNames []string) vec {
+	return vec{
+		name:       name,
+		help:       help,
+		typ:        typ,
+		labelNames: labelNames,
+		series:     map[string]*series{},
+	}
+}
+
+func (v *vec) metricName() string {
+	return v.name
+}
+
+// key returns the key of the series with the given label values.
+func (v *vec) key(labelValues []string) string {
+	if len(labelValues) != len(v.labelNames) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labelNames), len(labelValues)))
+	}
+	return strings.Join(labelValues, "\xff")
+}
+
+// lookup returns the key of the series with the given label values, creating
+// the series if it does not exist. v.mu must be held by the caller.
+func (v *vec) lookup(labelValues []string) string {
+	key := v.key(labelValues)
+	if _, ok := v.series[key]; !ok {
+		v.series[key] = &series{key: key, labels: append([]string(nil), labelValues...)}
+	}
+	return key
+}
+
+// sortedSeries returns the series of the metric in a stable order. v.mu must
+// be held by the caller.
+func (v *vec) sortedSeries() []*series {
+	ss := make([]*series, 0, len(v.series))
+	for _, s := range v.series {
+		ss = append(ss, s)
+	}
+	sort.Sort(byKey(ss))
+	return ss
+}
+
+func (v *vec) writeHeader(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", v.name, escapeHelp(v.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", v.name, v.typ)
+}
+
+// formatLabels formats label pairs as {a="b",c="d"}, with extra pairs (such as
+// "le" for histogram buckets) appended.
+func (v *vec) formatLabels(values []string, extra ...string) string {
+	pairs := make([]string, 0, len(values)+len(extra)/2)
+	for i, name := range v.labelNames {
+		pairs = append(pairs, name+`="`+escapeLabelValue(values[i])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escapeLabelValue(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+type byKey []*series
+
+func (s byKey) Len() int           { return len(s) }
+func (s byKey) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byKey) Less(i, j int) bool { return s[i].key < s[j].key }
+
+var (
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabelValue(s string) string {
+	return labelValueEscaper.Replace(s)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,118 @@
+package metrics_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nitrous-io/rise-server/pkg/metrics"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "metrics")
+}
+
+var _ = Describe("Metrics", func() {
+	var r *metrics.Registry
+
+	BeforeEach(func() {
+		r = metrics.NewRegistry()
+	})
+
+	output := func() string {
+		var buf bytes.Buffer
+		Expect(r.Write(&buf)).To(BeNil())
+		return buf.String()
+	}
+
+	Describe("Counter", func() {
+		It("counts per label values", func() {
+			c := r.NewCounter("jobs_total", "Number of jobs.", "queue")
+			c.Inc("build")
+			c.Inc("build")
+			c.Add(3, "deploy")
+
+			Expect(c.Value("build")).To(Equal(float64(2)))
+			Expect(c.Value("push")).To(Equal(float64(0)))
+			Expect(output()).To(Equal(`# HELP jobs_total Number of jobs.
+# TYPE jobs_total counter
+jobs_total{queue="build"} 2
+jobs_total{queue="deploy"} 3
+`))
+		})
+
+		It("escapes label values", func() {
+			c := r.NewCounter("requests_total", "Number of requests.", "route")
+			c.Inc("a\"b\\c\nd")
+
+			Expect(output()).To(ContainSubstring(`requests_total{route="a\"b\\c\nd"} 1`))
+		})
+
+		It("panics when the number of label values is wrong", func() {
+			c := r.NewCounter("jobs_total", "Number of jobs.", "queue")
+			Expect(func() { c.Inc() }).To(Panic())
+		})
+	})
+
+	Describe("Histogram", func() {
+		It("counts observations in cumulative buckets", func() {
+			h := r.NewHistogram("duration_seconds", "Duration.", []float64{1, 0.1}, "queue")
+			h.Observe(0.05, "build")
+			h.Observe(0.5, "build")
+			h.Observe(5, "build")
+
+			Expect(h.Count("build")).To(Equal(uint64(3)))
+			Expect(output()).To(Equal(`# HELP duration_seconds Duration.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{queue="build",le="0.1"} 1
+duration_seconds_bucket{queue="build",le="1"} 2
+duration_seconds_bucket{queue="build",le="+Inf"} 3
+duration_seconds_sum{queue="build"} 5.55
+duration_seconds_count{queue="build"} 3
+`))
+		})
+	})
+
+	It("panics when a metric is registered twice", func() {
+		r.NewCounter("jobs_total", "Number of jobs.")
+		Expect(func() { r.NewCounter("jobs_total", "Number of jobs.") }).To(Panic())
+	})
+
+	It("serves metrics over HTTP", func() {
+		r.NewCounter("jobs_total", "Number of jobs.").Inc()
+
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/metrics", nil)
+		Expect(err).To(BeNil())
+		r.Handler().ServeHTTP(w, req)
+
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Header().Get("Content-Type")).To(Equal(metrics.ContentType))
+		Expect(w.Body.String()).To(ContainSubstring("jobs_total 1\n"))
+	})
+
+	It("pushes metrics to a pushgateway", func() {
+		r.NewCounter("jobs_total", "Number of jobs.").Inc()
+
+		var (
+			method string
+			path   string
+			body   []byte
+		)
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			method, path = req.Method, req.URL.Path
+			body, _ = ioutil.ReadAll(req.Body)
+		}))
+		defer ts.Close()
+
+		Expect(r.Push(ts.URL, "renew-acme-certs")).To(BeNil())
+		Expect(method).To(Equal("PUT"))
+		Expect(path).To(Equal("/metrics/job/renew-acme-certs"))
+		Expect(string(body)).To(ContainSubstring("jobs_total 1\n"))
+	})
+})
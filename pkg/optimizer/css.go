@@ -0,0 +1,136 @@
+package optimizer
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+)
+
+var cssImportRe = regexp.MustCompile(`(?i)@import\b`)
+
+// CSS returns a stage that minifies stylesheets.
+func CSS() Stage {
+	return minifyStage("css", []string{".css"}, MinifyCSS, checkCSS)
+}
+
+func checkCSS(site *Site, file string, b []byte) {
+	for _, loc := range cssImportRe.FindAllIndex(b, -1) {
+		site.Warn(file, lineAt(b, loc[0]), "@import delays loading the stylesheet; consider linking to it from HTML instead")
+	}
+}
+
+// MinifyCSS removes comments and whitespace that are not needed from a
+// stylesheet. Comments starting with "/*!", which are commonly used for
+// licenses, are kept.
+func MinifyCSS(b []byte) ([]byte, error) {
+	var (
+		out   bytes.Buffer
+		line  = 1
+		opens []int // lines of unclosed braces
+		space bool  // whether whitespace was skipped since the last output
+	)
+
+	// emit writes s, preceded by a space if whitespace was skipped and
+	// removing it could change the meaning of the stylesheet.
+	emit := func(s []byte) {
+		if space && out.Len() > 0 {
+			last := out.Bytes()[out.Len()-1]
+			if !strings.ContainsRune("{};,>:(/", rune(last)) && !strings.ContainsRune("{};,>!)", rune(s[0])) {
+				out.WriteByte(' ')
+			}
+		}
+		space = false
+		out.Write(s)
+	}
+
+	for i := 0; i < len(b); {
+		c := b[i]
+		switch {
+		case c == '/' && i+1 < len(b) && b[i+1] == '*':
+			end := bytes.Index(b[i+2:], []byte("*/"))
+			if end < 0 {
+				return nil, &SyntaxError{Line: line, Msg: "unclosed comment"}
+			}
+			comment := b[i : i+2+end+2]
+			if len(comment) > 4 && comment[2] == '!' {
+				emit(comment)
+			} else {
+				// A comment separates the tokens around it.
+				space = true
+			}
+			line += bytes.Count(comment, []byte("\n"))
+			i += len(comment)
+
+		case c == '"' || c == '\'':
+			end, ok := scanString(b, i)
+			if !ok {
+				return nil, &SyntaxError{Line: line, Msg: "unclosed string"}
+			}
+			line += bytes.Count(b[i:end], []byte("\n"))
+			emit(b[i:end])
+			i = end
+
+		case c == '\\' && i+1 < len(b):
+			emit(b[i : i+2])
+			i += 2
+
+		case isSpace(c):
+			if c == '\n' {
+				line++
+			}
+			space = true
+			i++
+
+		case c == '{':
+			opens = append(opens, line)
+			emit(b[i : i+1])
+			i++
+
+		case c == '}':
+			if len(opens) == 0 {
+				return nil, &SyntaxError{Line: line, Msg: "unexpected }"}
+			}
+			opens = opens[:len(opens)-1]
+
+			// The last declaration of a block does not need a semicolon.
+			space = false
+			if n := out.Len(); n > 0 && out.Bytes()[n-1] == ';' {
+				out.Truncate(n - 1)
+			}
+			emit(b[i : i+1])
+			i++
+
+		default:
+			emit(b[i : i+1])
+			i++
+		}
+	}
+
+	if len(opens) > 0 {
+		return nil, &SyntaxError{Line: opens[len(opens)-1], Msg: "unclosed {"}
+	}
+
+	return out.Bytes(), nil
+}
+
+// scanString returns the offset just past the string literal starting at
+// b[i], or false if it is not terminated. A string cannot contain a newline
+// unless it is escaped.
+func scanString(b []byte, i int) (int, bool) {
+	quote := b[i]
+	for j := i + 1; j < len(b); j++ {
+		switch b[j] {
+		case '\\':
+			j++
+		case '\n':
+			return 0, false
+		case quote:
+			return j + 1, true
+		}
+	}
+	return 0, false
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
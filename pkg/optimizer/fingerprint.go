@@ -0,0 +1,267 @@
+package optimizer
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"path"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// FingerprintLength is the number of hex digits of the hash of a file's
+// contents that is added to its name by the fingerprint stage.
+const FingerprintLength = 6
+
+var (
+	fingerprintExts = []string{".css", ".js", ".png", ".jpg", ".jpeg", ".gif", ".svg", ".webp"}
+
+	htmlRefRe      = regexp.MustCompile(`(?i)(\s(?:href|src)\s*=\s*["']?)([^"'\s>]+)`)
+	htmlSrcsetRe   = regexp.MustCompile(`(?i)(\ssrcset\s*=\s*["'])([^"']*)`)
+	cssURLRe       = regexp.MustCompile(`(?i)(url\(\s*["']?)([^"'\s)]+)`)
+	cssImportRefRe = regexp.MustCompile(`(?i)(@import\s+["'])([^"']+)`)
+)
+
+// Fingerprint returns a stage that copies stylesheets, scripts and images to
+// names that contain a hash of their contents, e.g. "js/app.3f2a1c.js", and
+// rewrites the references to them in HTML documents and stylesheets to point
+// to the copies. Since the name of a fingerprinted file changes whenever its
+// contents do, it can be cached forever.
+//
+// Only files that are referenced by a relative or root-relative URL in an
+// HTML document or a stylesheet are fingerprinted. The original files are
+// kept, so that references that are not rewritten, e.g. in a script or from
+// another site, keep working. The copies are listed in Result.Copies.
+func Fingerprint() Stage {
+	return &fingerprint{}
+}
+
+type fingerprint struct{}
+
+func (s *fingerprint) Name() string {
+	return "fingerprint"
+}
+
+func (s *fingerprint) Run(ctx context.Context, site *Site) error {
+	isAsset := map[string]bool{}
+	for _, f := range site.FilesWithExt(fingerprintExts...) {
+		isAsset[f] = true
+	}
+	if len(isAsset) == 0 {
+		return nil
+	}
+
+	htmlFiles := site.FilesWithExt(".html", ".htm")
+	cssFiles := site.FilesWithExt(".css")
+
+	contents := map[string][]byte{}
+	for _, f := range append(htmlFiles, cssFiles...) {
+		b, err := site.ReadFile(f)
+		if err != nil {
+			return err
+		}
+		contents[f] = b
+	}
+
+	// Find the assets that are referenced, and the stylesheets that each
+	// stylesheet imports, which have to be fingerprinted first.
+	referenced := map[string]bool{}
+	imports := map[string][]string{}
+	for f, b := range contents {
+		rewriteRefs(f, b, func(target string) string {
+			if isAsset[target] {
+				referenced[target] = true
+				if isCSS(f) && isCSS(target) && target != f {
+					imports[f] = append(imports[f], target)
+				}
+			}
+			return ""
+		})
+	}
+
+	names := map[string]string{}
+	for f := range referenced {
+		if isCSS(f) {
+			continue
+		}
+		b, err := site.ReadFile(f)
+		if err != nil {
+			return err
+		}
+		names[f] = fingerprintedName(f, b)
+	}
+
+	// Stylesheets are fingerprinted after the references in them have been
+	// rewritten, in an order where every stylesheet comes after the ones it
+	// imports. Those that import each other are left with their names.
+	pending := map[string]bool{}
+	for _, f := range cssFiles {
+		pending[f] = true
+	}
+	for len(pending) > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		var ready []string
+		for _, f := range cssFiles {
+			if !pending[f] {
+				continue
+			}
+			blocked := false
+			for _, imp := range imports[f] {
+				if pending[imp] {
+					blocked = true
+					break
+				}
+			}
+			if !blocked {
+				ready = append(ready, f)
+			}
+		}
+
+		cycle := len(ready) == 0
+		if cycle {
+			for _, f := range cssFiles {
+				if pending[f] {
+					ready = append(ready, f)
+				}
+			}
+		}
+
+		for _, f := range ready {
+			delete(pending, f)
+			contents[f] = rewriteRefs(f, contents[f], func(target string) string {
+				return names[target]
+			})
+			if referenced[f] && !cycle {
+				names[f] = fingerprintedName(f, contents[f])
+			}
+		}
+	}
+
+	for _, f := range htmlFiles {
+		contents[f] = rewriteRefs(f, contents[f], func(target string) string {
+			return names[target]
+		})
+	}
+
+	for f, b := range contents {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		orig, err := site.ReadFile(f)
+		if err != nil {
+			return err
+		}
+		if string(orig) == string(b) {
+			continue
+		}
+		if err := site.WriteFile(f, b); err != nil {
+			return err
+		}
+	}
+
+	for _, f := range site.FilesWithExt(fingerprintExts...) {
+		if newName, ok := names[f]; ok {
+			if err := site.Copy(f, newName); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// fingerprintedName returns file with the hash of b added before its
+// extension.
+func fingerprintedName(file string, b []byte) string {
+	sum := sha1.Sum(b)
+	hash := hex.EncodeToString(sum[:])[:FingerprintLength]
+	ext := path.Ext(file)
+	return strings.TrimSuffix(file, ext) + "." + hash + ext
+}
+
+// rewriteRefs calls rename with the path of each file of the site that is
+// referenced in file, which is an HTML document or a stylesheet with the
+// contents b, and replaces the reference to the file with one to the path
+// returned, unless it is empty.
+func rewriteRefs(file string, b []byte, rename func(target string) string) []byte {
+	rewriteURL := func(u string) string {
+		target := resolveRef(file, u)
+		if target == "" {
+			return u
+		}
+
+		newName := rename(target)
+		if newName == "" {
+			return u
+		}
+
+		p, suffix := u, ""
+		if i := strings.IndexAny(u, "?#"); i >= 0 {
+			p, suffix = u[:i], u[i:]
+		}
+		return p[:strings.LastIndex(p, "/")+1] + path.Base(newName) + suffix
+	}
+
+	replace := func(re *regexp.Regexp, b []byte, rewrite func(s string) string) []byte {
+		return re.ReplaceAllFunc(b, func(m []byte) []byte {
+			sub := re.FindSubmatch(m)
+			return append(append([]byte{}, sub[1]...), rewrite(string(sub[2]))...)
+		})
+	}
+
+	if !isCSS(file) {
+		b = replace(htmlRefRe, b, rewriteURL)
+		b = replace(htmlSrcsetRe, b, func(srcset string) string {
+			candidates := strings.Split(srcset, ",")
+			for i, c := range candidates {
+				fields := strings.Fields(c)
+				if len(fields) == 0 {
+					continue
+				}
+				j := strings.Index(c, fields[0])
+				candidates[i] = c[:j] + rewriteURL(fields[0]) + c[j+len(fields[0]):]
+			}
+			return strings.Join(candidates, ",")
+		})
+	} else {
+		b = replace(cssImportRefRe, b, rewriteURL)
+	}
+	return replace(cssURLRe, b, rewriteURL)
+}
+
+// resolveRef returns the path of the file of the site that the URL u in file
+// refers to, or an empty string if it does not refer to a file of the site by
+// a relative or root-relative URL.
+func resolveRef(file, u string) string {
+	if u == "" || strings.Contains(u, "://") || strings.HasPrefix(u, "//") ||
+		strings.HasPrefix(u, "#") || strings.HasPrefix(strings.ToLower(u), "data:") {
+		return ""
+	}
+
+	p := u
+	if i := strings.IndexAny(u, "?#"); i >= 0 {
+		p = u[:i]
+	}
+	if p == "" {
+		return ""
+	}
+
+	var target string
+	if strings.HasPrefix(p, "/") {
+		target = path.Clean(p)[1:]
+	} else {
+		target = path.Join(path.Dir(file), p)
+	}
+	if strings.HasPrefix(target, "../") {
+		return ""
+	}
+	return target
+}
+
+func isCSS(file string) bool {
+	return strings.ToLower(path.Ext(file)) == ".css"
+}
@@ -0,0 +1,143 @@
+package optimizer_test
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/nitrous-io/rise-server/pkg/optimizer"
+	"golang.org/x/net/context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Fingerprint", func() {
+	var (
+		dir string
+		err error
+	)
+
+	writeFile := func(name, content string) {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		Expect(os.MkdirAll(filepath.Dir(p), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(p, []byte(content), 0644)).To(Succeed())
+	}
+
+	readFile := func(name string) string {
+		b, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		Expect(err).To(BeNil())
+		return string(b)
+	}
+
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name)))
+		return err == nil
+	}
+
+	hash := func(content string) string {
+		sum := sha1.Sum([]byte(content))
+		return hex.EncodeToString(sum[:])[:optimizer.FingerprintLength]
+	}
+
+	run := func() *optimizer.Result {
+		res, err := optimizer.New(optimizer.Fingerprint()).Run(context.Background(), dir, nil)
+		Expect(err).To(BeNil())
+		return res
+	}
+
+	BeforeEach(func() {
+		dir, err = ioutil.TempDir("", "optimizer")
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("copies referenced assets to fingerprinted names and rewrites the references to them", func() {
+		writeFile("index.html", `<link href="/css/app.css" rel="stylesheet"><script src="js/app.js?v=2"></script><img src=images/logo.png srcset="images/logo.png 1x, /images/logo@2x.png 2x"><a href="about.html">About</a>`)
+		writeFile("about/index.html", `<img src="../images/logo.png"><script src="https://cdn.example.com/js/app.js"></script>`)
+		writeFile("css/app.css", `body{background:url("../images/bg.gif")}`)
+		writeFile("js/app.js", "alert(1);")
+		writeFile("images/logo.png", "logo")
+		writeFile("images/logo@2x.png", "logo@2x")
+		writeFile("images/bg.gif", "bg")
+		writeFile("images/unused.png", "unused")
+
+		res := run()
+		Expect(res.Messages).To(BeEmpty())
+
+		bgName := "bg." + hash("bg") + ".gif"
+		css := `body{background:url("../images/` + bgName + `")}`
+		cssName := "app." + hash(css) + ".css"
+		jsName := "app." + hash("alert(1);") + ".js"
+		logoName := "logo." + hash("logo") + ".png"
+		logo2xName := "logo@2x." + hash("logo@2x") + ".png"
+
+		Expect(readFile("index.html")).To(Equal(`<link href="/css/` + cssName + `" rel="stylesheet"><script src="js/` + jsName + `?v=2"></script><img src=images/` + logoName + ` srcset="images/` + logoName + ` 1x, /images/` + logo2xName + ` 2x"><a href="about.html">About</a>`))
+		Expect(readFile("about/index.html")).To(Equal(`<img src="../images/` + logoName + `"><script src="https://cdn.example.com/js/app.js"></script>`))
+		Expect(readFile("css/" + cssName)).To(Equal(css))
+
+		Expect(readFile("js/" + jsName)).To(Equal("alert(1);"))
+		Expect(readFile("images/" + logoName)).To(Equal("logo"))
+		Expect(exists("images/unused.png")).To(BeTrue())
+
+		Expect(res.Copies).To(Equal(map[string]string{
+			"css/app.css":        "css/" + cssName,
+			"js/app.js":          "js/" + jsName,
+			"images/bg.gif":      "images/" + bgName,
+			"images/logo.png":    "images/" + logoName,
+			"images/logo@2x.png": "images/" + logo2xName,
+		}))
+	})
+
+	It("fingerprints imported stylesheets before the ones that import them", func() {
+		writeFile("index.html", `<link href="/css/main.css" rel="stylesheet">`)
+		writeFile("css/main.css", `@import "base.css";`)
+		writeFile("css/base.css", `@import url(reset.css);`)
+		writeFile("css/reset.css", `*{margin:0}`)
+
+		res := run()
+
+		resetName := "reset." + hash(`*{margin:0}`) + ".css"
+		base := `@import url(` + resetName + `);`
+		baseName := "base." + hash(base) + ".css"
+		main := `@import "` + baseName + `";`
+		mainName := "main." + hash(main) + ".css"
+
+		Expect(readFile("css/" + mainName)).To(Equal(main))
+		Expect(readFile("css/" + baseName)).To(Equal(base))
+		Expect(readFile("css/" + resetName)).To(Equal(`*{margin:0}`))
+		Expect(res.Copies).To(HaveLen(3))
+	})
+
+	It("leaves stylesheets that import each other with their names", func() {
+		writeFile("index.html", `<link href="a.css" rel="stylesheet">`)
+		writeFile("a.css", `@import "b.css";`)
+		writeFile("b.css", `@import "a.css";`)
+
+		res := run()
+
+		Expect(readFile("index.html")).To(Equal(`<link href="a.css" rel="stylesheet">`))
+		Expect(readFile("a.css")).To(Equal(`@import "b.css";`))
+		Expect(res.Copies).To(BeEmpty())
+	})
+
+	It("keeps the original assets, so that references that are not rewritten keep working", func() {
+		writeFile("index.html", `<link href="/css/app.css" rel="stylesheet"><img src="/images/logo.png"><script src="/js/app.js"></script>`)
+		writeFile("css/app.css", `body{color:red}`)
+		writeFile("images/logo.png", "logo")
+		writeFile("js/app.js", `var img = new Image(); img.src = "/images/logo.png";`)
+
+		res := run()
+		Expect(res.Messages).To(BeEmpty())
+		Expect(res.Copies).To(HaveKey("images/logo.png"))
+
+		Expect(readFile("js/app.js")).To(Equal(`var img = new Image(); img.src = "/images/logo.png";`))
+		Expect(readFile("images/logo.png")).To(Equal("logo"))
+		Expect(readFile("css/app.css")).To(Equal(`body{color:red}`))
+	})
+})
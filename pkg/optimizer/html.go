@@ -0,0 +1,172 @@
+package optimizer
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+)
+
+// rawTextElements are the elements whose contents are copied as they are.
+var rawTextElements = map[string]bool{
+	"script":   true,
+	"style":    true,
+	"pre":      true,
+	"textarea": true,
+}
+
+var insecureResourceRe = regexp.MustCompile(`(?i)<(?:script|link)\b[^>]*?\s(?:src|href)\s*=\s*["']?(http://[^"'\s>]+)`)
+
+// HTML returns a stage that minifies HTML documents.
+func HTML() Stage {
+	return minifyStage("html", []string{".html", ".htm"}, MinifyHTML, checkHTML)
+}
+
+func checkHTML(site *Site, file string, b []byte) {
+	for _, m := range insecureResourceRe.FindAllSubmatchIndex(b, -1) {
+		url := string(b[m[2]:m[3]])
+		site.Warn(file, lineAt(b, m[0]), "browsers block "+url+" when the page is loaded over HTTPS; use an https:// or protocol-relative URL instead")
+	}
+}
+
+// MinifyHTML removes comments from an HTML document and collapses whitespace
+// to a single space. Conditional comments, and the contents of script, style,
+// pre and textarea elements, are kept as they are.
+func MinifyHTML(b []byte) ([]byte, error) {
+	var (
+		out   bytes.Buffer
+		line  = 1
+		space bool // whether whitespace was skipped since the last output
+	)
+
+	emit := func(s []byte) {
+		if space && out.Len() > 0 {
+			out.WriteByte(' ')
+		}
+		space = false
+		out.Write(s)
+	}
+
+	for i := 0; i < len(b); {
+		c := b[i]
+		switch {
+		case bytes.HasPrefix(b[i:], []byte("<!--")):
+			end := bytes.Index(b[i+4:], []byte("-->"))
+			if end < 0 {
+				return nil, &SyntaxError{Line: line, Msg: "unclosed comment"}
+			}
+			comment := b[i : i+4+end+3]
+			if bytes.HasPrefix(comment, []byte("<!--[if")) || bytes.HasPrefix(comment, []byte("<!--<![")) {
+				emit(comment)
+			}
+			line += bytes.Count(comment, []byte("\n"))
+			i += len(comment)
+
+		case c == '<' && i+1 < len(b) && (b[i+1] == '!' || b[i+1] == '?'):
+			end := bytes.IndexByte(b[i:], '>')
+			if end < 0 {
+				return nil, &SyntaxError{Line: line, Msg: "unclosed declaration"}
+			}
+			decl := b[i : i+end+1]
+			line += bytes.Count(decl, []byte("\n"))
+			emit(decl)
+			i += len(decl)
+
+		case c == '<' && i+1 < len(b) && (isLetter(b[i+1]) || b[i+1] == '/'):
+			tag, name, end, err := scanTag(b, i, line)
+			if err != nil {
+				return nil, err
+			}
+			line += bytes.Count(b[i:end], []byte("\n"))
+			emit(tag)
+			i = end
+
+			if !rawTextElements[name] || bytes.HasSuffix(tag, []byte("/>")) {
+				continue
+			}
+
+			// Copy the contents of the element up to its closing tag.
+			closing := bytes.Index(bytes.ToLower(b[i:]), []byte("</"+name))
+			if closing < 0 {
+				return nil, &SyntaxError{Line: line, Msg: "unclosed <" + name + ">"}
+			}
+			line += bytes.Count(b[i:i+closing], []byte("\n"))
+			out.Write(b[i : i+closing])
+			i += closing
+
+		case isSpace(c):
+			if c == '\n' {
+				line++
+			}
+			space = true
+			i++
+
+		default:
+			j := i + 1
+			for j < len(b) && !isSpace(b[j]) && b[j] != '<' {
+				j++
+			}
+			emit(b[i:j])
+			i = j
+		}
+	}
+
+	return out.Bytes(), nil
+}
+
+// scanTag scans the start or end tag starting at b[i]. It returns the tag with
+// whitespace between attributes collapsed, the lowercased name of the element
+// ("" for end tags), and the offset just past the tag.
+func scanTag(b []byte, i, line int) (tag []byte, name string, end int, err error) {
+	var out bytes.Buffer
+	out.WriteByte('<')
+
+	j := i + 1
+	for j < len(b) && !isSpace(b[j]) && b[j] != '>' && b[j] != '/' || j == i+1 {
+		j++
+	}
+	out.Write(b[i+1 : j])
+	if b[i+1] != '/' {
+		name = strings.ToLower(string(b[i+1 : j]))
+	}
+
+	// Whitespace is only needed between attributes, not around the equals
+	// sign or before the end of the tag.
+	space := false
+	writeSpace := func(next byte) {
+		if space && next != '=' && out.Bytes()[out.Len()-1] != '=' {
+			out.WriteByte(' ')
+		}
+		space = false
+	}
+
+	for ; j < len(b); j++ {
+		c := b[j]
+		switch {
+		case c == '>':
+			out.WriteByte('>')
+			return out.Bytes(), name, j + 1, nil
+
+		case isSpace(c):
+			space = true
+
+		case c == '"' || c == '\'':
+			q := bytes.IndexByte(b[j+1:], c)
+			if q < 0 {
+				return nil, "", 0, &SyntaxError{Line: line, Msg: "unclosed attribute value in <" + name + ">"}
+			}
+			writeSpace(c)
+			out.Write(b[j : j+1+q+1])
+			j += q + 1
+
+		default:
+			writeSpace(c)
+			out.WriteByte(c)
+		}
+	}
+
+	return nil, "", 0, &SyntaxError{Line: line, Msg: "unclosed tag"}
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
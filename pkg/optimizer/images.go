@@ -0,0 +1,258 @@
+package optimizer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"path"
+	"strings"
+)
+
+// JPEG markers.
+const (
+	markerSOI  = 0xd8
+	markerSOS  = 0xda
+	markerAPP1 = 0xe1
+	markerAPP2 = 0xe2
+	markerCOM  = 0xfe
+)
+
+var (
+	exifHeader = []byte("Exif\x00\x00")
+	iccHeader  = []byte("ICC_PROFILE\x00")
+
+	pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+	// pngColorChunks are the types of the ancillary PNG chunks that affect the
+	// colors that an image is displayed in.
+	pngColorChunks = map[string]bool{
+		"iCCP": true,
+		"sRGB": true,
+		"gAMA": true,
+		"cHRM": true,
+	}
+
+	errMalformedJPEG = errors.New("malformed JPEG")
+	errMalformedPNG  = errors.New("malformed PNG")
+)
+
+// Images returns a stage that recompresses PNG, JPEG and GIF images. PNG and
+// GIF images are always recompressed losslessly. JPEG images are re-encoded at
+// jpegQuality if it is between 1 and 100; otherwise, only metadata that does
+// not affect how they are displayed is removed. Images that cannot be decoded
+// are left alone with a warning.
+func Images(jpegQuality int) Stage {
+	return &fileStage{
+		name: "images",
+		exts: []string{".png", ".jpg", ".jpeg", ".gif"},
+		transform: func(site *Site, file string, b []byte) ([]byte, error) {
+			var (
+				out []byte
+				err error
+			)
+
+			switch strings.ToLower(path.Ext(file)) {
+			case ".png":
+				out, err = RecompressPNG(b)
+			case ".gif":
+				out, err = RecompressGIF(b)
+			default:
+				if jpegQuality >= 1 && jpegQuality <= 100 {
+					out, err = RecompressJPEG(b, jpegQuality)
+				} else {
+					out, err = StripJPEG(b)
+				}
+			}
+
+			if err != nil {
+				site.Warn(file, 0, "could not be optimized: "+err.Error())
+				return b, nil
+			}
+			return smallest(b, out), nil
+		},
+	}
+}
+
+// RecompressPNG re-encodes a PNG image with the best compression. The chunks
+// that affect the colors the image is displayed in, such as its color
+// profile, are kept; other ancillary chunks, such as text, are not.
+func RecompressPNG(b []byte) ([]byte, error) {
+	chunks, err := pngChunks(b)
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := png.Decode(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	enc := &png.Encoder{CompressionLevel: png.BestCompression}
+	if err := enc.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	encoded := buf.Bytes()
+
+	encodedChunks, err := pngChunks(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	// Color chunks must come before PLTE and IDAT, so they are put right
+	// after IHDR, which is always the first chunk.
+	out := append([]byte{}, pngSignature...)
+	out = append(out, encodedChunks[0]...)
+	for _, chunk := range chunks {
+		if pngColorChunks[string(chunk[4:8])] {
+			out = append(out, chunk...)
+		}
+	}
+	for _, chunk := range encodedChunks[1:] {
+		out = append(out, chunk...)
+	}
+	return out, nil
+}
+
+// pngChunks splits a PNG image into its chunks, including their lengths,
+// types and CRCs. The first chunk is IHDR.
+func pngChunks(b []byte) ([][]byte, error) {
+	if !bytes.HasPrefix(b, pngSignature) {
+		return nil, errMalformedPNG
+	}
+
+	var chunks [][]byte
+	i := len(pngSignature)
+	for i < len(b) {
+		if i+12 > len(b) {
+			return nil, errMalformedPNG
+		}
+
+		n := int(binary.BigEndian.Uint32(b[i : i+4]))
+		if n < 0 || i+12+n > len(b) {
+			return nil, errMalformedPNG
+		}
+		chunks = append(chunks, b[i:i+12+n])
+		i += 12 + n
+	}
+
+	if len(chunks) == 0 || string(chunks[0][4:8]) != "IHDR" {
+		return nil, errMalformedPNG
+	}
+	return chunks, nil
+}
+
+// RecompressGIF re-encodes a GIF image, including all of its frames.
+func RecompressGIF(b []byte) ([]byte, error) {
+	g, err := gif.DecodeAll(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// RecompressJPEG re-encodes a JPEG image at the given quality, which is lossy.
+// The EXIF data and color profile of the original are kept so that the image
+// is still displayed the right way up and in the right colors.
+func RecompressJPEG(b []byte, quality int) ([]byte, error) {
+	segments, _, err := jpegSegments(b)
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := jpeg.Decode(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, err
+	}
+	encoded := buf.Bytes()
+
+	out := append([]byte{}, encoded[:2]...) // SOI
+	for _, seg := range segments {
+		if isEXIF(seg) || isICCProfile(seg) {
+			out = append(out, seg...)
+		}
+	}
+	return append(out, encoded[2:]...), nil
+}
+
+// StripJPEG removes comments and application segments other than JFIF, EXIF,
+// ICC profiles and Adobe color transforms from a JPEG image. The image data
+// is copied as it is, so this is lossless.
+func StripJPEG(b []byte) ([]byte, error) {
+	segments, rest, err := jpegSegments(b)
+	if err != nil {
+		return nil, err
+	}
+
+	out := append([]byte{}, b[:2]...) // SOI
+	for _, seg := range segments {
+		marker := seg[1]
+		switch {
+		case marker == markerCOM:
+			continue
+		case marker == markerAPP1 && !isEXIF(seg):
+			// XMP and other metadata.
+			continue
+		case marker >= 0xe3 && marker <= 0xef && marker != 0xee:
+			// APP3 to APP15, except APP14 which holds Adobe's color
+			// transform.
+			continue
+		}
+		out = append(out, seg...)
+	}
+	return append(out, rest...), nil
+}
+
+// jpegSegments splits a JPEG image into the marker segments that come before
+// the image data, including their markers, and the rest of the image starting
+// with the start of scan marker.
+func jpegSegments(b []byte) (segments [][]byte, rest []byte, err error) {
+	if len(b) < 4 || b[0] != 0xff || b[1] != markerSOI {
+		return nil, nil, errMalformedJPEG
+	}
+
+	i := 2
+	for i+4 <= len(b) {
+		if b[i] != 0xff {
+			return nil, nil, errMalformedJPEG
+		}
+		marker := b[i+1]
+		if marker == 0xff {
+			// Fill byte.
+			i++
+			continue
+		}
+		if marker == markerSOS {
+			return segments, b[i:], nil
+		}
+
+		n := int(binary.BigEndian.Uint16(b[i+2 : i+4]))
+		if n < 2 || i+2+n > len(b) {
+			return nil, nil, errMalformedJPEG
+		}
+		segments = append(segments, b[i:i+2+n])
+		i += 2 + n
+	}
+	return nil, nil, errMalformedJPEG
+}
+
+func isEXIF(seg []byte) bool {
+	return seg[1] == markerAPP1 && bytes.HasPrefix(seg[4:], exifHeader)
+}
+
+func isICCProfile(seg []byte) bool {
+	return seg[1] == markerAPP2 && bytes.HasPrefix(seg[4:], iccHeader)
+}
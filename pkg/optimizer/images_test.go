@@ -0,0 +1,233 @@
+package optimizer_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nitrous-io/rise-server/pkg/optimizer"
+	"golang.org/x/net/context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Images", func() {
+	var (
+		dir string
+		img *image.RGBA
+		err error
+	)
+
+	// segment returns a JPEG marker segment with the given payload.
+	segment := func(marker byte, payload string) []byte {
+		n := len(payload) + 2
+		return append([]byte{0xff, marker, byte(n >> 8), byte(n)}, payload...)
+	}
+
+	// withSegments inserts segments right after the start of image marker.
+	withSegments := func(b []byte, segments ...[]byte) []byte {
+		out := append([]byte{}, b[:2]...)
+		for _, seg := range segments {
+			out = append(out, seg...)
+		}
+		return append(out, b[2:]...)
+	}
+
+	// pngChunk returns a PNG chunk of the given type with the given data.
+	pngChunk := func(typ, data string) []byte {
+		chunk := make([]byte, 4, 12+len(data))
+		binary.BigEndian.PutUint32(chunk, uint32(len(data)))
+		chunk = append(chunk, typ+data...)
+		crc := make([]byte, 4)
+		binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE(chunk[4:]))
+		return append(chunk, crc...)
+	}
+
+	// pngChunkTypes returns the types of the chunks of a PNG image in order.
+	pngChunkTypes := func(b []byte) []string {
+		var types []string
+		for i := 8; i+8 <= len(b); {
+			n := int(binary.BigEndian.Uint32(b[i : i+4]))
+			types = append(types, string(b[i+4:i+8]))
+			i += 12 + n
+		}
+		return types
+	}
+
+	encodeJPEG := func() []byte {
+		var buf bytes.Buffer
+		Expect(jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100})).To(Succeed())
+		return buf.Bytes()
+	}
+
+	writeFile := func(name string, b []byte) {
+		Expect(ioutil.WriteFile(filepath.Join(dir, name), b, 0644)).To(Succeed())
+	}
+
+	readFile := func(name string) []byte {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		Expect(err).To(BeNil())
+		return b
+	}
+
+	BeforeEach(func() {
+		dir, err = ioutil.TempDir("", "optimizer")
+		Expect(err).To(BeNil())
+
+		img = image.NewRGBA(image.Rect(0, 0, 64, 64))
+		for x := 0; x < 64; x++ {
+			for y := 0; y < 64; y++ {
+				img.Set(x, y, color.RGBA{uint8(x * 4), uint8(y * 4), 128, 255})
+			}
+		}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("recompresses PNG images losslessly", func() {
+		var buf bytes.Buffer
+		enc := &png.Encoder{CompressionLevel: png.NoCompression}
+		Expect(enc.Encode(&buf, img)).To(Succeed())
+		writeFile("a.png", buf.Bytes())
+
+		res, err := optimizer.New(optimizer.Images(0)).Run(context.Background(), dir, nil)
+		Expect(err).To(BeNil())
+
+		out := readFile("a.png")
+		Expect(len(out)).To(BeNumerically("<", buf.Len()))
+
+		decoded, err := png.Decode(bytes.NewReader(out))
+		Expect(err).To(BeNil())
+		for x := 0; x < 64; x++ {
+			for y := 0; y < 64; y++ {
+				r1, g1, b1, a1 := img.At(x, y).RGBA()
+				r2, g2, b2, a2 := decoded.At(x, y).RGBA()
+				Expect([]uint32{r2, g2, b2, a2}).To(Equal([]uint32{r1, g1, b1, a1}))
+			}
+		}
+
+		Expect(res.Savings).To(HaveLen(1))
+		Expect(res.Savings[0].Stage).To(Equal("images"))
+		Expect(res.Savings[0].File).To(Equal("a.png"))
+		Expect(res.Savings[0].OriginalSize).To(Equal(int64(buf.Len())))
+		Expect(res.Savings[0].Size).To(Equal(int64(len(out))))
+	})
+
+	It("keeps the chunks of PNG images that affect their colors", func() {
+		var buf bytes.Buffer
+		enc := &png.Encoder{CompressionLevel: png.NoCompression}
+		Expect(enc.Encode(&buf, img)).To(Succeed())
+		encoded := buf.Bytes()
+
+		// Insert the chunks right after the signature and IHDR.
+		ihdrEnd := 8 + 12 + int(binary.BigEndian.Uint32(encoded[8:12]))
+		var orig []byte
+		orig = append(orig, encoded[:ihdrEnd]...)
+		orig = append(orig, pngChunk("iCCP", "profile\x00\x00not-really-compressed")...)
+		orig = append(orig, pngChunk("sRGB", "\x00")...)
+		orig = append(orig, pngChunk("gAMA", "\x00\x00\xb1\x8f")...)
+		orig = append(orig, pngChunk("cHRM", strings.Repeat("\x00", 32))...)
+		orig = append(orig, pngChunk("tEXt", "Comment\x00made with love")...)
+		orig = append(orig, encoded[ihdrEnd:]...)
+		writeFile("a.png", orig)
+
+		_, err := optimizer.New(optimizer.Images(0)).Run(context.Background(), dir, nil)
+		Expect(err).To(BeNil())
+
+		out := readFile("a.png")
+		Expect(len(out)).To(BeNumerically("<", len(orig)))
+
+		types := pngChunkTypes(out)
+		Expect(types[:5]).To(Equal([]string{"IHDR", "iCCP", "sRGB", "gAMA", "cHRM"}))
+		Expect(types).NotTo(ContainElement("tEXt"))
+		Expect(types[len(types)-1]).To(Equal("IEND"))
+
+		decoded, err := png.Decode(bytes.NewReader(out))
+		Expect(err).To(BeNil())
+		for x := 0; x < 64; x++ {
+			for y := 0; y < 64; y++ {
+				r1, g1, b1, a1 := img.At(x, y).RGBA()
+				r2, g2, b2, a2 := decoded.At(x, y).RGBA()
+				Expect([]uint32{r2, g2, b2, a2}).To(Equal([]uint32{r1, g1, b1, a1}))
+			}
+		}
+	})
+
+	It("removes metadata from JPEG images without re-encoding them", func() {
+		orig := encodeJPEG()
+		exif := segment(0xe1, "Exif\x00\x00orientation")
+		withMetadata := withSegments(orig,
+			exif,
+			segment(0xe1, "http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta/>"),
+			segment(0xfe, "Created with GIMP"),
+			segment(0xed, "Photoshop 3.0\x00"),
+		)
+		writeFile("a.jpg", withMetadata)
+
+		res, err := optimizer.New(optimizer.Images(0)).Run(context.Background(), dir, nil)
+		Expect(err).To(BeNil())
+
+		Expect(readFile("a.jpg")).To(Equal(withSegments(orig, exif)))
+		Expect(res.Savings).To(HaveLen(1))
+		Expect(res.Savings[0].BytesSaved()).To(Equal(int64(len(withMetadata) - len(orig) - len(exif))))
+	})
+
+	It("re-encodes JPEG images at the quality that is given, keeping EXIF data", func() {
+		exif := segment(0xe1, "Exif\x00\x00orientation")
+		orig := withSegments(encodeJPEG(), exif)
+		writeFile("a.jpeg", orig)
+
+		_, err := optimizer.New(optimizer.Images(50)).Run(context.Background(), dir, nil)
+		Expect(err).To(BeNil())
+
+		out := readFile("a.jpeg")
+		Expect(len(out)).To(BeNumerically("<", len(orig)))
+		Expect(out).To(ContainSubstring("Exif\x00\x00orientation"))
+
+		_, err = jpeg.Decode(bytes.NewReader(out))
+		Expect(err).To(BeNil())
+	})
+
+	It("recompresses GIF images", func() {
+		var buf bytes.Buffer
+		Expect(gif.Encode(&buf, img, nil)).To(Succeed())
+		writeFile("a.gif", buf.Bytes())
+
+		res, err := optimizer.New(optimizer.Images(0)).Run(context.Background(), dir, nil)
+		Expect(err).To(BeNil())
+		Expect(res.Messages).To(BeEmpty())
+
+		// Go's encoder produces the same output, so there is nothing to save.
+		Expect(readFile("a.gif")).To(Equal(buf.Bytes()))
+		Expect(res.Savings).To(BeEmpty())
+	})
+
+	It("leaves images that cannot be decoded alone with a warning", func() {
+		writeFile("broken.png", []byte("not a png"))
+		writeFile("broken.jpg", []byte("not a jpeg"))
+
+		res, err := optimizer.New(optimizer.Images(0)).Run(context.Background(), dir, nil)
+		Expect(err).To(BeNil())
+
+		Expect(readFile("broken.png")).To(Equal([]byte("not a png")))
+		Expect(readFile("broken.jpg")).To(Equal([]byte("not a jpeg")))
+
+		Expect(res.Errors()).To(BeEmpty())
+		Expect(res.Warnings()).To(HaveLen(2))
+		Expect(res.Warnings()[0].File).To(Equal("broken.jpg"))
+		Expect(res.Warnings()[0].Text).To(Equal("could not be optimized: malformed JPEG"))
+		Expect(res.Warnings()[1].File).To(Equal("broken.png"))
+	})
+})
@@ -0,0 +1,282 @@
+package optimizer
+
+import (
+	"bytes"
+	"strings"
+)
+
+// regexpKeywords are the keywords after which a slash starts a regular
+// expression rather than being a division.
+var regexpKeywords = map[string]bool{
+	"return":     true,
+	"typeof":     true,
+	"instanceof": true,
+	"case":       true,
+	"do":         true,
+	"else":       true,
+	"in":         true,
+	"of":         true,
+	"new":        true,
+	"delete":     true,
+	"void":       true,
+	"throw":      true,
+	"yield":      true,
+	"await":      true,
+}
+
+var closingBracket = map[byte]byte{'(': ')', '[': ']', '{': '}'}
+
+// JS returns a stage that minifies scripts.
+func JS() Stage {
+	return minifyStage("js", []string{".js"}, MinifyJS, nil)
+}
+
+// MinifyJS removes comments and whitespace that are not needed from a script.
+// It does not rename or restructure anything, and keeps line breaks where
+// they might end a statement, so it is safe for scripts that rely on
+// automatic semicolon insertion. Comments starting with "/*!" are kept.
+func MinifyJS(b []byte) ([]byte, error) {
+	var (
+		out  bytes.Buffer
+		line = 1
+
+		// Brackets that have not been closed yet, and the lines they are on.
+		brackets []byte
+		lines    []int
+
+		space   bool // whether whitespace was skipped since the last output
+		newline bool // whether that whitespace included a line break
+
+		lastWord  string // the last token, if it was an identifier or keyword
+		lastIsRe  bool   // whether the last token was a regular expression
+		lastIsNum bool   // whether the last token was a number
+	)
+
+	lastByte := func() byte {
+		if out.Len() == 0 {
+			return 0
+		}
+		return out.Bytes()[out.Len()-1]
+	}
+
+	// emit writes the token s, preceded by whatever whitespace is needed to
+	// keep the meaning of the script.
+	emit := func(s []byte) {
+		if space && out.Len() > 0 {
+			last, next := lastByte(), s[0]
+			switch {
+			case newline && !strings.ContainsRune("{;,([", rune(last)) && !strings.ContainsRune(")]};,", rune(next)):
+				out.WriteByte('\n')
+			case (isWordByte(last) || lastIsRe) && isWordByte(next),
+				lastIsNum && next == '.',
+				(last == '+' || last == '-' || last == '/') && last == next:
+				out.WriteByte(' ')
+			}
+		}
+		space, newline = false, false
+		lastWord, lastIsRe, lastIsNum = "", false, false
+		out.Write(s)
+	}
+
+	regexpAllowed := func() bool {
+		if lastIsRe || lastIsNum {
+			return false
+		}
+		if lastWord != "" {
+			return regexpKeywords[lastWord]
+		}
+		last := lastByte()
+		return last == 0 || strings.ContainsRune("(,=:[!&|?{};+-*%<>~^", rune(last))
+	}
+
+	for i := 0; i < len(b); {
+		c := b[i]
+		switch {
+		case c == '/' && i+1 < len(b) && b[i+1] == '/':
+			end := bytes.IndexByte(b[i:], '\n')
+			if end < 0 {
+				end = len(b) - i
+			}
+			space = true
+			i += end
+
+		case c == '/' && i+1 < len(b) && b[i+1] == '*':
+			end := bytes.Index(b[i+2:], []byte("*/"))
+			if end < 0 {
+				return nil, &SyntaxError{Line: line, Msg: "unclosed comment"}
+			}
+			comment := b[i : i+2+end+2]
+			n := bytes.Count(comment, []byte("\n"))
+			if len(comment) > 4 && comment[2] == '!' {
+				emit(comment)
+				// Make sure that whatever follows the comment is not on
+				// the same line if the comment ended one.
+				space, newline = true, true
+			} else {
+				space = true
+				newline = newline || n > 0
+			}
+			line += n
+			i += len(comment)
+
+		case c == '/' && regexpAllowed():
+			end, ok := scanRegexp(b, i)
+			if !ok {
+				return nil, &SyntaxError{Line: line, Msg: "unclosed regular expression"}
+			}
+			emit(b[i:end])
+			lastIsRe = true
+			i = end
+
+		case c == '"' || c == '\'':
+			end, ok := scanString(b, i)
+			if !ok {
+				return nil, &SyntaxError{Line: line, Msg: "unclosed string"}
+			}
+			line += bytes.Count(b[i:end], []byte("\n"))
+			emit(b[i:end])
+			i = end
+
+		case c == '`':
+			end, ok := scanTemplate(b, i)
+			if !ok {
+				return nil, &SyntaxError{Line: line, Msg: "unclosed template literal"}
+			}
+			line += bytes.Count(b[i:end], []byte("\n"))
+			emit(b[i:end])
+			i = end
+
+		case isSpace(c):
+			if c == '\n' {
+				line++
+				newline = true
+			}
+			space = true
+			i++
+
+		case isWordByte(c):
+			j := i + 1
+			for j < len(b) && isWordByte(b[j]) {
+				j++
+			}
+			isNum := c >= '0' && c <= '9'
+			emit(b[i:j])
+			if isNum {
+				lastIsNum = true
+			} else {
+				lastWord = string(b[i:j])
+			}
+			i = j
+
+		case c == '(' || c == '[' || c == '{':
+			brackets = append(brackets, c)
+			lines = append(lines, line)
+			emit(b[i : i+1])
+			i++
+
+		case c == ')' || c == ']' || c == '}':
+			n := len(brackets)
+			if n == 0 || closingBracket[brackets[n-1]] != c {
+				return nil, &SyntaxError{Line: line, Msg: "unexpected " + string(c)}
+			}
+			brackets, lines = brackets[:n-1], lines[:n-1]
+			emit(b[i : i+1])
+			i++
+
+		default:
+			emit(b[i : i+1])
+			i++
+		}
+	}
+
+	if n := len(brackets); n > 0 {
+		return nil, &SyntaxError{Line: lines[n-1], Msg: "unclosed " + string(brackets[n-1])}
+	}
+
+	return out.Bytes(), nil
+}
+
+// scanRegexp returns the offset just past the regular expression literal,
+// including its flags, starting at b[i], or false if it is not terminated.
+func scanRegexp(b []byte, i int) (int, bool) {
+	inClass := false
+	for j := i + 1; j < len(b); j++ {
+		switch b[j] {
+		case '\\':
+			j++
+		case '\n':
+			return 0, false
+		case '[':
+			inClass = true
+		case ']':
+			inClass = false
+		case '/':
+			if inClass {
+				continue
+			}
+			j++
+			for j < len(b) && isWordByte(b[j]) {
+				j++
+			}
+			return j, true
+		}
+	}
+	return 0, false
+}
+
+// scanTemplate returns the offset just past the template literal starting at
+// b[i], or false if it is not terminated.
+func scanTemplate(b []byte, i int) (int, bool) {
+	for j := i + 1; j < len(b); j++ {
+		switch {
+		case b[j] == '\\':
+			j++
+		case b[j] == '`':
+			return j + 1, true
+		case b[j] == '$' && j+1 < len(b) && b[j+1] == '{':
+			end, ok := scanTemplateExpr(b, j+2)
+			if !ok {
+				return 0, false
+			}
+			j = end - 1
+		}
+	}
+	return 0, false
+}
+
+// scanTemplateExpr returns the offset just past the closing brace of the
+// expression starting at b[i] in a template literal.
+func scanTemplateExpr(b []byte, i int) (int, bool) {
+	depth := 0
+	for j := i; j < len(b); j++ {
+		switch b[j] {
+		case '"', '\'':
+			end, ok := scanString(b, j)
+			if !ok {
+				return 0, false
+			}
+			j = end - 1
+		case '`':
+			end, ok := scanTemplate(b, j)
+			if !ok {
+				return 0, false
+			}
+			j = end - 1
+		case '{':
+			depth++
+		case '}':
+			if depth == 0 {
+				return j + 1, true
+			}
+			depth--
+		}
+	}
+	return 0, false
+}
+
+// isWordByte reports whether c can be part of an identifier, keyword or
+// number. Bytes of multi-byte UTF-8 characters are treated as such.
+func isWordByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '_' || c == '$' || c == '\\' || c >= 0x80
+}
@@ -0,0 +1,145 @@
+package optimizer
+
+import (
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+var (
+	linkRe = regexp.MustCompile(`(?i)\s(?:href|src)\s*=\s*["']?([^"'\s>]+)`)
+	idRe   = regexp.MustCompile(`(?i)\s(?:id|name)\s*=\s*["']?([^"'\s>]+)`)
+
+	// generatedFiles are served for every deployment without being in its
+	// bundle.
+	generatedFiles = map[string]bool{
+		"jsenv.js": true,
+	}
+)
+
+// BrokenLinkPrefix starts the text of every message recorded by the links
+// stage.
+const BrokenLinkPrefix = "broken link to "
+
+// CheckLinks returns a stage that looks for links and references to files in
+// HTML documents that do not exist in the site, and for links to fragments
+// that no element has as its ID. Each of them is recorded as a warning; files
+// are not modified. Links to the given redirected paths are not broken.
+func CheckLinks(redirectedPaths []string) Stage {
+	redirected := map[string]bool{}
+	for _, p := range redirectedPaths {
+		redirected[path.Clean("/"+p)] = true
+	}
+	return &checkLinks{redirected: redirected}
+}
+
+type checkLinks struct {
+	redirected map[string]bool
+}
+
+func (s *checkLinks) Name() string {
+	return "links"
+}
+
+func (s *checkLinks) Run(ctx context.Context, site *Site) error {
+	exists := map[string]bool{}
+	for _, f := range site.Files {
+		exists[f] = true
+	}
+
+	// ids caches the IDs of the elements of each HTML document.
+	ids := map[string]map[string]bool{}
+	idsOf := func(file string) (map[string]bool, error) {
+		if m, ok := ids[file]; ok {
+			return m, nil
+		}
+		b, err := site.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		m := map[string]bool{}
+		for _, sub := range idRe.FindAllSubmatch(b, -1) {
+			m[string(sub[1])] = true
+		}
+		ids[file] = m
+		return m, nil
+	}
+
+	for _, file := range site.FilesWithExt(".html", ".htm") {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		b, err := site.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		for _, loc := range linkRe.FindAllSubmatchIndex(b, -1) {
+			link := string(b[loc[2]:loc[3]])
+			u, err := url.Parse(link)
+			if err != nil || u.Scheme != "" || u.Host != "" || strings.HasPrefix(link, "//") {
+				continue
+			}
+
+			target := file
+			if u.Path != "" {
+				target = s.resolve(file, u.Path, exists)
+				if target == "" {
+					site.Warn(file, lineAt(b, loc[2]), BrokenLinkPrefix+link)
+					continue
+				}
+			}
+
+			if u.Fragment == "" || u.Fragment == "top" || !isHTML(target) {
+				continue
+			}
+
+			targetIDs, err := idsOf(target)
+			if err != nil {
+				return err
+			}
+			if !targetIDs[u.Fragment] {
+				site.Warn(file, lineAt(b, loc[2]), BrokenLinkPrefix+link+` (no element with ID "`+u.Fragment+`")`)
+			}
+		}
+	}
+	return nil
+}
+
+// resolve returns the file that is served for a link to p from file, or an
+// empty string if there is none. Redirected and generated paths resolve to
+// themselves.
+func (s *checkLinks) resolve(file, p string, exists map[string]bool) string {
+	var target string
+	if strings.HasPrefix(p, "/") {
+		target = path.Clean(p)[1:]
+	} else {
+		target = path.Join(path.Dir(file), p)
+	}
+	if target == "" {
+		target = "."
+	}
+
+	if s.redirected[path.Clean("/"+target)] || generatedFiles[target] {
+		return target
+	}
+
+	if exists[target] {
+		return target
+	}
+	for _, candidate := range []string{target + ".html", path.Join(target, "index.html")} {
+		if exists[candidate] {
+			return candidate
+		}
+	}
+	return ""
+}
+
+func isHTML(file string) bool {
+	ext := strings.ToLower(path.Ext(file))
+	return ext == ".html" || ext == ".htm"
+}
@@ -0,0 +1,81 @@
+package optimizer_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/nitrous-io/rise-server/pkg/optimizer"
+	"golang.org/x/net/context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CheckLinks", func() {
+	var (
+		dir string
+		err error
+	)
+
+	writeFile := func(name, content string) {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		Expect(os.MkdirAll(filepath.Dir(p), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(p, []byte(content), 0644)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		dir, err = ioutil.TempDir("", "optimizer")
+		Expect(err).To(BeNil())
+
+		writeFile("index.html", `<a href="/docs/">Docs</a>
+<a href="docs/install">Install</a>
+<a href="/docs/install.html#requirements">Requirements</a>
+<a href="#main">Skip</a> <main id="main"></main>
+<script src="/jsenv.js"></script>
+<a href="https://www.example.com/missing">Elsewhere</a>
+<a href="mailto:hello@example.com">Mail</a>`)
+		writeFile("docs/index.html", `<a href="../index.html">Home</a>`)
+		writeFile("docs/install.html", `<h2 id="requirements">Requirements</h2>`)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("does not warn about links that work", func() {
+		res, err := optimizer.New(optimizer.CheckLinks(nil)).Run(context.Background(), dir, nil)
+		Expect(err).To(BeNil())
+		Expect(res.Messages).To(BeEmpty())
+	})
+
+	It("warns about links to missing files and fragments", func() {
+		writeFile("docs/upgrade.html", `<p>
+<a href="/docs/setup.html">Setup</a>
+<img src="../images/logo.png">
+<a href="install.html#windows">Windows</a>
+<a href="#top">Top</a>
+</p>`)
+
+		res, err := optimizer.New(optimizer.CheckLinks(nil)).Run(context.Background(), dir, nil)
+		Expect(err).To(BeNil())
+
+		var msgs []string
+		for _, m := range res.Warnings() {
+			msgs = append(msgs, m.String())
+		}
+		Expect(msgs).To(Equal([]string{
+			"[Warning] docs/upgrade.html:2:broken link to /docs/setup.html",
+			"[Warning] docs/upgrade.html:3:broken link to ../images/logo.png",
+			`[Warning] docs/upgrade.html:4:broken link to install.html#windows (no element with ID "windows")`,
+		}))
+	})
+
+	It("does not warn about links to redirected paths", func() {
+		writeFile("about.html", `<a href="/old-docs/">Old docs</a>`)
+
+		res, err := optimizer.New(optimizer.CheckLinks([]string{"/old-docs"})).Run(context.Background(), dir, nil)
+		Expect(err).To(BeNil())
+		Expect(res.Messages).To(BeEmpty())
+	})
+})
@@ -0,0 +1,152 @@
+package optimizer_test
+
+import (
+	"github.com/nitrous-io/rise-server/pkg/optimizer"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("MinifyCSS", func() {
+	minify := func(src string) string {
+		out, err := optimizer.MinifyCSS([]byte(src))
+		Expect(err).To(BeNil())
+		return string(out)
+	}
+
+	It("removes comments and whitespace", func() {
+		Expect(minify(`
+/* layout */
+body {
+  text-align: center;
+  margin : 0 auto ;
+}
+
+h1 > a,
+h2 {
+  color: hotpink;
+}
+`)).To(Equal(`body{text-align:center;margin :0 auto}h1>a,h2{color:hotpink}`))
+	})
+
+	It("keeps whitespace that is significant", func() {
+		Expect(minify(`div :hover { width: calc(100% - 10px); font-family: "Helvetica  Neue", sans-serif }`)).
+			To(Equal(`div :hover{width:calc(100% - 10px);font-family:"Helvetica  Neue",sans-serif}`))
+		Expect(minify(`@media screen and (max-width: 600px) { a { color: red !important; } }`)).
+			To(Equal(`@media screen and (max-width:600px){a{color:red!important}}`))
+	})
+
+	It("keeps license comments", func() {
+		Expect(minify("/*! MIT */\na { b: c }")).To(Equal("/*! MIT */a{b:c}"))
+	})
+
+	It("returns an error with the line number for malformed stylesheets", func() {
+		_, err := optimizer.MinifyCSS([]byte("a {\n  color: red;\n\nb { color: blue }"))
+		Expect(err).To(Equal(&optimizer.SyntaxError{Line: 1, Msg: "unclosed {"}))
+
+		_, err = optimizer.MinifyCSS([]byte("a { color: red }\n}"))
+		Expect(err).To(Equal(&optimizer.SyntaxError{Line: 2, Msg: "unexpected }"}))
+
+		_, err = optimizer.MinifyCSS([]byte("a { content: \"foo }\n"))
+		Expect(err).To(Equal(&optimizer.SyntaxError{Line: 1, Msg: "unclosed string"}))
+	})
+})
+
+var _ = Describe("MinifyJS", func() {
+	minify := func(src string) string {
+		out, err := optimizer.MinifyJS([]byte(src))
+		Expect(err).To(BeNil())
+		return string(out)
+	}
+
+	It("removes comments and whitespace", func() {
+		Expect(minify(`
+// Cycle through the colors.
+var colors = ['pink', 'yellow'],
+    i = 0;
+
+/* Called every 500ms. */
+var disco = function() {
+  i = (i + 1) % 2;
+  document.body.style.backgroundColor = colors[i];
+};
+
+window.setInterval(disco, 500);
+`)).To(Equal(`var colors=['pink','yellow'],i=0;var disco=function(){i=(i+1)%2;document.body.style.backgroundColor=colors[i];};window.setInterval(disco,500);`))
+	})
+
+	It("keeps line breaks that may end a statement", func() {
+		Expect(minify("var a = 1\nvar b = a\n++b\nreturn\nb")).To(Equal("var a=1\nvar b=a\n++b\nreturn\nb"))
+		Expect(minify("if (a) {\n  b()\n}\nc()")).To(Equal("if(a){b()}\nc()"))
+	})
+
+	It("keeps whitespace that is significant", func() {
+		Expect(minify(`typeof x === "string" && a + +b && c - -d && 1 .toString()`)).
+			To(Equal(`typeof x==="string"&&a+ +b&&c- -d&&1 .toString()`))
+	})
+
+	It("does not touch strings, templates and regular expressions", func() {
+		Expect(minify(`var s = "a  // b", t = ` + "`x  ${ y + \"}\" }  z`" + `, r = /\/*  [/]/g ;`)).
+			To(Equal(`var s="a  // b",t=` + "`x  ${ y + \"}\" }  z`" + `,r=/\/*  [/]/g;`))
+		Expect(minify("return /a b/ in x")).To(Equal("return/a b/ in x"))
+		Expect(minify("a = b / c / d")).To(Equal("a=b/c/d"))
+	})
+
+	It("keeps license comments", func() {
+		Expect(minify("/*! MIT */\nvar a;")).To(Equal("/*! MIT */\nvar a;"))
+	})
+
+	It("returns an error with the line number for malformed scripts", func() {
+		_, err := optimizer.MinifyJS([]byte("var disco = function() {};\n\ndisco(;"))
+		Expect(err).To(Equal(&optimizer.SyntaxError{Line: 3, Msg: "unclosed ("}))
+
+		_, err = optimizer.MinifyJS([]byte("foo(]"))
+		Expect(err).To(Equal(&optimizer.SyntaxError{Line: 1, Msg: "unexpected ]"}))
+
+		_, err = optimizer.MinifyJS([]byte("var a = 'foo;\nvar b;"))
+		Expect(err).To(Equal(&optimizer.SyntaxError{Line: 1, Msg: "unclosed string"}))
+
+		_, err = optimizer.MinifyJS([]byte("a();\n/* foo"))
+		Expect(err).To(Equal(&optimizer.SyntaxError{Line: 2, Msg: "unclosed comment"}))
+	})
+})
+
+var _ = Describe("MinifyHTML", func() {
+	minify := func(src string) string {
+		out, err := optimizer.MinifyHTML([]byte(src))
+		Expect(err).To(BeNil())
+		return string(out)
+	}
+
+	It("removes comments and collapses whitespace", func() {
+		Expect(minify(`<!DOCTYPE html>
+<html lang='en'>
+  <head>
+    <!-- metadata -->
+    <meta  charset = "utf-8" >
+    <title>Never   Gonna</title>
+  </head>
+  <body>
+    <h1>Give You Up</h1>
+    <img src="a.jpg"
+         title="I  love you" />
+  </body>
+</html>
+`)).To(Equal(`<!DOCTYPE html> <html lang='en'> <head> <meta charset="utf-8"> <title>Never Gonna</title> </head> <body> <h1>Give You Up</h1> <img src="a.jpg" title="I  love you" /> </body> </html>`))
+	})
+
+	It("keeps conditional comments and the contents of raw text elements", func() {
+		src := "<!--[if IE]><p>IE</p><![endif]-->\n<pre>\n  a\n    b\n</pre>\n<script>\nvar a  =  1;\n</script>\n<TEXTAREA>  x  </textarea>"
+		Expect(minify(src)).To(Equal("<!--[if IE]><p>IE</p><![endif]--> <pre>\n  a\n    b\n</pre> <script>\nvar a  =  1;\n</script> <TEXTAREA>  x  </textarea>"))
+	})
+
+	It("returns an error with the line number for malformed documents", func() {
+		_, err := optimizer.MinifyHTML([]byte("<p>\n<!-- foo\n</p>"))
+		Expect(err).To(Equal(&optimizer.SyntaxError{Line: 2, Msg: "unclosed comment"}))
+
+		_, err = optimizer.MinifyHTML([]byte("<p>\n<a href=\"foo>bar</a>"))
+		Expect(err).To(Equal(&optimizer.SyntaxError{Line: 2, Msg: "unclosed attribute value in <a>"}))
+
+		_, err = optimizer.MinifyHTML([]byte("<body>\n<script>\nalert(1)"))
+		Expect(err).To(Equal(&optimizer.SyntaxError{Line: 2, Msg: "unclosed <script>"}))
+	})
+})
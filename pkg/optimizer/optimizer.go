@@ -0,0 +1,448 @@
+// Package optimizer minifies and post-processes the files of a static website
+// in place. It is a native replacement for the gulp pipeline in
+// builder/optimizer, which has to be run in a Docker container.
+package optimizer
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// Message levels.
+const (
+	LevelWarning = "warning"
+	LevelError   = "error"
+)
+
+// Prefixes of messages in the output of the optimizer, which are the same as
+// those printed by the Docker optimizer.
+const (
+	ErrorMessagePrefix   = "[Error] "
+	WarningMessagePrefix = "[Warning] "
+)
+
+// Message is a warning or an error about a file.
+type Message struct {
+	Level string
+	Stage string
+	File  string // slash-separated path relative to the root of the site
+	Line  int    // 0 if not known
+	Text  string
+}
+
+// String formats m the way the Docker optimizer does, e.g.
+// "[Error] js/app.js:11:unclosed (".
+func (m *Message) String() string {
+	prefix := WarningMessagePrefix
+	if m.Level == LevelError {
+		prefix = ErrorMessagePrefix
+	}
+
+	parts := []string{}
+	if m.File != "" {
+		parts = append(parts, m.File)
+	}
+	if m.Line > 0 {
+		parts = append(parts, strconv.Itoa(m.Line))
+	}
+	parts = append(parts, strings.Replace(m.Text, "\n", " ", -1))
+
+	return prefix + strings.Join(parts, ":")
+}
+
+// ParseMessages returns the warnings and errors in the output of the Docker
+// optimizer, which are lines starting with WarningMessagePrefix or
+// ErrorMessagePrefix in the format of Message.String. Other lines are
+// ignored.
+func ParseMessages(output string) []*Message {
+	var msgs []*Message
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimRight(line, "\r")
+
+		m := &Message{}
+		switch {
+		case strings.HasPrefix(line, ErrorMessagePrefix):
+			m.Level = LevelError
+			line = strings.TrimPrefix(line, ErrorMessagePrefix)
+		case strings.HasPrefix(line, WarningMessagePrefix):
+			m.Level = LevelWarning
+			line = strings.TrimPrefix(line, WarningMessagePrefix)
+		default:
+			continue
+		}
+
+		// The file comes first if there is one, e.g. "js/app.js:11:Unexpected
+		// token", "css/app.css:Missing '}'" or "Failed to generate sitemap".
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) > 1 && looksLikeFile(parts[0]) {
+			m.File = parts[0]
+			parts = parts[1:]
+			if n, err := strconv.Atoi(parts[0]); err == nil && len(parts) > 1 {
+				m.Line = n
+				parts = parts[1:]
+			}
+		}
+		m.Text = strings.Join(parts, ":")
+
+		msgs = append(msgs, m)
+	}
+	return msgs
+}
+
+// looksLikeFile reports whether s could be the path of a file of a site.
+func looksLikeFile(s string) bool {
+	return s != "" && !strings.ContainsAny(s, " \t") && strings.Contains(s, ".")
+}
+
+// Saving is a file that was made smaller by a stage.
+type Saving struct {
+	Stage        string
+	File         string
+	OriginalSize int64
+	Size         int64
+}
+
+// BytesSaved returns how many bytes smaller the file was made.
+func (s *Saving) BytesSaved() int64 {
+	return s.OriginalSize - s.Size
+}
+
+// SyntaxError is returned by the minifiers when a file cannot be parsed.
+type SyntaxError struct {
+	Line int
+	Msg  string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// Site is the website being optimized.
+type Site struct {
+	// Dir is the directory containing the files of the site.
+	Dir string
+
+	// DomainNames are the domain names the site is served from, including
+	// the protocol, e.g. "https://foo-bar.pubstorm.site".
+	DomainNames []string
+
+	// Files are the slash-separated paths of the files of the site relative
+	// to Dir, in lexical order, as of when the optimizer started.
+	Files []string
+
+	stage    string
+	messages []*Message
+	savings  []*Saving
+	copies   map[string]string
+}
+
+// NewSite returns a Site for the files in dir.
+func NewSite(dir string, domainNames []string) (*Site, error) {
+	s := &Site{
+		Dir:         dir,
+		DomainNames: domainNames,
+	}
+
+	err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		s.Files = append(s.Files, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(s.Files)
+	return s, nil
+}
+
+// FilesWithExt returns the files that have one of the given extensions, which
+// are matched case-insensitively.
+func (s *Site) FilesWithExt(exts ...string) []string {
+	var files []string
+	for _, f := range s.Files {
+		ext := strings.ToLower(path.Ext(f))
+		for _, e := range exts {
+			if ext == e {
+				files = append(files, f)
+				break
+			}
+		}
+	}
+	return files
+}
+
+// ReadFile returns the contents of file.
+func (s *Site) ReadFile(file string) ([]byte, error) {
+	return ioutil.ReadFile(s.path(file))
+}
+
+// WriteFile replaces the contents of file, keeping its permissions, or
+// creates it, along with any missing directories.
+func (s *Site) WriteFile(file string, b []byte) error {
+	p := s.path(file)
+
+	perm := os.FileMode(0644)
+	if fi, err := os.Stat(p); err == nil {
+		perm = fi.Mode().Perm()
+	} else if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(p, b, perm)
+}
+
+// Copy copies file to newName, both of which are slash-separated paths
+// relative to Dir, creating any missing directories.
+func (s *Site) Copy(file, newName string) error {
+	b, err := s.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if err := s.WriteFile(newName, b); err != nil {
+		return err
+	}
+
+	s.Files = append(s.Files, newName)
+	sort.Strings(s.Files)
+
+	if s.copies == nil {
+		s.copies = map[string]string{}
+	}
+	s.copies[file] = newName
+	return nil
+}
+
+func (s *Site) path(file string) string {
+	return filepath.Join(s.Dir, filepath.FromSlash(file))
+}
+
+// Warn records a warning about file.
+func (s *Site) Warn(file string, line int, text string) {
+	s.addMessage(LevelWarning, file, line, text)
+}
+
+// Error records an error about file.
+func (s *Site) Error(file string, line int, text string) {
+	s.addMessage(LevelError, file, line, text)
+}
+
+func (s *Site) addMessage(level, file string, line int, text string) {
+	s.messages = append(s.messages, &Message{
+		Level: level,
+		Stage: s.stage,
+		File:  file,
+		Line:  line,
+		Text:  text,
+	})
+}
+
+// Saved records that file was made smaller.
+func (s *Site) Saved(file string, originalSize, size int64) {
+	s.savings = append(s.savings, &Saving{
+		Stage:        s.stage,
+		File:         file,
+		OriginalSize: originalSize,
+		Size:         size,
+	})
+}
+
+// Stage is a step of the optimizer. Problems with individual files should be
+// recorded with site.Warn or site.Error, leaving the file as it was; an error
+// is returned only if the stage could not be completed.
+type Stage interface {
+	Name() string
+	Run(ctx context.Context, site *Site) error
+}
+
+// Optimizer runs its stages on a site, one after another.
+type Optimizer struct {
+	Stages []Stage
+}
+
+// New returns an Optimizer with the given stages.
+func New(stages ...Stage) *Optimizer {
+	return &Optimizer{Stages: stages}
+}
+
+// Default returns an Optimizer that does what the Docker optimizer does.
+// Minifying HTML comes last so that it does not get in the way of the other
+// stages.
+func Default() *Optimizer {
+	return New(
+		RewriteURLs(),
+		JS(),
+		CSS(),
+		Sitemap(),
+		HTML(),
+	)
+}
+
+// Result is the outcome of running the optimizer.
+type Result struct {
+	Messages []*Message
+	Savings  []*Saving
+
+	// Copies maps the names of the files that were copied to the names of
+	// their copies.
+	Copies map[string]string
+}
+
+// Errors returns the messages with LevelError.
+func (r *Result) Errors() []*Message {
+	return r.filter(LevelError)
+}
+
+// Warnings returns the messages with LevelWarning.
+func (r *Result) Warnings() []*Message {
+	return r.filter(LevelWarning)
+}
+
+func (r *Result) filter(level string) []*Message {
+	var msgs []*Message
+	for _, m := range r.Messages {
+		if m.Level == level {
+			msgs = append(msgs, m)
+		}
+	}
+	return msgs
+}
+
+// String returns the messages one per line, in the same format as the output
+// of the Docker optimizer.
+func (r *Result) String() string {
+	lines := make([]string, len(r.Messages))
+	for i, m := range r.Messages {
+		lines[i] = m.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Run optimizes the files in dir in place. It stops early with ctx.Err() if
+// ctx is cancelled, in which case some of the files may have been modified.
+func (o *Optimizer) Run(ctx context.Context, dir string, domainNames []string) (*Result, error) {
+	site, err := NewSite(dir, domainNames)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, stage := range o.Stages {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		site.stage = stage.Name()
+		if err := stage.Run(ctx, site); err != nil {
+			if err == ctx.Err() {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%s: %v", stage.Name(), err)
+		}
+	}
+
+	return &Result{
+		Messages: site.messages,
+		Savings:  site.savings,
+		Copies:   site.copies,
+	}, nil
+}
+
+// fileStage is a Stage that transforms every file with one of exts on its
+// own.
+type fileStage struct {
+	name      string
+	exts      []string
+	transform func(site *Site, file string, b []byte) ([]byte, error)
+}
+
+func (s *fileStage) Name() string {
+	return s.name
+}
+
+func (s *fileStage) Run(ctx context.Context, site *Site) error {
+	for _, file := range site.FilesWithExt(s.exts...) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		b, err := site.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		out, err := s.transform(site, file, b)
+		if err != nil {
+			if serr, ok := err.(*SyntaxError); ok {
+				site.Error(file, serr.Line, serr.Msg)
+			} else {
+				site.Error(file, 0, err.Error())
+			}
+			continue
+		}
+
+		if string(out) == string(b) {
+			continue
+		}
+
+		if err := site.WriteFile(file, out); err != nil {
+			return err
+		}
+		if len(out) < len(b) {
+			site.Saved(file, int64(len(b)), int64(len(out)))
+		}
+	}
+	return nil
+}
+
+// minifyStage returns a stage that replaces files with the output of minify,
+// unless that is not any smaller. If check is not nil, it is called with the
+// original contents of each file to record warnings.
+func minifyStage(name string, exts []string, minify func(b []byte) ([]byte, error), check func(site *Site, file string, b []byte)) Stage {
+	return &fileStage{
+		name: name,
+		exts: exts,
+		transform: func(site *Site, file string, b []byte) ([]byte, error) {
+			if check != nil {
+				check(site, file, b)
+			}
+
+			out, err := minify(b)
+			if err != nil {
+				return nil, err
+			}
+			return smallest(b, out), nil
+		},
+	}
+}
+
+// lineAt returns the line number of offset i in b.
+func lineAt(b []byte, i int) int {
+	return bytes.Count(b[:i], []byte("\n")) + 1
+}
+
+// smallest returns out, unless it is not any smaller than the original b.
+func smallest(b, out []byte) []byte {
+	if len(out) >= len(b) {
+		return b
+	}
+	return out
+}
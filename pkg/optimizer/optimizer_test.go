@@ -0,0 +1,189 @@
+package optimizer_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nitrous-io/rise-server/pkg/optimizer"
+	"golang.org/x/net/context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "optimizer")
+}
+
+var _ = Describe("Optimizer", func() {
+	var (
+		dir         string
+		domainNames []string
+		err         error
+	)
+
+	writeFile := func(name, content string) {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		Expect(os.MkdirAll(filepath.Dir(p), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(p, []byte(content), 0644)).To(Succeed())
+	}
+
+	readFile := func(name string) string {
+		b, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		Expect(err).To(BeNil())
+		return string(b)
+	}
+
+	BeforeEach(func() {
+		dir, err = ioutil.TempDir("", "optimizer")
+		Expect(err).To(BeNil())
+
+		domainNames = []string{"http://foo-bar.pubstorm.site", "https://www.foo-bar.com"}
+
+		writeFile("index.html", `<html>
+  <head>
+    <link href="https://www.foo-bar.com/css/app.css" rel="stylesheet">
+  </head>
+  <body>
+    <a href="http://foo-bar.pubstorm.site">Home</a>
+    <a href="http://www.foo-bar.com.example.com/">Elsewhere</a>
+  </body>
+</html>
+`)
+		writeFile("about/index.html", "<p>\n  About\n</p>\n")
+		writeFile("css/app.css", "body {\n  background: url('//www.foo-bar.com/bg.png?v=1');\n}\n")
+		writeFile("js/app.js", "var a = 1;\n\nalert(a);\n")
+		writeFile("images/logo.png", "not really a png")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("optimizes the files in place", func() {
+		res, err := optimizer.Default().Run(context.Background(), dir, domainNames)
+		Expect(err).To(BeNil())
+		Expect(res.Messages).To(BeEmpty())
+
+		Expect(readFile("index.html")).To(Equal(`<html> <head> <link href="/css/app.css" rel="stylesheet"> </head> <body> <a href="/">Home</a> <a href="http://www.foo-bar.com.example.com/">Elsewhere</a> </body> </html>`))
+		Expect(readFile("about/index.html")).To(Equal("<p> About </p>"))
+		Expect(readFile("css/app.css")).To(Equal("body{background:url('/bg.png?v=1')}"))
+		Expect(readFile("js/app.js")).To(Equal("var a=1;alert(a);"))
+		Expect(readFile("images/logo.png")).To(Equal("not really a png"))
+	})
+
+	It("generates sitemaps for each domain", func() {
+		_, err := optimizer.Default().Run(context.Background(), dir, domainNames)
+		Expect(err).To(BeNil())
+
+		index := readFile("sitemap.xml")
+		Expect(index).To(ContainSubstring(`<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">`))
+		Expect(index).To(ContainSubstring(`<loc>http://foo-bar.pubstorm.site/sitemap/sitemap-foo-bar-pubstorm-site.xml</loc>`))
+		Expect(index).To(ContainSubstring(`<loc>https://www.foo-bar.com/sitemap/sitemap-www-foo-bar-com.xml</loc>`))
+
+		sitemap := readFile("sitemap/sitemap-www-foo-bar-com.xml")
+		Expect(sitemap).To(ContainSubstring(`<loc>https://www.foo-bar.com/</loc>`))
+		Expect(sitemap).To(ContainSubstring(`<loc>https://www.foo-bar.com/about/</loc>`))
+	})
+
+	Context("when the site has its own sitemap", func() {
+		BeforeEach(func() {
+			writeFile("sitemap.xml", "<urlset></urlset>")
+		})
+
+		It("leaves it alone and warns about it", func() {
+			res, err := optimizer.Default().Run(context.Background(), dir, domainNames)
+			Expect(err).To(BeNil())
+
+			Expect(readFile("sitemap.xml")).To(Equal("<urlset></urlset>"))
+			Expect(res.Errors()).To(BeEmpty())
+			Expect(res.Warnings()).To(HaveLen(1))
+			Expect(res.Warnings()[0].Stage).To(Equal("sitemap"))
+		})
+	})
+
+	Context("when the site does not have any domains", func() {
+		BeforeEach(func() {
+			domainNames = nil
+		})
+
+		It("does not generate sitemaps", func() {
+			_, err := optimizer.Default().Run(context.Background(), dir, domainNames)
+			Expect(err).To(BeNil())
+
+			_, err = os.Stat(filepath.Join(dir, "sitemap.xml"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+	})
+
+	Context("when there are problems with some files", func() {
+		BeforeEach(func() {
+			writeFile("js/broken.js", "var disco = function() {};\n\ndisco(;\n")
+			writeFile("css/imports.css", "@import url('fonts.css');\na { color: red }\n")
+			writeFile("insecure.html", "<html>\n<script src=\"http://cdn.example.com/jquery.js\"></script>\n</html>\n")
+		})
+
+		It("reports warnings and errors for each file and leaves broken files alone", func() {
+			res, err := optimizer.Default().Run(context.Background(), dir, domainNames)
+			Expect(err).To(BeNil())
+
+			Expect(res.Errors()).To(HaveLen(1))
+			Expect(res.Errors()[0]).To(Equal(&optimizer.Message{
+				Level: optimizer.LevelError,
+				Stage: "js",
+				File:  "js/broken.js",
+				Line:  3,
+				Text:  "unclosed (",
+			}))
+			Expect(readFile("js/broken.js")).To(Equal("var disco = function() {};\n\ndisco(;\n"))
+
+			Expect(res.Warnings()).To(HaveLen(2))
+			Expect(res.Warnings()[0].File).To(Equal("css/imports.css"))
+			Expect(res.Warnings()[1].File).To(Equal("insecure.html"))
+			Expect(res.Warnings()[1].Line).To(Equal(2))
+			Expect(readFile("css/imports.css")).To(Equal("@import url('fonts.css');a{color:red}"))
+
+			Expect(res.String()).To(ContainSubstring("[Error] js/broken.js:3:unclosed (\n"))
+			Expect(res.String()).To(ContainSubstring("[Warning] insecure.html:2:browsers block http://cdn.example.com/jquery.js"))
+		})
+	})
+
+	Context("when the context is cancelled", func() {
+		It("stops and returns the error of the context", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			_, err := optimizer.Default().Run(ctx, dir, domainNames)
+			Expect(err).To(Equal(context.Canceled))
+			Expect(readFile("js/app.js")).To(Equal("var a = 1;\n\nalert(a);\n"))
+		})
+	})
+})
+
+var _ = Describe("ParseMessages", func() {
+	It("parses the warnings and errors in the output of the Docker optimizer", func() {
+		msgs := optimizer.ParseMessages("Starting 'js'...\n" +
+			"[Error] js/app.js:11:Unexpected token: punc ())\n" +
+			"[Error] css/app.css:Missing '}' after 'a'. Ignoring.\r\n" +
+			"[Warning] Failed to generate sitemap: no domains\n")
+
+		Expect(msgs).To(Equal([]*optimizer.Message{
+			{Level: optimizer.LevelError, File: "js/app.js", Line: 11, Text: "Unexpected token: punc ())"},
+			{Level: optimizer.LevelError, File: "css/app.css", Text: "Missing '}' after 'a'. Ignoring."},
+			{Level: optimizer.LevelWarning, Text: "Failed to generate sitemap: no domains"},
+		}))
+	})
+
+	It("parses what Result.String returns", func() {
+		msgs := []*optimizer.Message{
+			{Level: optimizer.LevelError, File: "index.html", Line: 3, Text: "unclosed <script>"},
+			{Level: optimizer.LevelWarning, File: "about.html", Text: "broken link to /team.html"},
+		}
+		res := &optimizer.Result{Messages: msgs}
+
+		Expect(optimizer.ParseMessages(res.String())).To(Equal(msgs))
+	})
+})
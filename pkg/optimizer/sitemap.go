@@ -0,0 +1,117 @@
+package optimizer
+
+import (
+	"bytes"
+	"encoding/xml"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const sitemapXMLNS = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+// Sitemap returns a stage that generates a sitemap of the HTML documents for
+// each of the site's domains in sitemap/, and a sitemap index in
+// sitemap.xml. Nothing is generated if the site has its own sitemap.xml.
+func Sitemap() Stage {
+	return &sitemap{}
+}
+
+type sitemap struct{}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+}
+
+type urlSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name     `xml:"sitemapindex"`
+	XMLNS    string       `xml:"xmlns,attr"`
+	Sitemaps []sitemapURL `xml:"sitemap"`
+}
+
+func (s *sitemap) Name() string {
+	return "sitemap"
+}
+
+func (s *sitemap) Run(ctx context.Context, site *Site) error {
+	if len(site.DomainNames) == 0 {
+		return nil
+	}
+
+	for _, f := range site.Files {
+		if f == "sitemap.xml" {
+			site.Warn(f, 0, "sitemap.xml already exists, so it was not generated")
+			return nil
+		}
+	}
+
+	var urls []sitemapURL
+	for _, file := range site.FilesWithExt(".html", ".htm") {
+		fi, err := os.Stat(site.path(file))
+		if err != nil {
+			return err
+		}
+
+		loc := file
+		if path.Base(loc) == "index.html" {
+			loc = strings.TrimSuffix(loc, "index.html")
+		}
+		urls = append(urls, sitemapURL{
+			Loc:     loc,
+			LastMod: fi.ModTime().UTC().Format(time.RFC3339),
+		})
+	}
+
+	index := sitemapIndex{XMLNS: sitemapXMLNS}
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	for _, dn := range site.DomainNames {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		baseURL := strings.TrimSuffix(dn, "/")
+		if !strings.Contains(baseURL, "://") {
+			baseURL = "http://" + baseURL
+		}
+
+		set := urlSet{XMLNS: sitemapXMLNS}
+		for _, u := range urls {
+			set.URLs = append(set.URLs, sitemapURL{
+				Loc:     baseURL + "/" + u.Loc,
+				LastMod: u.LastMod,
+			})
+		}
+
+		file := "sitemap/sitemap-" + strings.Replace(hostOf(dn), ".", "-", -1) + ".xml"
+		if err := writeXML(site, file, set); err != nil {
+			return err
+		}
+
+		index.Sitemaps = append(index.Sitemaps, sitemapURL{
+			Loc:     baseURL + "/" + file,
+			LastMod: now,
+		})
+	}
+
+	return writeXML(site, "sitemap.xml", index)
+}
+
+func writeXML(site *Site, file string, v interface{}) error {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	if err := xml.NewEncoder(&buf).Encode(v); err != nil {
+		return err
+	}
+	return site.WriteFile(file, buf.Bytes())
+}
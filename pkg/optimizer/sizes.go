@@ -0,0 +1,180 @@
+package optimizer
+
+import (
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Types of files that sizes are reported by.
+const (
+	FileTypeHTML  = "html"
+	FileTypeCSS   = "css"
+	FileTypeJS    = "js"
+	FileTypeImage = "image"
+	FileTypeFont  = "font"
+	FileTypeOther = "other"
+)
+
+var fileTypes = map[string]string{
+	".html":  FileTypeHTML,
+	".htm":   FileTypeHTML,
+	".css":   FileTypeCSS,
+	".js":    FileTypeJS,
+	".png":   FileTypeImage,
+	".jpg":   FileTypeImage,
+	".jpeg":  FileTypeImage,
+	".gif":   FileTypeImage,
+	".svg":   FileTypeImage,
+	".webp":  FileTypeImage,
+	".ico":   FileTypeImage,
+	".woff":  FileTypeFont,
+	".woff2": FileTypeFont,
+	".ttf":   FileTypeFont,
+	".otf":   FileTypeFont,
+	".eot":   FileTypeFont,
+}
+
+var (
+	htmlSrcRe     = regexp.MustCompile(`(?i)\ssrc\s*=\s*["']?([^"'\s>]+)`)
+	htmlLinkTagRe = regexp.MustCompile(`(?i)<link\s[^>]*>`)
+	htmlHrefRe    = regexp.MustCompile(`(?i)\shref\s*=\s*["']?([^"'\s>]+)`)
+	htmlRelRe     = regexp.MustCompile(`(?i)\srel\s*=\s*(?:"([^"]*)"|'([^']*)'|([^"'\s>]+))`)
+)
+
+// FileType returns the type of file by its extension, which is FileTypeOther
+// if it is not one of the others.
+func FileType(file string) string {
+	if typ, ok := fileTypes[strings.ToLower(path.Ext(file))]; ok {
+		return typ
+	}
+	return FileTypeOther
+}
+
+// Sizes are the sizes of the files of a site in bytes.
+type Sizes struct {
+	// Files are the sizes of the files by their slash-separated paths.
+	Files map[string]int64
+
+	// Pages are the page weights of the HTML documents by their paths,
+	// which are their sizes plus those of the stylesheets, scripts, images
+	// and fonts they load, directly or from their stylesheets.
+	Pages map[string]int64
+}
+
+// MeasureSizes returns the sizes of the files of the site in dir.
+func MeasureSizes(dir string) (*Sizes, error) {
+	site, err := NewSite(dir, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	sizes := &Sizes{
+		Files: make(map[string]int64, len(site.Files)),
+		Pages: map[string]int64{},
+	}
+	for _, f := range site.Files {
+		fi, err := os.Stat(site.path(f))
+		if err != nil {
+			return nil, err
+		}
+		sizes.Files[f] = fi.Size()
+	}
+
+	// refs caches the files that each document references.
+	refs := map[string][]string{}
+	refsOf := func(file string) ([]string, error) {
+		if r, ok := refs[file]; ok {
+			return r, nil
+		}
+		b, err := site.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		r := []string{}
+		for _, u := range loadedURLs(file, b) {
+			target := resolveRef(file, u)
+			// Frames are pages of their own.
+			if _, ok := sizes.Files[target]; ok && !isHTML(target) {
+				r = append(r, target)
+			}
+		}
+		refs[file] = r
+		return r, nil
+	}
+
+	for _, page := range site.FilesWithExt(".html", ".htm") {
+		loaded := map[string]bool{page: true}
+		queue := []string{page}
+		for len(queue) > 0 {
+			f := queue[0]
+			queue = queue[1:]
+			if f != page && !isCSS(f) {
+				continue
+			}
+
+			r, err := refsOf(f)
+			if err != nil {
+				return nil, err
+			}
+			for _, target := range r {
+				if !loaded[target] {
+					loaded[target] = true
+					queue = append(queue, target)
+				}
+			}
+		}
+
+		var weight int64
+		for f := range loaded {
+			weight += sizes.Files[f]
+		}
+		sizes.Pages[page] = weight
+	}
+
+	return sizes, nil
+}
+
+// loadedURLs returns the URLs of the resources that browsers load along with
+// file, which is an HTML document or a stylesheet with the contents b: those
+// in src and srcset attributes, the stylesheets of <link rel="stylesheet">,
+// and those in url() and @import in stylesheets. Links to other resources,
+// e.g. <a href> and <link rel="icon">, are left out.
+func loadedURLs(file string, b []byte) []string {
+	var urls []string
+	addMatches := func(re *regexp.Regexp, i int) {
+		for _, m := range re.FindAllSubmatch(b, -1) {
+			urls = append(urls, string(m[i]))
+		}
+	}
+
+	if !isCSS(file) {
+		addMatches(htmlSrcRe, 1)
+		for _, m := range htmlSrcsetRe.FindAllSubmatch(b, -1) {
+			for _, c := range strings.Split(string(m[2]), ",") {
+				if fields := strings.Fields(c); len(fields) > 0 {
+					urls = append(urls, fields[0])
+				}
+			}
+		}
+		for _, tag := range htmlLinkTagRe.FindAll(b, -1) {
+			rel := htmlRelRe.FindSubmatch(tag)
+			href := htmlHrefRe.FindSubmatch(tag)
+			if rel == nil || href == nil {
+				continue
+			}
+			for _, typ := range strings.Fields(string(rel[1]) + string(rel[2]) + string(rel[3])) {
+				if strings.ToLower(typ) == "stylesheet" {
+					urls = append(urls, string(href[1]))
+					break
+				}
+			}
+		}
+	} else {
+		addMatches(cssImportRefRe, 2)
+	}
+	addMatches(cssURLRe, 2)
+	return urls
+}
@@ -0,0 +1,92 @@
+package optimizer_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nitrous-io/rise-server/pkg/optimizer"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("MeasureSizes", func() {
+	var (
+		dir string
+		err error
+	)
+
+	// writeFile writes content to name, padded with spaces to size bytes.
+	writeFile := func(name, content string, size int) {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		Expect(os.MkdirAll(filepath.Dir(p), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(p, []byte(content+strings.Repeat(" ", size-len(content))), 0644)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		dir, err = ioutil.TempDir("", "optimizer")
+		Expect(err).To(BeNil())
+
+		writeFile("index.html", `<link href="css/app.css" rel="stylesheet"><script src="/js/app.js"></script><a href="about.html">About</a>`, 1000)
+		writeFile("about.html", `<img src="images/logo.png">`, 500)
+		writeFile("css/app.css", `@import "base.css"; body { background: url(../images/bg.jpg) }`, 300)
+		writeFile("css/base.css", `@import "app.css";`, 100)
+		writeFile("js/app.js", "", 2000)
+		writeFile("images/bg.jpg", "", 4000)
+		writeFile("images/logo.png", "", 800)
+		writeFile("fonts/icons.woff2", "", 700)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("returns the size of every file", func() {
+		sizes, err := optimizer.MeasureSizes(dir)
+		Expect(err).To(BeNil())
+		Expect(sizes.Files).To(Equal(map[string]int64{
+			"index.html":        1000,
+			"about.html":        500,
+			"css/app.css":       300,
+			"css/base.css":      100,
+			"js/app.js":         2000,
+			"images/bg.jpg":     4000,
+			"images/logo.png":   800,
+			"fonts/icons.woff2": 700,
+		}))
+	})
+
+	It("returns the weight of every page, without the pages it links to", func() {
+		sizes, err := optimizer.MeasureSizes(dir)
+		Expect(err).To(BeNil())
+		Expect(sizes.Pages).To(Equal(map[string]int64{
+			"index.html": 1000 + 300 + 100 + 4000 + 2000,
+			"about.html": 500 + 800,
+		}))
+	})
+
+	It("only counts the resources that pages load, not the ones they link to", func() {
+		writeFile("downloads.html", `<link rel="icon" href="/favicon.ico"><link rel="alternate" href="/feed.xml"><link href="/css/base.css" rel="preload stylesheet"><a href="/whitepaper.pdf">Whitepaper</a><img srcset="images/logo.png 1x, images/bg.jpg 2x">`, 600)
+		writeFile("favicon.ico", "", 1500)
+		writeFile("feed.xml", "", 2500)
+		writeFile("whitepaper.pdf", "", 5000000)
+
+		sizes, err := optimizer.MeasureSizes(dir)
+		Expect(err).To(BeNil())
+		Expect(sizes.Pages["downloads.html"]).To(Equal(int64(600 + 100 + 300 + 4000 + 800)))
+	})
+})
+
+var _ = Describe("FileType", func() {
+	It("returns the type of a file by its extension", func() {
+		Expect(optimizer.FileType("index.HTML")).To(Equal(optimizer.FileTypeHTML))
+		Expect(optimizer.FileType("css/app.css")).To(Equal(optimizer.FileTypeCSS))
+		Expect(optimizer.FileType("js/app.js")).To(Equal(optimizer.FileTypeJS))
+		Expect(optimizer.FileType("images/logo.svg")).To(Equal(optimizer.FileTypeImage))
+		Expect(optimizer.FileType("fonts/icons.woff2")).To(Equal(optimizer.FileTypeFont))
+		Expect(optimizer.FileType("robots.txt")).To(Equal(optimizer.FileTypeOther))
+		Expect(optimizer.FileType("CNAME")).To(Equal(optimizer.FileTypeOther))
+	})
+})
@@ -0,0 +1,77 @@
+package optimizer
+
+import (
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// RewriteURLs returns a stage that turns absolute URLs that point to one of
+// the site's own domains into root-relative ones in HTML documents and
+// stylesheets, e.g. "http://www.example.com/css/app.css" into
+// "/css/app.css". Links and assets then work over both HTTP and HTTPS, and on
+// every domain the site is served from.
+func RewriteURLs() Stage {
+	return &rewriteURLs{}
+}
+
+type rewriteURLs struct{}
+
+func (s *rewriteURLs) Name() string {
+	return "urls"
+}
+
+func (s *rewriteURLs) Run(ctx context.Context, site *Site) error {
+	hosts := make([]string, 0, len(site.DomainNames))
+	for _, dn := range site.DomainNames {
+		if host := hostOf(dn); host != "" {
+			hosts = append(hosts, regexp.QuoteMeta(host))
+		}
+	}
+	if len(hosts) == 0 {
+		return nil
+	}
+
+	// An absolute or protocol-relative URL to one of the hosts, with an
+	// optional path, and the character that ends it so that hosts that
+	// merely start with one of ours are left alone.
+	url := `(?:https?:)?//(?:` + strings.Join(hosts, "|") + `)(?::\d+)?([/?#][^"'\s>)]*)?(["'\s>)])`
+	var (
+		attrRe = regexp.MustCompile(`(?i)(\s(?:href|src|action)\s*=\s*["']?)` + url)
+		cssRe  = regexp.MustCompile(`(?i)(url\(\s*["']?)` + url)
+	)
+
+	rewrite := func(re *regexp.Regexp, b []byte) []byte {
+		return re.ReplaceAllFunc(b, func(m []byte) []byte {
+			sub := re.FindSubmatch(m)
+			out := append([]byte{}, sub[1]...)
+			if len(sub[2]) == 0 || sub[2][0] != '/' {
+				out = append(out, '/')
+			}
+			out = append(out, sub[2]...)
+			return append(out, sub[3]...)
+		})
+	}
+
+	stage := &fileStage{
+		name: s.Name(),
+		exts: []string{".html", ".htm", ".css"},
+		transform: func(site *Site, file string, b []byte) ([]byte, error) {
+			if !strings.HasSuffix(strings.ToLower(file), ".css") {
+				b = rewrite(attrRe, b)
+			}
+			return rewrite(cssRe, b), nil
+		},
+	}
+	return stage.Run(ctx, site)
+}
+
+// hostOf returns the host of a domain name that may include the protocol,
+// e.g. "www.example.com" for "https://www.example.com".
+func hostOf(domainName string) string {
+	if i := strings.Index(domainName, "://"); i >= 0 {
+		domainName = domainName[i+3:]
+	}
+	return strings.TrimSuffix(domainName, "/")
+}
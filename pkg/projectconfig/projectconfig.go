@@ -0,0 +1,298 @@
+// Package projectconfig parses and validates pubstorm.json, the file in which
+// a project describes how it is to be built and served.
+package projectconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FileName is the name of the file at the root of a project, and of the
+// bundles of its deployments.
+const FileName = "pubstorm.json"
+
+// Minify options for each type of file.
+const (
+	TypeHTML = "html"
+	TypeCSS  = "css"
+	TypeJS   = "js"
+)
+
+// Config is the contents of pubstorm.json. Every field is optional.
+type Config struct {
+	Name string `json:"name,omitempty"`
+
+	// Path is the output directory, relative to the root of the repository,
+	// whose contents are deployed.
+	Path string `json:"path,omitempty"`
+
+	// Ignore lists glob patterns of files that are not deployed. Patterns
+	// without a slash match the name of a file or directory at any depth;
+	// other patterns match paths from the root of the output directory.
+	Ignore []string `json:"ignore,omitempty"`
+
+	Optimizer *Optimizer `json:"optimizer,omitempty"`
+
+	Redirects []*Redirect `json:"redirects,omitempty"`
+	Headers   []*Header   `json:"headers,omitempty"`
+
+	// ErrorPages maps HTTP status codes to the pages that are served with
+	// them, e.g. {"404": "/404.html"}.
+	ErrorPages map[string]string `json:"error_pages,omitempty"`
+}
+
+// Optimizer holds the options for optimizing a project when it is built.
+type Optimizer struct {
+	// Minify turns minifying each type of file on or off. Types that are not
+	// listed are minified.
+	Minify map[string]bool `json:"minify,omitempty"`
+
+	// ImageQuality overrides the quality JPEG images are re-encoded at, as set
+	// in the project's settings.
+	ImageQuality *int `json:"image_quality,omitempty"`
+}
+
+// Redirect redirects requests for one path to another path or URL.
+type Redirect struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Status int    `json:"status,omitempty"` // 301 if not set
+}
+
+// Header adds response headers to the files whose paths match a glob pattern.
+type Header struct {
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers"`
+}
+
+// ValidationError lists what is wrong with a pubstorm.json.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return FileName + " is invalid:\n" + strings.Join(e.Problems, "\n")
+}
+
+var redirectStatuses = map[int]bool{
+	http.StatusMovedPermanently:  true,
+	http.StatusFound:             true,
+	http.StatusSeeOther:          true,
+	http.StatusTemporaryRedirect: true,
+	308:                          true, // Permanent Redirect
+}
+
+// Parse parses and validates the contents of a pubstorm.json. It returns a
+// *ValidationError if they are not valid.
+func Parse(b []byte) (*Config, error) {
+	c := &Config{}
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, &ValidationError{Problems: []string{describeJSONError(err)}}
+	}
+
+	if problems := c.Validate(); len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+	return c, nil
+}
+
+// Load reads the pubstorm.json at the root of dir. A directory without one
+// has an empty Config.
+func Load(dir string) (*Config, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, FileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &Config{}, nil
+		}
+		return nil, err
+	}
+	return Parse(b)
+}
+
+func describeJSONError(err error) string {
+	switch err := err.(type) {
+	case *json.SyntaxError:
+		return fmt.Sprintf("not valid JSON: %v (at byte %d)", err, err.Offset)
+	case *json.UnmarshalTypeError:
+		return fmt.Sprintf("a JSON %s is not allowed where %s is expected", err.Value, jsonType(err.Type.Kind()))
+	}
+	return err.Error()
+}
+
+// jsonType returns the name of the JSON type that Go values of kind k are
+// decoded from.
+func jsonType(k reflect.Kind) string {
+	switch k {
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.String:
+		return "a string"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	}
+	return "an object"
+}
+
+// Validate returns a description of each problem with c.
+func (c *Config) Validate() []string {
+	var problems []string
+	add := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, a...))
+	}
+
+	if c.Path != "" {
+		p := path.Clean(c.Path)
+		if path.IsAbs(p) || p == ".." || strings.HasPrefix(p, "../") {
+			add("path must be a directory within the project")
+		}
+	}
+
+	for i, pattern := range c.Ignore {
+		if pattern == "" {
+			add("ignore[%d] must not be empty", i)
+		} else if _, err := path.Match(pattern, ""); err != nil {
+			add("ignore[%d] is not a valid glob pattern", i)
+		}
+	}
+
+	if o := c.Optimizer; o != nil {
+		for _, typ := range sortedKeys(o.Minify) {
+			if typ != TypeHTML && typ != TypeCSS && typ != TypeJS {
+				add("optimizer.minify.%s is not a known file type (expected html, css or js)", typ)
+			}
+		}
+		if o.ImageQuality != nil && (*o.ImageQuality < 0 || *o.ImageQuality > 100) {
+			add("optimizer.image_quality must be between 0 and 100")
+		}
+	}
+
+	for i, r := range c.Redirects {
+		if r == nil {
+			add("redirects[%d] must be an object", i)
+			continue
+		}
+		if r.From == "" {
+			add("redirects[%d].from is required", i)
+		} else if !strings.HasPrefix(r.From, "/") {
+			add("redirects[%d].from must start with /", i)
+		}
+		if r.To == "" {
+			add("redirects[%d].to is required", i)
+		}
+		if r.Status != 0 && !redirectStatuses[r.Status] {
+			add("redirects[%d].status must be one of 301, 302, 303, 307 or 308", i)
+		}
+	}
+
+	for i, h := range c.Headers {
+		if h == nil {
+			add("headers[%d] must be an object", i)
+			continue
+		}
+		if h.Path == "" {
+			add("headers[%d].path is required", i)
+		} else if !strings.HasPrefix(h.Path, "/") {
+			add("headers[%d].path must start with /", i)
+		} else if _, err := path.Match(h.Path, ""); err != nil {
+			add("headers[%d].path is not a valid glob pattern", i)
+		}
+		if len(h.Headers) == 0 {
+			add("headers[%d].headers is required", i)
+		}
+		for _, name := range sortedKeys(h.Headers) {
+			if !isToken(name) {
+				add("headers[%d].headers has an invalid header name %q", i, name)
+			}
+		}
+	}
+
+	for _, code := range sortedKeys(c.ErrorPages) {
+		if n, err := strconv.Atoi(code); err != nil || n < 400 || n > 599 {
+			add("error_pages.%s is not an HTTP error status code", code)
+		} else if !strings.HasPrefix(c.ErrorPages[code], "/") {
+			add("error_pages.%s must start with /", code)
+		}
+	}
+
+	return problems
+}
+
+// Ignored reports whether file, a slash-separated path relative to the root
+// of the output directory, matches one of the Ignore patterns.
+func (c *Config) Ignored(file string) bool {
+	file = path.Clean(strings.TrimPrefix(file, "/"))
+	for _, pattern := range c.Ignore {
+		pattern = strings.Trim(pattern, "/")
+		for p := file; p != "." && p != "/"; p = path.Dir(p) {
+			name := p
+			if !strings.Contains(pattern, "/") {
+				name = path.Base(p)
+			}
+			if ok, _ := path.Match(pattern, name); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// MinifyEnabled reports whether files of the given type, one of TypeHTML,
+// TypeCSS and TypeJS, are to be minified.
+func (c *Config) MinifyEnabled(typ string) bool {
+	if c.Optimizer == nil {
+		return true
+	}
+	enabled, ok := c.Optimizer.Minify[typ]
+	return !ok || enabled
+}
+
+// ImageQuality returns the quality JPEG images are to be re-encoded at,
+// which is projectQuality unless it is overridden.
+func (c *Config) ImageQuality(projectQuality int) int {
+	if c.Optimizer == nil || c.Optimizer.ImageQuality == nil {
+		return projectQuality
+	}
+	return *c.Optimizer.ImageQuality
+}
+
+// isToken reports whether s is a valid HTTP header name.
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r >= 0x7f || r <= ' ' || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, r) {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch m := m.(type) {
+	case map[string]bool:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]string:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
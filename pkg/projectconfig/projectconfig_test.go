@@ -0,0 +1,147 @@
+package projectconfig_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nitrous-io/rise-server/pkg/projectconfig"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "projectconfig")
+}
+
+var _ = Describe("Project config", func() {
+	problems := func(s string) []string {
+		_, err := projectconfig.Parse([]byte(s))
+		Expect(err).NotTo(BeNil())
+		verr, ok := err.(*projectconfig.ValidationError)
+		Expect(ok).To(BeTrue())
+		return verr.Problems
+	}
+
+	Describe("Parse", func() {
+		It("parses every option", func() {
+			c, err := projectconfig.Parse([]byte(`{
+				"name": "foo-bar",
+				"path": "./build",
+				"ignore": ["*.map", "drafts/"],
+				"optimizer": {
+					"minify": {"js": false},
+					"image_quality": 80
+				},
+				"redirects": [{"from": "/old", "to": "/new", "status": 302}],
+				"headers": [{"path": "/fonts/*", "headers": {"Access-Control-Allow-Origin": "*"}}],
+				"error_pages": {"404": "/404.html"}
+			}`))
+			Expect(err).To(BeNil())
+
+			Expect(c.Path).To(Equal("./build"))
+			Expect(c.Ignore).To(Equal([]string{"*.map", "drafts/"}))
+			Expect(c.MinifyEnabled(projectconfig.TypeJS)).To(BeFalse())
+			Expect(c.MinifyEnabled(projectconfig.TypeCSS)).To(BeTrue())
+			Expect(c.ImageQuality(0)).To(Equal(80))
+			Expect(c.Redirects).To(Equal([]*projectconfig.Redirect{{From: "/old", To: "/new", Status: 302}}))
+			Expect(c.Headers[0].Headers).To(HaveKeyWithValue("Access-Control-Allow-Origin", "*"))
+			Expect(c.ErrorPages).To(HaveKeyWithValue("404", "/404.html"))
+		})
+
+		It("accepts a config with only a path", func() {
+			c, err := projectconfig.Parse([]byte(`{ "name": "", "path": "." }`))
+			Expect(err).To(BeNil())
+			Expect(c.MinifyEnabled(projectconfig.TypeHTML)).To(BeTrue())
+			Expect(c.ImageQuality(50)).To(Equal(50))
+		})
+
+		It("describes malformed JSON", func() {
+			Expect(problems(`{ "invalid json" }`)).To(ConsistOf(HavePrefix("not valid JSON: ")))
+		})
+
+		It("describes values of the wrong type", func() {
+			Expect(problems(`{ "ignore": "*.map" }`)).To(Equal([]string{
+				"a JSON string is not allowed where an array is expected",
+			}))
+		})
+
+		It("describes every problem with the options", func() {
+			Expect(problems(`{
+				"path": "../elsewhere",
+				"ignore": ["[", ""],
+				"optimizer": {
+					"minify": {"png": false},
+					"image_quality": 101
+				},
+				"redirects": [{"from": "old", "status": 200}],
+				"headers": [{"path": "fonts/*"}, {"path": "/", "headers": {"Bad Name": "x"}}],
+				"error_pages": {"200": "/ok.html", "404": "404.html"}
+			}`)).To(Equal([]string{
+				"path must be a directory within the project",
+				"ignore[0] is not a valid glob pattern",
+				"ignore[1] must not be empty",
+				"optimizer.minify.png is not a known file type (expected html, css or js)",
+				"optimizer.image_quality must be between 0 and 100",
+				"redirects[0].from must start with /",
+				"redirects[0].to is required",
+				"redirects[0].status must be one of 301, 302, 303, 307 or 308",
+				"headers[0].path must start with /",
+				"headers[0].headers is required",
+				`headers[1].headers has an invalid header name "Bad Name"`,
+				"error_pages.200 is not an HTTP error status code",
+				"error_pages.404 must start with /",
+			}))
+		})
+
+		It("returns an error message that lists the problems", func() {
+			_, err := projectconfig.Parse([]byte(`{ "optimizer": { "image_quality": -1 } }`))
+			Expect(err.Error()).To(Equal("pubstorm.json is invalid:\noptimizer.image_quality must be between 0 and 100"))
+		})
+	})
+
+	Describe("Load", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "projectconfig")
+			Expect(err).To(BeNil())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("returns an empty config if there is no pubstorm.json", func() {
+			c, err := projectconfig.Load(dir)
+			Expect(err).To(BeNil())
+			Expect(c).To(Equal(&projectconfig.Config{}))
+		})
+
+		It("parses the pubstorm.json in the directory", func() {
+			Expect(ioutil.WriteFile(filepath.Join(dir, "pubstorm.json"), []byte(`{"ignore": ["*.map"]}`), 0644)).To(Succeed())
+
+			c, err := projectconfig.Load(dir)
+			Expect(err).To(BeNil())
+			Expect(c.Ignore).To(Equal([]string{"*.map"}))
+		})
+	})
+
+	Describe("Ignored", func() {
+		It("matches patterns without a slash against names at any depth, and others against paths", func() {
+			c := &projectconfig.Config{Ignore: []string{"*.map", "drafts/", "/docs/*.md"}}
+
+			Expect(c.Ignored("js/app.js.map")).To(BeTrue())
+			Expect(c.Ignored("drafts/index.html")).To(BeTrue())
+			Expect(c.Ignored("blog/drafts/post.html")).To(BeTrue())
+			Expect(c.Ignored("docs/README.md")).To(BeTrue())
+
+			Expect(c.Ignored("js/app.js")).To(BeFalse())
+			Expect(c.Ignored("blog/docs/README.md")).To(BeFalse())
+		})
+	})
+})
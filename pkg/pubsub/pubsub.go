@@ -2,10 +2,8 @@ package pubsub
 
 import (
 	"encoding/json"
-	"time"
 
-	"github.com/nitrous-io/rise-server/pkg/mqconn"
-	"github.com/streadway/amqp"
+	"github.com/nitrous-io/rise-server/pkg/broker"
 )
 
 type Message struct {
@@ -27,41 +25,5 @@ func NewMessageWithJSON(exchangeName, route string, data interface{}) (*Message,
 }
 
 func (j *Message) Publish() error {
-	mq, err := mqconn.MQ()
-	if err != nil {
-		return err
-	}
-
-	ch, err := mq.Channel()
-	if err != nil {
-		return err
-	}
-	defer ch.Close()
-
-	// This is to make sure the exchange exists
-	err = ch.ExchangeDeclare(
-		j.ExchangeName, // name
-		"direct",       // type
-		true,           // durable
-		false,          // auto-deleted
-		false,          // internal
-		false,          // no-wait
-		nil,            // arguments
-	)
-	if err != nil {
-		return err
-	}
-
-	return ch.Publish(
-		j.ExchangeName, // exchange
-		j.Route,        // routing key
-		false,          // mandatory
-		false,          // immediate
-		amqp.Publishing{
-			DeliveryMode: amqp.Persistent,
-			ContentType:  "text/plain",
-			Body:         []byte(j.Data),
-			Timestamp:    time.Now(),
-		},
-	)
+	return broker.Default.Publish(j.ExchangeName, j.Route, j.Data)
 }
@@ -0,0 +1,38 @@
+package worker
+
+import (
+	"net/http"
+	"os"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/nitrous-io/rise-server/pkg/health"
+	"github.com/nitrous-io/rise-server/pkg/metrics"
+)
+
+// HTTPAddr is the address worker processes serve metrics and health checks
+// on, e.g. ":9100". It is set with the WORKER_HTTP_ADDR environment variable.
+// If empty, nothing is served.
+var HTTPAddr = os.Getenv("WORKER_HTTP_ADDR")
+
+// StartHTTPServer serves the following on HTTPAddr in the background:
+//
+//	/metrics       metrics in the Prometheus text format
+//	/health/live   200 OK as long as the process is running
+//	/health/ready  the result of running checker
+func StartHTTPServer(checker *health.Checker) {
+	if HTTPAddr == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Handle("/health/live", health.LiveHandler())
+	mux.Handle("/health/ready", checker.ReadyHandler())
+
+	go func() {
+		log.Infof("Serving metrics and health checks on %s...", HTTPAddr)
+		if err := http.ListenAndServe(HTTPAddr, mux); err != nil {
+			log.Errorln("Failed to start HTTP server:", err)
+		}
+	}()
+}
@@ -0,0 +1,249 @@
+package worker
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/nitrous-io/rise-server/pkg/broker"
+	"github.com/nitrous-io/rise-server/pkg/metrics"
+	"golang.org/x/net/context"
+)
+
+var (
+	// RetryDelay is how long a failed message is held before it is nacked, to
+	// prevent thrashing.
+	RetryDelay = 1 * time.Second
+
+	// DefaultGracePeriod is used when Worker.GracePeriod is zero. It can be set
+	// with the WORKER_GRACE_PERIOD environment variable, e.g. "2m".
+	DefaultGracePeriod = 30 * time.Second
+
+	// DefaultConcurrency is the number of messages a worker process handles at
+	// the same time. It can be set with the WORKER_CONCURRENCY environment
+	// variable.
+	DefaultConcurrency = 1
+)
+
+var (
+	jobsTotal = metrics.NewCounter(
+		"worker_jobs_total",
+		"Number of messages processed, by queue.",
+		"queue",
+	)
+	jobFailuresTotal = metrics.NewCounter(
+		"worker_job_failures_total",
+		"Number of messages that failed to be processed, by queue and whether they will be retried.",
+		"queue", "retry",
+	)
+	jobDuration = metrics.NewHistogram(
+		"worker_job_duration_seconds",
+		"Time taken to process messages, by queue.",
+		[]float64{.1, .5, 1, 5, 10, 30, 60, 120, 300, 600},
+		"queue",
+	)
+)
+
+// Errors returned from this package.
+var (
+	ErrSubscriptionClosed = errors.New("subscription closed")
+)
+
+func init() {
+	if gracePeriodEnv := os.Getenv("WORKER_GRACE_PERIOD"); gracePeriodEnv != "" {
+		d, err := time.ParseDuration(gracePeriodEnv)
+		if err != nil {
+			log.Warn("Ignoring WORKER_GRACE_PERIOD, not a valid duration!")
+		} else {
+			DefaultGracePeriod = d
+		}
+	}
+
+	if concurrencyEnv := os.Getenv("WORKER_CONCURRENCY"); concurrencyEnv != "" {
+		n, err := strconv.Atoi(concurrencyEnv)
+		if err != nil || n < 1 {
+			log.Warn("Ignoring WORKER_CONCURRENCY, not a valid positive number!")
+		} else {
+			DefaultConcurrency = n
+		}
+	}
+}
+
+// Worker processes messages delivered by a broker subscription.
+type Worker struct {
+	// Name is the name of the queue (or exchange) the worker consumes from. It
+	// is used in log messages.
+	Name string
+
+	// Work processes the body of a message. It should return early with
+	// ctx.Err() when ctx is cancelled, leaving things in a state where the
+	// message can be processed again.
+	Work func(ctx context.Context, data []byte) error
+
+	// Retryable reports whether a message that failed with the given error
+	// should be redelivered. Messages that should not be retried are acked. If
+	// nil, every failed message is redelivered.
+	Retryable func(err error) bool
+
+	// GracePeriod is how long in-flight messages are given to finish once the
+	// worker is stopped, before they are cancelled. If zero,
+	// DefaultGracePeriod is used.
+	GracePeriod time.Duration
+
+	// Concurrency is the number of messages that are processed at the same
+	// time, each in its own goroutine. The subscription should be created with
+	// a prefetch count of at least Concurrency. If zero, messages are processed
+	// one at a time.
+	Concurrency int
+}
+
+// Run processes messages until stop is closed. It returns an error if the
+// subscription ended or the connection to the broker was lost.
+//
+// Before returning, Run closes sub so that no more messages are delivered, and
+// waits for the messages that are being processed to finish. Those that do not
+// finish within the grace period are cancelled.
+func (w *Worker) Run(sub broker.Subscription, stop <-chan struct{}) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	concurrency := w.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var (
+		wg       sync.WaitGroup
+		slots    = make(chan struct{}, concurrency)
+		draining = make(chan struct{})
+	)
+
+	err := func() error {
+		for {
+			// Wait until there is room for another message.
+			select {
+			case slots <- struct{}{}:
+			case err := <-sub.Err():
+				return err
+			case <-stop:
+				return nil
+			}
+
+			select {
+			case d, ok := <-sub.Deliveries():
+				if !ok {
+					return ErrSubscriptionClosed
+				}
+
+				wg.Add(1)
+				go func() {
+					defer func() {
+						<-slots
+						wg.Done()
+					}()
+
+					jobCtx, cancelJob := context.WithCancel(ctx)
+					defer cancelJob()
+
+					w.handle(jobCtx, d, draining)
+				}()
+			case err := <-sub.Err():
+				return err
+			case <-stop:
+				return nil
+			}
+		}
+	}()
+
+	close(draining)
+
+	if err := sub.Close(); err != nil {
+		log.WithFields(log.Fields{"queue": w.Name}).Warnln("Failed to close subscription:", err)
+	}
+
+	w.drain(&wg, cancel)
+
+	return err
+}
+
+// drain waits for in-flight messages to be processed, and cancels them if
+// they take longer than the grace period.
+func (w *Worker) drain(wg *sync.WaitGroup, cancel context.CancelFunc) {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	gracePeriod := w.GracePeriod
+	if gracePeriod == 0 {
+		gracePeriod = DefaultGracePeriod
+	}
+
+	select {
+	case <-done:
+		return
+	case <-time.After(gracePeriod):
+	}
+
+	log.WithFields(log.Fields{"queue": w.Name}).Warnf("In-flight work did not finish within %v, cancelling", gracePeriod)
+	cancel()
+	<-done
+}
+
+func (w *Worker) handle(ctx context.Context, d *broker.Delivery, draining <-chan struct{}) {
+	fields := log.Fields{"queue": w.Name}
+	if reqID := requestID(d.Body); reqID != "" {
+		fields["request_id"] = reqID
+	}
+
+	start := time.Now()
+	err := w.Work(ctx, d.Body)
+	jobDuration.ObserveSince(start, w.Name)
+	jobsTotal.Inc(w.Name)
+
+	if err == nil {
+		// success
+		if err := d.Ack(); err != nil {
+			log.WithFields(fields).Warnln("Failed to Ack message:", err)
+		}
+		return
+	}
+
+	// failure
+	log.WithFields(fields).Warnln("Work failed", err, string(d.Body))
+
+	if w.Retryable != nil && !w.Retryable(err) {
+		jobFailuresTotal.Inc(w.Name, "false")
+		if err := d.Ack(); err != nil {
+			log.WithFields(fields).Warnln("Failed to Ack message:", err)
+		}
+		return
+	}
+
+	jobFailuresTotal.Inc(w.Name, "true")
+
+	// nack after a delay to prevent thrashing, unless we are shutting down
+	select {
+	case <-time.After(RetryDelay):
+	case <-draining:
+	}
+
+	if err := d.Nack(true); err != nil {
+		log.WithFields(fields).Warnln("Failed to Nack message:", err)
+	}
+}
+
+// requestID returns the ID of the API request that caused a job to be
+// enqueued, if the job data has one.
+func requestID(data []byte) string {
+	var v struct {
+		RequestID string `json:"request_id"`
+	}
+	json.Unmarshal(data, &v)
+	return v.RequestID
+}
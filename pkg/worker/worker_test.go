@@ -0,0 +1,212 @@
+package worker_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nitrous-io/rise-server/pkg/broker"
+	"github.com/nitrous-io/rise-server/pkg/worker"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/net/context"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "worker")
+}
+
+var _ = Describe("Worker", func() {
+	var (
+		b        *broker.Memory
+		sub      broker.Subscription
+		prefetch int
+		w        *worker.Worker
+		stop     chan struct{}
+		done     chan error
+
+		mu       sync.Mutex
+		received []string
+
+		errPermanent = errors.New("permanent")
+		errTemporary = errors.New("temporary")
+
+		origRetryDelay time.Duration
+	)
+
+	BeforeEach(func() {
+		origRetryDelay = worker.RetryDelay
+		worker.RetryDelay = 10 * time.Millisecond
+
+		b = broker.NewMemory()
+		received = nil
+		prefetch = 1
+
+		stop = make(chan struct{})
+		done = make(chan error, 1)
+
+		attempts := map[string]int{}
+		w = &worker.Worker{
+			Name: "fooq",
+			Work: func(ctx context.Context, data []byte) error {
+				mu.Lock()
+				defer mu.Unlock()
+
+				body := string(data)
+				received = append(received, body)
+				attempts[body]++
+
+				switch {
+				case body == "permanent":
+					return errPermanent
+				case body == "temporary" && attempts[body] == 1:
+					return errTemporary
+				}
+				return nil
+			},
+			Retryable: func(err error) bool {
+				return err != errPermanent
+			},
+		}
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		sub, err = b.Consume("fooq", prefetch)
+		Expect(err).To(BeNil())
+
+		go func(w *worker.Worker, sub broker.Subscription, stop chan struct{}, done chan error) {
+			done <- w.Run(sub, stop)
+		}(w, sub, stop, done)
+	})
+
+	AfterEach(func() {
+		sub.Close()
+		Eventually(done).Should(Receive())
+		worker.RetryDelay = origRetryDelay
+	})
+
+	receivedMessages := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), received...)
+	}
+
+	It("processes messages", func() {
+		Expect(b.Enqueue("fooq", []byte("foo"))).To(BeNil())
+		Expect(b.Enqueue("fooq", []byte("bar"))).To(BeNil())
+
+		Eventually(receivedMessages).Should(Equal([]string{"foo", "bar"}))
+	})
+
+	It("retries messages that fail with a retryable error", func() {
+		Expect(b.Enqueue("fooq", []byte("temporary"))).To(BeNil())
+
+		Eventually(receivedMessages).Should(Equal([]string{"temporary", "temporary"}))
+		Consistently(receivedMessages).Should(HaveLen(2))
+	})
+
+	It("does not retry messages that fail with a permanent error", func() {
+		Expect(b.Enqueue("fooq", []byte("permanent"))).To(BeNil())
+		Expect(b.Enqueue("fooq", []byte("foo"))).To(BeNil())
+
+		Eventually(receivedMessages).Should(Equal([]string{"permanent", "foo"}))
+		Consistently(receivedMessages).Should(HaveLen(2))
+		Expect(b.Len("fooq")).To(Equal(0))
+	})
+
+	It("returns when stopped", func() {
+		close(stop)
+		Eventually(done).Should(Receive(BeNil()))
+		done <- nil // for AfterEach
+	})
+
+	It("returns an error when the subscription is closed", func() {
+		Expect(sub.Close()).To(BeNil())
+		Eventually(done).Should(Receive(Equal(worker.ErrSubscriptionClosed)))
+		done <- nil // for AfterEach
+	})
+
+	Context("when concurrency is greater than 1", func() {
+		var release chan struct{}
+
+		BeforeEach(func() {
+			release = make(chan struct{})
+
+			prefetch = 2
+			w.Concurrency = 2
+			w.Work = func(ctx context.Context, data []byte) error {
+				mu.Lock()
+				received = append(received, string(data))
+				mu.Unlock()
+
+				<-release
+				return nil
+			}
+
+			for _, m := range []string{"a", "b", "c"} {
+				Expect(b.Enqueue("fooq", []byte(m))).To(BeNil())
+			}
+		})
+
+		It("processes that many messages at the same time", func() {
+			Eventually(receivedMessages).Should(ConsistOf("a", "b"))
+			Consistently(receivedMessages, 50*time.Millisecond).Should(HaveLen(2))
+
+			close(release)
+			Eventually(receivedMessages).Should(ConsistOf("a", "b", "c"))
+		})
+	})
+
+	Context("when stopped while a message is being processed", func() {
+		var (
+			started chan struct{}
+			release chan struct{}
+		)
+
+		BeforeEach(func() {
+			started = make(chan struct{})
+			release = make(chan struct{})
+
+			w.GracePeriod = 100 * time.Millisecond
+			w.Work = func(ctx context.Context, data []byte) error {
+				close(started)
+				select {
+				case <-release:
+					return nil
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+
+			Expect(b.Enqueue("fooq", []byte("foo"))).To(BeNil())
+			Expect(b.Enqueue("fooq", []byte("bar"))).To(BeNil())
+		})
+
+		It("stops consuming and waits for the message to finish", func() {
+			Eventually(started).Should(BeClosed())
+			close(stop)
+
+			Consistently(done, 50*time.Millisecond).ShouldNot(Receive())
+			close(release)
+
+			Eventually(done).Should(Receive(BeNil()))
+			done <- nil // for AfterEach
+
+			// "foo" was acked and "bar" was never delivered.
+			Expect(b.Len("fooq")).To(Equal(1))
+		})
+
+		It("cancels the message once the grace period is over and requeues it", func() {
+			Eventually(started).Should(BeClosed())
+			close(stop)
+
+			Eventually(done).Should(Receive(BeNil()))
+			done <- nil // for AfterEach
+
+			Expect(b.Len("fooq")).To(Equal(2))
+		})
+	})
+})
@@ -27,9 +27,11 @@ import (
 	"github.com/nitrous-io/rise-server/pkg/filetransfer"
 	"github.com/nitrous-io/rise-server/pkg/githubapi"
 	"github.com/nitrous-io/rise-server/pkg/job"
+	"github.com/nitrous-io/rise-server/pkg/projectconfig"
 	"github.com/nitrous-io/rise-server/shared/messages"
 	"github.com/nitrous-io/rise-server/shared/queues"
 	"github.com/nitrous-io/rise-server/shared/s3client"
+	"golang.org/x/net/context"
 )
 
 var (
@@ -42,12 +44,33 @@ var (
 	ErrRecordNotFound             = errors.New("project or deployment is deleted")
 )
 
+// Retryable reports whether a push job that failed with err should be retried.
+func Retryable(err error) bool {
+	switch err {
+	case ErrUnexpectedDeploymentState,
+		ErrProjectConfigNotFound,
+		ErrProjectConfigInvalidFormat,
+		ErrRecordNotFound:
+		return false
+	}
+	return true
+}
+
 func Work(data []byte) error {
+	return WorkContext(context.Background(), data)
+}
+
+// WorkContext is like Work, but stops early with ctx.Err() if ctx is cancelled
+// before the repository archive has been uploaded. The deployment is then left
+// in the pending_upload state so that the job can be retried.
+func WorkContext(ctx context.Context, data []byte) error {
 	d := &messages.PushJobData{}
 	if err := json.Unmarshal(data, d); err != nil {
 		return err
 	}
 
+	logger := log.WithFields(log.Fields{"request_id": d.RequestID})
+
 	db, err := dbconn.DB()
 	if err != nil {
 		return err
@@ -88,8 +111,18 @@ func Work(data []byte) error {
 		return err
 	}
 
-	projPath, err := fetchProjectPath(pl)
+	cfgJSON, cfg, err := fetchProjectConfig(pl)
 	if err != nil {
+		switch err := err.(type) {
+		case *projectconfig.ValidationError:
+			m := "Your repository's " + err.Error()
+			depl.ErrorMessage = &m
+			if err := depl.UpdateState(db, deployment.StateBuildFailed); err != nil {
+				logger.Errorf("failed to update deployment state for deployment ID %d due to %v", depl.ID, err)
+			}
+			return ErrProjectConfigInvalidFormat
+		}
+
 		switch err {
 		case ErrProjectConfigNotFound:
 			m := "Your GitHub repository does not contain a pubstorm.json file, aborting. Please check in the pubstorm.json file in the root of your repository."
@@ -118,7 +151,17 @@ func Work(data []byte) error {
 	}
 	defer os.RemoveAll(tmpDir)
 
-	if err := fetchAndUnpackArchive(archiveURL, tmpDir, projPath); err != nil {
+	if err := fetchAndUnpackArchive(logger, archiveURL, tmpDir, cfg.Path); err != nil {
+		return err
+	}
+
+	// The builder and deployer read pubstorm.json from the bundle, which only
+	// contains the project path.
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, projectconfig.FileName), cfgJSON, 0644); err != nil {
+		return err
+	}
+
+	if err := ctx.Err(); err != nil {
 		return err
 	}
 
@@ -133,6 +176,10 @@ func Work(data []byte) error {
 	}
 
 	uploadKey := fmt.Sprintf("deployments/%s/raw-bundle.tar.gz", depl.PrefixID())
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	if err := S3.Upload(s3client.BucketRegion, s3client.BucketName, uploadKey, tarball, "", "private"); err != nil {
 		return err
 	}
@@ -142,10 +189,12 @@ func Work(data []byte) error {
 		j, err = job.NewWithJSON(queues.Deploy, &messages.DeployJobData{
 			DeploymentID: depl.ID,
 			UseRawBundle: true,
+			RequestID:    d.RequestID,
 		})
 	} else {
 		j, err = job.NewWithJSON(queues.Build, &messages.BuildJobData{
 			DeploymentID: depl.ID,
+			RequestID:    d.RequestID,
 		})
 	}
 	if err != nil {
@@ -164,9 +213,10 @@ func Work(data []byte) error {
 	return depl.UpdateState(db, newState)
 }
 
-// fetchProjectPath downloads the pubstorm.json file from root dir of repository
-// to determine the project path.
-func fetchProjectPath(pl *githubapi.PushPayload) (string, error) {
+// fetchProjectConfig downloads the pubstorm.json file from root dir of
+// repository, and returns its contents along with the parsed config, which
+// determines the project path.
+func fetchProjectConfig(pl *githubapi.PushPayload) ([]byte, *projectconfig.Config, error) {
 	qs := url.Values{}
 	qs.Add("ref", pl.After)
 	cfgURL := fmt.Sprintf("%s/repos/%s/contents/pubstorm.json?%s",
@@ -179,21 +229,30 @@ func fetchProjectPath(pl *githubapi.PushPayload) (string, error) {
 	cl := &http.Client{Timeout: 2 * time.Second}
 	res, err := cl.Do(req)
 	if err != nil {
-		return "", err
+		return nil, nil, err
 	}
 	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusOK {
-		return "", ErrProjectConfigNotFound
+		return nil, nil, ErrProjectConfigNotFound
 	}
-	var j struct {
-		Path string `json:"path"`
+
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, nil, err
 	}
-	if err := json.NewDecoder(res.Body).Decode(&j); err != nil {
-		return "", ErrProjectConfigInvalidFormat
+
+	// Keep the existing message for files that are not JSON at all.
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, nil, ErrProjectConfigInvalidFormat
 	}
 
-	return j.Path, nil
+	cfg, err := projectconfig.Parse(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	return b, cfg, nil
 }
 
 // fetchAndUnpackArchive downloads the gzipped tarball from the given URL and
@@ -206,7 +265,7 @@ func fetchProjectPath(pl *githubapi.PushPayload) (string, error) {
 //   3. git config --local core.sparseCheckout true
 //   4. echo build/ >> .git/info/sparse-checkout
 //   5. git pull origin master
-func fetchAndUnpackArchive(url, dst, subdir string) error {
+func fetchAndUnpackArchive(logger *log.Entry, url, dst, subdir string) error {
 	cl := &http.Client{Timeout: 10 * time.Second}
 	req, err := http.NewRequest("GET", url, nil)
 	if common.GitHubAPIToken != "" {
@@ -214,7 +273,7 @@ func fetchAndUnpackArchive(url, dst, subdir string) error {
 	}
 	res, err := cl.Do(req)
 	if err != nil {
-		log.Errorf("error downloading archive of repo from GitHub, err: %v", err)
+		logger.Errorf("error downloading archive of repo from GitHub, err: %v", err)
 		return ErrGitHubArchiveProblem
 	}
 	defer res.Body.Close()
@@ -175,7 +175,8 @@ var _ = Describe("Pushd", func() {
 
 		// See testhelper/fixtures/github-repo-archive/ directory or unarchive the
 		// testhelper/fixtures/github-repo-archive.tar.gz file.
-		Expect(filenames).To(ConsistOf("index.html", "css/app.css"))
+		// pubstorm.json is added so that the builder and deployer can read it.
+		Expect(filenames).To(ConsistOf("index.html", "css/app.css", "pubstorm.json"))
 	})
 
 	It("enqueues a build job", func() {
@@ -267,6 +268,25 @@ var _ = Describe("Pushd", func() {
 			Expect(depl.State).To(Equal(deployment.StateDeployFailed))
 		})
 	})
+
+	Context("when the repository's pubstorm.json has invalid options", func() {
+		BeforeEach(func() {
+			contentsBody = `{ "path": "./build", "redirects": [{ "from": "/old" }] }`
+		})
+
+		It("returns an error and records what is wrong with it", func() {
+			err := pushd.Work([]byte(fmt.Sprintf(`{
+				"push_id": %d
+			}`, pu.ID)))
+			Expect(err).To(Equal(pushd.ErrProjectConfigInvalidFormat))
+
+			err = db.First(depl, pu.DeploymentID).Error
+			Expect(err).To(BeNil())
+
+			Expect(*depl.ErrorMessage).To(Equal("Your repository's pubstorm.json is invalid:\nredirects[0].to is required"))
+			Expect(depl.State).To(Equal(deployment.StateBuildFailed))
+		})
+	})
 })
 
 var ghPushPayload = []byte(`{
@@ -0,0 +1,153 @@
+// risedev runs the API server together with in-process consumers for the
+// deploy, build and push queues and for edge invalidations, so that the whole
+// platform can be run on a laptop with just PostgreSQL. Messages go through an
+// in-memory broker and files are stored on the local disk instead of S3.
+package main
+
+import (
+	"flag"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+
+	log "github.com/Sirupsen/logrus"
+	healthctrl "github.com/nitrous-io/rise-server/apiserver/controllers/health"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/server"
+	"github.com/nitrous-io/rise-server/builder/builder"
+	"github.com/nitrous-io/rise-server/deployer/deployer"
+	"github.com/nitrous-io/rise-server/edged/invalidator"
+	"github.com/nitrous-io/rise-server/pkg/broker"
+	"github.com/nitrous-io/rise-server/pkg/filetransfer"
+	"github.com/nitrous-io/rise-server/pkg/health"
+	"github.com/nitrous-io/rise-server/pkg/worker"
+	"github.com/nitrous-io/rise-server/pushd/pushd"
+	"github.com/nitrous-io/rise-server/shared/exchanges"
+	"github.com/nitrous-io/rise-server/shared/queues"
+	"github.com/nitrous-io/rise-server/shared/s3client"
+	"golang.org/x/net/context"
+)
+
+var (
+	addr          = flag.String("addr", ":3000", "address the API server listens on")
+	storageDir    = flag.String("storage-dir", filepath.Join(os.TempDir(), "risedev"), "directory where uploaded files are stored")
+	skipOptimizer = flag.Bool("skip-optimizer", false, "skip running the Docker optimizer on builds")
+	edgeHost      = flag.String("edge-host", invalidator.APIHost, "edge server to send invalidations to; if empty, invalidations are only logged")
+)
+
+// consumer is a worker together with the subscription it processes.
+type consumer struct {
+	sub broker.Subscription
+	w   *worker.Worker
+}
+
+func main() {
+	flag.Parse()
+	run()
+	os.Exit(1)
+}
+
+func run() {
+	// Every component runs in this process and shares the same connection
+	// pool, so make sure it can be opened before starting anything.
+	if _, err := dbconn.DB(); err != nil {
+		log.Errorln("Failed to connect to db:", err)
+		return
+	}
+
+	broker.Default = broker.NewMemory()
+
+	storage := filetransfer.NewLocal(*storageDir)
+	s3client.S3 = storage
+	builder.S3 = storage
+	deployer.S3 = storage
+	pushd.S3 = storage
+
+	// There is no RabbitMQ to check when messages go through the in-memory
+	// broker.
+	healthctrl.Checker = health.NewChecker()
+	healthctrl.Checker.Add("database", healthctrl.Database)
+	healthctrl.Checker.Add("storage", healthctrl.Storage)
+
+	if *skipOptimizer {
+		builder.OptimizerCmd = func(containerName string, srcDir string, domainNames []string, envFile string) *exec.Cmd {
+			return exec.Command("true")
+		}
+	}
+
+	// Allow any project to be deployed locally.
+	deployer.AcceptedProjectNames = nil
+
+	invalidate := invalidator.WorkContext
+	if *edgeHost == "" {
+		invalidate = func(ctx context.Context, data []byte) error {
+			log.WithFields(log.Fields{"exchange": exchanges.Edges}).Infof("Invalidation: %s", data)
+			return nil
+		}
+	} else {
+		invalidator.APIHost = *edgeHost
+	}
+
+	var consumers []consumer
+
+	concurrency := worker.DefaultConcurrency
+
+	for _, w := range []*worker.Worker{
+		{Name: queues.Deploy, Work: deployer.WorkContext, Retryable: deployer.Retryable, Concurrency: concurrency},
+		{Name: queues.Build, Work: builder.WorkContext, Retryable: builder.Retryable, Concurrency: concurrency},
+		{Name: queues.Push, Work: pushd.WorkContext, Retryable: pushd.Retryable, Concurrency: concurrency},
+	} {
+		sub, err := broker.Default.Consume(w.Name, concurrency)
+		if err != nil {
+			log.Errorf("Failed to start consuming message from queue(%s): %v", w.Name, err)
+			return
+		}
+		consumers = append(consumers, consumer{sub, w})
+	}
+
+	sub, err := broker.Default.Subscribe(exchanges.Edges, exchanges.RouteV1Invalidation, concurrency)
+	if err != nil {
+		log.Errorf("Failed to subscribe to exchange(%s) and route(%s): %v", exchanges.Edges, exchanges.RouteV1Invalidation, err)
+		return
+	}
+	consumers = append(consumers, consumer{sub, &worker.Worker{Name: exchanges.Edges, Work: invalidate, Concurrency: concurrency}})
+
+	var (
+		wg   sync.WaitGroup
+		stop = make(chan struct{})
+	)
+
+	for _, c := range consumers {
+		wg.Add(1)
+		go func(sub broker.Subscription, w *worker.Worker) {
+			defer wg.Done()
+
+			log.Infof("Worker started listening to %s...", w.Name)
+			if err := w.Run(sub, stop); err != nil {
+				log.Errorf("Worker for %s stopped: %v", w.Name, err)
+			}
+		}(c.sub, c.w)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		log.Infof("API server listening on %s, storing files in %s", *addr, *storageDir)
+		errCh <- server.New().Run(*addr)
+	}()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+
+	select {
+	case err := <-errCh:
+		log.Errorln("API server stopped:", err)
+	case sig := <-sigCh:
+		log.Errorln("Caught signal:", sig)
+	}
+
+	close(stop)
+	wg.Wait()
+}
@@ -6,17 +6,21 @@ type DeployJobData struct {
 	SkipInvalidation  bool   `json:"skip_invalidation"`        // if true, prefix cache invalidation message will not be published
 	UseRawBundle      bool   `json:"use_raw_bundle"`           // if true, it uses raw bundle to deploy instead of optimized bundle
 	ArchiveFormat     string `json:"archive_format,omitempty"` // "zip" or "tar.gz"
+	RequestID         string `json:"request_id,omitempty"`     // ID of the API request that caused the job to be enqueued, for tracing
 }
 
 type BuildJobData struct {
 	DeploymentID  uint   `json:"deployment_id"`
 	ArchiveFormat string `json:"archive_format,omitempty"` // "zip" or "tar.gz"
+	RequestID     string `json:"request_id,omitempty"`     // ID of the API request that caused the job to be enqueued, for tracing
 }
 
 type PushJobData struct {
-	PushID uint `json:"push_id"`
+	PushID    uint   `json:"push_id"`
+	RequestID string `json:"request_id,omitempty"` // ID of the API request that caused the job to be enqueued, for tracing
 }
 
 type V1InvalidationMessageData struct {
-	Domains []string `json:"domains"`
+	Domains   []string `json:"domains"`
+	RequestID string   `json:"request_id,omitempty"` // ID of the API request that caused the invalidation, for tracing
 }
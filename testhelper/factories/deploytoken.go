@@ -0,0 +1,37 @@
+package factories
+
+import (
+	"fmt"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/models/deploytoken"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+
+	. "github.com/onsi/gomega"
+)
+
+var deployTokenN = 0
+
+// DeployToken creates a deploy token for proj, created by u.
+func DeployToken(db *gorm.DB, proj *project.Project, u *user.User) (t *deploytoken.DeployToken) {
+	if u == nil {
+		u = User(db)
+	}
+
+	if proj == nil {
+		proj = Project(db, u)
+	}
+
+	deployTokenN++
+	t = &deploytoken.DeployToken{
+		ProjectID:   proj.ID,
+		CreatedByID: u.ID,
+		Name:        fmt.Sprintf("deploy-token-%04d", deployTokenN),
+	}
+
+	err := db.Create(t).Error
+	Expect(err).To(BeNil())
+
+	return t
+}
@@ -0,0 +1,45 @@
+package factories
+
+import (
+	"fmt"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/models/organization"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+
+	. "github.com/onsi/gomega"
+)
+
+var organizationN = 0
+
+// Organization creates an organization with owner as its owner.
+func Organization(db *gorm.DB, owner *user.User) (org *organization.Organization) {
+	if owner == nil {
+		owner = User(db)
+	}
+
+	organizationN++
+	org = &organization.Organization{
+		Name: fmt.Sprintf("organization%04d", organizationN),
+	}
+
+	err := db.Create(org).Error
+	Expect(err).To(BeNil())
+
+	err = org.AddMember(db, owner, organization.RoleOwner)
+	Expect(err).To(BeNil())
+
+	return org
+}
+
+// OrganizationProject creates a project that belongs to org, created by u.
+func OrganizationProject(db *gorm.DB, org *organization.Organization, u *user.User) (proj *project.Project) {
+	proj = Project(db, u)
+
+	err := db.Model(proj).Update("organization_id", org.ID).Error
+	Expect(err).To(BeNil())
+	proj.OrganizationID = &org.ID
+
+	return proj
+}
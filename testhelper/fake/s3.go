@@ -32,6 +32,10 @@ type S3 struct {
 }
 
 func (s *S3) Upload(region, bucket, key string, body io.Reader, contentType, acl string) (err error) {
+	return s.UploadWithCacheControl(region, bucket, key, body, contentType, acl, "")
+}
+
+func (s *S3) UploadWithCacheControl(region, bucket, key string, body io.Reader, contentType, acl, cacheControl string) (err error) {
 	var content []byte
 
 	if s.UploadError == nil {
@@ -49,7 +53,7 @@ func (s *S3) Upload(region, bucket, key string, body io.Reader, contentType, acl
 		err = s.UploadError
 	}
 
-	s.UploadCalls.Add(List{region, bucket, key, body, contentType, acl}, List{err}, Map{
+	s.UploadCalls.Add(List{region, bucket, key, body, contentType, acl, cacheControl}, List{err}, Map{
 		"uploaded_content": content,
 	})
 
@@ -0,0 +1,87 @@
+package sharedexamples
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/models/collab"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// ItRequiresProjectRole tests that a collaborator of the project needs at
+// least the given role to make the request.
+func ItRequiresProjectRole(
+	varFn func() (*gorm.DB, *user.User, *project.Project),
+	role string,
+	reqFn func() *http.Response,
+	assertFn func(),
+) {
+	var (
+		db   *gorm.DB
+		u    *user.User
+		proj *project.Project
+
+		res *http.Response
+	)
+
+	Context("when the project does not belong to current user", func() {
+		BeforeEach(func() {
+			db, u, proj = varFn()
+
+			u2 := factories.User(db)
+			err := db.Model(proj).Update("user_id", u2.ID).Error
+			Expect(err).To(BeNil())
+		})
+
+		for _, r := range []string{collab.RoleViewer, collab.RoleDeployer, collab.RoleAdmin} {
+			r := r
+
+			if collab.HasRole(r, role) {
+				Context("when user is a collaborator with the "+r+" role", func() {
+					BeforeEach(func() {
+						err := proj.AddCollaboratorWithRole(db, u, r)
+						Expect(err).To(BeNil())
+					})
+
+					It("does not respond with 403 forbidden", func() {
+						res = reqFn()
+
+						Expect(res.StatusCode).NotTo(Equal(http.StatusForbidden))
+					})
+				})
+				continue
+			}
+
+			Context("when user is a collaborator with the "+r+" role", func() {
+				BeforeEach(func() {
+					err := proj.AddCollaboratorWithRole(db, u, r)
+					Expect(err).To(BeNil())
+				})
+
+				It("returns 403 forbidden", func() {
+					res = reqFn()
+
+					b := &bytes.Buffer{}
+					_, err := b.ReadFrom(res.Body)
+					Expect(err).To(BeNil())
+
+					Expect(res.StatusCode).To(Equal(http.StatusForbidden))
+					Expect(b.String()).To(MatchJSON(`{
+						"error": "forbidden",
+						"error_description": "you do not have permission to perform this action"
+					}`))
+
+					if assertFn != nil {
+						assertFn()
+					}
+				})
+			})
+		}
+	})
+}